@@ -0,0 +1,185 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const annotateUsage = `usage: proxyrecorder annotate [flags] <record-dir> <request-id>
+
+Adds notes, tags and a star to a recorded request, e.g. to document a
+recording before handing it to someone else as a repro. Annotations are
+shown in the tool, can be searched and are included in exports. With no
+flags, prints the request's annotation.
+
+  -session name   session the request is in (default the active session)
+  -notes text     replace the request's notes, "" removes them
+  -tag tag        add a tag, e.g. bug, expected or flaky; can be repeated
+  -untag tag      remove a tag; can be repeated
+  -star           star the request
+  -unstar         unstar the request
+`
+
+const deleteUsage = `usage: proxyrecorder delete [-session name] <record-dir> <request-id>
+
+Deletes a recorded request or checkpoint. Its snapshot, if it has one, is
+kept as a deleted checkpoint, so the requests after it are still diffed
+against it and the other requests keep their IDs.
+
+Neither annotate nor delete can be used while a proxy recorder is recording
+into the directory; use its tool or API instead.
+`
+
+func annotateCommand(args []string) {
+	flags := flag.NewFlagSet("annotate", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(annotateUsage)
+		os.Exit(1)
+	}
+	sessionName := flags.String("session", "", "")
+	notes := flags.String("notes", "", "")
+	var tags, untags stringList
+	flags.Var(&tags, "tag", "")
+	flags.Var(&untags, "untag", "")
+	star := flags.Bool("star", false, "")
+	unstar := flags.Bool("unstar", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 || (*star && *unstar) {
+		flags.Usage()
+	}
+	edit := flags.NFlag() > 0 && !(flags.NFlag() == 1 && *sessionName != "")
+	if edit {
+		lock := lockRecordDir(flags.Arg(0))
+		defer lock.Release()
+	}
+	rec, requestID := requestToEdit(flags.Arg(0), *sessionName, flags.Arg(1))
+
+	annotation, err := rec.MaybeGetAnnotation(requestID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if annotation == nil {
+		annotation = &recorder.Annotation{}
+	}
+	if !edit {
+		printAnnotation(*annotation)
+		return
+	}
+
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "notes" {
+			annotation.Notes = *notes
+		}
+	})
+	annotation.Tags = append(annotation.Tags, tags...)
+	var kept []string
+	for _, tag := range annotation.Tags {
+		if !contains(untags, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	annotation.Tags = kept
+	if *star {
+		annotation.Starred = true
+	}
+	if *unstar {
+		annotation.Starred = false
+	}
+
+	err = rec.SaveAnnotation(requestID, *annotation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	annotation, err = rec.MaybeGetAnnotation(requestID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if annotation == nil {
+		annotation = &recorder.Annotation{}
+	}
+	printAnnotation(*annotation)
+}
+
+func deleteCommand(args []string) {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(deleteUsage)
+		os.Exit(1)
+	}
+	sessionName := flags.String("session", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+	lock := lockRecordDir(flags.Arg(0))
+	defer lock.Release()
+	rec, requestID := requestToEdit(flags.Arg(0), *sessionName, flags.Arg(1))
+
+	err := rec.DeleteRequest(requestID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("deleted %s\n", rec.FormatRequestID(requestID))
+}
+
+// requestToEdit returns the recorder for a session and a request ID in it,
+// exiting if the request doesn't exist.
+func requestToEdit(recordPath string, sessionName string, requestIDString string) (*recorder.Recorder, int) {
+	sessions := &recorder.Sessions{RootPath: recordPath}
+	session, err := sessions.Resolve(sessionName)
+	if err != nil {
+		log.Fatalf("%s: %s", recordPath, err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	requestID, err := strconv.Atoi(requestIDString)
+	if err != nil || requestID <= 0 {
+		log.Fatalf("invalid request id \"%s\"", requestIDString)
+	}
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, id := range requestIDs {
+		if id == requestID {
+			return rec, requestID
+		}
+	}
+	log.Fatalf("no request %d in session %s", requestID, session.Name)
+	return nil, 0
+}
+
+func printAnnotation(annotation recorder.Annotation) {
+	if annotation.IsEmpty() {
+		fmt.Println("no annotation")
+		return
+	}
+	if annotation.Starred {
+		fmt.Println("starred")
+	}
+	if len(annotation.Tags) > 0 {
+		fmt.Printf("tags: %s\n", strings.Join(annotation.Tags, ", "))
+	}
+	if annotation.Notes != "" {
+		fmt.Println(annotation.Notes)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
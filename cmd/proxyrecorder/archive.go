@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dnerdy/proxyrecorder/pkg/archive"
+)
+
+const packUsage = `usage: proxyrecorder pack [-config file] [-o archive] <record-dir>
+
+Bundles a record directory into a single zip archive with a manifest of its
+sessions and file checksums. -config embeds a configuration file in the
+manifest. The archive defaults to <record-dir>.zip.
+`
+
+const unpackUsage = `usage: proxyrecorder unpack <archive> <record-dir>
+
+Extracts an archive into a new record directory, verifying checksums.
+`
+
+func packCommand(args []string) {
+	flags := flag.NewFlagSet("pack", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(packUsage)
+		os.Exit(1)
+	}
+	configPath := flags.String("config", "", "")
+	outputPath := flags.String("o", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	recordPath := filepath.Clean(flags.Arg(0))
+	if *outputPath == "" {
+		*outputPath = recordPath + ".zip"
+	}
+
+	var config []byte
+	if *configPath != "" {
+		var err error
+		config, err = ioutil.ReadFile(*configPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	f, err := os.Create(*outputPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	manifest, err := archive.Pack(recordPath, f, archive.PackOptions{
+		ToolVersion: version,
+		Config:      config,
+	})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*outputPath)
+		log.Fatal(err)
+	}
+
+	fmt.Printf(
+		"packed %d sessions, %d files into %s\n",
+		len(manifest.Sessions),
+		len(manifest.Files),
+		*outputPath,
+	)
+}
+
+func unpackCommand(args []string) {
+	flags := flag.NewFlagSet("unpack", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(unpackUsage)
+		os.Exit(1)
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+
+	err := os.MkdirAll(flags.Arg(1), 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lock := lockRecordDir(flags.Arg(1))
+	manifest, err := archive.Unpack(flags.Arg(0), flags.Arg(1))
+	lock.Release()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf(
+		"unpacked %d sessions, %d files into %s (packed by proxyrecorder %s)\n",
+		len(manifest.Sessions),
+		len(manifest.Files),
+		flags.Arg(1),
+		manifest.ToolVersion,
+	)
+}
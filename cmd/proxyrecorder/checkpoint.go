@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+)
+
+const snapshotUsage = `usage: proxyrecorder snapshot [-tool url] [label]
+
+Takes a snapshot in a running proxy recorder now and records it as a
+labeled checkpoint after the requests recorded so far, e.g. to capture
+changes made by a background job or a direct database edit. The label
+defaults to "manual snapshot".
+`
+
+func snapshotCommand(args []string) {
+	flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(snapshotUsage)
+		os.Exit(1)
+	}
+	toolURL := flags.String("tool", defaultToolURL, "")
+	flags.Parse(args)
+
+	client := &toolClient{*toolURL}
+	query := url.Values{}
+	if label := strings.Join(flags.Args(), " "); label != "" {
+		query.Set("label", label)
+	}
+
+	var info proxy.RequestInfo
+	err := client.post("/checkpoint", query, nil, &info)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%06d checkpoint %s in session %s\n", info.RequestID, info.Checkpoint, info.Session)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultToolURL = "http://localhost:1234"
+
+// toolClient talks to the tool of a running proxy recorder.
+type toolClient struct {
+	baseURL string
+}
+
+// post sends body as JSON to path on the tool and decodes the JSON response
+// into result if result isn't nil.
+func (c *toolClient) post(path string, query url.Values, body interface{}, result interface{}) error {
+	var content []byte
+	if body != nil {
+		var err error
+		content, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	u := strings.TrimSuffix(c.baseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	resp, err := http.Post(u, "application/json", bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("is proxyrecorder running? %w", err)
+	}
+	defer resp.Body.Close()
+
+	respContent, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respContent)))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(respContent, result)
+}
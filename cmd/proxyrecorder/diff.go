@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/recordingdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+)
+
+const diffUsage = `usage: proxyrecorder diff [flags] <record-dir-a> <record-dir-b>
+
+Compares two recordings of the same flow, e.g. before and after a backend
+change. Requests are lined up by operation name and order, and the diff
+shows operations only one recording made, responses that differ and
+snapshot changes that only one recording saw. Exits with status 1 when the
+recordings differ.
+
+flags:
+  -session-a name    session to compare in <record-dir-a>
+  -session-b name    session to compare in <record-dir-b>
+  -ignore pattern    leave out values matching a path pattern, e.g.
+                     "**.createdAt"; can be repeated
+  -normalize file    JSON file of snapshot normalization rules, added to the
+                     built-in rules for GTP snapshots
+  -json              print the diff as JSON
+  -view              serve the tool with both recordings instead, sessions
+                     are named a/<session> and b/<session>
+  -tool-port port    port the tool listens on with -view (default 1234)
+`
+
+func diffCommand(args []string) {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(diffUsage)
+		os.Exit(1)
+	}
+	sessionA := flags.String("session-a", "", "")
+	sessionB := flags.String("session-b", "", "")
+	var ignore stringList
+	flags.Var(&ignore, "ignore", "")
+	normalizeRules := flags.String("normalize", "", "")
+	printJSON := flags.Bool("json", false, "")
+	view := flags.Bool("view", false, "")
+	toolPort := flags.Int("tool-port", 1234, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+
+	patterns, err := jsonpath.CompileAll(ignore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	normalizer, err := snapshotNormalizer(&Snapshotter{}, *normalizeRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *view {
+		sessions := recorder.CombineSessions(map[string]recorder.SessionLoader{
+			"a": &recorder.Sessions{RootPath: flags.Arg(0)},
+			"b": &recorder.Sessions{RootPath: flags.Arg(1)},
+		})
+		formatsA := recordDirFormats(flags.Arg(0))
+		formatsB := recordDirFormats(flags.Arg(1))
+		formats := func(session string) *snapshotformat.Registry {
+			if strings.HasPrefix(session, "b/") {
+				return formatsB
+			}
+			return formatsA
+		}
+		log.Fatal(serveViewer(sessions, formats, *toolPort, snapshotDiffOptions(""), normalizer))
+	}
+
+	recA, formatsA, formatA := snapshotDiffRecorder(flags.Arg(0), *sessionA)
+	recB, formatsB, formatB := snapshotDiffRecorder(flags.Arg(1), *sessionB)
+	diff, err := recordingdiff.Compare(recA, recB, recordingdiff.Options{
+		Ignore:          patterns,
+		DecodeSnapshot:  snapshotDecoder(formatsA, normalizer, formatA),
+		DecodeSnapshotB: snapshotDecoder(formatsB, normalizer, formatB),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *printJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(diff)
+	} else {
+		printRecordingDiff(diff)
+	}
+	if diff.Changed+diff.Added+diff.Removed > 0 {
+		os.Exit(1)
+	}
+}
+
+func printRecordingDiff(diff *recordingdiff.Diff) {
+	for _, pair := range diff.Pairs {
+		if pair.Kind == recordingdiff.PairSame {
+			continue
+		}
+		fmt.Printf("%-8s %s %s\n", pair.Kind, formatStep(pair.A), formatStep(pair.B))
+		if pair.Message != "" {
+			fmt.Printf("    %s\n", pair.Message)
+		}
+		printChanges("response", pair.ResponseChanges)
+		printChanges("snapshot only in a", pair.SnapshotOnlyA)
+		printChanges("snapshot only in b", pair.SnapshotOnlyB)
+	}
+	fmt.Println(diff.Summary)
+}
+
+func formatStep(step *recordingdiff.Step) string {
+	if step == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%s", step.RequestID, step.OperationName)
+}
+
+func printChanges(label string, changes []jsondiff.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Printf("    %s:\n", label)
+	for _, change := range changes {
+		fmt.Printf("        %-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+	}
+}
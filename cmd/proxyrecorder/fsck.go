@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dnerdy/proxyrecorder/pkg/fsck"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const fsckUsage = `usage: proxyrecorder fsck [-repair] [-session name] <record-dir>
+
+Checks every session in the record directory, or just the named one, for
+missing request and response files, gaps in request numbering, unparsable
+requests and orphaned snapshots. With -repair, broken requests are moved to
+the session's lost+found directory and the remaining requests are
+renumbered to close gaps.
+`
+
+func fsckCommand(args []string) {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(fsckUsage)
+		os.Exit(1)
+	}
+	repair := flags.Bool("repair", false, "")
+	sessionName := flags.String("session", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	sessions := &recorder.Sessions{RootPath: flags.Arg(0)}
+
+	if *repair {
+		// Repairs renumber requests, which would collide with a proxy
+		// recorder writing new ones.
+		lock := lockRecordDir(sessions.RootPath)
+		defer lock.Release()
+	}
+
+	var names []string
+	if *sessionName != "" {
+		names = []string{*sessionName}
+	} else {
+		list, err := sessions.List()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, session := range list {
+			names = append(names, session.Name)
+		}
+	}
+
+	unrepaired := 0
+	for _, name := range names {
+		rec, err := sessions.Recorder(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		n, err := fsckSession(name, rec, *repair)
+		if err != nil {
+			log.Fatal(err)
+		}
+		unrepaired += n
+	}
+
+	if unrepaired > 0 {
+		os.Exit(1)
+	}
+}
+
+// fsckSession checks and optionally repairs a session, returning the number
+// of problems that remain.
+func fsckSession(name string, rec *recorder.Recorder, repair bool) (int, error) {
+	fmt.Printf("session %s\n", name)
+
+	stray, err := fsck.StrayEntries(rec)
+	if err != nil {
+		return 0, err
+	}
+	for _, path := range stray {
+		fmt.Printf("  ignoring %s\n", path)
+	}
+
+	problems, err := fsck.Check(rec)
+	if err != nil {
+		return 0, err
+	}
+	if len(problems) == 0 {
+		fmt.Println("  ok")
+		return 0, nil
+	}
+	for _, problem := range problems {
+		fmt.Printf("  %s\n", problem)
+	}
+
+	if !repair {
+		return len(problems), nil
+	}
+
+	actions, err := fsck.Repair(rec, problems)
+	for _, action := range actions {
+		fmt.Printf("  %s\n", action)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	remaining := 0
+	for _, problem := range problems {
+		if !problem.Repairable() {
+			remaining++
+		}
+	}
+	return remaining, nil
+}
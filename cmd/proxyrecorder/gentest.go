@@ -0,0 +1,133 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/dnerdy/proxyrecorder/pkg/gentest"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const genTestUsage = `usage: proxyrecorder gen-test [flags] <record-dir>
+
+Generates a Go test from a session, by default the active one. Each request
+becomes a step that sends the recorded query and variables to a handler
+with net/http/httptest and checks the response, and each snapshot becomes
+an assertion on what changed since the previous one.
+
+The test calls two functions you write in the same package:
+
+  func newTestHandler(t testing.TB) http.Handler  // the handler under test
+  func takeTestSnapshot(t testing.TB) []byte      // its state as JSON
+
+flags:
+  -o file               file to write, e.g. exam/recorded_test.go (default
+                        stdout)
+  -package name         package of the test (default the name of the
+                        directory of -o)
+  -test-name name       name of the test function (default Test followed by
+                        the session name)
+  -handler-func name    name of the handler function (default newTestHandler)
+  -snapshot-func name   name of the snapshot function (default
+                        takeTestSnapshot); empty to leave out snapshots
+  -ignore pattern       leave out values matching a path pattern, e.g.
+                        "**.createdAt"; can be repeated
+  -session name         session to generate the test from
+`
+
+func genTestCommand(args []string) {
+	flags := flag.NewFlagSet("gen-test", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(genTestUsage)
+		os.Exit(1)
+	}
+	outputPath := flags.String("o", "", "")
+	packageName := flags.String("package", "", "")
+	testName := flags.String("test-name", "", "")
+	handlerFunc := flags.String("handler-func", "newTestHandler", "")
+	snapshotFunc := flags.String("snapshot-func", "takeTestSnapshot", "")
+	var ignore stringList
+	flags.Var(&ignore, "ignore", "")
+	sessionName := flags.String("session", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	if *packageName == "" && *outputPath != "" {
+		dir, err := filepath.Abs(filepath.Dir(*outputPath))
+		if err != nil {
+			log.Fatal(err)
+		}
+		*packageName = filepath.Base(dir)
+	}
+	if *packageName == "" {
+		log.Fatal("no package name, use -package or -o")
+	}
+
+	recordPath := flags.Arg(0)
+	sessions := &recorder.Sessions{RootPath: recordPath}
+	session, err := sessions.Resolve(*sessionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *testName == "" {
+		*testName = "Test" + exportedName(session.Name)
+	}
+
+	source, err := gentest.Generate(rec, gentest.Options{
+		Package:         *packageName,
+		TestName:        *testName,
+		Source:          fmt.Sprintf("%s, session %s", filepath.Base(recordPath), session.Name),
+		HandlerFunc:     *handlerFunc,
+		SnapshotFunc:    *snapshotFunc,
+		Ignore:          ignore,
+		SnapshotType:    session.SnapshotType,
+		SnapshotFormats: recordDirFormats(recordPath),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *outputPath == "" {
+		os.Stdout.Write(source)
+		return
+	}
+	err = ioutil.WriteFile(*outputPath, source, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// exportedName turns a session name like "exam-review" into "ExamReview".
+func exportedName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "Recording"
+	}
+	return b.String()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/har"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const exportUsage = `usage: proxyrecorder export [-format har] [-session name] [-snapshots] [-o file] <record-dir>
+
+Exports a session, by default the active one, as an HTTP Archive. With
+-snapshots, each request's snapshot is included in its entry's _snapshot
+field.
+`
+
+const importUsage = `usage: proxyrecorder import [-session name] [-config file] <har-file> <record-dir>
+
+Imports the GraphQL requests in a HAR, e.g. one saved from browser devtools,
+into a new session named after the HAR file, with the characters that
+can't be in a session name replaced by "-". Requests are filtered with the
+same selector used when recording, configured with -config.
+`
+
+// version is the proxy recorder version recorded in exports. It can be set
+// at build time with -ldflags "-X main.version=...".
+var version = "dev"
+
+func exportCommand(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(exportUsage)
+		os.Exit(1)
+	}
+	format := flags.String("format", "har", "")
+	sessionName := flags.String("session", "", "")
+	includeSnapshots := flags.Bool("snapshots", false, "")
+	outputPath := flags.String("o", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 || *format != "har" {
+		flags.Usage()
+	}
+
+	sessions := &recorder.Sessions{RootPath: flags.Arg(0)}
+	session, err := sessions.Resolve(*sessionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archive, err := har.Export(rec, har.ExportOptions{
+		CreatorVersion:   version,
+		IncludeSnapshots: *includeSnapshots,
+		Comment:          session.Description,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(archive)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func importCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(importUsage)
+		os.Exit(1)
+	}
+	sessionName := flags.String("session", "", "")
+	configPath := flags.String("config", "", "")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+	}
+	selector, err := requestSelector(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	harPath := flags.Arg(0)
+	content, err := ioutil.ReadFile(harPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var archive har.HAR
+	err = json.Unmarshal(content, &archive)
+	if err != nil {
+		log.Fatalf("%s: %s", harPath, err)
+	}
+
+	if *sessionName == "" {
+		*sessionName = recorder.SessionName(strings.TrimSuffix(filepath.Base(harPath), filepath.Ext(harPath)))
+		if *sessionName == "" {
+			log.Fatalf("no session name can be made from %s, use -session", filepath.Base(harPath))
+		}
+	}
+
+	recordPath := flags.Arg(1)
+	err = os.MkdirAll(recordPath, 0755)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lock := lockRecordDir(recordPath)
+	defer lock.Release()
+	sessions := &recorder.Sessions{RootPath: recordPath}
+	session, err := sessions.Create(recorder.Session{
+		Name:        *sessionName,
+		Description: "imported from " + filepath.Base(harPath),
+		Context: map[string]string{
+			"importedFrom": harPath,
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	imported, err := har.Import(&archive, rec, selector)
+	if err != nil {
+		log.Fatal(err)
+	}
+	_, err = sessions.Close(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf(
+		"imported %d of %d entries into session %s\n",
+		imported,
+		len(archive.Log.Entries),
+		session.Name,
+	)
+}
@@ -2,31 +2,122 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"syscall"
+	"time"
 
+	"github.com/dnerdy/proxyrecorder/pkg/config"
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
 	"github.com/dnerdy/proxyrecorder/pkg/proxy"
 	"github.com/dnerdy/proxyrecorder/pkg/recorder"
 	"github.com/dnerdy/proxyrecorder/pkg/server"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
 )
 
+const usage = `usage: proxyrecorder [record flags] <record-dir> <webapp> <kaid> <exam-group-id>
+       proxyrecorder session <list|new|switch|close> [flags] [args]
+       proxyrecorder snapshot [-tool url] [label]
+       proxyrecorder fsck [-repair] [-session name] <record-dir>
+       proxyrecorder export [-format har] [-session name] [-o file] <record-dir>
+       proxyrecorder import [-session name] [-config file] <har-file> <record-dir>
+       proxyrecorder pack [-config file] [-o archive] <record-dir>
+       proxyrecorder unpack <archive> <record-dir>
+       proxyrecorder view [-tool-port port] [-identity paths] <record-dir|archive>
+       proxyrecorder verify [flags] <record-dir>
+       proxyrecorder gen-test [flags] <record-dir>
+       proxyrecorder snapshot-diff [flags] <record-dir> <request-id>
+       proxyrecorder diff [flags] <record-dir-a> <record-dir-b>
+       proxyrecorder annotate [flags] <record-dir> <request-id>
+       proxyrecorder delete [-session name] <record-dir> <request-id>
+
+record flags:
+  -session name         record into the named session, creating it if needed
+  -description text     description for a newly created session
+  -fsync mode           none, file (default) or all; how hard to flush writes
+  -on-locked action     refuse (default) or view when another proxy recorder
+                        is recording into the record directory
+  -proxy-port port      port the proxy listens on (default 8109)
+  -tool-port port       port the tool listens on (default 1234, 1235 when
+                        viewing a locked record directory)
+  -config file          JSON config file, e.g. with rules for which requests
+                        are recorded and snapshotted (default: record
+                        everything, snapshot mutations); reloaded when it
+                        changes
+  -normalize file       JSON file of snapshot normalization rules, added to
+                        the built-in rules for GTP snapshots
+  -identity paths       comma separated paths of the fields that identify
+                        entities in snapshot diffs (default
+                        key.value.kind,key.value.id,key.value.name)
+`
+
 func printUsageAndExit() {
-	fmt.Println(`usage: proxyrecorder <record-dir> <webapp> <kaid> <exam-group-id>
- `)
+	fmt.Print(usage)
 	os.Exit(1)
 }
 
-// TODO: record the kaid in the record directory?
-// TODO: record the examGroupID in the record directory?
+// commands are the subcommands of proxyrecorder. Running proxyrecorder
+// without a subcommand records.
+var commands = map[string]func(args []string){
+	"session":       sessionCommand,
+	"snapshot":      snapshotCommand,
+	"fsck":          fsckCommand,
+	"export":        exportCommand,
+	"import":        importCommand,
+	"pack":          packCommand,
+	"unpack":        unpackCommand,
+	"view":          viewCommand,
+	"verify":        verifyCommand,
+	"gen-test":      genTestCommand,
+	"snapshot-diff": snapshotDiffCommand,
+	"diff":          diffCommand,
+	"annotate":      annotateCommand,
+	"delete":        deleteCommand,
+}
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+	record(os.Args[1:])
+}
+
+func record(args []string) {
 	ctx := context.Background()
 
-	if len(os.Args) != 5 {
+	flags := flag.NewFlagSet("proxyrecorder", flag.ExitOnError)
+	flags.Usage = printUsageAndExit
+	sessionName := flags.String("session", "", "")
+	description := flags.String("description", "", "")
+	fsync := flags.String("fsync", "file", "")
+	onLocked := flags.String("on-locked", "refuse", "")
+	proxyPort := flags.Int("proxy-port", 8109, "")
+	toolPort := flags.Int("tool-port", 0, "")
+	identity := flags.String("identity", "", "")
+	configPath := flags.String("config", "", "")
+	normalizeRules := flags.String("normalize", "", "")
+	flags.Parse(args)
+
+	syncMode, err := recorder.ParseSyncMode(*fsync)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *onLocked != "refuse" && *onLocked != "view" {
+		printUsageAndExit()
+	}
+
+	if flags.NArg() != 4 {
 		printUsageAndExit()
 	}
 
@@ -35,10 +126,10 @@ func main() {
 		panic(err)
 	}
 
-	recordPath := os.Args[1]
-	webappPath := os.Args[2]
-	kaid := os.Args[3]
-	examGroupID := os.Args[4]
+	recordPath := flags.Arg(0)
+	webappPath := flags.Arg(1)
+	kaid := flags.Arg(2)
+	examGroupID := flags.Arg(3)
 
 	recordPath = filepath.Join(cwd, recordPath)
 
@@ -47,32 +138,228 @@ func main() {
 		panic(err)
 	}
 
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 	snapshotter := &Snapshotter{
 		webappPath,
 		kaid,
 		examGroupID,
 	}
-	requestRecorder := &recorder.Recorder{
+	normalizer, err := snapshotNormalizer(snapshotter, *normalizeRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	settings, err := serverSettings(cfg, snapshotter, normalizer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessions := &recorder.Sessions{
 		RootPath: recordPath,
+		Sync:     syncMode,
 	}
-	selector := &RequestSelector{}
 
-	s := server.NewServer(
-		snapshotter,
-		selector,
-		requestRecorder,
-	)
-	log.Fatal(s.ListenAndServe(ctx))
+	lock, err := recorder.AcquireLock(recordPath, recorder.LockInfo{
+		ToolURL: fmt.Sprintf("http://localhost:%d", portOrDefault(*toolPort, 1234)),
+	})
+	var lockedErr *recorder.LockedError
+	if errors.As(err, &lockedErr) && *onLocked == "view" {
+		fmt.Printf("warning: %s\n", err)
+		if lockedErr.Info.ToolURL != "" {
+			fmt.Printf("warning: its tool is at %s\n", lockedErr.Info.ToolURL)
+		}
+		log.Fatal(serveViewer(sessions, recordDirRegistries(recordPath), portOrDefault(*toolPort, 1235), snapshotDiffOptions(*identity), normalizer))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	releaseLockOnExit(lock)
+
+	err = activateSession(sessions, *sessionName, *description, settings.SessionContext, snapshotter.SnapshotContentType())
+	if err != nil {
+		lock.Release()
+		log.Fatal(err)
+	}
+
+	s := server.NewServer(settings, sessions)
+	s.ProxyPort = *proxyPort
+	s.ToolPort = portOrDefault(*toolPort, 1234)
+	s.SnapshotDiff = snapshotDiffOptions(*identity)
+	s.SnapshotNormalizer = normalizer
+	s.SnapshotFormats = recordDirRegistries(recordPath)
+
+	if *configPath != "" {
+		// A config that can't be used is reported in the tool, and
+		// recording carries on with the previous one.
+		config.Watch(ctx, *configPath, time.Second, func(cfg config.Config, err error) {
+			if err == nil {
+				var settings server.Settings
+				settings, err = serverSettings(cfg, snapshotter, normalizer)
+				if err == nil {
+					err = s.Reload(settings)
+				}
+			}
+			if err != nil {
+				s.ConfigError(err)
+			}
+		})
+	}
+
+	err = s.ListenAndServe(ctx)
+	lock.Release()
+	log.Fatal(err)
+}
+
+func portOrDefault(port int, defaultPort int) int {
+	if port == 0 {
+		return defaultPort
+	}
+	return port
+}
+
+// releaseLockOnExit releases the record directory lock when the proxy
+// recorder is interrupted, so the next run doesn't find a stale lock.
+func releaseLockOnExit(lock *recorder.Lock) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		lock.Release()
+		os.Exit(0)
+	}()
+}
+
+// lockRecordDir locks a record directory for a command that writes into it,
+// exiting if a proxy recorder holds the lock.
+func lockRecordDir(recordPath string) *recorder.Lock {
+	lock, err := recorder.AcquireLock(recordPath, recorder.LockInfo{})
+	var lockedErr *recorder.LockedError
+	if errors.As(err, &lockedErr) && lockedErr.Info.ToolURL != "" {
+		log.Fatalf("%s, use its tool at %s instead", err, lockedErr.Info.ToolURL)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	return lock
 }
 
-type RequestSelector struct{}
+// activateSession picks the session to record into and makes it active. If
+// no name is given the active session is used, then a recording made before
+// sessions existed, and otherwise a new session is created.
+func activateSession(
+	sessions *recorder.Sessions,
+	name string,
+	description string,
+	sessionContext map[string]string,
+	snapshotType string,
+) error {
+	if name == "" {
+		active, err := sessions.Active()
+		if err != nil && !errors.Is(err, recorder.ErrNoActiveSession) {
+			return err
+		}
+		name = active
+	}
+	if name == "" {
+		if _, err := sessions.Get(recorder.LegacySessionName); err == nil {
+			name = recorder.LegacySessionName
+		}
+	}
+	if name == "" {
+		name = "session-" + time.Now().Format("20060102-150405")
+	}
+
+	session, err := sessions.Get(name)
+	if errors.Is(err, recorder.ErrSessionNotFound) {
+		session, err = sessions.Create(recorder.Session{
+			Name:         name,
+			Description:  description,
+			Context:      sessionContext,
+			SnapshotType: snapshotType,
+		})
+	}
+	if err != nil {
+		return err
+	}
 
-func (s *RequestSelector) ShouldRecordRequest(proxy.GraphQLRequest) bool {
-	return true
+	if session.Context != nil && !reflect.DeepEqual(session.Context, sessionContext) {
+		fmt.Printf("warning: session %s was recorded with %v\n", session.Name, session.Context)
+	}
+
+	_, err = sessions.Reopen(session.Name)
+	if err != nil {
+		return err
+	}
+	return sessions.SetActive(session.Name)
 }
 
-func (s *RequestSelector) ShouldSnapshotRequest(r proxy.GraphQLRequest) bool {
-	return r.OperationType == proxy.OperationTypeMutation
+// loadConfig reads a config file, or returns the default config if the
+// path is empty.
+func loadConfig(configPath string) (config.Config, error) {
+	if configPath == "" {
+		return config.Default(), nil
+	}
+	return config.Load(configPath)
+}
+
+// requestSelector returns the selector configured in a config file, or the
+// default one, which records everything and snapshots mutations, if the
+// path is empty.
+func requestSelector(configPath string) (proxy.RequestSelector, error) {
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewSelector()
+}
+
+// serverSettings returns the server settings a config describes. The
+// config's snapshotter settings override the command line arguments the
+// snapshotter was created with.
+func serverSettings(cfg config.Config, snapshotter *Snapshotter, normalizer *normalize.Normalizer) (server.Settings, error) {
+	snapshotter, err := snapshotter.withSettings(cfg.Snapshotter)
+	if err != nil {
+		return server.Settings{}, err
+	}
+	selectors, err := cfg.NewSelectors()
+	if err != nil {
+		return server.Settings{}, err
+	}
+	redactor, err := cfg.NewRedactor()
+	if err != nil {
+		return server.Settings{}, err
+	}
+	upstream, routes, err := cfg.Upstream.Parse()
+	if err != nil {
+		return server.Settings{}, err
+	}
+
+	ruleSets := map[string]proxy.RequestSelector{}
+	for name, selector := range selectors {
+		ruleSets[name] = selector
+	}
+	var serverSnapshotter proxy.Snapshotter = snapshotter
+	if normalizer.AtStore() {
+		serverSnapshotter = &normalize.Snapshotter{
+			Snapshotter: snapshotter,
+			Normalizer:  normalizer,
+		}
+	}
+	return server.Settings{
+		Snapshotter:    serverSnapshotter,
+		RuleSets:       ruleSets,
+		RuleSet:        config.DefaultRuleSet,
+		Redactor:       redactor,
+		Upstream:       upstream,
+		Routes:         routes,
+		DetectDrift:    cfg.DetectDrift,
+		SnapshotBefore: cfg.SnapshotBefore,
+		SessionContext: map[string]string{
+			"kaid":        snapshotter.kaid,
+			"examGroupID": snapshotter.examGroupID,
+		},
+	}, nil
 }
 
 type Snapshotter struct {
@@ -81,6 +368,25 @@ type Snapshotter struct {
 	examGroupID string
 }
 
+// withSettings returns a copy of the snapshotter with the "webapp", "kaid"
+// and "examGroupID" settings from the config file applied.
+func (s *Snapshotter) withSettings(settings map[string]string) (*Snapshotter, error) {
+	snapshotter := *s
+	for name, value := range settings {
+		switch name {
+		case "webapp":
+			snapshotter.webappPath = value
+		case "kaid":
+			snapshotter.kaid = value
+		case "examGroupID":
+			snapshotter.examGroupID = value
+		default:
+			return nil, fmt.Errorf("snapshotter: unknown setting \"%s\", expected webapp, kaid or examGroupID", name)
+		}
+	}
+	return &snapshotter, nil
+}
+
 func (s *Snapshotter) TakeSnapshot(_ proxy.GraphQLRequest) ([]byte, error) {
 	tmpfile, err := ioutil.TempFile("", "snapshot.pickle")
 	if err != nil {
@@ -108,3 +414,7 @@ func (s *Snapshotter) TakeSnapshot(_ proxy.GraphQLRequest) ([]byte, error) {
 func (s *Snapshotter) SnapshotInfo() string {
 	return fmt.Sprintf("kaid: %s, examGroupID: %s", s.kaid, s.examGroupID)
 }
+
+func (s *Snapshotter) SnapshotContentType() string {
+	return snapshotformat.Pickle
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
+)
+
+// NormalizationRules are the rules for GTP user data snapshots: properties
+// are sorted by name and their modification times are masked.
+func (s *Snapshotter) NormalizationRules() normalize.Rules {
+	return normalize.Rules{
+		Mask: []normalize.Rule{
+			{
+				Path:  "**.properties.*",
+				Where: map[string]interface{}{"name": "lastModified"},
+				Field: "value",
+			},
+		},
+		Sort: []normalize.SortRule{
+			{Path: "**.properties", By: "name"},
+		},
+	}
+}
+
+// snapshotNormalizer returns a normalizer for a snapshotter's rules,
+// followed by the rules in the file at rulesPath if it isn't empty.
+func snapshotNormalizer(snapshotter normalize.RuleProvider, rulesPath string) (*normalize.Normalizer, error) {
+	rules := snapshotter.NormalizationRules()
+	if rulesPath != "" {
+		fileRules, err := normalize.LoadRules(rulesPath)
+		if err != nil {
+			return nil, err
+		}
+		rules = rules.Merge(fileRules)
+	}
+	return normalize.New(rules)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const sessionUsage = `usage: proxyrecorder session list <record-dir>
+       proxyrecorder session new [-tool url] [-description text] <name>
+       proxyrecorder session switch [-tool url] <name>
+       proxyrecorder session close [-tool url] <name>
+
+new, switch and close change the sessions of a running proxy recorder.
+`
+
+func sessionCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Print(sessionUsage)
+		os.Exit(1)
+	}
+
+	flags := flag.NewFlagSet("session "+args[0], flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(sessionUsage)
+		os.Exit(1)
+	}
+	toolURL := flags.String("tool", defaultToolURL, "")
+	description := flags.String("description", "", "")
+	flags.Parse(args[1:])
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	client := &toolClient{*toolURL}
+	name := flags.Arg(0)
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = listSessions(&recorder.Sessions{RootPath: flags.Arg(0)})
+	case "new":
+		var session recorder.Session
+		err = client.post("/sessions/new", nil, recorder.Session{
+			Name:        name,
+			Description: *description,
+		}, &session)
+		if err == nil {
+			fmt.Printf("recording session %s\n", session.Name)
+		}
+	case "switch":
+		err = client.post("/sessions/switch", url.Values{"name": {name}}, nil, nil)
+		if err == nil {
+			fmt.Printf("recording session %s\n", name)
+		}
+	case "close":
+		err = client.post("/sessions/close", url.Values{"name": {name}}, nil, nil)
+		if err == nil {
+			fmt.Printf("closed session %s\n", name)
+		}
+	default:
+		flags.Usage()
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func listSessions(sessions *recorder.Sessions) error {
+	list, err := sessions.List()
+	if err != nil {
+		return err
+	}
+	active, err := sessions.Active()
+	if err != nil && !errors.Is(err, recorder.ErrNoActiveSession) {
+		return err
+	}
+	for _, session := range list {
+		marker := " "
+		if session.Name == active {
+			marker = "*"
+		}
+		ended := "open"
+		if session.Closed() {
+			ended = session.EndedAt.Format("2006-01-02 15:04")
+		}
+		fmt.Printf(
+			"%s %-24s %s - %-16s %s\n",
+			marker,
+			session.Name,
+			session.StartedAt.Format("2006-01-02 15:04"),
+			ended,
+			session.Description,
+		)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+)
+
+const snapshotDiffUsage = `usage: proxyrecorder snapshot-diff [flags] <record-dir> <request-id> [<to-request-id>]
+
+Shows which entities changed between a request's snapshot and the snapshot
+before it. Entities are matched by their identity fields, so reordered
+entities and properties aren't reported as changes.
+
+Given two request IDs, shows what changed between the snapshots after each
+of them, e.g. 0 and the last request for the effect of a whole session. The
+second request can be in another session or record directory, to compare
+two recordings of the same flow.
+
+flags:
+  -session name      session the request is in
+  -to-session name   session the second request is in (default -session)
+  -to-dir dir        record directory the second request is in (default
+                     <record-dir>)
+  -identity paths    comma separated paths of the fields that identify
+                     entities (default key.value.kind,key.value.id,key.value.name)
+  -ignore pattern    leave out values matching a path pattern, e.g.
+                     "properties.updated"; can be repeated
+  -normalize file    JSON file of snapshot normalization rules, added to the
+                     built-in rules for GTP snapshots
+  -json              print the diff as JSON
+`
+
+func snapshotDiffCommand(args []string) {
+	flags := flag.NewFlagSet("snapshot-diff", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(snapshotDiffUsage)
+		os.Exit(1)
+	}
+	sessionName := flags.String("session", "", "")
+	toSessionName := flags.String("to-session", "", "")
+	toDir := flags.String("to-dir", "", "")
+	identity := flags.String("identity", "", "")
+	var ignore stringList
+	flags.Var(&ignore, "ignore", "")
+	normalizeRules := flags.String("normalize", "", "")
+	printJSON := flags.Bool("json", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 2 && flags.NArg() != 3 {
+		flags.Usage()
+	}
+
+	options := snapshotDiffOptions(*identity)
+	var err error
+	options.Ignore, err = jsonpath.CompileAll(ignore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	normalizer, err := snapshotNormalizer(&Snapshotter{}, *normalizeRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rec, formats, format := snapshotDiffRecorder(flags.Arg(0), *sessionName)
+	toFormats, toFormat := formats, format
+	requestID := parseRequestID(flags.Arg(1))
+
+	var priorSnapshot, snapshot []byte
+	if flags.NArg() == 2 {
+		if requestID == 0 {
+			log.Fatal("the initial snapshot has no snapshot before it")
+		}
+		snapshot, err = rec.MaybeGetSnapshot(requestID)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if snapshot == nil {
+			log.Fatalf("request %s has no snapshot", rec.FormatRequestID(requestID))
+		}
+		priorSnapshot, err = rec.GetPriorSnapshot(requestID)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if *toDir == "" {
+			*toDir = flags.Arg(0)
+		}
+		if *toSessionName == "" {
+			*toSessionName = *sessionName
+		}
+		var toRec *recorder.Recorder
+		toRec, toFormats, toFormat = snapshotDiffRecorder(*toDir, *toSessionName)
+		priorSnapshot = snapshotAt(rec, requestID)
+		snapshot = snapshotAt(toRec, parseRequestID(flags.Arg(2)))
+	}
+
+	diff, err := snapshotdiff.DiffJSON(
+		readableSnapshot(formats, normalizer, format, priorSnapshot),
+		readableSnapshot(toFormats, normalizer, toFormat, snapshot),
+		options,
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *printJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(diff)
+		return
+	}
+
+	fmt.Println(diff.Summary)
+	for _, entity := range diff.Entities {
+		fmt.Printf("%-8s %s %s\n", entity.Kind, entity.List, entity.Identity)
+		for _, change := range entity.Changes {
+			fmt.Printf("    %-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+		}
+	}
+	for _, change := range diff.Other {
+		fmt.Printf("%-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+	}
+}
+
+// snapshotDiffRecorder returns the recorder for a session, the registry its
+// snapshots are decoded with and their content type, exiting if there
+// isn't one.
+func snapshotDiffRecorder(recordPath string, sessionName string) (*recorder.Recorder, *snapshotformat.Registry, string) {
+	sessions := &recorder.Sessions{RootPath: recordPath}
+	session, err := sessions.Resolve(sessionName)
+	if err != nil {
+		log.Fatalf("%s: %s", recordPath, err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return rec, recordDirFormats(recordPath), session.SnapshotType
+}
+
+// recordDirFormats returns the registry for the snapshots in a record
+// directory, which loads protobuf descriptors from it.
+func recordDirFormats(recordPath string) *snapshotformat.Registry {
+	return snapshotformat.Default.WithDescriptorDir(recordPath)
+}
+
+// readableSnapshot decodes a snapshot to JSON from its format with formats
+// and normalizes it, as the tool shows it. Snapshots that can't be decoded
+// are returned as recorded.
+func readableSnapshot(
+	formats *snapshotformat.Registry,
+	normalizer *normalize.Normalizer,
+	format string,
+	snapshot []byte,
+) []byte {
+	decoded, ok := formats.Readable(format, snapshot)
+	if !ok {
+		return snapshot
+	}
+	return normalizer.ApplyJSON(decoded)
+}
+
+// snapshotDecoder returns a function that decodes snapshots of a format
+// with formats and normalizes them, for comparing them.
+func snapshotDecoder(
+	formats *snapshotformat.Registry,
+	normalizer *normalize.Normalizer,
+	format string,
+) func(snapshot []byte) (interface{}, error) {
+	return func(snapshot []byte) (interface{}, error) {
+		value, err := formats.Decode(format, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return normalizer.Apply(value), nil
+	}
+}
+
+func parseRequestID(s string) int {
+	requestID, err := strconv.Atoi(s)
+	if err != nil || requestID < 0 {
+		log.Fatalf("invalid request id %s", s)
+	}
+	return requestID
+}
+
+// snapshotAt returns the snapshot after a request, exiting if there isn't
+// one.
+func snapshotAt(rec *recorder.Recorder, requestID int) []byte {
+	snapshot, err := recorder.SnapshotAt(rec, requestID)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if snapshot == nil {
+		log.Fatalf("%s: no snapshot at request %s", rec.RootPath, rec.FormatRequestID(requestID))
+	}
+	return snapshot
+}
+
+// snapshotDiffOptions returns the default snapshot diff options, with the
+// identity fields replaced if identity, a comma separated list of paths,
+// isn't empty.
+func snapshotDiffOptions(identity string) snapshotdiff.Options {
+	options := snapshotdiff.DefaultOptions()
+	if identity != "" {
+		options.Identity = strings.Split(identity, ",")
+	}
+	return options
+}
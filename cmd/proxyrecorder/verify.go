@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/server"
+	"github.com/dnerdy/proxyrecorder/pkg/verify"
+)
+
+const verifyUsage = `usage: proxyrecorder verify [flags] <record-dir>
+
+Replays a session, by default the active one, against the upstream in
+request order and reports where the responses, and what changed between
+snapshots, differ from the recording. Exits with status 1 if there are any
+mismatches.
+
+flags:
+  -session name       session to replay
+  -upstream url       origin to replay against (default http://localhost:8309)
+  -ignore pattern     leave out values matching a path pattern when comparing,
+                      e.g. "**.createdAt" or "data.*.id"; can be repeated
+  -reset-cmd command  shell command run before replaying to reset the
+                      upstream's state
+  -webapp path        webapp checkout used to take snapshots with the kaid
+                      and exam group the session was recorded with; without
+                      it snapshots aren't compared
+  -normalize file     JSON file of snapshot normalization rules, added to the
+                      built-in rules for GTP snapshots
+  -json               print the result as JSON
+`
+
+// stringList is a flag that can be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+func verifyCommand(args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(verifyUsage)
+		os.Exit(1)
+	}
+	sessionName := flags.String("session", "", "")
+	upstream := flags.String("upstream", "http://localhost:8309", "")
+	var ignore stringList
+	flags.Var(&ignore, "ignore", "")
+	resetCommand := flags.String("reset-cmd", "", "")
+	webappPath := flags.String("webapp", "", "")
+	normalizeRules := flags.String("normalize", "", "")
+	printJSON := flags.Bool("json", false, "")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	patterns, err := jsonpath.CompileAll(ignore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sessions := &recorder.Sessions{RootPath: flags.Arg(0)}
+	session, err := sessions.Resolve(*sessionName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	normalizer, err := snapshotNormalizer(&Snapshotter{}, *normalizeRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	options := verify.Options{
+		Upstream:       *upstream,
+		Ignore:         patterns,
+		DecodeSnapshot: snapshotDecoder(recordDirFormats(flags.Arg(0)), normalizer, session.SnapshotType),
+		Reporter:       &server.Reporter{},
+	}
+	if *printJSON {
+		options.Reporter = nil
+	}
+	if *resetCommand != "" {
+		options.Reset = func() error {
+			cmd := exec.Command("sh", "-c", *resetCommand)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("%w: %s", err, out)
+			}
+			return nil
+		}
+	}
+	if *webappPath != "" {
+		options.Snapshotter, err = sessionSnapshotter(session, *webappPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	result, err := verify.Run(rec, options)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *printJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		printVerifyResult(result)
+	}
+	if !result.OK() {
+		os.Exit(1)
+	}
+}
+
+// sessionSnapshotter returns a snapshotter for the user and exam group a
+// session was recorded with.
+func sessionSnapshotter(session recorder.Session, webappPath string) (proxy.Snapshotter, error) {
+	kaid := session.Context["kaid"]
+	examGroupID := session.Context["examGroupID"]
+	if kaid == "" || examGroupID == "" {
+		return nil, fmt.Errorf("session %s wasn't recorded with a kaid and exam group", session.Name)
+	}
+	return &Snapshotter{webappPath, kaid, examGroupID}, nil
+}
+
+func printVerifyResult(result *verify.Result) {
+	for _, mismatch := range result.Mismatches {
+		fmt.Println(mismatch)
+		for _, change := range mismatch.Changes {
+			fmt.Printf("    %-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+		}
+		for _, change := range mismatch.Missing {
+			fmt.Printf("    missing    %-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+		}
+		for _, change := range mismatch.Unexpected {
+			fmt.Printf("    unexpected %-8s %s: %v -> %v\n", change.Kind, change.Path, change.Before, change.After)
+		}
+	}
+	fmt.Printf(
+		"replayed %d requests and compared %d snapshots, %d mismatches\n",
+		result.Requests,
+		result.Snapshots,
+		len(result.Mismatches),
+	)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dnerdy/proxyrecorder/pkg/archive"
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/server"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+)
+
+const viewUsage = `usage: proxyrecorder view [-tool-port port] [-identity paths] [-normalize file] <record-dir|archive>
+
+Serves the tool, read-only, for a record directory or an archive made with
+pack. The proxy isn't started, so no webapp checkout, kaid or upstream
+services are needed, and nothing in the recording is changed. -identity
+and -normalize set how snapshots are diffed, as for recording.
+`
+
+func viewCommand(args []string) {
+	flags := flag.NewFlagSet("view", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Print(viewUsage)
+		os.Exit(1)
+	}
+	toolPort := flags.Int("tool-port", 1234, "")
+	identity := flags.String("identity", "", "")
+	normalizeRules := flags.String("normalize", "", "")
+	flags.Parse(args)
+	diffOptions := snapshotDiffOptions(*identity)
+	normalizer, err := snapshotNormalizer(&Snapshotter{}, *normalizeRules)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+	}
+
+	path := flags.Arg(0)
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if !info.IsDir() {
+		r, err := archive.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer r.Close()
+		// Archives have no record directory to load protobuf descriptors
+		// from, so their snapshots are decoded by field number.
+		log.Fatal(serveViewer(r, nil, *toolPort, diffOptions, normalizer))
+	}
+
+	sessions := &recorder.Sessions{RootPath: path}
+	list, err := sessions.List()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(list) == 0 {
+		log.Fatalf("%s doesn't contain any recordings", path)
+	}
+
+	var lockedErr *recorder.LockedError
+	if err := recorder.CheckUnlocked(path); errors.As(err, &lockedErr) {
+		fmt.Printf("note: pid %d is recording into %s, reload to see new requests\n", lockedErr.Info.PID, path)
+	}
+
+	log.Fatal(serveViewer(sessions, recordDirRegistries(path), *toolPort, diffOptions, normalizer))
+}
+
+// serveViewer serves the tool for browsing sessions without recording.
+func serveViewer(
+	sessions recorder.SessionLoader,
+	formats snapshotformat.Registries,
+	toolPort int,
+	diffOptions snapshotdiff.Options,
+	normalizer *normalize.Normalizer,
+) error {
+	viewer := server.NewViewer(sessions)
+	viewer.ToolPort = toolPort
+	viewer.SnapshotDiff = diffOptions
+	viewer.SnapshotNormalizer = normalizer
+	viewer.SnapshotFormats = formats
+	return viewer.ListenAndServeViewer(context.Background())
+}
+
+// recordDirRegistries returns the registries for the sessions in a record
+// directory, which all share the one for the directory.
+func recordDirRegistries(recordPath string) snapshotformat.Registries {
+	formats := recordDirFormats(recordPath)
+	return func(string) *snapshotformat.Registry {
+		return formats
+	}
+}
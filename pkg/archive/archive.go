@@ -0,0 +1,252 @@
+// Package archive bundles a record directory into a single zip file that's
+// easy to share. The archive holds a manifest with the tool version, the
+// configuration and session metadata of the recording, and a checksum for
+// every file. Archives can be unpacked back into a record directory or read
+// in place with Open.
+package archive
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+const (
+	ManifestName  = "manifest.json"
+	FormatVersion = 1
+)
+
+type Manifest struct {
+	FormatVersion int       `json:"formatVersion"`
+	ToolVersion   string    `json:"toolVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+	// Config is the proxy recorder configuration the recording was made
+	// with, if there was one.
+	Config   json.RawMessage   `json:"config,omitempty"`
+	Active   string            `json:"active,omitempty"`
+	Sessions []ManifestSession `json:"sessions"`
+	Files    []ManifestFile    `json:"files"`
+}
+
+type ManifestSession struct {
+	recorder.Session
+	// Path is the slash separated directory of the session in the archive.
+	// It's empty for recordings made before sessions existed.
+	Path string `json:"path"`
+}
+
+type ManifestFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+type PackOptions struct {
+	ToolVersion string
+	Config      json.RawMessage
+}
+
+// Pack writes the recording in rootPath to w. Files that aren't part of the
+// recording, like the lock file, lost+found and stray entries, are left
+// out.
+func Pack(rootPath string, w io.Writer, options PackOptions) (*Manifest, error) {
+	sessions := &recorder.Sessions{RootPath: rootPath}
+
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		ToolVersion:   options.ToolVersion,
+		CreatedAt:     time.Now(),
+		Config:        options.Config,
+		Sessions:      []ManifestSession{},
+	}
+
+	list, err := sessions.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, session := range list {
+		rec, err := sessions.Recorder(session.Name)
+		if err != nil {
+			return nil, err
+		}
+		relPath, err := filepath.Rel(rootPath, rec.RootPath)
+		if err != nil {
+			return nil, err
+		}
+		if relPath == "." {
+			relPath = ""
+		}
+		manifest.Sessions = append(manifest.Sessions, ManifestSession{
+			Session: session,
+			Path:    filepath.ToSlash(relPath),
+		})
+	}
+	manifest.Active, _ = sessions.Active()
+
+	paths, err := recordingFiles(rootPath, "")
+	if err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	for _, p := range paths {
+		file, err := packFile(zw, rootPath, p)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, *file)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	mw, err := zw.Create(ManifestName)
+	if err != nil {
+		return nil, err
+	}
+	_, err = mw.Write(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return manifest, zw.Close()
+}
+
+// recordingFiles returns the slash separated paths of the files in a
+// directory of a recording, relative to rootPath.
+func recordingFiles(rootPath string, dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(rootPath, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Request directories only hold files. The root of a record directory
+	// and session directories hold request directories and session
+	// metadata, and the root also holds the sessions directory.
+	isRequestDir := strings.HasPrefix(path.Base(dir), "request-")
+
+	var paths []string
+	for _, entry := range entries {
+		name := entry.Name()
+		p := path.Join(dir, name)
+		switch {
+		case isTemporary(name):
+			continue
+		case isRequestDir:
+			if !entry.IsDir() {
+				paths = append(paths, p)
+			}
+			continue
+		case strings.HasPrefix(name, recorder.LockFile) || name == recorder.LostAndFound:
+			continue
+		case dir == "sessions":
+			if !entry.IsDir() {
+				continue
+			}
+		case !recorder.IsRecordingEntry(entry):
+			continue
+		}
+
+		if !entry.IsDir() {
+			paths = append(paths, p)
+			continue
+		}
+		subpaths, err := recordingFiles(rootPath, p)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, subpaths...)
+	}
+	return paths, nil
+}
+
+// isTemporary reports whether a file is a leftover from an atomic write.
+func isTemporary(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, ".tmp-")
+}
+
+func packFile(zw *zip.Writer, rootPath string, p string) (*ManifestFile, error) {
+	f, err := os.Open(filepath.Join(rootPath, filepath.FromSlash(p)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return nil, err
+	}
+	header.Name = p
+	header.Method = zip.Deflate
+
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(w, hash), f)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ManifestFile{
+		Path:   p,
+		Size:   size,
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// Unpack extracts an archive into dest, which must not exist or be empty,
+// verifying every file's checksum.
+func Unpack(archivePath string, dest string) (*Manifest, error) {
+	r, err := Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	entries, err := ioutil.ReadDir(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		// The lock is held by whoever is unpacking.
+		if !strings.HasPrefix(entry.Name(), recorder.LockFile) {
+			return nil, fmt.Errorf("%s is not empty", dest)
+		}
+	}
+
+	for _, file := range r.manifest.Files {
+		content, err := r.readFile(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		p := filepath.Join(dest, filepath.FromSlash(file.Path))
+		err = os.MkdirAll(filepath.Dir(p), 0755)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(p, content, 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return r.manifest, nil
+}
@@ -0,0 +1,130 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+type archiveSuite struct {
+	suite.Suite
+	tmpPath     string
+	recordPath  string
+	archivePath string
+}
+
+func (suite *archiveSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.tmpPath, err = ioutil.TempDir("", "archive")
+	suite.Require().NoError(err)
+	suite.recordPath = filepath.Join(suite.tmpPath, "output")
+	suite.archivePath = filepath.Join(suite.tmpPath, "output.zip")
+
+	// A recording made before sessions existed, plus a named session.
+	legacy := &recorder.Recorder{RootPath: suite.recordPath}
+	suite.Require().NoError(legacy.SaveSnapshot(0, []byte(`{"legacy": 0}`)))
+	suite.Require().NoError(legacy.SaveRequest(1, []byte(`{"operationName": "legacyOperation"}`)))
+	suite.Require().NoError(legacy.SaveResponse(1, []byte(`{}`)))
+
+	sessions := &recorder.Sessions{RootPath: suite.recordPath}
+	_, err = sessions.Create(recorder.Session{Name: "named", Description: "a named session"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(sessions.SetActive("named"))
+	named, err := sessions.Recorder("named")
+	suite.Require().NoError(err)
+	suite.Require().NoError(named.SaveSnapshot(0, []byte(`{"named": 0}`)))
+	suite.Require().NoError(named.SaveRequest(1, []byte(`{"operationName": "namedOperation"}`)))
+	suite.Require().NoError(named.SaveResponse(1, []byte(`{"data": 1}`)))
+	suite.Require().NoError(named.SaveSnapshot(1, []byte(`{"named": 1}`)))
+
+	// None of these should be packed.
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(suite.recordPath, ".DS_Store"), nil, 0644))
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(suite.recordPath, recorder.LockFile), nil, 0644))
+}
+
+func (suite *archiveSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.tmpPath)
+}
+
+func (suite *archiveSuite) pack() *Manifest {
+	var buf bytes.Buffer
+	manifest, err := Pack(suite.recordPath, &buf, PackOptions{ToolVersion: "test"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(ioutil.WriteFile(suite.archivePath, buf.Bytes(), 0644))
+	return manifest
+}
+
+func (suite *archiveSuite) TestPackAndRead() {
+	manifest := suite.pack()
+	suite.Assert().Equal("named", manifest.Active)
+	suite.Require().Len(manifest.Sessions, 2)
+
+	var paths []string
+	for _, file := range manifest.Files {
+		paths = append(paths, file.Path)
+	}
+	suite.Assert().NotContains(paths, ".DS_Store")
+	suite.Assert().NotContains(paths, recorder.LockFile)
+	suite.Assert().Contains(paths, "request-000001/request.txt")
+	suite.Assert().Contains(paths, "sessions/named/request-000001/snapshot.txt")
+
+	r, err := Open(suite.archivePath)
+	suite.Require().NoError(err)
+	defer r.Close()
+	suite.Require().NoError(r.Verify())
+
+	session, err := r.Get("named")
+	suite.Require().NoError(err)
+	suite.Assert().Equal("a named session", session.Description)
+
+	for name, operation := range map[string]string{
+		recorder.LegacySessionName: "legacyOperation",
+		"named":                    "namedOperation",
+	} {
+		loader, err := r.Loader(name)
+		suite.Require().NoError(err)
+		requestIDs, err := loader.GetAllRequestIDs()
+		suite.Require().NoError(err)
+		suite.Assert().Equal([]int{1}, requestIDs)
+		request, err := loader.GetRequest(1)
+		suite.Require().NoError(err)
+		suite.Assert().Contains(string(request), operation)
+	}
+
+	loader, err := r.Loader("named")
+	suite.Require().NoError(err)
+	prior, err := loader.GetPriorSnapshot(1)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(`{"named": 0}`, string(prior))
+	snapshot, err := loader.MaybeGetSnapshot(2)
+	suite.Require().NoError(err)
+	suite.Assert().Nil(snapshot)
+}
+
+func (suite *archiveSuite) TestUnpack() {
+	suite.pack()
+	dest := filepath.Join(suite.tmpPath, "unpacked")
+
+	_, err := Unpack(suite.archivePath, dest)
+	suite.Require().NoError(err)
+
+	sessions := &recorder.Sessions{RootPath: dest}
+	list, err := sessions.List()
+	suite.Require().NoError(err)
+	suite.Require().Len(list, 2)
+	active, err := sessions.Active()
+	suite.Require().NoError(err)
+	suite.Assert().Equal("named", active)
+
+	_, err = Unpack(suite.archivePath, dest)
+	suite.Assert().Error(err, "unpacking into a non-empty directory should fail")
+}
+
+func TestArchive(t *testing.T) {
+	suite.Run(t, new(archiveSuite))
+}
@@ -0,0 +1,285 @@
+package archive
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+// Reader reads a recording from an archive without unpacking it. It
+// implements recorder.SessionLoader, so the tool can serve an archive
+// directly.
+type Reader struct {
+	zr       *zip.ReadCloser
+	manifest *Manifest
+	files    map[string]*zip.File
+	checksum map[string]ManifestFile
+}
+
+// Open opens an archive and reads its manifest. File checksums are verified
+// as files are read.
+func Open(archivePath string) (*Reader, error) {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{
+		zr:       zr,
+		files:    make(map[string]*zip.File),
+		checksum: make(map[string]ManifestFile),
+	}
+	for _, f := range zr.File {
+		r.files[f.Name] = f
+	}
+
+	content, err := r.readUnverified(ManifestName)
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s: no manifest, is this a proxy recorder archive? %w", archivePath, err)
+	}
+	r.manifest = &Manifest{}
+	err = json.Unmarshal(content, r.manifest)
+	if err != nil {
+		zr.Close()
+		return nil, fmt.Errorf("%s: %w", archivePath, err)
+	}
+	if r.manifest.FormatVersion > FormatVersion {
+		zr.Close()
+		return nil, fmt.Errorf(
+			"%s: archive format %d is newer than this proxy recorder supports",
+			archivePath,
+			r.manifest.FormatVersion,
+		)
+	}
+	for _, file := range r.manifest.Files {
+		if path.IsAbs(file.Path) || strings.HasPrefix(path.Clean(file.Path), "..") {
+			zr.Close()
+			return nil, fmt.Errorf("%s: invalid path %s", archivePath, file.Path)
+		}
+		r.checksum[file.Path] = file
+	}
+
+	return r, nil
+}
+
+func (r *Reader) Close() error {
+	return r.zr.Close()
+}
+
+func (r *Reader) Manifest() *Manifest {
+	return r.manifest
+}
+
+// Verify checks the checksums of all files in the archive.
+func (r *Reader) Verify() error {
+	for _, file := range r.manifest.Files {
+		if _, err := r.readFile(file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Reader) List() ([]recorder.Session, error) {
+	var sessions []recorder.Session
+	for _, session := range r.manifest.Sessions {
+		sessions = append(sessions, session.Session)
+	}
+	return sessions, nil
+}
+
+func (r *Reader) Get(name string) (recorder.Session, error) {
+	session, err := r.manifestSession(name)
+	if err != nil {
+		return recorder.Session{}, err
+	}
+	return session.Session, nil
+}
+
+func (r *Reader) Active() (string, error) {
+	if r.manifest.Active == "" {
+		return "", recorder.ErrNoActiveSession
+	}
+	return r.manifest.Active, nil
+}
+
+func (r *Reader) Loader(name string) (recorder.RecorderLoader, error) {
+	session, err := r.manifestSession(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sessionReader{r, session.Path}, nil
+}
+
+func (r *Reader) manifestSession(name string) (*ManifestSession, error) {
+	for _, session := range r.manifest.Sessions {
+		if session.Name == name {
+			return &session, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", recorder.ErrSessionNotFound, name)
+}
+
+// readFile reads a file listed in the manifest and verifies its checksum.
+// Files that aren't in the archive return an error satisfying
+// os.IsNotExist.
+func (r *Reader) readFile(p string) ([]byte, error) {
+	expected, ok := r.checksum[p]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	content, err := r.readUnverified(p)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(content)
+	if hex.EncodeToString(sum[:]) != expected.SHA256 {
+		return nil, fmt.Errorf("%s: checksum mismatch, the archive is corrupt", p)
+	}
+	return content, nil
+}
+
+func (r *Reader) readUnverified(p string) ([]byte, error) {
+	f, ok := r.files[p]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: p, Err: os.ErrNotExist}
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+var requestFileRegex = regexp.MustCompile(`^request-(\d{6})/`)
+
+// sessionReader implements recorder.RecorderLoader for one session in an
+// archive.
+type sessionReader struct {
+	r    *Reader
+	path string
+}
+
+func (s *sessionReader) GetAllRequestIDs() ([]int, error) {
+	seen := make(map[int]bool)
+	var requestIDs []int
+	for _, file := range s.r.manifest.Files {
+		rel := file.Path
+		if s.path != "" {
+			if !strings.HasPrefix(rel, s.path+"/") {
+				continue
+			}
+			rel = strings.TrimPrefix(rel, s.path+"/")
+		}
+		matches := requestFileRegex.FindStringSubmatch(rel)
+		if len(matches) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, err
+		}
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			requestIDs = append(requestIDs, id)
+		}
+	}
+	sort.Ints(requestIDs)
+	return requestIDs, nil
+}
+
+func (s *sessionReader) GetRequest(requestID int) ([]byte, error) {
+	return s.loadFile(requestID, "request.txt")
+}
+
+func (s *sessionReader) GetResponse(requestID int) ([]byte, error) {
+	return s.loadFile(requestID, "response.txt")
+}
+
+func (s *sessionReader) MaybeGetSnapshot(requestID int) ([]byte, error) {
+	snapshot, err := s.loadFile(requestID, "snapshot.txt")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+func (s *sessionReader) MaybeGetPreSnapshot(requestID int) ([]byte, error) {
+	snapshot, err := s.loadFile(requestID, "pre-snapshot.txt")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return snapshot, err
+}
+
+func (s *sessionReader) GetPriorSnapshot(requestID int) ([]byte, error) {
+	return recorder.FindPriorSnapshot(s, requestID)
+}
+
+func (s *sessionReader) MaybeGetMeta(requestID int) (*recorder.RequestMeta, error) {
+	content, err := s.loadFile(requestID, "meta.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta recorder.RequestMeta
+	err = json.Unmarshal(content, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (s *sessionReader) MaybeGetCheckpoint(requestID int) (*recorder.Checkpoint, error) {
+	content, err := s.loadFile(requestID, "checkpoint.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return recorder.ParseCheckpoint(content)
+}
+
+func (s *sessionReader) MaybeGetAnnotation(requestID int) (*recorder.Annotation, error) {
+	content, err := s.loadFile(requestID, "annotations.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return recorder.ParseAnnotation(content)
+}
+
+func (s *sessionReader) HasFile(requestID int, filename string) (bool, error) {
+	_, ok := s.r.checksum[s.filePath(requestID, filename)]
+	return ok, nil
+}
+
+func (s *sessionReader) FormatRequestID(requestID int) string {
+	return fmt.Sprintf("%06d", requestID)
+}
+
+func (s *sessionReader) loadFile(requestID int, filename string) ([]byte, error) {
+	return s.r.readFile(s.filePath(requestID, filename))
+}
+
+func (s *sessionReader) filePath(requestID int, filename string) string {
+	return path.Join(s.path, "request-"+s.FormatRequestID(requestID), filename)
+}
@@ -0,0 +1,212 @@
+// Package config loads the recorder's config file, e.g.
+//
+//	{
+//		"selector": {
+//			"default": "record",
+//			"rules": [
+//				{"operationName": "Log*", "action": "ignore"},
+//				{"operationType": "mutation", "action": "snapshot"}
+//			]
+//		},
+//		"ruleSets": {
+//			"queries only": {"rules": [{"operationType": "mutation", "action": "ignore"}]}
+//		},
+//		"redact": {"headers": ["Authorization", "Cookie"]},
+//		"snapshotter": {"examGroupID": "lsat"},
+//		"detectDrift": true,
+//		"snapshotBefore": true,
+//		"upstream": {
+//			"origin": "http://localhost:8309",
+//			"routes": [{"pathPrefix": "/api/internal/graphql", "origin": "http://localhost:8081"}]
+//		}
+//	}
+//
+// Settings missing from the file keep their defaults, e.g. without
+// "rules" mutations are snapshotted; "rules": [] has no rules. A running
+// proxy recorder reloads the file when it changes, see Watch.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/redact"
+	"github.com/dnerdy/proxyrecorder/pkg/selector"
+)
+
+// DefaultRuleSet is the name of the rule set in a config's Selector.
+const DefaultRuleSet = "default"
+
+// DefaultOrigin is where requests are proxied to by default: the test prep
+// service.
+const DefaultOrigin = "http://localhost:8309"
+
+type Config struct {
+	// Selector decides which requests are recorded and snapshotted.
+	Selector selector.Config `json:"selector"`
+	// RuleSets are more selectors, by name, that can be switched to from
+	// the tool while recording. Unlike Selector, they start without any
+	// rules.
+	RuleSets map[string]selector.Config `json:"ruleSets,omitempty"`
+	// Redact removes secrets from what's recorded.
+	Redact redact.Rules `json:"redact"`
+	// Snapshotter are settings for the snapshotter. What they mean depends
+	// on the snapshotter; the GTP snapshotter reads "webapp", "kaid" and
+	// "examGroupID", which override the command line arguments.
+	Snapshotter map[string]string `json:"snapshotter,omitempty"`
+	// Upstream is where requests are proxied to.
+	Upstream Upstream `json:"upstream"`
+	// DetectDrift takes a snapshot before each snapshotted mutation to
+	// catch changes made to the upstream without a recorded request.
+	DetectDrift bool `json:"detectDrift,omitempty"`
+	// SnapshotBefore also takes a snapshot before each snapshotted request,
+	// so that its diff is exact, and serializes those requests.
+	SnapshotBefore bool `json:"snapshotBefore,omitempty"`
+}
+
+type Upstream struct {
+	// Origin defaults to DefaultOrigin.
+	Origin string `json:"origin,omitempty"`
+	// Routes proxy requests whose paths start with a prefix to other
+	// origins. The first route that matches is used.
+	Routes []Route `json:"routes,omitempty"`
+}
+
+type Route struct {
+	PathPrefix string `json:"pathPrefix"`
+	Origin     string `json:"origin"`
+}
+
+// Default is the config used without a config file: every request is
+// recorded and mutations are snapshotted.
+func Default() Config {
+	return Config{
+		Selector: selector.DefaultConfig(),
+		Upstream: Upstream{Origin: DefaultOrigin},
+	}
+}
+
+// Parse decodes a config and checks it. Unknown settings are errors, so
+// that typos don't go unnoticed.
+func Parse(content []byte) (Config, error) {
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return config, err
+	}
+	config.setDefaults()
+	if _, err := config.NewSelectors(); err != nil {
+		return config, err
+	}
+	if _, err := config.NewRedactor(); err != nil {
+		return config, err
+	}
+	if _, _, err := config.Upstream.Parse(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// setDefaults fills in the settings a config file leaves out. Decoding into
+// a default config instead would merge rules from the file into the
+// default rules.
+func (c *Config) setDefaults() {
+	defaults := Default()
+	if c.Selector.Default == "" {
+		c.Selector.Default = defaults.Selector.Default
+	}
+	if c.Selector.Rules == nil {
+		c.Selector.Rules = defaults.Selector.Rules
+	}
+	if c.Upstream.Origin == "" {
+		c.Upstream.Origin = defaults.Upstream.Origin
+	}
+}
+
+// Load reads and checks a config file.
+func Load(path string) (Config, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	config, err := Parse(content)
+	if err != nil {
+		return config, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
+
+// NewSelector returns the request selector the config describes.
+func (c Config) NewSelector() (*selector.Selector, error) {
+	s, err := selector.New(c.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("selector: %w", err)
+	}
+	return s, nil
+}
+
+// NewSelectors returns the selectors of all of the rule sets, by name,
+// including the default one.
+func (c Config) NewSelectors() (map[string]*selector.Selector, error) {
+	s, err := c.NewSelector()
+	if err != nil {
+		return nil, err
+	}
+	selectors := map[string]*selector.Selector{DefaultRuleSet: s}
+	for name, ruleSet := range c.RuleSets {
+		if name == DefaultRuleSet {
+			return nil, fmt.Errorf("ruleSets: \"%s\" is the name of selector", name)
+		}
+		selectors[name], err = selector.New(ruleSet)
+		if err != nil {
+			return nil, fmt.Errorf("ruleSets: %s: %w", name, err)
+		}
+	}
+	return selectors, nil
+}
+
+// NewRedactor returns the redactor the config describes.
+func (c Config) NewRedactor() (*redact.Redactor, error) {
+	r, err := redact.New(c.Redact)
+	if err != nil {
+		return nil, fmt.Errorf("redact: %w", err)
+	}
+	return r, nil
+}
+
+// Parse parses the origin and the routes' origins.
+func (u Upstream) Parse() (*url.URL, []proxy.Route, error) {
+	origin, err := parseOrigin(u.Origin)
+	if err != nil {
+		return nil, nil, fmt.Errorf("upstream: %w", err)
+	}
+	var routes []proxy.Route
+	for _, route := range u.Routes {
+		if !strings.HasPrefix(route.PathPrefix, "/") {
+			return nil, nil, fmt.Errorf("upstream: route path prefix \"%s\" doesn't start with /", route.PathPrefix)
+		}
+		routeOrigin, err := parseOrigin(route.Origin)
+		if err != nil {
+			return nil, nil, fmt.Errorf("upstream: route %s: %w", route.PathPrefix, err)
+		}
+		routes = append(routes, proxy.Route{PathPrefix: route.PathPrefix, Origin: routeOrigin})
+	}
+	return origin, routes, nil
+}
+
+func parseOrigin(origin string) (*url.URL, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid origin \"%s\", expected e.g. http://localhost:8309", origin)
+	}
+	return u, nil
+}
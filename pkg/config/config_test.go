@@ -0,0 +1,178 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/selector"
+	"github.com/stretchr/testify/suite"
+)
+
+type configSuite struct {
+	suite.Suite
+	tempDir string
+}
+
+func (suite *configSuite) BeforeTest(suiteName, testName string) {
+	tempDir, err := ioutil.TempDir("", "config")
+	suite.Require().NoError(err)
+	suite.tempDir = tempDir
+}
+
+func (suite *configSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.tempDir)
+}
+
+func (suite *configSuite) writeConfig(content string) string {
+	path := filepath.Join(suite.tempDir, "config.json")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func (suite *configSuite) TestLoad() {
+	config, err := Load(suite.writeConfig(`{
+		"selector": {
+			"default": "ignore",
+			"rules": [{"operationName": "get*", "action": "record"}]
+		}
+	}`))
+	suite.Require().NoError(err)
+
+	s, err := config.NewSelector()
+	suite.Require().NoError(err)
+	suite.True(s.ShouldRecordRequest(proxy.GraphQLRequest{OperationName: "getTasks"}))
+	suite.False(s.ShouldRecordRequest(proxy.GraphQLRequest{OperationName: "LogEvent"}))
+}
+
+func (suite *configSuite) TestMissingSettingsKeepDefaults() {
+	config, err := Load(suite.writeConfig(`{}`))
+	suite.Require().NoError(err)
+	suite.Equal(Default(), config)
+
+	config, err = Load(suite.writeConfig(`{"selector": {"default": "ignore"}}`))
+	suite.Require().NoError(err)
+	suite.Equal(selector.Ignore, config.Selector.Default)
+	suite.Equal(selector.DefaultConfig().Rules, config.Selector.Rules)
+}
+
+func (suite *configSuite) TestRuleSets() {
+	config, err := Load(suite.writeConfig(`{
+		"ruleSets": {
+			"queries only": {"rules": [{"operationType": "mutation", "action": "ignore"}]},
+			"nothing": {"default": "ignore"}
+		}
+	}`))
+	suite.Require().NoError(err)
+
+	selectors, err := config.NewSelectors()
+	suite.Require().NoError(err)
+	suite.Len(selectors, 3)
+
+	mutation := proxy.GraphQLRequest{OperationType: proxy.OperationTypeMutation}
+	suite.True(selectors[DefaultRuleSet].ShouldSnapshotRequest(mutation))
+	suite.False(selectors["queries only"].ShouldRecordRequest(mutation))
+	suite.True(selectors["queries only"].ShouldRecordRequest(proxy.GraphQLRequest{OperationType: proxy.OperationTypeQuery}))
+	suite.False(selectors["nothing"].ShouldRecordRequest(proxy.GraphQLRequest{}))
+
+	_, err = Load(suite.writeConfig(`{"ruleSets": {"default": {}}}`))
+	suite.Error(err)
+
+	_, err = Load(suite.writeConfig(`{"ruleSets": {"bad": {"default": "keep"}}}`))
+	suite.Error(err)
+	suite.Contains(err.Error(), "ruleSets: bad: default")
+}
+
+func (suite *configSuite) TestInvalidConfig() {
+	_, err := Load(suite.writeConfig(`{"selectors": {}}`))
+	suite.Error(err)
+
+	_, err = Load(suite.writeConfig(`{"selector": {"rules": [{"action": "keep"}]}}`))
+	suite.Error(err)
+	suite.Contains(err.Error(), "config.json: selector: rule 1")
+
+	_, err = Load(filepath.Join(suite.tempDir, "missing.json"))
+	suite.True(os.IsNotExist(err))
+}
+
+func (suite *configSuite) TestUpstreamAndRedact() {
+	config, err := Load(suite.writeConfig(`{
+		"redact": {"headers": ["Cookie"], "request": ["variables.password"]},
+		"upstream": {"routes": [{"pathPrefix": "/api/", "origin": "http://localhost:8081"}]}
+	}`))
+	suite.Require().NoError(err)
+
+	origin, routes, err := config.Upstream.Parse()
+	suite.Require().NoError(err)
+	suite.Equal(DefaultOrigin, origin.String())
+	suite.Require().Len(routes, 1)
+	suite.Equal("/api/", routes[0].PathPrefix)
+	suite.Equal("localhost:8081", routes[0].Origin.Host)
+
+	_, err = Load(suite.writeConfig(`{"upstream": {"origin": "localhost:8309"}}`))
+	suite.Error(err)
+
+	_, err = Load(suite.writeConfig(`{"upstream": {"routes": [{"pathPrefix": "api", "origin": "http://localhost:8081"}]}}`))
+	suite.Error(err)
+
+	_, err = Load(suite.writeConfig(`{"redact": {"response": [""]}}`))
+	suite.Error(err)
+}
+
+func (suite *configSuite) TestWatch() {
+	path := suite.writeConfig(`{}`)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type change struct {
+		config Config
+		err    error
+	}
+	changes := make(chan change, 10)
+	Watch(ctx, path, 5*time.Millisecond, func(config Config, err error) {
+		changes <- change{config, err}
+	})
+	next := func() change {
+		select {
+		case c := <-changes:
+			return c
+		case <-time.After(5 * time.Second):
+			suite.FailNow("the change wasn't noticed")
+			return change{}
+		}
+	}
+	// Modification times can be coarse, so each write also changes the
+	// size.
+	write := func(content string) {
+		suite.Require().NoError(ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	write(`{"selector": {"default": "ignore"}}`)
+	c := next()
+	suite.Require().NoError(c.err)
+	suite.Equal(selector.Ignore, c.config.Selector.Default)
+
+	write(`{"selector": {"default": "keep"}  }`)
+	suite.Error(next().err)
+
+	write(`{"selector": {"default": "snapshot"}    }`)
+	c = next()
+	suite.Require().NoError(c.err)
+	suite.Equal(selector.Snapshot, c.config.Selector.Default)
+
+	suite.Require().NoError(os.Remove(path))
+	suite.True(os.IsNotExist(next().err))
+	select {
+	case <-changes:
+		suite.Fail("a missing file is only reported once")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConfig(t *testing.T) {
+	suite.Run(t, new(configSuite))
+}
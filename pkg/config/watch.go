@@ -0,0 +1,51 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls a config file every interval until ctx is done, and calls
+// onChange with the reloaded config when the file's modification time or
+// size changes. If the file isn't a valid config, or it's removed,
+// onChange is called with the error instead, once until the file changes
+// again. Watch returns right away; the file is polled, and onChange is
+// called, in another goroutine. Changes made after Watch returns are
+// noticed.
+func Watch(ctx context.Context, path string, interval time.Duration, onChange func(Config, error)) {
+	last, _ := os.Stat(path)
+	go watch(ctx, path, interval, last, onChange)
+}
+
+func watch(ctx context.Context, path string, interval time.Duration, last os.FileInfo, onChange func(Config, error)) {
+	missing := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			// Editors that save by renaming remove the file for a moment,
+			// so it's only reported if it's still missing next time.
+			missing++
+			if last != nil && missing > 1 {
+				last = nil
+				onChange(Config{}, err)
+			}
+			continue
+		}
+		missing = 0
+		if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+		onChange(Load(path))
+	}
+}
@@ -0,0 +1,214 @@
+// Package fsck checks the integrity of a recording and repairs the problems
+// it finds. Broken request directories are moved into the recording's
+// lost+found directory rather than deleted, and gaps in request numbering
+// are closed by renumbering the requests that follow them.
+package fsck
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+type ProblemKind string
+
+const (
+	ProblemMissingInitialSnapshot ProblemKind = "missing-initial-snapshot"
+	ProblemMissingRequest         ProblemKind = "missing-request"
+	ProblemMissingResponse        ProblemKind = "missing-response"
+	ProblemUnparsableRequest      ProblemKind = "unparsable-request"
+	ProblemOrphanedSnapshot       ProblemKind = "orphaned-snapshot"
+	ProblemGap                    ProblemKind = "gap"
+)
+
+type Problem struct {
+	Kind      ProblemKind
+	RequestID int
+	Message   string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%06d %-24s %s", p.RequestID, p.Kind, p.Message)
+}
+
+// Repairable reports whether Repair can fix the problem.
+func (p Problem) Repairable() bool {
+	return p.Kind != ProblemMissingInitialSnapshot
+}
+
+// Check returns the problems found in the recording in request order.
+func Check(rec *recorder.Recorder) ([]Problem, error) {
+	var problems []Problem
+
+	initialSnapshot, err := rec.MaybeGetSnapshot(0)
+	if err != nil {
+		return nil, err
+	}
+	if initialSnapshot == nil {
+		problems = append(problems, Problem{
+			Kind:    ProblemMissingInitialSnapshot,
+			Message: "no initial snapshot, diffs for the first snapshot will be unavailable",
+		})
+	}
+
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	expectedID := 1
+	for _, requestID := range requestIDs {
+		if requestID != expectedID {
+			problems = append(problems, Problem{
+				Kind:      ProblemGap,
+				RequestID: requestID,
+				Message:   fmt.Sprintf("expected request %06d", expectedID),
+			})
+		}
+		expectedID = requestID + 1
+
+		problem, err := checkRequest(rec, requestID)
+		if err != nil {
+			return nil, err
+		}
+		if problem != nil {
+			problems = append(problems, *problem)
+		}
+	}
+
+	return problems, nil
+}
+
+func checkRequest(rec *recorder.Recorder, requestID int) (*Problem, error) {
+	// Checkpoints only have a snapshot, if taking it succeeded.
+	isCheckpoint, err := rec.HasFile(requestID, "checkpoint.json")
+	if err != nil || isCheckpoint {
+		return nil, err
+	}
+	hasRequest, err := rec.HasFile(requestID, "request.txt")
+	if err != nil {
+		return nil, err
+	}
+	hasResponse, err := rec.HasFile(requestID, "response.txt")
+	if err != nil {
+		return nil, err
+	}
+	hasSnapshot, err := rec.HasFile(requestID, "snapshot.txt")
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !hasRequest && !hasResponse && hasSnapshot:
+		return &Problem{
+			Kind:      ProblemOrphanedSnapshot,
+			RequestID: requestID,
+			Message:   "snapshot without a request or response",
+		}, nil
+	case !hasRequest:
+		return &Problem{
+			Kind:      ProblemMissingRequest,
+			RequestID: requestID,
+			Message:   "no request.txt",
+		}, nil
+	case !hasResponse:
+		return &Problem{
+			Kind:      ProblemMissingResponse,
+			RequestID: requestID,
+			Message:   "no response.txt",
+		}, nil
+	}
+
+	request, err := rec.GetRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	_, err = proxy.ParseRequest(request)
+	if err != nil {
+		return &Problem{
+			Kind:      ProblemUnparsableRequest,
+			RequestID: requestID,
+			Message:   err.Error(),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// Repair fixes the given problems, which must come from Check on the same
+// recording. Broken request directories are moved to lost+found, then the
+// remaining requests are renumbered to close any gaps. It returns a
+// description of each action taken.
+func Repair(rec *recorder.Recorder, problems []Problem) ([]string, error) {
+	var actions []string
+
+	renumber := false
+	for _, problem := range problems {
+		switch problem.Kind {
+		case ProblemMissingRequest,
+			ProblemMissingResponse,
+			ProblemUnparsableRequest,
+			ProblemOrphanedSnapshot:
+			dest, err := rec.Quarantine(problem.RequestID)
+			if err != nil {
+				return actions, err
+			}
+			actions = append(actions, fmt.Sprintf(
+				"moved request %s to %s",
+				rec.FormatRequestID(problem.RequestID),
+				dest,
+			))
+			renumber = true
+		case ProblemGap:
+			renumber = true
+		}
+	}
+
+	if !renumber {
+		return actions, nil
+	}
+
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return actions, err
+	}
+	for i, requestID := range requestIDs {
+		// Requests are renumbered in ascending order, so the new ID is
+		// always free.
+		newRequestID := i + 1
+		if requestID == newRequestID {
+			continue
+		}
+		err := rec.Renumber(requestID, newRequestID)
+		if err != nil {
+			return actions, err
+		}
+		actions = append(actions, fmt.Sprintf(
+			"renumbered request %s to %s",
+			rec.FormatRequestID(requestID),
+			rec.FormatRequestID(newRequestID),
+		))
+	}
+
+	return actions, nil
+}
+
+// StrayEntries returns the entries in the recording directory that aren't
+// part of the recording. They're ignored by the proxy recorder, so they
+// aren't problems, but they're worth knowing about.
+func StrayEntries(rec *recorder.Recorder) ([]string, error) {
+	entries, err := ioutil.ReadDir(rec.RootPath)
+	if err != nil {
+		return nil, err
+	}
+	var stray []string
+	for _, entry := range entries {
+		if !recorder.IsRecordingEntry(entry) {
+			stray = append(stray, filepath.Join(rec.RootPath, entry.Name()))
+		}
+	}
+	return stray, nil
+}
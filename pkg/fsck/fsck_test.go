@@ -0,0 +1,136 @@
+package fsck
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+const validRequest = `{"operationName": "someOperation", "query": "query someOperation { field }"}`
+
+type fsckSuite struct {
+	suite.Suite
+	rootPath string
+	rec      *recorder.Recorder
+}
+
+func (suite *fsckSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "fsck")
+	suite.Require().NoError(err)
+	suite.rec = &recorder.Recorder{RootPath: suite.rootPath}
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, []byte(`{}`)))
+}
+
+func (suite *fsckSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *fsckSuite) saveRequest(requestID int, request string) {
+	suite.Require().NoError(suite.rec.SaveRequest(requestID, []byte(request)))
+	suite.Require().NoError(suite.rec.SaveResponse(requestID, []byte(`{}`)))
+}
+
+func (suite *fsckSuite) TestStrayEntriesAreIgnored() {
+	suite.saveRequest(1, validRequest)
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(suite.rootPath, ".DS_Store"), nil, 0644))
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(suite.rootPath, "README"), nil, 0644))
+
+	requestIDs, err := suite.rec.GetAllRequestIDs()
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]int{1}, requestIDs)
+
+	problems, err := Check(suite.rec)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(problems)
+
+	stray, err := StrayEntries(suite.rec)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(
+		[]string{
+			filepath.Join(suite.rootPath, ".DS_Store"),
+			filepath.Join(suite.rootPath, "README"),
+		},
+		stray,
+	)
+}
+
+func (suite *fsckSuite) TestCheckpointsAreValid() {
+	suite.saveRequest(1, validRequest)
+	suite.Require().NoError(suite.rec.SaveCheckpoint(2, recorder.Checkpoint{Label: "cron ran"}))
+	suite.Require().NoError(suite.rec.SaveSnapshot(2, []byte(`{}`)))
+	suite.Require().NoError(suite.rec.SaveCheckpoint(3, recorder.Checkpoint{Label: "snapshot failed"}))
+	suite.saveRequest(4, validRequest)
+
+	problems, err := Check(suite.rec)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(problems)
+}
+
+func (suite *fsckSuite) TestProblemsAreFound() {
+	suite.saveRequest(1, validRequest)
+	suite.Require().NoError(suite.rec.SaveRequest(2, []byte(validRequest)))
+	suite.Require().NoError(suite.rec.SaveResponse(3, []byte(`{}`)))
+	suite.saveRequest(4, `not json`)
+	suite.Require().NoError(suite.rec.SaveSnapshot(5, []byte(`{}`)))
+	suite.saveRequest(7, validRequest)
+
+	problems, err := Check(suite.rec)
+	suite.Require().NoError(err)
+
+	var kinds []ProblemKind
+	var requestIDs []int
+	for _, problem := range problems {
+		kinds = append(kinds, problem.Kind)
+		requestIDs = append(requestIDs, problem.RequestID)
+	}
+	suite.Assert().Equal(
+		[]ProblemKind{
+			ProblemMissingResponse,
+			ProblemMissingRequest,
+			ProblemUnparsableRequest,
+			ProblemOrphanedSnapshot,
+			ProblemGap,
+		},
+		kinds,
+	)
+	suite.Assert().Equal([]int{2, 3, 4, 5, 7}, requestIDs)
+}
+
+func (suite *fsckSuite) TestRepair() {
+	suite.saveRequest(1, validRequest)
+	suite.saveRequest(2, `not json`)
+	suite.saveRequest(3, validRequest)
+	suite.saveRequest(5, `{"operationName": "last", "query": "query last { field }"}`)
+
+	problems, err := Check(suite.rec)
+	suite.Require().NoError(err)
+	suite.Require().Len(problems, 2)
+
+	_, err = Repair(suite.rec, problems)
+	suite.Require().NoError(err)
+
+	problems, err = Check(suite.rec)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(problems)
+
+	requestIDs, err := suite.rec.GetAllRequestIDs()
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]int{1, 2, 3}, requestIDs)
+
+	request, err := suite.rec.GetRequest(3)
+	suite.Require().NoError(err)
+	suite.Assert().Contains(string(request), "last")
+
+	quarantined, err := ioutil.ReadFile(filepath.Join(suite.rootPath, recorder.LostAndFound, "request-000002", "request.txt"))
+	suite.Require().NoError(err)
+	suite.Assert().Equal("not json", string(quarantined))
+}
+
+func TestFsck(t *testing.T) {
+	suite.Run(t, new(fsckSuite))
+}
@@ -0,0 +1,294 @@
+// Package gentest generates Go test source from a recording. Each recorded
+// request becomes a step with its query, variables and expected response,
+// and each snapshot becomes an assertion on what changed since the previous
+// one. The generated test uses net/http/httptest through the
+// proxyrecordertest package and calls functions the test package provides
+// to build the handler under test and take snapshots.
+package gentest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+)
+
+type Options struct {
+	Package  string
+	TestName string
+	// Source describes the recording in the generated header, e.g. the
+	// record directory and session.
+	Source string
+	// HandlerFunc is the name of a func(testing.TB) http.Handler in the test
+	// package that returns the handler under test.
+	HandlerFunc string
+	// SnapshotFunc is the name of a func(testing.TB) []byte in the test
+	// package that returns a JSON snapshot of the handler's state. If it's
+	// empty, snapshots aren't asserted on.
+	SnapshotFunc string
+	// SnapshotType is the content type of the recording's snapshots, see
+	// recorder.Session. They're detected if it's empty. Snapshots are
+	// decoded with SnapshotFormats, or snapshotformat.Default if it's nil,
+	// and the deltas are asserted on as JSON.
+	SnapshotType    string
+	SnapshotFormats *snapshotformat.Registry
+	// Ignore are path patterns for volatile values left out of the
+	// generated assertions.
+	Ignore []string
+}
+
+type step struct {
+	proxy.RequestInfo
+	Path      string
+	Query     string
+	Variables string
+	Response  string
+	// Snapshot is set when the request was followed by a snapshot. Delta
+	// is the JSON list of changes since the previous snapshot, and is empty
+	// when there's no previous snapshot to compare with.
+	Snapshot bool
+	Delta    string
+}
+
+type testData struct {
+	Options
+	InitialSnapshot bool
+	Steps           []step
+}
+
+// Generate returns gofmt'd test source for a recording.
+func Generate(rec recorder.RecorderLoader, options Options) ([]byte, error) {
+	if options.Package == "" || options.TestName == "" || options.HandlerFunc == "" {
+		return nil, fmt.Errorf("a package, test name and handler func are required")
+	}
+	ignore, err := jsonpath.CompileAll(options.Ignore)
+	if err != nil {
+		return nil, err
+	}
+
+	data := testData{Options: options}
+	formats := options.SnapshotFormats
+	if formats == nil {
+		formats = snapshotformat.Default
+	}
+	decodeSnapshot := func(snapshot []byte) (interface{}, error) {
+		value, err := formats.Decode(options.SnapshotType, snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("snapshot can't be decoded, generate without snapshots: %w", err)
+		}
+		return jsonpath.Remove(value, ignore), nil
+	}
+
+	var priorSnapshot interface{}
+	if options.SnapshotFunc != "" {
+		initial, err := rec.MaybeGetSnapshot(0)
+		if err != nil {
+			return nil, err
+		}
+		if initial != nil {
+			priorSnapshot, err = decodeSnapshot(initial)
+			if err != nil {
+				return nil, fmt.Errorf("initial snapshot: %w", err)
+			}
+			data.InitialSnapshot = true
+		}
+	}
+
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, requestID := range requestIDs {
+		// Checkpoints can't be replayed by a test, but later deltas are
+		// relative to their snapshots.
+		checkpoint, err := rec.MaybeGetCheckpoint(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			if options.SnapshotFunc == "" {
+				continue
+			}
+			snapshot, err := rec.MaybeGetSnapshot(requestID)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot != nil {
+				priorSnapshot, err = decodeSnapshot(snapshot)
+				if err != nil {
+					return nil, fmt.Errorf("checkpoint %s: %w", rec.FormatRequestID(requestID), err)
+				}
+			}
+			continue
+		}
+
+		s, err := makeStep(rec, requestID, ignore)
+		if err != nil {
+			return nil, fmt.Errorf("request %s: %w", rec.FormatRequestID(requestID), err)
+		}
+
+		if options.SnapshotFunc != "" {
+			// Requests snapshotted before they were forwarded are diffed
+			// against that snapshot.
+			preSnapshot, err := rec.MaybeGetPreSnapshot(requestID)
+			if err != nil {
+				return nil, err
+			}
+			if preSnapshot != nil {
+				priorSnapshot, err = decodeSnapshot(preSnapshot)
+				if err != nil {
+					return nil, fmt.Errorf("request %s: %w", rec.FormatRequestID(requestID), err)
+				}
+			}
+
+			snapshot, err := rec.MaybeGetSnapshot(requestID)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot != nil {
+				value, err := decodeSnapshot(snapshot)
+				if err != nil {
+					return nil, fmt.Errorf("request %s: %w", rec.FormatRequestID(requestID), err)
+				}
+				s.Snapshot = true
+				if priorSnapshot != nil {
+					delta, err := json.MarshalIndent(jsondiff.Diff(priorSnapshot, value), "", "\t")
+					if err != nil {
+						return nil, err
+					}
+					s.Delta = string(delta)
+				}
+				priorSnapshot = value
+			}
+		}
+
+		data.Steps = append(data.Steps, *s)
+	}
+
+	var b bytes.Buffer
+	err = testTemplate.Execute(&b, data)
+	if err != nil {
+		return nil, err
+	}
+	source, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("formatting generated source: %w\n%s", err, b.Bytes())
+	}
+	return source, nil
+}
+
+func makeStep(rec recorder.RecorderLoader, requestID int, ignore []*jsonpath.Pattern) (*step, error) {
+	content, err := rec.GetRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	graphQLRequest, err := proxy.ParseRequest(content)
+	if err != nil {
+		return nil, err
+	}
+	response, err := rec.GetResponse(requestID)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := rec.MaybeGetMeta(requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/backend-graphql/" + graphQLRequest.OperationName
+	if meta != nil {
+		if u, err := url.Parse(meta.URL); err == nil && u.Path != "" {
+			path = u.RequestURI()
+		}
+	}
+
+	variables := ""
+	if len(graphQLRequest.Variables) > 0 {
+		content, err := json.MarshalIndent(graphQLRequest.Variables, "", "\t")
+		if err != nil {
+			return nil, err
+		}
+		variables = string(content)
+	}
+
+	value, err := jsondiff.Decode(response)
+	if err != nil {
+		return nil, fmt.Errorf("response isn't JSON: %w", err)
+	}
+	expected, err := json.MarshalIndent(jsonpath.Remove(value, ignore), "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	return &step{
+		RequestInfo: proxy.RequestInfo{
+			RequestID:     requestID,
+			OperationType: graphQLRequest.OperationType,
+			OperationName: graphQLRequest.OperationName,
+		},
+		Path:      path,
+		Query:     graphQLRequest.Query,
+		Variables: variables,
+		Response:  string(expected),
+	}, nil
+}
+
+// literal returns a Go string literal for s, preferring a raw string so
+// JSON and GraphQL stay readable.
+func literal(s string) string {
+	if !strings.Contains(s, "`") && !strings.Contains(s, "\r") {
+		return "`" + s + "`"
+	}
+	return strconv.Quote(s)
+}
+
+var testTemplate = template.Must(template.New("test").Funcs(template.FuncMap{
+	"literal": literal,
+	"quote":   strconv.Quote,
+}).Parse(`// Code generated by proxyrecorder gen-test{{if .Source}} from {{.Source}}{{end}}. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxyrecordertest"
+)
+
+func {{.TestName}}(t *testing.T) {
+	r := proxyrecordertest.NewRunner(t, {{.HandlerFunc}}(t){{range .Ignore}}, {{quote .}}{{end}})
+{{- if .InitialSnapshot}}
+	r.Snapshot({{.SnapshotFunc}}(t))
+{{- end}}
+{{range .Steps}}
+	// {{printf "%06d" .RequestID}} {{.OperationType}} {{.OperationName}}
+	r.Send(proxyrecordertest.Step{
+		RequestID:     {{.RequestID}},
+		Path:          {{quote .Path}},
+		OperationName: {{quote .OperationName}},
+		Query:         {{literal .Query}},
+{{- if .Variables}}
+		Variables:     {{literal .Variables}},
+{{- end}}
+		Response:      {{literal .Response}},
+	})
+{{- if .Snapshot}}
+{{- if .Delta}}
+	r.ExpectDelta({{.RequestID}}, {{$.SnapshotFunc}}(t), {{literal .Delta}})
+{{- else}}
+	r.Snapshot({{$.SnapshotFunc}}(t))
+{{- end}}
+{{- end}}
+{{end -}}
+}
+`))
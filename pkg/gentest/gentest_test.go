@@ -0,0 +1,111 @@
+package gentest
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+	"github.com/stretchr/testify/suite"
+)
+
+type gentestSuite struct {
+	suite.Suite
+	rootPath string
+	rec      *recorder.Recorder
+}
+
+func (suite *gentestSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "gentest")
+	suite.Require().NoError(err)
+	suite.rec = &recorder.Recorder{RootPath: suite.rootPath}
+
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, []byte(`{"points": 100, "updatedAt": 1}`)))
+	suite.Require().NoError(suite.rec.SaveRequest(1, []byte(`{
+		"operationName": "addPoints",
+		"query": "mutation addPoints($n: Int!) { addPoints(n: $n) { points } }",
+		"variables": {"n": 10}
+	}`)))
+	suite.Require().NoError(suite.rec.SaveResponse(1, []byte(`{"data": {"addPoints": {"points": 110, "updatedAt": 2}}}`)))
+	suite.Require().NoError(suite.rec.SaveSnapshot(1, []byte(`{"points": 110, "updatedAt": 2}`)))
+}
+
+func (suite *gentestSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *gentestSuite) TestGenerate() {
+	source, err := Generate(suite.rec, Options{
+		Package:      "exam",
+		TestName:     "TestRecordedExam",
+		Source:       "output, session default",
+		HandlerFunc:  "newTestHandler",
+		SnapshotFunc: "takeTestSnapshot",
+		Ignore:       []string{"**.updatedAt"},
+	})
+	suite.Require().NoError(err)
+
+	_, err = parser.ParseFile(token.NewFileSet(), "recorded_test.go", source, 0)
+	suite.Require().NoError(err)
+
+	s := string(source)
+	suite.Contains(s, "// Code generated by proxyrecorder gen-test from output, session default. DO NOT EDIT.")
+	suite.Contains(s, `r := proxyrecordertest.NewRunner(t, newTestHandler(t), "**.updatedAt")`)
+	suite.Contains(s, "r.Snapshot(takeTestSnapshot(t))")
+	suite.Contains(s, "// 000001 mutation addPoints")
+	suite.Contains(s, "Query:         `mutation addPoints($n: Int!) { addPoints(n: $n) { points } }`,")
+	suite.Contains(s, `"n": 10`)
+	suite.Contains(s, `"points": 110`)
+	suite.NotContains(s, `"updatedAt"`)
+	suite.Contains(s, "r.ExpectDelta(1, takeTestSnapshot(t), `[")
+	suite.Contains(s, `"path": "points"`)
+}
+
+func (suite *gentestSuite) TestWithoutSnapshots() {
+	source, err := Generate(suite.rec, Options{
+		Package:     "exam",
+		TestName:    "TestRecordedExam",
+		HandlerFunc: "newTestHandler",
+	})
+	suite.Require().NoError(err)
+	suite.NotContains(string(source), "Snapshot")
+	suite.NotContains(string(source), "ExpectDelta")
+}
+
+func (suite *gentestSuite) TestPickleSnapshots() {
+	initial, err := ioutil.ReadFile("../pickle/testdata/python3-protocol4.pickle")
+	suite.Require().NoError(err)
+	snapshot, err := ioutil.ReadFile("../pickle/testdata/python2-protocol2.pickle")
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, initial))
+	suite.Require().NoError(suite.rec.SaveSnapshot(1, snapshot))
+
+	source, err := Generate(suite.rec, Options{
+		Package:      "exam",
+		TestName:     "TestRecordedExam",
+		HandlerFunc:  "newTestHandler",
+		SnapshotFunc: "takeTestSnapshot",
+		SnapshotType: snapshotformat.Pickle,
+	})
+	suite.Require().NoError(err)
+
+	// Both pickles hold the same snapshot.
+	suite.Contains(string(source), "r.ExpectDelta(1, takeTestSnapshot(t), `[]`)")
+
+	_, err = Generate(suite.rec, Options{
+		Package:      "exam",
+		TestName:     "TestRecordedExam",
+		HandlerFunc:  "newTestHandler",
+		SnapshotFunc: "takeTestSnapshot",
+		SnapshotType: snapshotformat.JSON,
+	})
+	suite.Error(err)
+}
+
+func TestGentest(t *testing.T) {
+	suite.Run(t, new(gentestSuite))
+}
@@ -0,0 +1,165 @@
+package har
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+type ExportOptions struct {
+	CreatorVersion string
+	// IncludeSnapshots adds each request's snapshot to its entry as the
+	// custom _snapshot field.
+	IncludeSnapshots bool
+	// Comment is the comment for the whole log, e.g. the session
+	// description.
+	Comment string
+}
+
+// Export converts a recording to a HAR. Requests recorded before request
+// metadata was saved are exported as POSTs with a 200 response, no headers
+// and no timings. Annotations are exported as entry comments and the custom
+// _tags and _starred fields.
+func Export(rec recorder.RecorderLoader, options ExportOptions) (*HAR, error) {
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []Entry{}
+	for _, requestID := range requestIDs {
+		// Checkpoints aren't HTTP requests.
+		checkpoint, err := rec.MaybeGetCheckpoint(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			continue
+		}
+		entry, err := exportEntry(rec, requestID, options)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return &HAR{
+		Log: Log{
+			Version: "1.2",
+			Creator: Creator{
+				Name:    "proxyrecorder",
+				Version: options.CreatorVersion,
+			},
+			Entries: entries,
+			Comment: options.Comment,
+		},
+	}, nil
+}
+
+func exportEntry(rec recorder.RecorderLoader, requestID int, options ExportOptions) (*Entry, error) {
+	request, err := rec.GetRequest(requestID)
+	if err != nil {
+		return nil, err
+	}
+	graphQLRequest, err := proxy.ParseRequest(request)
+	if err != nil {
+		return nil, err
+	}
+	response, err := rec.GetResponse(requestID)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := rec.MaybeGetMeta(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		meta = &recorder.RequestMeta{
+			Method: http.MethodPost,
+			URL:    "http://localhost:8109/backend-graphql/" + graphQLRequest.OperationName,
+			Status: http.StatusOK,
+		}
+	}
+
+	entry := &Entry{
+		StartedDateTime: meta.SentAt,
+		Request: Request{
+			Method:      meta.Method,
+			URL:         meta.URL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []Cookie{},
+			Headers:     headersToHAR(meta.RequestHeader),
+			QueryString: queryStringToHAR(meta.URL),
+			PostData: &PostData{
+				MimeType: "application/json",
+				Text:     string(request),
+			},
+			HeadersSize: -1,
+			BodySize:    len(request),
+		},
+		Response: Response{
+			Status:      meta.Status,
+			StatusText:  http.StatusText(meta.Status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []Cookie{},
+			Headers:     headersToHAR(meta.ResponseHeader),
+			Content: Content{
+				Size:     len(response),
+				MimeType: contentType(meta.ResponseHeader),
+				Text:     string(response),
+			},
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: Timings{
+			Blocked: -1,
+			DNS:     -1,
+			Connect: -1,
+		},
+		RequestID:     requestID,
+		OperationType: string(graphQLRequest.OperationType),
+		OperationName: graphQLRequest.OperationName,
+	}
+
+	if !meta.SentAt.IsZero() && !meta.CompletedAt.IsZero() {
+		wait := float64(meta.CompletedAt.Sub(meta.SentAt)) / float64(time.Millisecond)
+		entry.Time = wait
+		entry.Timings.Wait = wait
+	}
+
+	annotation, err := rec.MaybeGetAnnotation(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if annotation != nil {
+		entry.Comment = annotation.Notes
+		entry.Tags = annotation.Tags
+		entry.Starred = annotation.Starred
+	}
+
+	if options.IncludeSnapshots {
+		snapshot, err := rec.MaybeGetSnapshot(requestID)
+		if err != nil {
+			return nil, err
+		}
+		entry.Snapshot = string(snapshot)
+	}
+
+	return entry, nil
+}
+
+func contentType(header http.Header) string {
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		return contentType
+	}
+	return "application/json"
+}
+
+func sortNameValues(values []NameValue) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+}
@@ -0,0 +1,150 @@
+// Package har converts recordings to and from HTTP Archive (HAR) 1.2 files,
+// the format browser devtools use to save network activity. Only the parts
+// of the format the proxy recorder needs are modeled.
+package har
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Time is the total time of the request in milliseconds.
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    struct{} `json:"cache"`
+	Timings  Timings  `json:"timings"`
+	Comment  string   `json:"comment,omitempty"`
+
+	// Custom fields, which HAR requires to start with an underscore.
+	RequestID     int    `json:"_requestID,omitempty"`
+	OperationType string `json:"_operationType,omitempty"`
+	OperationName string `json:"_operationName,omitempty"`
+	Snapshot      string `json:"_snapshot,omitempty"`
+	// The request's annotation, with its notes in Comment.
+	Tags    []string `json:"_tags,omitempty"`
+	Starred bool     `json:"_starred,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Cookie struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type Content struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings are in milliseconds. -1 means the timing doesn't apply.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Decoded returns the decoded response body.
+func (c Content) Decoded() ([]byte, error) {
+	if c.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(c.Text)
+	}
+	return []byte(c.Text), nil
+}
+
+func headersToHAR(header http.Header) []NameValue {
+	headers := []NameValue{}
+	for name, values := range header {
+		for _, value := range values {
+			headers = append(headers, NameValue{name, value})
+		}
+	}
+	sortNameValues(headers)
+	return headers
+}
+
+func headersFromHAR(headers []NameValue) http.Header {
+	header := http.Header{}
+	for _, h := range headers {
+		// HTTP/2 pseudo-headers like :authority aren't real headers.
+		if strings.HasPrefix(h.Name, ":") {
+			continue
+		}
+		header.Add(h.Name, h.Value)
+	}
+	return header
+}
+
+func queryStringToHAR(rawURL string) []NameValue {
+	queryString := []NameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return queryString
+	}
+	for name, values := range u.Query() {
+		for _, value := range values {
+			queryString = append(queryString, NameValue{name, value})
+		}
+	}
+	sortNameValues(queryString)
+	return queryString
+}
@@ -0,0 +1,170 @@
+package har
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+type testRequestSelector struct{}
+
+func (s *testRequestSelector) ShouldRecordRequest(r proxy.GraphQLRequest) bool {
+	return r.OperationName != "ignoredOperation"
+}
+
+func (s *testRequestSelector) ShouldSnapshotRequest(r proxy.GraphQLRequest) bool {
+	return false
+}
+
+type harSuite struct {
+	suite.Suite
+	rootPath string
+	rec      *recorder.Recorder
+}
+
+func (suite *harSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "har")
+	suite.Require().NoError(err)
+	suite.rec = &recorder.Recorder{RootPath: suite.rootPath}
+}
+
+func (suite *harSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *harSuite) TestImport() {
+	content := []byte(`{
+		"log": {
+			"version": "1.2",
+			"creator": {"name": "WebInspector", "version": "537.36"},
+			"entries": [
+				{
+					"startedDateTime": "2020-08-01T12:00:01.000Z",
+					"time": 20,
+					"request": {
+						"method": "POST",
+						"url": "https://www.khanacademy.org/api/internal/graphql/secondOperation",
+						"headers": [{"name": ":authority", "value": "www.khanacademy.org"}],
+						"postData": {"mimeType": "application/json", "text": "{\"operationName\": \"secondOperation\", \"query\": \"mutation secondOperation { field }\"}"}
+					},
+					"response": {
+						"status": 200,
+						"headers": [{"name": "content-encoding", "value": "gzip"}],
+						"content": {"size": 12, "mimeType": "application/json", "text": "eyJkYXRhIjogMn0=", "encoding": "base64"}
+					}
+				},
+				{
+					"startedDateTime": "2020-08-01T12:00:00.000Z",
+					"time": 10,
+					"request": {
+						"method": "POST",
+						"url": "https://www.khanacademy.org/api/internal/graphql/firstOperation",
+						"postData": {"mimeType": "application/json", "text": "{\"operationName\": \"firstOperation\", \"query\": \"query firstOperation { field }\"}"}
+					},
+					"response": {
+						"status": 200,
+						"content": {"size": 11, "mimeType": "application/json", "text": "{\"data\": 1}"}
+					}
+				},
+				{
+					"startedDateTime": "2020-08-01T12:00:02.000Z",
+					"request": {
+						"method": "POST",
+						"url": "https://www.khanacademy.org/api/internal/graphql/ignoredOperation",
+						"postData": {"mimeType": "application/json", "text": "{\"operationName\": \"ignoredOperation\", \"query\": \"query ignoredOperation { field }\"}"}
+					},
+					"response": {"status": 200, "content": {"text": "{}"}}
+				},
+				{
+					"startedDateTime": "2020-08-01T12:00:03.000Z",
+					"request": {"method": "GET", "url": "https://www.khanacademy.org/"},
+					"response": {"status": 200, "content": {"text": "<html></html>"}}
+				}
+			]
+		}
+	}`)
+	var archive HAR
+	suite.Require().NoError(json.Unmarshal(content, &archive))
+
+	imported, err := Import(&archive, suite.rec, &testRequestSelector{})
+	suite.Require().NoError(err)
+	suite.Assert().Equal(2, imported)
+
+	request, err := suite.rec.GetRequest(1)
+	suite.Require().NoError(err)
+	suite.Assert().Contains(string(request), "firstOperation")
+
+	response, err := suite.rec.GetResponse(2)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(`{"data": 2}`, string(response))
+
+	meta, err := suite.rec.MaybeGetMeta(2)
+	suite.Require().NoError(err)
+	suite.Assert().Equal(20*time.Millisecond, meta.CompletedAt.Sub(meta.SentAt))
+	suite.Assert().Empty(meta.RequestHeader)
+	suite.Assert().Empty(meta.ResponseHeader.Get("Content-Encoding"))
+}
+
+func (suite *harSuite) TestExport() {
+	sentAt := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, []byte(`{"initial": true}`)))
+	suite.Require().NoError(suite.rec.SaveRequest(1, []byte(`{"operationName": "someMutation", "query": "mutation someMutation { field }"}`)))
+	suite.Require().NoError(suite.rec.SaveResponse(1, []byte(`{"data": {}}`)))
+	suite.Require().NoError(suite.rec.SaveSnapshot(1, []byte(`{"after": true}`)))
+	suite.Require().NoError(suite.rec.SaveMeta(1, recorder.RequestMeta{
+		Method:         http.MethodPost,
+		URL:            "http://localhost:8109/backend-graphql/someMutation?lang=en",
+		RequestHeader:  http.Header{"Cookie": {"KAID=1"}},
+		Status:         http.StatusOK,
+		ResponseHeader: http.Header{"Content-Type": {"application/json; charset=utf-8"}},
+		SentAt:         sentAt,
+		CompletedAt:    sentAt.Add(250 * time.Millisecond),
+	}))
+
+	archive, err := Export(suite.rec, ExportOptions{CreatorVersion: "test", IncludeSnapshots: true})
+	suite.Require().NoError(err)
+	suite.Require().Len(archive.Log.Entries, 1)
+
+	entry := archive.Log.Entries[0]
+	suite.Assert().Equal(sentAt, entry.StartedDateTime)
+	suite.Assert().Equal(250.0, entry.Time)
+	suite.Assert().Equal([]NameValue{{"lang", "en"}}, entry.Request.QueryString)
+	suite.Assert().Equal([]NameValue{{"Cookie", "KAID=1"}}, entry.Request.Headers)
+	suite.Assert().Equal("application/json; charset=utf-8", entry.Response.Content.MimeType)
+	suite.Assert().Equal(`{"data": {}}`, entry.Response.Content.Text)
+	suite.Assert().Equal("mutation", entry.OperationType)
+	suite.Assert().Equal(`{"after": true}`, entry.Snapshot)
+}
+
+func (suite *harSuite) TestAnnotationsRoundTrip() {
+	annotation := recorder.Annotation{Notes: "returns stale data", Tags: []string{"bug"}, Starred: true}
+	suite.Require().NoError(suite.rec.SaveRequest(1, []byte(`{"operationName": "someQuery", "query": "query someQuery { field }"}`)))
+	suite.Require().NoError(suite.rec.SaveResponse(1, []byte(`{"data": {}}`)))
+	suite.Require().NoError(suite.rec.SaveAnnotation(1, annotation))
+
+	archive, err := Export(suite.rec, ExportOptions{})
+	suite.Require().NoError(err)
+	suite.Require().Len(archive.Log.Entries, 1)
+	suite.Equal("returns stale data", archive.Log.Entries[0].Comment)
+
+	imported := &recorder.Recorder{RootPath: filepath.Join(suite.rootPath, "imported")}
+	suite.Require().NoError(os.Mkdir(imported.RootPath, 0755))
+	_, err = Import(archive, imported, &testRequestSelector{})
+	suite.Require().NoError(err)
+	saved, err := imported.MaybeGetAnnotation(1)
+	suite.Require().NoError(err)
+	suite.Equal(&annotation, saved)
+}
+
+func TestHAR(t *testing.T) {
+	suite.Run(t, new(harSuite))
+}
@@ -0,0 +1,107 @@
+package har
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+// Import saves the GraphQL requests in a HAR that the selector would record
+// into rec, in the order they were sent, and returns how many were
+// imported. Entries that aren't GraphQL requests are skipped. Snapshots
+// can't be recreated from a HAR, so imported recordings don't have any.
+// Entry comments, tags and stars are imported as annotations if rec can
+// save them.
+func Import(h *HAR, rec recorder.RecorderSaver, selector proxy.RequestSelector) (int, error) {
+	entries := make([]Entry, len(h.Log.Entries))
+	copy(entries, h.Log.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].StartedDateTime.Before(entries[j].StartedDateTime)
+	})
+
+	requestID, err := rec.NextRequestID()
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, entry := range entries {
+		if entry.Request.Method != http.MethodPost || entry.Request.PostData == nil {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil || !proxy.IsGraphQLPath(u.Path) {
+			continue
+		}
+
+		request := []byte(entry.Request.PostData.Text)
+		graphQLRequest, err := proxy.ParseRequest(request)
+		if err != nil {
+			// Batched requests and other non-standard payloads.
+			continue
+		}
+		graphQLRequest.Path = u.Path
+		graphQLRequest.Header = headersFromHAR(entry.Request.Headers)
+		if !selector.ShouldRecordRequest(graphQLRequest) {
+			continue
+		}
+
+		response, err := entry.Response.Content.Decoded()
+		if err != nil {
+			return imported, fmt.Errorf("response for %s: %w", entry.Request.URL, err)
+		}
+
+		err = importEntry(rec, requestID, entry, request, response)
+		if err != nil {
+			return imported, err
+		}
+		requestID++
+		imported++
+	}
+
+	return imported, nil
+}
+
+func importEntry(rec recorder.RecorderSaver, requestID int, entry Entry, request []byte, response []byte) error {
+	err := rec.SaveRequest(requestID, request)
+	if err != nil {
+		return err
+	}
+	err = rec.SaveResponse(requestID, response)
+	if err != nil {
+		return err
+	}
+
+	responseHeader := headersFromHAR(entry.Response.Headers)
+	// The response is saved decoded, so its content encoding no longer
+	// applies.
+	responseHeader.Del("Content-Encoding")
+
+	err = rec.SaveMeta(requestID, recorder.RequestMeta{
+		Method:         entry.Request.Method,
+		URL:            entry.Request.URL,
+		RequestHeader:  headersFromHAR(entry.Request.Headers),
+		Status:         entry.Response.Status,
+		ResponseHeader: responseHeader,
+		SentAt:         entry.StartedDateTime,
+		CompletedAt:    entry.StartedDateTime.Add(time.Duration(entry.Time * float64(time.Millisecond))),
+	})
+	if err != nil {
+		return err
+	}
+
+	annotation := recorder.Annotation{
+		Notes:   entry.Comment,
+		Tags:    entry.Tags,
+		Starred: entry.Starred,
+	}
+	if editor, ok := rec.(recorder.RequestEditor); ok && !annotation.IsEmpty() {
+		return editor.SaveAnnotation(requestID, annotation)
+	}
+	return nil
+}
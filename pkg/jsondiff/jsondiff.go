@@ -0,0 +1,165 @@
+// Package jsondiff computes the structural differences between two decoded
+// JSON values. Objects are compared key by key and arrays index by index,
+// so unlike a text diff, formatting and key order don't matter.
+package jsondiff
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change is a single difference. Path is dot separated, with array indices
+// as decimal numbers, and is empty for the root value.
+type Change struct {
+	Path   string      `json:"path"`
+	Kind   ChangeKind  `json:"kind"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Diff returns the changes that turn before into after, ordered by path.
+func Diff(before, after interface{}) []Change {
+	changes := []Change{}
+	diff(nil, before, after, &changes)
+	return changes
+}
+
+// Decode decodes JSON into the generic representation Diff works on.
+// Numbers are decoded as json.Number so large IDs compare exactly.
+func Decode(content []byte) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(string(content)))
+	decoder.UseNumber()
+	var value interface{}
+	err := decoder.Decode(&value)
+	return value, err
+}
+
+func diff(path []string, before, after interface{}, changes *[]Change) {
+	switch b := before.(type) {
+	case map[string]interface{}:
+		a, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(b)+len(a))
+		for key := range b {
+			keys = append(keys, key)
+		}
+		for key := range a {
+			if _, ok := b[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := append(path[:len(path):len(path)], key)
+			bv, inBefore := b[key]
+			av, inAfter := a[key]
+			switch {
+			case !inAfter:
+				*changes = append(*changes, Change{Path: join(childPath), Kind: ChangeRemoved, Before: bv})
+			case !inBefore:
+				*changes = append(*changes, Change{Path: join(childPath), Kind: ChangeAdded, After: av})
+			default:
+				diff(childPath, bv, av, changes)
+			}
+		}
+		return
+	case []interface{}:
+		a, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(b) || i < len(a); i++ {
+			childPath := append(path[:len(path):len(path)], strconv.Itoa(i))
+			switch {
+			case i >= len(a):
+				*changes = append(*changes, Change{Path: join(childPath), Kind: ChangeRemoved, Before: b[i]})
+			case i >= len(b):
+				*changes = append(*changes, Change{Path: join(childPath), Kind: ChangeAdded, After: a[i]})
+			default:
+				diff(childPath, b[i], a[i], changes)
+			}
+		}
+		return
+	}
+
+	if !Equal(before, after) {
+		*changes = append(*changes, Change{Path: join(path), Kind: ChangeChanged, Before: before, After: after})
+	}
+}
+
+// Equal reports whether two decoded JSON values are the same. Numbers are
+// compared by value, whether they were decoded as float64 or json.Number.
+func Equal(a, b interface{}) bool {
+	an, aIsNumber := number(a)
+	bn, bIsNumber := number(b)
+	if aIsNumber && bIsNumber {
+		return an == bn
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func number(v interface{}) (string, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		if err != nil {
+			return n.String(), true
+		}
+		return strconv.FormatFloat(f, 'g', -1, 64), true
+	case float64:
+		return strconv.FormatFloat(n, 'g', -1, 64), true
+	}
+	return "", false
+}
+
+// EqualChanges reports whether two lists of changes describe the same
+// differences.
+func EqualChanges(a, b []Change) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Kind != b[i].Kind {
+			return false
+		}
+		if !Equal(a[i].Before, b[i].Before) || !Equal(a[i].After, b[i].After) {
+			return false
+		}
+	}
+	return true
+}
+
+// Subtract returns the changes in a that aren't in b.
+func Subtract(a, b []Change) []Change {
+	var result []Change
+	for _, change := range a {
+		found := false
+		for _, other := range b {
+			if EqualChanges([]Change{change}, []Change{other}) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+func join(path []string) string {
+	return strings.Join(path, ".")
+}
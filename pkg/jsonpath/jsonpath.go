@@ -0,0 +1,193 @@
+// Package jsonpath matches locations in decoded JSON values against simple
+// dot separated patterns, e.g. "data.user.id", "data.items.*.createdAt" or
+// "**.lastModified". A path is the list of object keys and array indices
+// (as decimal strings) leading to a value. In a pattern, "*" matches any
+// single key or index and "**" matches any number of them, including none.
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Pattern struct {
+	raw      string
+	segments []string
+}
+
+func Compile(pattern string) (*Pattern, error) {
+	if strings.TrimSpace(pattern) == "" {
+		return nil, fmt.Errorf("empty path pattern")
+	}
+	segments := Split(pattern)
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path pattern \"%s\", empty segment", pattern)
+		}
+	}
+	return &Pattern{raw: pattern, segments: segments}, nil
+}
+
+func MustCompile(pattern string) *Pattern {
+	p, err := Compile(pattern)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+// CompileAll compiles a list of patterns.
+func CompileAll(patterns []string) ([]*Pattern, error) {
+	compiled := make([]*Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		p, err := Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// Match reports whether the pattern matches a path.
+func (p *Pattern) Match(path []string) bool {
+	return match(p.segments, path)
+}
+
+func match(segments []string, path []string) bool {
+	if len(segments) == 0 {
+		return len(path) == 0
+	}
+	if segments[0] == "**" {
+		for i := 0; i <= len(path); i++ {
+			if match(segments[1:], path[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(path) == 0 {
+		return false
+	}
+	if segments[0] != "*" && segments[0] != path[0] {
+		return false
+	}
+	return match(segments[1:], path[1:])
+}
+
+// MatchAny reports whether any of the patterns match a path.
+func MatchAny(patterns []*Pattern, path []string) bool {
+	for _, p := range patterns {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split splits a dot separated path into its segments.
+func Split(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// Join joins path segments with dots.
+func Join(path []string) string {
+	return strings.Join(path, ".")
+}
+
+// Get returns the value at a path, and whether it exists.
+func Get(value interface{}, path []string) (interface{}, bool) {
+	for _, segment := range path {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			var ok bool
+			value, ok = v[segment]
+			if !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// Transform returns a copy of value in which every value at a path matching
+// one of the patterns is replaced with the result of fn. If fn returns
+// false the value is removed instead. Values are visited parents first, and
+// the children of a replaced value aren't visited.
+func Transform(
+	value interface{},
+	patterns []*Pattern,
+	fn func(path []string, value interface{}) (interface{}, bool),
+) interface{} {
+	if len(patterns) == 0 {
+		return value
+	}
+	result, _ := transform(nil, value, patterns, fn)
+	return result
+}
+
+func transform(
+	path []string,
+	value interface{},
+	patterns []*Pattern,
+	fn func(path []string, value interface{}) (interface{}, bool),
+) (interface{}, bool) {
+	if len(path) > 0 && MatchAny(patterns, path) {
+		return fn(path, value)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			childResult, keep := transform(appendPath(path, key), child, patterns, fn)
+			if keep {
+				result[key] = childResult
+			}
+		}
+		return result, true
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for i, child := range v {
+			childResult, keep := transform(appendPath(path, strconv.Itoa(i)), child, patterns, fn)
+			if keep {
+				result = append(result, childResult)
+			}
+		}
+		return result, true
+	}
+	return value, true
+}
+
+// Remove returns a copy of value without the values at paths matching any
+// of the patterns.
+func Remove(value interface{}, patterns []*Pattern) interface{} {
+	return Transform(value, patterns, func([]string, interface{}) (interface{}, bool) {
+		return nil, false
+	})
+}
+
+// appendPath appends to a copy of path, so that sibling paths don't share
+// a backing array.
+func appendPath(path []string, segment string) []string {
+	result := make([]string, len(path)+1)
+	copy(result, path)
+	result[len(path)] = segment
+	return result
+}
@@ -0,0 +1,320 @@
+// Package normalize removes noise from snapshots before they're diffed.
+// Rules drop values, mask values that change on every write, like
+// timestamps and random IDs, with a placeholder, and sort lists whose order
+// doesn't matter. Rules can be applied when snapshots are stored or only
+// when they're diffed, leaving the stored snapshots untouched.
+package normalize
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+)
+
+// Mask replaces masked values.
+const Mask = "<masked>"
+
+const (
+	// AtStore normalizes snapshots before they're saved.
+	AtStore = "store"
+	// AtDiff saves snapshots as taken and normalizes them when they're
+	// diffed.
+	AtDiff = "diff"
+)
+
+// Rule selects values in a snapshot.
+type Rule struct {
+	// Path is a jsonpath pattern, e.g. "**.lastModified".
+	Path string `json:"path"`
+	// Where restricts the rule to objects whose fields have the given
+	// values, e.g. {"name": "lastModified"} for a datastore property.
+	Where map[string]interface{} `json:"where,omitempty"`
+	// Field, for mask rules, masks one field of the selected objects rather
+	// than the whole value.
+	Field string `json:"field,omitempty"`
+}
+
+// SortRule sorts the lists at Path by the value at By within each item.
+// Without By, items are sorted by their JSON encoding.
+type SortRule struct {
+	Path string `json:"path"`
+	By   string `json:"by,omitempty"`
+}
+
+type Rules struct {
+	Drop []Rule     `json:"drop,omitempty"`
+	Mask []Rule     `json:"mask,omitempty"`
+	Sort []SortRule `json:"sort,omitempty"`
+	// At is AtStore or AtDiff. It defaults to AtDiff, so the rules can be
+	// changed later without losing anything.
+	At string `json:"at,omitempty"`
+}
+
+// RuleProvider is implemented by snapshotters that know which parts of
+// their snapshots are noise.
+type RuleProvider interface {
+	NormalizationRules() Rules
+}
+
+// Merge returns the rules in r followed by the rules in other. other's At
+// takes precedence if it's set.
+func (r Rules) Merge(other Rules) Rules {
+	merged := Rules{
+		Drop: append(append([]Rule{}, r.Drop...), other.Drop...),
+		Mask: append(append([]Rule{}, r.Mask...), other.Mask...),
+		Sort: append(append([]SortRule{}, r.Sort...), other.Sort...),
+		At:   r.At,
+	}
+	if other.At != "" {
+		merged.At = other.At
+	}
+	return merged
+}
+
+// LoadRules reads rules from a JSON file.
+func LoadRules(path string) (Rules, error) {
+	var rules Rules
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return rules, err
+	}
+	err = json.Unmarshal(content, &rules)
+	if err != nil {
+		return rules, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+type compiledRule struct {
+	Rule
+	pattern *jsonpath.Pattern
+}
+
+type compiledSortRule struct {
+	SortRule
+	pattern *jsonpath.Pattern
+	by      []string
+}
+
+// Normalizer applies compiled rules.
+type Normalizer struct {
+	rules Rules
+	drop  []compiledRule
+	mask  []compiledRule
+	sort  []compiledSortRule
+}
+
+func New(rules Rules) (*Normalizer, error) {
+	if rules.At == "" {
+		rules.At = AtDiff
+	}
+	if rules.At != AtStore && rules.At != AtDiff {
+		return nil, fmt.Errorf("invalid at \"%s\", expected %s or %s", rules.At, AtStore, AtDiff)
+	}
+
+	n := &Normalizer{rules: rules}
+	var err error
+	n.drop, err = compileRules(rules.Drop)
+	if err != nil {
+		return nil, err
+	}
+	n.mask, err = compileRules(rules.Mask)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules.Sort {
+		pattern, err := jsonpath.Compile(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		n.sort = append(n.sort, compiledSortRule{rule, pattern, jsonpath.Split(rule.By)})
+	}
+	return n, nil
+}
+
+func compileRules(rules []Rule) ([]compiledRule, error) {
+	var compiled []compiledRule
+	for _, rule := range rules {
+		pattern, err := jsonpath.Compile(rule.Path)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, compiledRule{rule, pattern})
+	}
+	return compiled, nil
+}
+
+func (n *Normalizer) Rules() Rules {
+	return n.rules
+}
+
+// AtStore reports whether snapshots should be normalized before they're
+// saved.
+func (n *Normalizer) AtStore() bool {
+	return n.rules.At == AtStore
+}
+
+// Apply returns a normalized copy of a decoded snapshot.
+func (n *Normalizer) Apply(value interface{}) interface{} {
+	result, _ := n.apply(nil, value)
+	return result
+}
+
+// ApplyJSON normalizes a JSON snapshot. Snapshots that aren't JSON are
+// returned unchanged.
+func (n *Normalizer) ApplyJSON(content []byte) []byte {
+	if n == nil || len(content) == 0 {
+		return content
+	}
+	value, err := jsondiff.Decode(content)
+	if err != nil {
+		return content
+	}
+	normalized, err := json.Marshal(n.Apply(value))
+	if err != nil {
+		return content
+	}
+	return normalized
+}
+
+// apply returns the normalized value and false if it should be dropped.
+func (n *Normalizer) apply(path []string, value interface{}) (interface{}, bool) {
+	if len(path) > 0 {
+		for _, rule := range n.drop {
+			if rule.matches(path, value) {
+				return nil, false
+			}
+		}
+		for _, rule := range n.mask {
+			if !rule.matches(path, value) {
+				continue
+			}
+			if rule.Field == "" {
+				return Mask, true
+			}
+			if m, ok := value.(map[string]interface{}); ok {
+				if _, ok := m[rule.Field]; ok {
+					masked := copyMap(m)
+					masked[rule.Field] = Mask
+					value = masked
+				}
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			childResult, keep := n.apply(appendPath(path, key), child)
+			if keep {
+				result[key] = childResult
+			}
+		}
+		return result, true
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for i, child := range v {
+			childResult, keep := n.apply(appendPath(path, strconv.Itoa(i)), child)
+			if keep {
+				result = append(result, childResult)
+			}
+		}
+		for _, rule := range n.sort {
+			if rule.pattern.Match(path) {
+				sortList(result, rule.by)
+			}
+		}
+		return result, true
+	}
+	return value, true
+}
+
+func (r compiledRule) matches(path []string, value interface{}) bool {
+	if !r.pattern.Match(path) {
+		return false
+	}
+	if len(r.Where) == 0 {
+		return true
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for field, expected := range r.Where {
+		actual, ok := m[field]
+		if !ok || !jsondiff.Equal(actual, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortList(list []interface{}, by []string) {
+	keys := make([]string, len(list))
+	for i, item := range list {
+		key, _ := jsonpath.Get(item, by)
+		content, _ := json.Marshal(key)
+		keys[i] = string(content)
+	}
+	sort.Stable(byKey{list, keys})
+}
+
+type byKey struct {
+	list []interface{}
+	keys []string
+}
+
+func (b byKey) Len() int { return len(b.list) }
+
+// Less compares numbers numerically and everything else by its JSON
+// encoding.
+func (b byKey) Less(i, j int) bool {
+	x, xErr := strconv.ParseFloat(b.keys[i], 64)
+	y, yErr := strconv.ParseFloat(b.keys[j], 64)
+	if xErr == nil && yErr == nil {
+		return x < y
+	}
+	return b.keys[i] < b.keys[j]
+}
+
+func (b byKey) Swap(i, j int) {
+	b.list[i], b.list[j] = b.list[j], b.list[i]
+	b.keys[i], b.keys[j] = b.keys[j], b.keys[i]
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	return result
+}
+
+func appendPath(path []string, segment string) []string {
+	result := make([]string, len(path)+1)
+	copy(result, path)
+	result[len(path)] = segment
+	return result
+}
+
+// Snapshotter normalizes the snapshots another snapshotter takes, for
+// normalizing before snapshots are stored.
+type Snapshotter struct {
+	proxy.Snapshotter
+	Normalizer *Normalizer
+}
+
+func (s *Snapshotter) TakeSnapshot(r proxy.GraphQLRequest) ([]byte, error) {
+	snapshot, err := s.Snapshotter.TakeSnapshot(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.Normalizer.ApplyJSON(snapshot), nil
+}
@@ -0,0 +1,90 @@
+package normalize
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type normalizeSuite struct {
+	suite.Suite
+}
+
+const snapshot = `{
+	"generatedAt": "2020-08-01T12:00:00Z",
+	"task_entities": [
+		{
+			"key": {"kind": "Task", "id": 12},
+			"properties": [
+				{"name": "points", "value": {"type": "int", "value": 10}},
+				{"name": "lastModified", "value": {"type": "datetime", "value": "2020-08-01"}},
+				{"name": "attemptID", "value": {"type": "string", "value": "a8f3"}}
+			]
+		},
+		{
+			"key": {"kind": "Task", "id": 9},
+			"properties": []
+		}
+	]
+}`
+
+func (suite *normalizeSuite) TestApply() {
+	n, err := New(Rules{
+		Drop: []Rule{
+			{Path: "generatedAt"},
+			{Path: "**.properties.*", Where: map[string]interface{}{"name": "attemptID"}},
+		},
+		Mask: []Rule{
+			{Path: "**.properties.*", Where: map[string]interface{}{"name": "lastModified"}, Field: "value"},
+		},
+		Sort: []SortRule{
+			{Path: "task_entities", By: "key.id"},
+			{Path: "**.properties", By: "name"},
+		},
+	})
+	suite.Require().NoError(err)
+	suite.False(n.AtStore())
+
+	suite.JSONEq(`{
+		"task_entities": [
+			{
+				"key": {"kind": "Task", "id": 9},
+				"properties": []
+			},
+			{
+				"key": {"kind": "Task", "id": 12},
+				"properties": [
+					{"name": "lastModified", "value": "<masked>"},
+					{"name": "points", "value": {"type": "int", "value": 10}}
+				]
+			}
+		]
+	}`, string(n.ApplyJSON([]byte(snapshot))))
+}
+
+func (suite *normalizeSuite) TestNotJSON() {
+	n, err := New(Rules{Drop: []Rule{{Path: "generatedAt"}}})
+	suite.Require().NoError(err)
+	suite.Equal([]byte("\x80\x04pickle"), n.ApplyJSON([]byte("\x80\x04pickle")))
+
+	var none *Normalizer
+	suite.Equal([]byte(snapshot), none.ApplyJSON([]byte(snapshot)))
+}
+
+func (suite *normalizeSuite) TestInvalidRules() {
+	_, err := New(Rules{At: "never"})
+	suite.Error(err)
+
+	_, err = New(Rules{Mask: []Rule{{Path: "a..b"}}})
+	suite.Error(err)
+}
+
+func (suite *normalizeSuite) TestMerge() {
+	rules := Rules{Drop: []Rule{{Path: "a"}}, At: AtStore}.Merge(Rules{Drop: []Rule{{Path: "b"}}})
+	suite.Equal([]Rule{{Path: "a"}, {Path: "b"}}, rules.Drop)
+	suite.Equal(AtStore, rules.At)
+}
+
+func TestNormalize(t *testing.T) {
+	suite.Run(t, new(normalizeSuite))
+}
@@ -0,0 +1,1148 @@
+// Package pickle decodes Python pickles into values that can be encoded as
+// JSON, so snapshots written by Python tools like the GTP user data export
+// can be diffed, searched and shown without a Python process.
+//
+// Protocols 0 through 5 are supported, except for persistent IDs, the
+// extension registry and out-of-band buffers. Objects are decoded as JSON
+// objects with a "__class__" field and the fields of their state, except
+// for a few standard library types with a natural JSON form: sets become
+// sorted lists, OrderedDicts become objects and datetimes become ISO 8601
+// strings.
+package pickle
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	opMark           = '('
+	opStop           = '.'
+	opPop            = '0'
+	opPopMark        = '1'
+	opDup            = '2'
+	opFloat          = 'F'
+	opInt            = 'I'
+	opBinInt         = 'J'
+	opBinInt1        = 'K'
+	opLong           = 'L'
+	opBinInt2        = 'M'
+	opNone           = 'N'
+	opReduce         = 'R'
+	opString         = 'S'
+	opBinString      = 'T'
+	opShortBinString = 'U'
+	opUnicode        = 'V'
+	opBinUnicode     = 'X'
+	opAppend         = 'a'
+	opBuild          = 'b'
+	opGlobal         = 'c'
+	opDict           = 'd'
+	opEmptyDict      = '}'
+	opAppends        = 'e'
+	opGet            = 'g'
+	opBinGet         = 'h'
+	opLongBinGet     = 'j'
+	opList           = 'l'
+	opEmptyList      = ']'
+	opPut            = 'p'
+	opBinPut         = 'q'
+	opLongBinPut     = 'r'
+	opSetItem        = 's'
+	opTuple          = 't'
+	opEmptyTuple     = ')'
+	opSetItems       = 'u'
+	opBinFloat       = 'G'
+
+	opProto    = 0x80
+	opNewObj   = 0x81
+	opTuple1   = 0x85
+	opTuple2   = 0x86
+	opTuple3   = 0x87
+	opNewTrue  = 0x88
+	opNewFalse = 0x89
+	opLong1    = 0x8a
+	opLong4    = 0x8b
+
+	opBinBytes      = 'B'
+	opShortBinBytes = 'C'
+
+	opShortBinUnicode = 0x8c
+	opBinUnicode8     = 0x8d
+	opBinBytes8       = 0x8e
+	opEmptySet        = 0x8f
+	opAddItems        = 0x90
+	opFrozenSet       = 0x91
+	opNewObjEx        = 0x92
+	opStackGlobal     = 0x93
+	opMemoize         = 0x94
+	opFrame           = 0x95
+
+	opByteArray8 = 0x96
+)
+
+// IsPickle reports whether content starts like a protocol 2 or later
+// pickle. Older protocols have no header and aren't detected.
+func IsPickle(content []byte) bool {
+	return len(content) >= 2 && content[0] == opProto && content[1] >= 2 && content[1] <= 5
+}
+
+// Decode decodes a pickle of any protocol. The result is made of the types
+// encoding/json decodes into with UseNumber: nil, bool, json.Number,
+// string, []interface{} and map[string]interface{}.
+func Decode(content []byte) (interface{}, error) {
+	input := bytes.NewReader(content)
+	u := &unpickler{
+		r:     bufio.NewReader(input),
+		input: input,
+		memo:  map[int]interface{}{},
+	}
+	value, err := u.load()
+	if err != nil {
+		return nil, fmt.Errorf("pickle: %w", err)
+	}
+	converted, err := newConverter(len(content)).toJSON(value)
+	if err != nil {
+		return nil, fmt.Errorf("pickle: %w", err)
+	}
+	return converted, nil
+}
+
+// ToJSON decodes a pickle and encodes it as canonical JSON, with object
+// keys sorted and no insignificant whitespace.
+func ToJSON(content []byte) ([]byte, error) {
+	value, err := Decode(content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// Canonicalize returns a pickle as canonical JSON. Content that isn't a
+// pickle, or can't be decoded, is returned unchanged.
+func Canonicalize(content []byte) []byte {
+	if !IsPickle(content) {
+		return content
+	}
+	decoded, err := ToJSON(content)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
+// The values built while unpickling. Lists, dicts, sets and objects are
+// pointers because opcodes after the one that creates them can add to them
+// through the memo.
+type (
+	global struct {
+		module string
+		name   string
+	}
+	tuple []interface{}
+	list  struct {
+		items []interface{}
+	}
+	dict struct {
+		keys   []string
+		values map[string]interface{}
+	}
+	set struct {
+		items []interface{}
+	}
+	object struct {
+		class global
+		args  []interface{}
+		state interface{}
+	}
+	mark struct{}
+)
+
+func (g global) String() string {
+	return g.module + "." + g.name
+}
+
+func newDict() *dict {
+	return &dict{values: map[string]interface{}{}}
+}
+
+func (d *dict) set(key, value interface{}) {
+	k := keyString(key)
+	if _, ok := d.values[k]; !ok {
+		d.keys = append(d.keys, k)
+	}
+	d.values[k] = value
+}
+
+type unpickler struct {
+	r *bufio.Reader
+	// input is what r reads from, for checking lengths against what's left
+	input *bytes.Reader
+	stack []interface{}
+	marks []int
+	memo  map[int]interface{}
+}
+
+func (u *unpickler) load() (interface{}, error) {
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("unexpected end of pickle")
+		}
+		if op == opStop {
+			return u.pop()
+		}
+		err = u.dispatch(op)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (u *unpickler) dispatch(op byte) error {
+	switch op {
+	case opProto:
+		_, err := u.r.ReadByte()
+		return err
+	case opFrame:
+		_, err := u.read(8)
+		return err
+
+	case opMark:
+		u.marks = append(u.marks, len(u.stack))
+		u.push(mark{})
+	case opPop:
+		_, err := u.pop()
+		return err
+	case opPopMark:
+		_, err := u.popMark()
+		return err
+	case opDup:
+		top, err := u.top()
+		if err != nil {
+			return err
+		}
+		u.push(top)
+
+	case opNone:
+		u.push(nil)
+	case opNewTrue:
+		u.push(true)
+	case opNewFalse:
+		u.push(false)
+
+	case opInt:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "00":
+			u.push(false)
+		case "01":
+			u.push(true)
+		default:
+			return u.pushInt(line)
+		}
+	case opLong:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		return u.pushInt(strings.TrimSuffix(line, "L"))
+	case opBinInt:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinInt1:
+		b, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		u.push(int64(b))
+	case opBinInt2:
+		b, err := u.read(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong1:
+		n, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return u.pushLong(int(n))
+	case opLong4:
+		n, err := u.readLength(4)
+		if err != nil {
+			return err
+		}
+		return u.pushLong(n)
+
+	case opFloat:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return fmt.Errorf("invalid float %q", line)
+		}
+		u.push(f)
+	case opBinFloat:
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+	case opString:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		s, err := unquote(line)
+		if err != nil {
+			return err
+		}
+		u.push([]byte(s))
+	case opBinString, opBinBytes:
+		return u.pushBytes(4, false)
+	case opShortBinString, opShortBinBytes:
+		return u.pushBytes(1, false)
+	case opBinBytes8, opByteArray8:
+		return u.pushBytes(8, false)
+	case opUnicode:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		s, err := rawUnicodeUnescape(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case opBinUnicode:
+		return u.pushBytes(4, true)
+	case opShortBinUnicode:
+		return u.pushBytes(1, true)
+	case opBinUnicode8:
+		return u.pushBytes(8, true)
+
+	case opEmptyTuple:
+		u.push(tuple{})
+	case opTuple:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(tuple(items))
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(u.stack) < n {
+			return errors.New("stack underflow")
+		}
+		items := append(tuple{}, u.stack[len(u.stack)-n:]...)
+		u.stack = u.stack[:len(u.stack)-n]
+		u.push(items)
+
+	case opEmptyList:
+		u.push(&list{})
+	case opList:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&list{items: items})
+	case opAppend:
+		value, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.appendTo([]interface{}{value})
+	case opAppends:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		return u.appendTo(items)
+
+	case opEmptyDict:
+		u.push(newDict())
+	case opDict:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		d := newDict()
+		err = setItems(d, items)
+		if err != nil {
+			return err
+		}
+		u.push(d)
+	case opSetItem:
+		value, err := u.pop()
+		if err != nil {
+			return err
+		}
+		key, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.setItemsOn([]interface{}{key, value})
+	case opSetItems:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		return u.setItemsOn(items)
+
+	case opEmptySet:
+		u.push(&set{})
+	case opFrozenSet:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&set{items: items})
+	case opAddItems:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		top, err := u.top()
+		if err != nil {
+			return err
+		}
+		s, ok := top.(*set)
+		if !ok {
+			return errors.New("ADDITEMS to a non-set")
+		}
+		s.items = append(s.items, items...)
+
+	case opGlobal:
+		module, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		name, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		u.push(global{module, name})
+	case opStackGlobal:
+		name, err := u.pop()
+		if err != nil {
+			return err
+		}
+		module, err := u.pop()
+		if err != nil {
+			return err
+		}
+		moduleString, ok1 := module.(string)
+		nameString, ok2 := name.(string)
+		if !ok1 || !ok2 {
+			return errors.New("STACK_GLOBAL requires strings")
+		}
+		u.push(global{moduleString, nameString})
+	case opReduce:
+		args, err := u.pop()
+		if err != nil {
+			return err
+		}
+		callable, err := u.pop()
+		if err != nil {
+			return err
+		}
+		value, err := reduce(callable, args)
+		if err != nil {
+			return err
+		}
+		u.push(value)
+	case opNewObj:
+		args, err := u.pop()
+		if err != nil {
+			return err
+		}
+		class, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.pushNewObj(class, args)
+	case opNewObjEx:
+		// Keyword arguments are left out.
+		if _, err := u.pop(); err != nil {
+			return err
+		}
+		args, err := u.pop()
+		if err != nil {
+			return err
+		}
+		class, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.pushNewObj(class, args)
+	case opBuild:
+		state, err := u.pop()
+		if err != nil {
+			return err
+		}
+		top, err := u.top()
+		if err != nil {
+			return err
+		}
+		return build(top, state)
+
+	case opPut:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(line)
+		if err != nil {
+			return fmt.Errorf("invalid memo index %q", line)
+		}
+		return u.put(index)
+	case opBinPut:
+		index, err := u.readLength(1)
+		if err != nil {
+			return err
+		}
+		return u.put(index)
+	case opLongBinPut:
+		index, err := u.readLength(4)
+		if err != nil {
+			return err
+		}
+		return u.put(index)
+	case opMemoize:
+		return u.put(len(u.memo))
+	case opGet:
+		line, err := u.readLine()
+		if err != nil {
+			return err
+		}
+		index, err := strconv.Atoi(line)
+		if err != nil {
+			return fmt.Errorf("invalid memo index %q", line)
+		}
+		return u.get(index)
+	case opBinGet:
+		index, err := u.readLength(1)
+		if err != nil {
+			return err
+		}
+		return u.get(index)
+	case opLongBinGet:
+		index, err := u.readLength(4)
+		if err != nil {
+			return err
+		}
+		return u.get(index)
+
+	default:
+		return fmt.Errorf("unsupported opcode 0x%02x", op)
+	}
+	return nil
+}
+
+func (u *unpickler) push(value interface{}) {
+	u.stack = append(u.stack, value)
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	value, err := u.top()
+	if err != nil {
+		return nil, err
+	}
+	u.stack = u.stack[:len(u.stack)-1]
+	return value, nil
+}
+
+func (u *unpickler) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errors.New("stack underflow")
+	}
+	value := u.stack[len(u.stack)-1]
+	if _, ok := value.(mark); ok {
+		return nil, errors.New("unexpected mark")
+	}
+	return value, nil
+}
+
+// popMark pops the values pushed since the last mark, and the mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	if len(u.marks) == 0 {
+		return nil, errors.New("no mark")
+	}
+	start := u.marks[len(u.marks)-1]
+	u.marks = u.marks[:len(u.marks)-1]
+	if start >= len(u.stack) {
+		return nil, errors.New("mark popped off the stack")
+	}
+	items := append([]interface{}{}, u.stack[start+1:]...)
+	u.stack = u.stack[:start]
+	return items, nil
+}
+
+func (u *unpickler) put(index int) error {
+	top, err := u.top()
+	if err != nil {
+		return err
+	}
+	u.memo[index] = top
+	return nil
+}
+
+func (u *unpickler) get(index int) error {
+	value, ok := u.memo[index]
+	if !ok {
+		return fmt.Errorf("memo index %d not found", index)
+	}
+	u.push(value)
+	return nil
+}
+
+func (u *unpickler) appendTo(items []interface{}) error {
+	top, err := u.top()
+	if err != nil {
+		return err
+	}
+	l, ok := top.(*list)
+	if !ok {
+		return errors.New("APPEND to a non-list")
+	}
+	l.items = append(l.items, items...)
+	return nil
+}
+
+func (u *unpickler) setItemsOn(items []interface{}) error {
+	top, err := u.top()
+	if err != nil {
+		return err
+	}
+	switch target := top.(type) {
+	case *dict:
+		return setItems(target, items)
+	case *object:
+		// e.g. a defaultdict or a dict subclass.
+		state, ok := target.state.(*dict)
+		if !ok {
+			state = newDict()
+			target.state = state
+		}
+		return setItems(state, items)
+	}
+	return errors.New("SETITEM on a non-dict")
+}
+
+func setItems(d *dict, items []interface{}) error {
+	if len(items)%2 != 0 {
+		return errors.New("odd number of dict items")
+	}
+	for i := 0; i < len(items); i += 2 {
+		d.set(items[i], items[i+1])
+	}
+	return nil
+}
+
+func (u *unpickler) pushInt(s string) error {
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		u.push(i)
+		return nil
+	}
+	i, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return fmt.Errorf("invalid int %q", s)
+	}
+	u.push(i)
+	return nil
+}
+
+// pushLong pushes an n byte little-endian two's complement integer.
+func (u *unpickler) pushLong(n int) error {
+	b, err := u.read(n)
+	if err != nil {
+		return err
+	}
+	if n <= 8 {
+		var i int64
+		for j := n - 1; j >= 0; j-- {
+			i = i<<8 | int64(b[j])
+		}
+		if n > 0 && n < 8 && b[n-1]&0x80 != 0 {
+			i -= 1 << (8 * uint(n))
+		}
+		u.push(i)
+		return nil
+	}
+	bigEndian := make([]byte, n)
+	for j := range b {
+		bigEndian[n-1-j] = b[j]
+	}
+	i := new(big.Int).SetBytes(bigEndian)
+	if b[n-1]&0x80 != 0 {
+		i.Sub(i, new(big.Int).Lsh(big.NewInt(1), uint(8*n)))
+	}
+	u.push(i)
+	return nil
+}
+
+// pushBytes reads a length of lengthSize bytes followed by that many bytes,
+// and pushes them as a string if text is true.
+func (u *unpickler) pushBytes(lengthSize int, text bool) error {
+	n, err := u.readLength(lengthSize)
+	if err != nil {
+		return err
+	}
+	b, err := u.read(n)
+	if err != nil {
+		return err
+	}
+	if text {
+		u.push(string(b))
+	} else {
+		u.push(b)
+	}
+	return nil
+}
+
+func (u *unpickler) pushNewObj(class, args interface{}) error {
+	g, ok := class.(global)
+	if !ok {
+		return errors.New("NEWOBJ requires a class")
+	}
+	value, err := reduce(g, args)
+	if err != nil {
+		return err
+	}
+	u.push(value)
+	return nil
+}
+
+// read reads n bytes. Lengths come from the input, so n is checked against
+// what's left of it before anything is allocated, otherwise a few bytes
+// could ask for gigabytes.
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, errors.New("invalid length")
+	}
+	if n > u.remaining() {
+		return nil, errors.New("unexpected end of pickle")
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(u.r, b)
+	if err != nil {
+		return nil, errors.New("unexpected end of pickle")
+	}
+	return b, nil
+}
+
+// remaining returns the number of bytes left to read.
+func (u *unpickler) remaining() int {
+	return u.r.Buffered() + u.input.Len()
+}
+
+// readLength reads a little-endian unsigned length of size bytes.
+func (u *unpickler) readLength(size int) (int, error) {
+	b, err := u.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for i := size - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	if n > math.MaxInt32 {
+		return 0, fmt.Errorf("length %d is too large", n)
+	}
+	return int(n), nil
+}
+
+func (u *unpickler) readLine() (string, error) {
+	line, err := u.r.ReadString('\n')
+	if err != nil {
+		return "", errors.New("unexpected end of pickle")
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+// reduce calls a global with arguments. Known standard library types are
+// built directly, anything else becomes an object.
+func reduce(callable, args interface{}) (interface{}, error) {
+	g, ok := callable.(global)
+	if !ok {
+		return nil, errors.New("REDUCE requires a global")
+	}
+	argList, ok := args.(tuple)
+	if !ok {
+		return nil, errors.New("REDUCE requires a tuple of arguments")
+	}
+
+	switch g.String() {
+	case "_codecs.encode":
+		// Python 3 pickles bytes this way with protocol 2.
+		if len(argList) == 2 {
+			if s, ok := argList[0].(string); ok {
+				return latin1(s), nil
+			}
+		}
+	case "copy_reg._reconstructor", "copyreg._reconstructor":
+		if len(argList) >= 1 {
+			if class, ok := argList[0].(global); ok {
+				return &object{class: class}, nil
+			}
+		}
+	case "__builtin__.set", "builtins.set", "__builtin__.frozenset", "builtins.frozenset":
+		s := &set{}
+		if len(argList) == 1 {
+			if l, ok := argList[0].(*list); ok {
+				s.items = l.items
+			}
+		}
+		return s, nil
+	case "collections.OrderedDict", "__builtin__.dict", "builtins.dict":
+		// Python 2 passes the items as a list of pairs, Python 3 sets them
+		// afterwards.
+		d := newDict()
+		if len(argList) == 1 {
+			if l, ok := argList[0].(*list); ok {
+				for _, item := range l.items {
+					switch pair := item.(type) {
+					case *list:
+						if len(pair.items) == 2 {
+							d.set(pair.items[0], pair.items[1])
+						}
+					case tuple:
+						if len(pair) == 2 {
+							d.set(pair[0], pair[1])
+						}
+					}
+				}
+			}
+		}
+		return d, nil
+	case "collections.defaultdict":
+		return newDict(), nil
+	case "datetime.datetime", "datetime.date", "datetime.time":
+		if s, ok := formatDatetime(g.name, argList); ok {
+			return s, nil
+		}
+	case "decimal.Decimal":
+		if len(argList) == 1 {
+			if s, ok := argList[0].(string); ok {
+				return json.Number(s), nil
+			}
+		}
+	}
+	return &object{class: g, args: argList}, nil
+}
+
+func build(target, state interface{}) error {
+	switch t := target.(type) {
+	case *object:
+		if d, ok := t.state.(*dict); ok {
+			if s, ok := state.(*dict); ok {
+				for _, key := range s.keys {
+					d.set(key, s.values[key])
+				}
+				return nil
+			}
+		}
+		t.state = state
+	case *dict:
+		s, ok := state.(*dict)
+		if !ok {
+			return errors.New("BUILD on a dict requires a dict")
+		}
+		for _, key := range s.keys {
+			t.set(key, s.values[key])
+		}
+	default:
+		return fmt.Errorf("BUILD on %T", target)
+	}
+	return nil
+}
+
+// formatDatetime formats the pickled state of a datetime, date or time,
+// its packed fields, as ISO 8601. Time zones are ignored.
+func formatDatetime(kind string, args tuple) (string, bool) {
+	if len(args) < 1 {
+		return "", false
+	}
+	var b []byte
+	switch a := args[0].(type) {
+	case []byte:
+		b = a
+	case string:
+		// Python 2 datetimes loaded in Python 3 keep their state as text.
+		b = latin1(a)
+	default:
+		return "", false
+	}
+
+	clock := func(b []byte) string {
+		s := fmt.Sprintf("%02d:%02d:%02d", b[0], b[1], b[2])
+		if us := int(b[3])<<16 | int(b[4])<<8 | int(b[5]); us != 0 {
+			s += fmt.Sprintf(".%06d", us)
+		}
+		return s
+	}
+	switch {
+	case kind == "datetime" && len(b) == 10:
+		return fmt.Sprintf("%04d-%02d-%02dT%s", int(b[0])<<8|int(b[1]), b[2], b[3], clock(b[4:])), true
+	case kind == "date" && len(b) == 4:
+		return fmt.Sprintf("%04d-%02d-%02d", int(b[0])<<8|int(b[1]), b[2], b[3]), true
+	case kind == "time" && len(b) == 6:
+		return clock(b), true
+	}
+	return "", false
+}
+
+func latin1(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		b = append(b, byte(r))
+	}
+	return b
+}
+
+// A pickle can refer to the same value more than once through the memo, so
+// a few bytes can describe a cycle, or a value that's exponentially large
+// once it's expanded. The converter limits how deep and how many values it
+// converts.
+const (
+	maxDepth = 1000
+	maxNodes = 1 << 20
+	// maxKeyNodes limits the values converted for each dict key that isn't
+	// a string.
+	maxKeyNodes = 1 << 12
+)
+
+var errTooLarge = errors.New("value is cyclic or too large to convert")
+
+// converter converts unpickled values to the types encoding/json decodes
+// into.
+type converter struct {
+	// nodes is the number of values that can still be converted.
+	nodes int
+	depth int
+}
+
+// newConverter returns a converter for the values in a pickle of size
+// bytes.
+func newConverter(size int) *converter {
+	return &converter{nodes: maxNodes + 16*size}
+}
+
+func (c *converter) toJSON(value interface{}) (interface{}, error) {
+	c.nodes -= 1
+	if c.nodes < 0 || c.depth >= maxDepth {
+		return nil, errTooLarge
+	}
+	c.depth += 1
+	defer func() {
+		c.depth -= 1
+	}()
+
+	switch v := value.(type) {
+	case nil, bool, string, json.Number:
+		return v, nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case *big.Int:
+		return json.Number(v.String()), nil
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64)), nil
+	case []byte:
+		if utf8.Valid(v) {
+			return string(v), nil
+		}
+		return map[string]interface{}{"__bytes__": base64.StdEncoding.EncodeToString(v)}, nil
+	case tuple:
+		return c.listJSON(v)
+	case *list:
+		return c.listJSON(v.items)
+	case *dict:
+		m := make(map[string]interface{}, len(v.values))
+		for key, item := range v.values {
+			converted, err := c.toJSON(item)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+	case *set:
+		items, err := c.listJSON(v.items)
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, len(items))
+		for i, item := range items {
+			keys[i] = keyString(item)
+		}
+		sort.Sort(byKey{keys, items})
+		return items, nil
+	case global:
+		return map[string]interface{}{"__class__": v.String()}, nil
+	case *object:
+		m := map[string]interface{}{"__class__": v.class.String()}
+		if len(v.args) > 0 {
+			args, err := c.listJSON(v.args)
+			if err != nil {
+				return nil, err
+			}
+			m["__args__"] = args
+		}
+		if d, ok := v.state.(*dict); ok {
+			for key, item := range d.values {
+				converted, err := c.toJSON(item)
+				if err != nil {
+					return nil, err
+				}
+				m[key] = converted
+			}
+		} else if v.state != nil {
+			state, err := c.toJSON(v.state)
+			if err != nil {
+				return nil, err
+			}
+			m["__state__"] = state
+		}
+		return m, nil
+	}
+	return fmt.Sprint(value), nil
+}
+
+func (c *converter) listJSON(items []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(items))
+	for i, item := range items {
+		converted, err := c.toJSON(item)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = converted
+	}
+	return result, nil
+}
+
+// keyString returns the JSON object key used for a dict key: strings are
+// used as is and anything else as its JSON encoding.
+func keyString(key interface{}) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case []byte:
+		if utf8.Valid(k) {
+			return string(k)
+		}
+	}
+	converted, err := (&converter{nodes: maxKeyNodes}).toJSON(key)
+	if err != nil {
+		return fmt.Sprint(key)
+	}
+	encoded, err := json.Marshal(converted)
+	if err != nil {
+		return fmt.Sprint(key)
+	}
+	return string(encoded)
+}
+
+type byKey struct {
+	keys  []string
+	items []interface{}
+}
+
+func (s byKey) Len() int           { return len(s.keys) }
+func (s byKey) Less(i, j int) bool { return s.keys[i] < s.keys[j] }
+func (s byKey) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+}
+
+// unquote decodes the repr of a Python 2 str, as written by STRING.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || (s[0] != '\'' && s[0] != '"') || s[len(s)-1] != s[0] {
+		return "", fmt.Errorf("invalid string %q", s)
+	}
+	body := s[1 : len(s)-1]
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		if c != '\\' || i+1 == len(body) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch body[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '\\', '\'', '"':
+			b.WriteByte(body[i])
+		case 'x':
+			if i+3 > len(body) {
+				return "", fmt.Errorf("invalid string %q", s)
+			}
+			n, err := strconv.ParseUint(body[i+1:i+3], 16, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid string %q", s)
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(body[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// rawUnicodeUnescape decodes Python's raw-unicode-escape encoding, as
+// written by UNICODE.
+func rawUnicodeUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (s[i+1] == 'u' || s[i+1] == 'U') {
+			size := 4
+			if s[i+1] == 'U' {
+				size = 8
+			}
+			if i+2+size > len(s) {
+				return "", fmt.Errorf("invalid unicode %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+2:i+2+size], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode %q", s)
+			}
+			b.WriteRune(rune(r))
+			i += 1 + size
+			continue
+		}
+		// Other characters are latin-1.
+		b.WriteRune(rune(s[i]))
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,141 @@
+package pickle
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type pickleSuite struct {
+	suite.Suite
+}
+
+// The testdata pickles are the same snapshot pickled by Python 2 and 3 with
+// several protocols.
+const expected = `{
+	"non_task_entities": [],
+	"object": {"__class__": "__main__.Entity", "id": 12, "kind": "Task"},
+	"ordered": {"a": [1, 2], "z": 1},
+	"task_entities": [
+		{
+			"key": {"type": "key", "value": {"id": 12, "kind": "Task", "name": ""}},
+			"properties": [
+				{"name": "points", "value": {"type": "int", "value": 10}},
+				{"name": "big", "value": {"type": "int", "value": 1180591620717411303424}},
+				{"name": "negative", "value": {"type": "int", "value": -300}},
+				{"name": "ratio", "value": {"type": "float", "value": 0.5}},
+				{"name": "done", "value": {"type": "bool", "value": true}},
+				{"name": "title", "value": {"type": "string", "value": "café ☃"}},
+				{"name": "lastModified", "value": {"type": "datetime", "value": "2020-08-01T12:30:05.000250"}},
+				{"name": "due", "value": {"type": "date", "value": "2020-09-01"}},
+				{"name": "tags", "value": {"type": "list", "value": ["a", "b"]}},
+				{"name": "empty", "value": {"type": "null", "value": null}}
+			]
+		}
+	]
+}`
+
+func (suite *pickleSuite) TestDecode() {
+	paths, err := filepath.Glob("testdata/*.pickle")
+	suite.Require().NoError(err)
+	suite.Require().NotEmpty(paths)
+
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		suite.Require().NoError(err)
+
+		decoded, err := ToJSON(content)
+		if suite.NoError(err, path) {
+			suite.JSONEq(expected, string(decoded), path)
+		}
+	}
+}
+
+func (suite *pickleSuite) TestToJSONIsCanonical() {
+	content, err := ioutil.ReadFile("testdata/python3-protocol4.pickle")
+	suite.Require().NoError(err)
+
+	decoded, err := ToJSON(content)
+	suite.Require().NoError(err)
+
+	var value interface{}
+	decoder := json.NewDecoder(bytes.NewReader(decoded))
+	decoder.UseNumber()
+	suite.Require().NoError(decoder.Decode(&value))
+	reencoded, err := json.Marshal(value)
+	suite.Require().NoError(err)
+	suite.Equal(string(reencoded), string(decoded))
+}
+
+func (suite *pickleSuite) TestIsPickle() {
+	suite.True(IsPickle([]byte("\x80\x04\x95")))
+	suite.True(IsPickle([]byte("\x80\x02}q\x00")))
+	suite.False(IsPickle([]byte(`{"a": 1}`)))
+	suite.False(IsPickle([]byte("\x80\x09")))
+	suite.False(IsPickle(nil))
+}
+
+func (suite *pickleSuite) TestCanonicalize() {
+	suite.Equal([]byte(`{"a": 1}`), Canonicalize([]byte(`{"a": 1}`)))
+	suite.Equal([]byte(`{"a":1}`), Canonicalize([]byte("\x80\x04\x95\n\x00\x00\x00\x00\x00\x00\x00}\x94\x8c\x01a\x94K\x01s.")))
+
+	truncated := []byte("\x80\x04}\x94\x8c\x01a")
+	suite.Equal(truncated, Canonicalize(truncated))
+}
+
+func (suite *pickleSuite) TestErrors() {
+	for _, content := range []string{
+		"",
+		"\x80\x02",
+		"\x80\x02K",
+		"\x80\x02.",
+		"\x80\x02h\x05.",
+		"\x80\x02Q.",
+		// Lengths past the end of the input, which would otherwise be
+		// allocated before the input runs out.
+		"\x80\x02X\xff\xff\xff\x7f",
+		"\x80\x02B\xff\xff\xff\x7f",
+		"\x80\x02\x8b\xff\xff\xff\x7f",
+		"X000z",
+		// A list that contains itself.
+		"\x80\x02]q\x00h\x00a.",
+		// Lists of the previous list, twice, so doubling in size 64 times.
+		"\x80\x02]q\x00" + strings.Repeat("h\x00h\x00\x86q\x00", 64) + ".",
+	} {
+		_, err := Decode([]byte(content))
+		suite.Error(err, "%q", content)
+	}
+}
+
+func TestPickle(t *testing.T) {
+	suite.Run(t, new(pickleSuite))
+}
+
+// FuzzUnpickle checks that Decode returns an error, rather than panicking or
+// running out of memory, however the input is malformed.
+func FuzzUnpickle(f *testing.F) {
+	paths, err := filepath.Glob("testdata/*.pickle")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(content)
+	}
+	f.Add([]byte("\x80\x02X\xff\xff\xff\x7f"))
+	f.Add([]byte("\x80\x02\x8b\xff\xff\xff\x7f"))
+	f.Add([]byte("X000z"))
+
+	f.Fuzz(func(t *testing.T, content []byte) {
+		Decode(content)
+		Canonicalize(content)
+	})
+}
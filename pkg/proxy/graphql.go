@@ -0,0 +1,282 @@
+package proxy
+
+import (
+	"strings"
+)
+
+// graphQLToken is a lexical token of a GraphQL document. Strings are kept
+// only as a placeholder, since their contents never matter here.
+type graphQLToken struct {
+	punct bool
+	text  string
+}
+
+func (t graphQLToken) is(punct string) bool {
+	return t.punct && t.text == punct
+}
+
+func (t graphQLToken) isName(name string) bool {
+	return !t.punct && t.text == name
+}
+
+// tokenizeGraphQL splits a GraphQL document into names, punctuators and
+// placeholders for strings and numbers, skipping whitespace, commas and
+// comments.
+func tokenizeGraphQL(document string) []graphQLToken {
+	var tokens []graphQLToken
+	for i := 0; i < len(document); {
+		c := document[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(document) && document[i] != '\n' && document[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(document[i:], `"""`):
+			end := strings.Index(document[i+3:], `"""`)
+			for end >= 0 && document[i+3+end-1] == '\\' {
+				next := strings.Index(document[i+3+end+1:], `"""`)
+				if next < 0 {
+					end = -1
+					break
+				}
+				end += next + 1
+			}
+			if end < 0 {
+				i = len(document)
+			} else {
+				i += end + 6
+			}
+			tokens = append(tokens, graphQLToken{text: `""`})
+		case c == '"':
+			i++
+			for i < len(document) && document[i] != '"' && document[i] != '\n' {
+				if document[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			i++
+			tokens = append(tokens, graphQLToken{text: `""`})
+		case strings.HasPrefix(document[i:], "..."):
+			tokens = append(tokens, graphQLToken{punct: true, text: "..."})
+			i += 3
+		case isGraphQLNameStart(c):
+			start := i
+			for i < len(document) && (isGraphQLNameStart(document[i]) || isDigit(document[i])) {
+				i++
+			}
+			tokens = append(tokens, graphQLToken{text: document[start:i]})
+		case c == '-' || isDigit(c):
+			// Numbers can't be confused with names, so anything that could
+			// be part of one is consumed.
+			i++
+			for i < len(document) && (isDigit(document[i]) || document[i] == '.' || document[i] == 'e' ||
+				document[i] == 'E' || document[i] == '+' || document[i] == '-') {
+				i++
+			}
+			tokens = append(tokens, graphQLToken{text: "0"})
+		default:
+			tokens = append(tokens, graphQLToken{punct: true, text: string(c)})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isGraphQLNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// selectionSet is the range of tokens between the braces of a selection
+// set.
+type selectionSet struct {
+	start, end int
+}
+
+type graphQLDocument struct {
+	tokens     []graphQLToken
+	operations map[string]selectionSet
+	// operationOrder has the names of the operations in the order they're
+	// defined. Anonymous operations are named "".
+	operationOrder []string
+	fragments      map[string]selectionSet
+}
+
+func parseGraphQLDocument(document string) *graphQLDocument {
+	d := &graphQLDocument{
+		tokens:     tokenizeGraphQL(document),
+		operations: map[string]selectionSet{},
+		fragments:  map[string]selectionSet{},
+	}
+	for i := 0; i < len(d.tokens); {
+		t := d.tokens[i]
+		switch {
+		case t.is("{"):
+			set, next := d.selectionSet(i)
+			d.addOperation("", set)
+			i = next
+		case t.isName("query") || t.isName("mutation") || t.isName("subscription"):
+			i++
+			name := ""
+			if i < len(d.tokens) && !d.tokens[i].punct {
+				name = d.tokens[i].text
+				i++
+			}
+			i = d.skipToSelectionSet(i)
+			set, next := d.selectionSet(i)
+			d.addOperation(name, set)
+			i = next
+		case t.isName("fragment"):
+			name := ""
+			if i+1 < len(d.tokens) {
+				name = d.tokens[i+1].text
+			}
+			i = d.skipToSelectionSet(i + 1)
+			set, next := d.selectionSet(i)
+			d.fragments[name] = set
+			i = next
+		default:
+			// Type system definitions and anything unexpected.
+			i++
+		}
+	}
+	return d
+}
+
+func (d *graphQLDocument) addOperation(name string, set selectionSet) {
+	if _, ok := d.operations[name]; !ok {
+		d.operationOrder = append(d.operationOrder, name)
+	}
+	d.operations[name] = set
+}
+
+// skipToSelectionSet returns the index of the next "{" that isn't in
+// parentheses, e.g. after an operation's variable definitions, whose
+// default values can contain braces.
+func (d *graphQLDocument) skipToSelectionSet(i int) int {
+	for i < len(d.tokens) && !d.tokens[i].is("{") {
+		if d.tokens[i].is("(") {
+			i = d.skipBalanced(i, "(", ")")
+			continue
+		}
+		i++
+	}
+	return i
+}
+
+// skipBalanced returns the index after the token that closes the one at i.
+func (d *graphQLDocument) skipBalanced(i int, open, close string) int {
+	depth := 0
+	for ; i < len(d.tokens); i++ {
+		switch {
+		case d.tokens[i].is(open):
+			depth++
+		case d.tokens[i].is(close):
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
+
+// selectionSet returns the selection set starting at i, and the index
+// after it.
+func (d *graphQLDocument) selectionSet(i int) (selectionSet, int) {
+	if i >= len(d.tokens) {
+		return selectionSet{i, i}, i
+	}
+	end := d.skipBalanced(i, "{", "}")
+	return selectionSet{i + 1, end - 1}, end
+}
+
+// rootFields returns the names of the fields an operation selects at its
+// root, following fragments.
+func (d *graphQLDocument) rootFields(operationName string) []string {
+	set, ok := d.operations[operationName]
+	if !ok {
+		if operationName != "" || len(d.operationOrder) == 0 {
+			return nil
+		}
+		set = d.operations[d.operationOrder[0]]
+	}
+	var fields []string
+	seen := map[string]bool{}
+	d.collectFields(set, &fields, seen, map[string]bool{})
+	return fields
+}
+
+func (d *graphQLDocument) collectFields(set selectionSet, fields *[]string, seen map[string]bool, fragments map[string]bool) {
+	i := set.start
+	for i < set.end {
+		t := d.tokens[i]
+		switch {
+		case t.is("..."):
+			i++
+			if i < set.end && !d.tokens[i].punct && !d.tokens[i].isName("on") {
+				name := d.tokens[i].text
+				i++
+				if fragment, ok := d.fragments[name]; ok && !fragments[name] {
+					fragments[name] = true
+					d.collectFields(fragment, fields, seen, fragments)
+				}
+				i = d.skipDirectives(i, set.end)
+				continue
+			}
+			// An inline fragment, with or without a type condition.
+			for i < set.end && !d.tokens[i].is("{") {
+				i++
+			}
+			inline, next := d.selectionSet(i)
+			d.collectFields(inline, fields, seen, fragments)
+			i = next
+		case !t.punct:
+			name := t.text
+			i++
+			if i < set.end && d.tokens[i].is(":") && i+1 < set.end {
+				name = d.tokens[i+1].text
+				i += 2
+			}
+			if !seen[name] {
+				seen[name] = true
+				*fields = append(*fields, name)
+			}
+			if i < set.end && d.tokens[i].is("(") {
+				i = d.skipBalanced(i, "(", ")")
+			}
+			i = d.skipDirectives(i, set.end)
+			if i < set.end && d.tokens[i].is("{") {
+				i = d.skipBalanced(i, "{", "}")
+			}
+		default:
+			i++
+		}
+	}
+}
+
+func (d *graphQLDocument) skipDirectives(i int, end int) int {
+	for i < end && d.tokens[i].is("@") {
+		i += 2
+		if i < end && d.tokens[i].is("(") {
+			i = d.skipBalanced(i, "(", ")")
+		}
+	}
+	return i
+}
+
+// RootFields returns the names of the fields a GraphQL operation selects at
+// its root, e.g. ["updateTask"] for
+// "mutation Save($id: ID!) { updateTask(id: $id) { id } }". Aliases are
+// resolved to field names, and fields selected through fragments are
+// included. If operationName is empty, the document's first operation is
+// used.
+func RootFields(query string, operationName string) []string {
+	return parseGraphQLDocument(query).rootFields(operationName)
+}
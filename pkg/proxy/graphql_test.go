@@ -0,0 +1,53 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type graphQLSuite struct {
+	suite.Suite
+}
+
+func (suite *graphQLSuite) TestRootFields() {
+	suite.Equal(
+		[]string{"updateTask", "task"},
+		RootFields(`mutation Save($id: ID!, $input: Input = {a: "{"}) {
+			renamed: updateTask(id: $id, input: {title: "} x"}) @include(if: true) { id }
+			# comment { ignored }
+			task(id: $id) { id }
+		}`, "Save"),
+	)
+}
+
+func (suite *graphQLSuite) TestRootFieldsOfNamedOperation() {
+	document := `
+		query First { a }
+		mutation Second { b, c(x: """block " } string""") }
+	`
+	suite.Equal([]string{"a"}, RootFields(document, ""))
+	suite.Equal([]string{"b", "c"}, RootFields(document, "Second"))
+	suite.Nil(RootFields(document, "Missing"))
+	suite.Equal([]string{"a"}, RootFields("{ a { b } }", ""))
+}
+
+func (suite *graphQLSuite) TestRootFieldsFollowFragments() {
+	suite.Equal(
+		[]string{"__typename", "a", "b", "c"},
+		RootFields(`
+			query Q { __typename ...Fields ... on Query { b } ... @skip(if: false) { c } }
+			fragment Fields on Query { a ...Fields }
+		`, "Q"),
+	)
+}
+
+func (suite *graphQLSuite) TestParseRequestSetsRootFields() {
+	graphQLRequest, err := ParseRequest([]byte(`{"operationName": "Save", "query": "mutation Save { updateTask { id } }"}`))
+	suite.Require().NoError(err)
+	suite.Equal([]string{"updateTask"}, graphQLRequest.RootFields)
+}
+
+func TestGraphQL(t *testing.T) {
+	suite.Run(t, new(graphQLSuite))
+}
@@ -5,7 +5,9 @@ package proxy
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -13,16 +15,37 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/redact"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
 )
 
 type RequestInfo struct {
+	Session          string        `json:"session"`
 	RequestID        int           `json:"requestID"`
 	OperationType    OperationType `json:"operationType"`
 	OperationName    string        `json:"operationName"`
 	WillSnapshot     bool          `json:"willSnapshot"`
 	ShapshotComplete bool          `json:"snapshotComplete"`
+	// Checkpoint is the label of a checkpoint, which has a snapshot but no
+	// request, see Handler.Checkpoint. Drift is set on the checkpoints added
+	// by drift detection, see Config.DetectDrift.
+	Checkpoint string `json:"checkpoint,omitempty"`
+	Drift      bool   `json:"drift,omitempty"`
+	// SentAt is when the proxy received the request and CompletedAt is when
+	// it received the response. They aren't set on checkpoints, or on
+	// requests recorded before they were saved.
+	SentAt      *time.Time `json:"sentAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	// Deleted is set on what's left of deleted requests and Annotation on
+	// annotated ones, by the tool; the proxy only records new requests.
+	Deleted    bool                 `json:"deleted,omitempty"`
+	Annotation *recorder.Annotation `json:"annotation,omitempty"`
+	// ReplayOf is set on requests replayed from the tool, see
+	// Handler.Replay.
+	ReplayOf *recorder.ReplayOf `json:"replayOf,omitempty"`
 }
 
 type RequestSelector interface {
@@ -37,21 +60,113 @@ type Reporter interface {
 type Snapshotter interface {
 	TakeSnapshot(r GraphQLRequest) ([]byte, error)
 	SnapshotInfo() string
+	// SnapshotContentType declares the format of the snapshots as a
+	// content type, e.g. "application/json", or returns "" to have it
+	// detected. See the snapshotformat package for the formats that can be
+	// decoded.
+	SnapshotContentType() string
+}
+
+// SnapshotNormalizer normalizes snapshots decoded to JSON, e.g.
+// normalize.Normalizer.
+type SnapshotNormalizer interface {
+	ApplyJSON(content []byte) []byte
+}
+
+// Redactor removes secrets from requests and responses before they're
+// recorded.
+type Redactor interface {
+	RedactHeader(header http.Header) http.Header
+	RedactRequest(content []byte) []byte
+	RedactResponse(content []byte) []byte
+}
+
+// Route proxies requests whose paths start with PathPrefix to another
+// origin.
+type Route struct {
+	PathPrefix string
+	Origin     *url.URL
+}
+
+// Config is what a Handler proxies to and records with. It can be replaced
+// with SetConfig while the handler runs; requests already in flight finish
+// with the config they started with.
+type Config struct {
+	// Host is the Host header sent to Origin.
+	Host   string
+	Origin *url.URL
+	// Routes are tried in order before Origin. Requests proxied by a route
+	// are sent with its origin's host as the Host header.
+	Routes      []Route
+	Selector    RequestSelector
+	Snapshotter Snapshotter
+	// Redactor, if set, redacts what's recorded. The values of
+	// redact.CredentialHeaders are redacted either way.
+	Redactor Redactor
+	// DetectDrift takes a snapshot before each snapshotted mutation is
+	// forwarded and compares it with the previous snapshot. If they differ,
+	// the upstream changed without a recorded request, so the snapshot is
+	// recorded as a drift checkpoint before the mutation and OnDrift, if
+	// set, is called with the session. Otherwise the changes would show up
+	// in the mutation's diff. It needs a recorder that can load snapshots.
+	// Snapshots are compared the way the tool diffs them: decoded from the
+	// snapshotter's format with the session's registry in SnapshotFormats
+	// and normalized with SnapshotNormalizer, if set.
+	DetectDrift        bool
+	OnDrift            func(session string)
+	SnapshotFormats    snapshotformat.Registries
+	SnapshotNormalizer SnapshotNormalizer
+	// SnapshotBefore takes a snapshot before each request selected for a
+	// snapshot is forwarded, as well as after its response, and saves
+	// both, so that the request's diff is exact even if something else
+	// changed the upstream in between.
+	//
+	// With either DetectDrift or SnapshotBefore, the requests selected for a
+	// snapshot are serialized: each waits for the previous one's snapshot
+	// after its response, so concurrent requests from the browser don't end
+	// up in each other's diffs or drift checks. Other requests aren't held
+	// up.
+	SnapshotBefore bool
+}
+
+// snapshotLoader is implemented by recorders that can load the snapshots
+// they've saved, e.g. recorder.Recorder.
+type snapshotLoader interface {
+	GetPriorSnapshot(requestID int) ([]byte, error)
+}
+
+// target returns the origin and Host header a request path is proxied to.
+func (c *Config) target(path string) (*url.URL, string) {
+	for _, route := range c.Routes {
+		if strings.HasPrefix(path, route.PathPrefix) {
+			return route.Origin, route.Origin.Host
+		}
+	}
+	return c.Origin, c.Host
 }
 
 type Handler struct {
-	snapshotter     Snapshotter
 	recorder        recorder.RecorderSaver
-	selector        RequestSelector
 	reporter        Reporter
 	requestInfoChan chan RequestInfo
-	host            string
-	proxyOrigin     *url.URL
+	session         string
+	config          *Config
 	proxy           *httputil.ReverseProxy
 	nextRequestID   int
-	requestContent  map[*http.Request][]byte
-	// Hold when updating nextRequestID or requestContent
+	// Hold when updating recorder, session, config or nextRequestID
 	mu sync.Mutex
+	// Held from before a request's pre snapshot until after its response's
+	// snapshot, see Config.SnapshotBefore
+	serialMu sync.Mutex
+	// Requests are recorded in the order they arrived in, see reserve. Hold
+	// queueMu when updating nextTicket, turn, settled or saves.
+	nextTicket int
+	turn       int
+	settled    map[int]queuedSave
+	saves      []queuedSave
+	queueMu    sync.Mutex
+	// savesReady wakes up the save worker, see runSaves.
+	savesReady chan struct{}
 }
 
 func NewHandler(
@@ -74,16 +189,20 @@ func NewHandler(
 	}
 
 	handler := &Handler{
-		snapshotter:     snapshotter,
 		recorder:        rec,
-		selector:        selector,
 		reporter:        reporter,
 		requestInfoChan: requestInfoChan,
-		host:            host,
-		proxyOrigin:     proxyOrigin,
-		requestContent:  make(map[*http.Request][]byte),
-		nextRequestID:   nextRequestID,
+		config: &Config{
+			Host:        host,
+			Origin:      proxyOrigin,
+			Selector:    selector,
+			Snapshotter: snapshotter,
+		},
+		nextRequestID: nextRequestID,
+		settled:       map[int]queuedSave{},
+		savesReady:    make(chan struct{}, 1),
 	}
+	go handler.runSaves()
 
 	handler.proxy = httputil.NewSingleHostReverseProxy(proxyOrigin)
 	handler.proxy.Director = handler.ProxyDirector
@@ -96,10 +215,104 @@ func NewHandler(
 	return handler, nil
 }
 
+// SetRecorder switches the recorder that requests are saved to, e.g. when
+// the active session changes. Passing a nil recorder stops recording until
+// another recorder is set.
+func (h *Handler) SetRecorder(session string, rec recorder.RecorderSaver) error {
+	nextRequestID := 0
+	if rec != nil {
+		var err error
+		nextRequestID, err = rec.NextRequestID()
+		if err != nil {
+			return err
+		}
+	}
+
+	h.mu.Lock()
+	h.session = session
+	h.recorder = rec
+	h.nextRequestID = nextRequestID
+	h.mu.Unlock()
+
+	return nil
+}
+
+// SetConfig replaces what the handler proxies to and records with.
+func (h *Handler) SetConfig(config Config) {
+	h.mu.Lock()
+	h.config = &config
+	h.mu.Unlock()
+}
+
+// Config returns what the handler currently proxies to and records with.
+func (h *Handler) Config() Config {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return *h.config
+}
+
+// pendingRequest is what the director remembers about a request until its
+// response arrives. It's kept in the request's context because the reverse
+// proxy doesn't hand the response handler the same *http.Request the
+// director saw.
+type pendingRequest struct {
+	content []byte
+	url     string
+	sentAt  time.Time
+	config  *Config
+	// settle records the request, or gives up its place in the recording,
+	// see Handler.reserve.
+	settle func(save func()) <-chan struct{}
+	// preSnapshot is the snapshot taken before the request was forwarded,
+	// when detecting drift or snapshotting before requests. unlock lets the
+	// next serialized request through.
+	preSnapshot []byte
+	unlock      func()
+	// replayOf and saved are set on replayed requests, see Handler.Replay.
+	// saving is set once the request is to be recorded, and saved gets the
+	// result.
+	replayOf *recorder.ReplayOf
+	saving   bool
+	saved    chan savedRequest
+}
+
+// savedRequest is the result of recording a request.
+type savedRequest struct {
+	info RequestInfo
+	err  error
+}
+
+// release gives up the request's place in the recording, if it hasn't been
+// recorded, and lets the next serialized request through, if this one is
+// holding it up. It's called when the request is done, in case it wasn't
+// recorded, e.g. because the upstream couldn't be reached.
+func (p *pendingRequest) release() {
+	if p.settle != nil {
+		p.settle(nil)
+		p.settle = nil
+	}
+	if p.unlock != nil {
+		p.unlock()
+		p.unlock = nil
+	}
+}
+
+type pendingRequestKey struct{}
+
 func (h *Handler) ProxyDirector(req *http.Request) {
-	req.Host = h.host
-	req.URL.Scheme = h.proxyOrigin.Scheme
-	req.URL.Host = h.proxyOrigin.Host
+	sentAt := time.Now()
+	originalURL := *req.URL
+	originalURL.Scheme = "http"
+	originalURL.Host = req.Host
+
+	h.mu.Lock()
+	config := h.config
+	h.mu.Unlock()
+
+	origin, host := config.target(req.URL.Path)
+	req.Host = host
+	req.URL.Scheme = origin.Scheme
+	req.URL.Host = origin.Host
 
 	var content []byte
 
@@ -109,9 +322,172 @@ func (h *Handler) ProxyDirector(req *http.Request) {
 		req.Body = ioutil.NopCloser(bytes.NewReader(content))
 	}
 
-	h.mu.Lock()
-	h.requestContent[req] = content
-	h.mu.Unlock()
+	// ServeHTTP puts the pending request into the context, so that it can
+	// release it however the request ends. Without it, the request's place
+	// in the recording is only reserved once its response arrives.
+	pending, fromServeHTTP := req.Context().Value(pendingRequestKey{}).(*pendingRequest)
+	if pending == nil {
+		pending = &pendingRequest{}
+		*req = *req.WithContext(context.WithValue(req.Context(), pendingRequestKey{}, pending))
+	}
+	pending.content = content
+	pending.url = originalURL.String()
+	pending.sentAt = sentAt
+	pending.config = config
+
+	if !IsGraphQLPath(req.URL.Path) || len(content) == 0 {
+		return
+	}
+	graphQLRequest, err := ParseRequest(content)
+	if err != nil {
+		return
+	}
+	graphQLRequest.Path = req.URL.Path
+	graphQLRequest.Header = req.Header
+	if !config.Selector.ShouldRecordRequest(graphQLRequest) {
+		return
+	}
+
+	// A serialized request reserves its place once it's let through, so
+	// that it can't wait on a request that has a later place.
+	if config.DetectDrift || config.SnapshotBefore {
+		h.takePreSnapshot(graphQLRequest, pending)
+	}
+	if fromServeHTTP {
+		pending.settle = h.reserve()
+	}
+}
+
+// takePreSnapshot waits for the previous serialized request, if the request
+// will be snapshotted, and takes a snapshot of the upstream before it's
+// forwarded. Only mutations are snapshotted before unless
+// Config.SnapshotBefore is set.
+func (h *Handler) takePreSnapshot(graphQLRequest GraphQLRequest, pending *pendingRequest) {
+	config := pending.config
+	if !config.Selector.ShouldSnapshotRequest(graphQLRequest) {
+		return
+	}
+
+	h.serialMu.Lock()
+	pending.unlock = h.serialMu.Unlock
+
+	if !config.SnapshotBefore && graphQLRequest.OperationType != OperationTypeMutation {
+		return
+	}
+
+	snapshot, err := config.Snapshotter.TakeSnapshot(graphQLRequest)
+	if err != nil {
+		h.log("error", fmt.Sprintf("taking a snapshot before %s: %s", graphQLRequest.OperationName, err))
+		return
+	}
+	pending.preSnapshot = snapshot
+}
+
+// reserve gives a request its place in the recording when it arrives, so
+// that requests are recorded, and numbered, in the order the client sent
+// them rather than the order their responses come back in. The returned
+// function settles the place: save, which records the request and takes the
+// next request ID, is run by the save worker once every request reserved
+// before it has been settled. A nil save gives up the place, e.g. because
+// the request wasn't recorded after all, without leaving a gap in the IDs.
+// The returned channel is closed once save has run.
+func (h *Handler) reserve() func(save func()) <-chan struct{} {
+	h.queueMu.Lock()
+	ticket := h.nextTicket
+	h.nextTicket += 1
+	h.queueMu.Unlock()
+
+	return func(save func()) <-chan struct{} {
+		return h.settleTicket(ticket, save)
+	}
+}
+
+// queuedSave is a settled request's save, and the channel closed once it's
+// run.
+type queuedSave struct {
+	save func()
+	done chan struct{}
+}
+
+// settleTicket queues the saves of the requests whose turn it is for the
+// save worker. A request's save is queued right away unless one reserved
+// before it is still in flight, in which case it's queued once that one is
+// settled. Saves never run in the goroutine that settles, and they don't
+// take snapshots, see ProxyResponseHandler, so they're quick.
+func (h *Handler) settleTicket(ticket int, save func()) <-chan struct{} {
+	settled := queuedSave{save: save, done: make(chan struct{})}
+	if save == nil {
+		close(settled.done)
+	}
+
+	h.queueMu.Lock()
+	h.settled[ticket] = settled
+	queued := false
+	for {
+		next, ok := h.settled[h.turn]
+		if !ok {
+			break
+		}
+		delete(h.settled, h.turn)
+		h.turn += 1
+		if next.save != nil {
+			h.saves = append(h.saves, next)
+			queued = true
+		}
+	}
+	h.queueMu.Unlock()
+
+	if queued {
+		select {
+		case h.savesReady <- struct{}{}:
+		default:
+			// The worker is already due to run the queued saves.
+		}
+	}
+	return settled.done
+}
+
+// runSaves is the save worker. It runs the queued saves one at a time, in
+// the order they were queued, for as long as the handler exists.
+func (h *Handler) runSaves() {
+	for range h.savesReady {
+		h.queueMu.Lock()
+		saves := h.saves
+		h.saves = nil
+		h.queueMu.Unlock()
+
+		for _, queued := range saves {
+			queued.save()
+			close(queued.done)
+		}
+	}
+}
+
+// drifted reports whether a snapshot taken before a request differs from
+// the most recent snapshot before the request's ID, once both are decoded
+// and normalized.
+func drifted(session string, rec recorder.RecorderSaver, requestID int, preSnapshot []byte, config *Config) bool {
+	loader, ok := rec.(snapshotLoader)
+	if !ok {
+		return false
+	}
+	prior, err := loader.GetPriorSnapshot(requestID)
+	if err != nil {
+		return false
+	}
+	formats := config.SnapshotFormats.For(session)
+	return !bytes.Equal(comparableSnapshot(formats, prior, config), comparableSnapshot(formats, preSnapshot, config))
+}
+
+// comparableSnapshot returns a snapshot as the tool diffs it: decoded to
+// canonical JSON and normalized. Snapshots that can't be decoded are
+// compared as recorded.
+func comparableSnapshot(formats *snapshotformat.Registry, snapshot []byte, config *Config) []byte {
+	decoded, ok := formats.Readable(config.Snapshotter.SnapshotContentType(), snapshot)
+	if !ok || config.SnapshotNormalizer == nil {
+		return decoded
+	}
+	return config.SnapshotNormalizer.ApplyJSON(decoded)
 }
 
 func (h *Handler) log(label, message string) {
@@ -125,6 +501,11 @@ type GraphQLRequest struct {
 
 	// Set after the operation is parsed
 	OperationType OperationType `json:"-"`
+	RootFields    []string      `json:"-"`
+
+	// Set by the proxy from the HTTP request, for selectors
+	Path   string      `json:"-"`
+	Header http.Header `json:"-"`
 }
 
 func ParseRequest(content []byte) (GraphQLRequest, error) {
@@ -143,6 +524,8 @@ func ParseRequest(content []byte) (GraphQLRequest, error) {
 		graphQLRequest.OperationType = OperationTypeMutation
 	}
 
+	graphQLRequest.RootFields = RootFields(graphQLRequest.Query, graphQLRequest.OperationName)
+
 	return graphQLRequest, nil
 }
 
@@ -154,16 +537,40 @@ const (
 	OperationTypeMutation OperationType = "mutation"
 )
 
+// graphQLPaths are the paths GraphQL requests are sent to: the first by the
+// dev server and the second in production, e.g. in HAR files captured from
+// the live site.
+var graphQLPaths = []string{
+	"/backend-graphql/",
+	"/api/internal/graphql",
+}
+
+// IsGraphQLPath reports whether requests to a URL path are GraphQL requests.
+func IsGraphQLPath(path string) bool {
+	for _, graphQLPath := range graphQLPaths {
+		if strings.Contains(path, graphQLPath) {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Handler) ProxyResponseHandler(resp *http.Response) error {
+	completedAt := time.Now()
+
 	// Look for graphql requests.
-	if !strings.Contains(resp.Request.URL.Path, "/backend-graphql/") {
+	if !IsGraphQLPath(resp.Request.URL.Path) {
 		return nil
 	}
 
-	h.mu.Lock()
-	requestContent := h.requestContent[resp.Request]
-	delete(h.requestContent, resp.Request)
-	h.mu.Unlock()
+	pending, _ := resp.Request.Context().Value(pendingRequestKey{}).(*pendingRequest)
+	if pending == nil {
+		config := h.Config()
+		pending = &pendingRequest{config: &config}
+	}
+	defer pending.release()
+	requestContent := pending.content
+	config := pending.config
 
 	if string(requestContent) == "" {
 		h.log("warning", "no request content, "+resp.Request.URL.Path)
@@ -176,8 +583,10 @@ func (h *Handler) ProxyResponseHandler(resp *http.Response) error {
 		h.reporter.Report("error", err.Error())
 		return nil
 	}
+	graphQLRequest.Path = resp.Request.URL.Path
+	graphQLRequest.Header = resp.Request.Header
 
-	if !h.selector.ShouldRecordRequest(graphQLRequest) {
+	if !config.Selector.ShouldRecordRequest(graphQLRequest) {
 		return nil
 	}
 
@@ -187,53 +596,360 @@ func (h *Handler) ProxyResponseHandler(resp *http.Response) error {
 		return nil
 	}
 
-	h.mu.Lock()
-	currentRequestID := h.nextRequestID
-	h.nextRequestID += 1
-	h.mu.Unlock()
+	shouldSnapshot := config.Selector.ShouldSnapshotRequest(graphQLRequest)
+
+	// Credentials are redacted even if the Redactor doesn't, so that no
+	// recording has them.
+	requestHeader := redact.RedactCredentials(resp.Request.Header.Clone())
+	responseHeader := redact.RedactCredentials(resp.Header.Clone())
+	if config.Redactor != nil {
+		requestContent = config.Redactor.RedactRequest(requestContent)
+		responseContent = config.Redactor.RedactResponse(responseContent)
+		requestHeader = config.Redactor.RedactHeader(requestHeader)
+		responseHeader = config.Redactor.RedactHeader(responseHeader)
+	}
+	meta := recorder.RequestMeta{
+		Method:         resp.Request.Method,
+		URL:            pending.url,
+		RequestHeader:  requestHeader,
+		Status:         resp.StatusCode,
+		ResponseHeader: responseHeader,
+		SentAt:         pending.sentAt,
+		CompletedAt:    completedAt,
+		ReplayOf:       pending.replayOf,
+	}
 
-	shouldSnapshot := h.selector.ShouldSnapshotRequest(graphQLRequest)
+	// The request is saved once the requests that arrived before it have
+	// been, and the next serialized request waits until it's snapshotted.
+	settle := pending.settle
+	if settle == nil {
+		settle = h.reserve()
+	}
+	unlock := pending.unlock
+	pending.settle = nil
+	pending.unlock = nil
+	pending.saving = true
+	finish := func(info RequestInfo, err error) {
+		if unlock != nil {
+			unlock()
+		}
+		if pending.saved != nil {
+			pending.saved <- savedRequest{info, err}
+		}
+	}
+
+	if !shouldSnapshot {
+		settle(func() {
+			info, _, err := h.saveRequest(graphQLRequest, shouldSnapshot, requestContent, responseContent, meta, pending)
+			finish(info, err)
+		})
+		return nil
+	}
+
+	// A snapshotted request's response is held until it's snapshotted, as
+	// the client's next request may change the upstream. It waits for its
+	// own save, but the snapshot is taken here rather than by the save
+	// worker, so it isn't held up by other requests' snapshots.
+	var info RequestInfo
+	var rec recorder.RecorderSaver
+	<-settle(func() {
+		info, rec, err = h.saveRequest(graphQLRequest, shouldSnapshot, requestContent, responseContent, meta, pending)
+	})
+	if err == nil {
+		info = h.snapshotRequest(info, graphQLRequest, rec, config)
+	}
+	finish(info, err)
+
+	return nil
+}
+
+// snapshotRequest takes a saved request's snapshot and returns its updated
+// info.
+func (h *Handler) snapshotRequest(
+	info RequestInfo,
+	graphQLRequest GraphQLRequest,
+	rec recorder.RecorderSaver,
+	config *Config,
+) RequestInfo {
+	err := TakeSnapshot(
+		info.RequestID,
+		graphQLRequest,
+		config.Snapshotter,
+		rec,
+		h.reporter,
+	)
+	info.ShapshotComplete = err == nil
+	h.requestInfoChan <- info
+	return info
+}
+
+// saveRequest gives a request the next request ID and saves it, along with
+// a drift checkpoint before it if the upstream drifted. It returns the
+// request's info and the recorder it was saved to. It doesn't take the
+// request's snapshot, see snapshotRequest.
+func (h *Handler) saveRequest(
+	graphQLRequest GraphQLRequest,
+	shouldSnapshot bool,
+	requestContent []byte,
+	responseContent []byte,
+	meta recorder.RequestMeta,
+	pending *pendingRequest,
+) (RequestInfo, recorder.RecorderSaver, error) {
+	config := pending.config
+
+	// The prior snapshot is read without holding mu. If another request
+	// took an ID in the meantime, e.g. a checkpoint, it's read again, so
+	// that the drift checkpoint gets the ID right before the request's.
+	checkDrift := config.DetectDrift && shouldSnapshot && pending.preSnapshot != nil
+	var rec recorder.RecorderSaver
+	var session string
+	var currentRequestID int
+	driftID := 0
+	for {
+		h.mu.Lock()
+		rec = h.recorder
+		session = h.session
+		currentRequestID = h.nextRequestID
+		h.mu.Unlock()
+
+		drift := rec != nil && checkDrift && drifted(session, rec, currentRequestID, pending.preSnapshot, config)
+
+		h.mu.Lock()
+		if h.recorder != rec || h.nextRequestID != currentRequestID {
+			h.mu.Unlock()
+			continue
+		}
+		if rec != nil {
+			if drift {
+				driftID = currentRequestID
+				currentRequestID += 1
+			}
+			h.nextRequestID = currentRequestID + 1
+		}
+		h.mu.Unlock()
+		break
+	}
+
+	if rec == nil {
+		h.log("warning", "no active session, not recording "+graphQLRequest.OperationName)
+		return RequestInfo{}, nil, ErrNotRecording
+	}
+
+	if driftID != 0 {
+		h.recordDrift(session, rec, driftID, graphQLRequest, pending, config)
+	}
 
 	// Send initial request info (may be updated below)
-	h.requestInfoChan <- RequestInfo{
+	info := RequestInfo{
+		Session:       session,
 		RequestID:     currentRequestID,
 		OperationType: graphQLRequest.OperationType,
 		OperationName: graphQLRequest.OperationName,
 		WillSnapshot:  shouldSnapshot,
+		SentAt:        &meta.SentAt,
+		CompletedAt:   &meta.CompletedAt,
+		ReplayOf:      meta.ReplayOf,
 	}
+	h.requestInfoChan <- info
 
-	h.recorder.SaveRequest(currentRequestID, requestContent)
-	h.recorder.SaveResponse(currentRequestID, responseContent)
+	rec.SaveRequest(currentRequestID, requestContent)
+	rec.SaveResponse(currentRequestID, responseContent)
+	rec.SaveMeta(currentRequestID, meta)
 
 	h.log(
 		string(graphQLRequest.OperationType),
 		fmt.Sprintf(
 			"%s %s",
-			h.recorder.FormatRequestID(currentRequestID),
+			rec.FormatRequestID(currentRequestID),
 			graphQLRequest.OperationName,
 		),
 	)
 
-	if shouldSnapshot {
-		err := TakeSnapshot(
-			currentRequestID,
-			graphQLRequest,
-			h.snapshotter,
-			h.recorder,
-			h.reporter,
-		)
-
-		// Update request info
-		h.requestInfoChan <- RequestInfo{
-			RequestID:        currentRequestID,
-			OperationType:    graphQLRequest.OperationType,
-			OperationName:    graphQLRequest.OperationName,
-			WillSnapshot:     shouldSnapshot,
-			ShapshotComplete: err == nil,
+	if shouldSnapshot && config.SnapshotBefore && pending.preSnapshot != nil {
+		rec.SavePreSnapshot(currentRequestID, pending.preSnapshot)
+	}
+	return info, rec, nil
+}
+
+// recordDrift records the snapshot taken before a request as a drift
+// checkpoint.
+func (h *Handler) recordDrift(
+	session string,
+	rec recorder.RecorderSaver,
+	requestID int,
+	graphQLRequest GraphQLRequest,
+	pending *pendingRequest,
+	config *Config,
+) {
+	label := "drift before " + graphQLRequest.OperationName
+	rec.SaveCheckpoint(requestID, recorder.Checkpoint{
+		Label:   label,
+		TakenAt: pending.sentAt,
+		Drift:   true,
+	})
+	rec.SaveSnapshot(requestID, pending.preSnapshot)
+	h.requestInfoChan <- RequestInfo{
+		Session:          session,
+		RequestID:        requestID,
+		WillSnapshot:     true,
+		ShapshotComplete: true,
+		Checkpoint:       label,
+		Drift:            true,
+	}
+	h.log("warning", fmt.Sprintf(
+		"%s %s: the upstream changed since the last snapshot",
+		rec.FormatRequestID(requestID),
+		label,
+	))
+	if config.OnDrift != nil {
+		config.OnDrift(session)
+	}
+}
+
+// ErrNotRecording is returned by Checkpoint and Replay when there's no
+// recorder, e.g. because the active session was closed.
+var ErrNotRecording = errors.New("not recording, no active session")
+
+// ErrNotSelected is returned by Replay, without sending the request, when
+// the request isn't selected for recording, e.g. because recording is
+// paused or the rule set skips it.
+var ErrNotSelected = errors.New("the request isn't selected for recording")
+
+// ErrNotRecorded is returned by Replay when a request was sent but not
+// recorded, e.g. because the upstream couldn't be reached.
+var ErrNotRecorded = errors.New("the request wasn't recorded")
+
+// Replay sends a request through the proxy, e.g. a recorded request sent
+// again from the tool after editing its variables, and records it like any
+// other request, linked to the request it replays in its meta. It returns
+// once the request has been recorded and snapshotted. The response is only
+// recorded, not returned.
+func (h *Handler) Replay(req *http.Request, replayOf recorder.ReplayOf) (RequestInfo, error) {
+	var content []byte
+	if req.Body != nil {
+		var err error
+		content, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return RequestInfo{}, err
 		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(content))
+	}
+	graphQLRequest, err := ParseRequest(content)
+	if err != nil || !IsGraphQLPath(req.URL.Path) {
+		return RequestInfo{}, fmt.Errorf("%w, it isn't a GraphQL request", ErrNotSelected)
+	}
+	graphQLRequest.Path = req.URL.Path
+	graphQLRequest.Header = req.Header
+	if !h.Config().Selector.ShouldRecordRequest(graphQLRequest) {
+		return RequestInfo{}, ErrNotSelected
 	}
 
-	return nil
+	pending := &pendingRequest{
+		replayOf: &replayOf,
+		saved:    make(chan savedRequest, 1),
+	}
+	w := &replayResponseWriter{header: http.Header{}, status: http.StatusOK}
+	h.proxy.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), pendingRequestKey{}, pending)))
+	saving := pending.saving
+	pending.release()
+	if !saving {
+		return RequestInfo{}, fmt.Errorf("%w, the upstream responded with %d", ErrNotRecorded, w.status)
+	}
+	saved := <-pending.saved
+	return saved.info, saved.err
+}
+
+// replayResponseWriter discards the response to a replayed request, which
+// is recorded by the response handler, keeping its status.
+type replayResponseWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *replayResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *replayResponseWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+}
+
+func (w *replayResponseWriter) Write(content []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(content), nil
+}
+
+// Checkpoint takes a snapshot now and records it as a labeled checkpoint
+// after the requests recorded so far, e.g. to capture changes made by a
+// background job that didn't go through the proxy. It returns once the
+// snapshot has been taken. It waits for serialized requests, so that it
+// doesn't end up between a request's snapshots, and for requests that are
+// still in flight, so that it's recorded after them.
+func (h *Handler) Checkpoint(label string) (RequestInfo, error) {
+	h.serialMu.Lock()
+	defer h.serialMu.Unlock()
+
+	takenAt := time.Now()
+
+	var info RequestInfo
+	var rec recorder.RecorderSaver
+	var config *Config
+	var err error
+	<-h.reserve()(func() {
+		info, rec, config, err = h.saveCheckpoint(label, takenAt)
+	})
+	if err != nil {
+		return RequestInfo{}, err
+	}
+
+	// The snapshot is taken here rather than by the save worker, like a
+	// request's.
+	err = TakeSnapshot(info.RequestID, GraphQLRequest{}, config.Snapshotter, rec, h.reporter)
+	info.ShapshotComplete = err == nil
+	h.requestInfoChan <- info
+	return info, err
+}
+
+// saveCheckpoint gives a checkpoint the next request ID and saves it. It
+// returns the checkpoint's info, and the recorder and config to snapshot it
+// with.
+func (h *Handler) saveCheckpoint(label string, takenAt time.Time) (RequestInfo, recorder.RecorderSaver, *Config, error) {
+	h.mu.Lock()
+	rec := h.recorder
+	session := h.session
+	config := h.config
+	requestID := h.nextRequestID
+	if rec != nil {
+		h.nextRequestID += 1
+	}
+	h.mu.Unlock()
+
+	if rec == nil {
+		return RequestInfo{}, nil, nil, ErrNotRecording
+	}
+
+	err := rec.SaveCheckpoint(requestID, recorder.Checkpoint{
+		Label:   label,
+		TakenAt: takenAt,
+	})
+	if err != nil {
+		return RequestInfo{}, nil, nil, err
+	}
+	info := RequestInfo{
+		Session:      session,
+		RequestID:    requestID,
+		WillSnapshot: true,
+		Checkpoint:   label,
+	}
+	h.requestInfoChan <- info
+
+	h.log("checkpoint", fmt.Sprintf("%s %s", rec.FormatRequestID(requestID), label))
+	return info, rec, config, nil
 }
 
 func TakeSnapshot(
@@ -284,5 +1000,7 @@ func readAndResetResponseContent(resp *http.Response) ([]byte, error) {
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.proxy.ServeHTTP(w, r)
+	pending := &pendingRequest{}
+	defer pending.release()
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), pendingRequestKey{}, pending)))
 }
@@ -1,13 +1,21 @@
 package proxy
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/redact"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -19,23 +27,75 @@ type requestRecord struct {
 
 type testRequestRecorder struct {
 	records []requestRecord
+	metas   map[int]recorder.RequestMeta
+	// Hold when appending to or searching records, or saving metas
+	mu sync.Mutex
+}
+
+func (r *testRequestRecorder) add(record requestRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+}
+
+// has reports whether a record has been saved.
+func (r *testRequestRecorder) has(recordType string, requestID int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, record := range r.records {
+		if record.recordType == recordType && record.requestID == requestID {
+			return true
+		}
+	}
+	return false
 }
 
 func (r *testRequestRecorder) SaveRequest(requestID int, content []byte) error {
-	r.records = append(r.records, requestRecord{"request", requestID, content})
+	r.add(requestRecord{"request", requestID, content})
 	return nil
 }
 
 func (r *testRequestRecorder) SaveResponse(requestID int, content []byte) error {
-	r.records = append(r.records, requestRecord{"response", requestID, content})
+	r.add(requestRecord{"response", requestID, content})
 	return nil
 }
 
 func (r *testRequestRecorder) SaveSnapshot(requestID int, content []byte) error {
-	r.records = append(r.records, requestRecord{"snapshot", requestID, content})
+	r.add(requestRecord{"snapshot", requestID, content})
 	return nil
 }
 
+func (r *testRequestRecorder) SavePreSnapshot(requestID int, content []byte) error {
+	r.add(requestRecord{"pre-snapshot", requestID, content})
+	return nil
+}
+
+func (r *testRequestRecorder) SaveMeta(requestID int, meta recorder.RequestMeta) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.metas == nil {
+		r.metas = map[int]recorder.RequestMeta{}
+	}
+	r.metas[requestID] = meta
+	return nil
+}
+
+func (r *testRequestRecorder) SaveCheckpoint(requestID int, checkpoint recorder.Checkpoint) error {
+	r.add(requestRecord{"checkpoint", requestID, []byte(checkpoint.Label)})
+	return nil
+}
+
+func (r *testRequestRecorder) GetPriorSnapshot(requestID int) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.records) - 1; i >= 0; i-- {
+		if r.records[i].recordType == "snapshot" && r.records[i].requestID < requestID {
+			return r.records[i].content, nil
+		}
+	}
+	return nil, recorder.ErrNoPriorSnapshot
+}
+
 func (r *testRequestRecorder) FormatRequestID(requestID int) string {
 	return fmt.Sprintf("%06d", requestID)
 }
@@ -54,6 +114,15 @@ func (s *testRequestSelector) ShouldSnapshotRequest(r GraphQLRequest) bool {
 	return r.OperationType == OperationTypeMutation
 }
 
+// snapshotAllSelector snapshots every request it records.
+type snapshotAllSelector struct {
+	testRequestSelector
+}
+
+func (s *snapshotAllSelector) ShouldSnapshotRequest(r GraphQLRequest) bool {
+	return true
+}
+
 type testReport struct {
 	label   string
 	message string
@@ -71,9 +140,18 @@ type testSnapshotter struct {
 	snapshotContent string
 	snapshotError   error
 	snapshotInfo    string
+	contentType     string
+	// taking, if set, is called before each snapshot is taken
+	taking func(r GraphQLRequest)
+	// taken counts the snapshots taken, atomically
+	taken int32
 }
 
-func (s *testSnapshotter) TakeSnapshot(_ GraphQLRequest) ([]byte, error) {
+func (s *testSnapshotter) TakeSnapshot(r GraphQLRequest) ([]byte, error) {
+	if s.taking != nil {
+		s.taking(r)
+	}
+	atomic.AddInt32(&s.taken, 1)
 	return []byte(s.snapshotContent), s.snapshotError
 }
 
@@ -81,14 +159,63 @@ func (s *testSnapshotter) SnapshotInfo() string {
 	return s.snapshotInfo
 }
 
+func (s *testSnapshotter) SnapshotContentType() string {
+	return s.contentType
+}
+
+// ignoreFieldNormalizer removes a field from snapshots, like a
+// normalize.Normalizer with a rule for it.
+type ignoreFieldNormalizer struct {
+	field string
+}
+
+func (n *ignoreFieldNormalizer) ApplyJSON(content []byte) []byte {
+	var value map[string]interface{}
+	if json.Unmarshal(content, &value) != nil {
+		return content
+	}
+	delete(value, n.field)
+	normalized, _ := json.Marshal(value)
+	return normalized
+}
+
 type staticHandler struct {
 	Content string
+	// Serving, if set, is called before the content is written.
+	Serving func()
 }
 
 func (h *staticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Serving != nil {
+		h.Serving()
+	}
 	w.Write([]byte(h.Content))
 }
 
+type ignoreAllSelector struct{}
+
+func (s *ignoreAllSelector) ShouldRecordRequest(r GraphQLRequest) bool {
+	return false
+}
+
+func (s *ignoreAllSelector) ShouldSnapshotRequest(r GraphQLRequest) bool {
+	return false
+}
+
+type testRedactor struct{}
+
+func (r *testRedactor) RedactHeader(header http.Header) http.Header {
+	return header
+}
+
+func (r *testRedactor) RedactRequest(content []byte) []byte {
+	return []byte("redacted request")
+}
+
+func (r *testRedactor) RedactResponse(content []byte) []byte {
+	return []byte("redacted response")
+}
+
 type handlerSuite struct {
 	suite.Suite
 	snapshotter     *testSnapshotter
@@ -126,6 +253,11 @@ func (suite *handlerSuite) AfterTest(suiteName, testName string) {
 	suite.server.Close()
 }
 
+// waitForSaves waits until the requests sent so far have been recorded.
+func (suite *handlerSuite) waitForSaves() {
+	<-suite.proxyRecorder.reserve()(func() {})
+}
+
 func (suite *handlerSuite) TestAllURLsAreProxied() {
 	req := httptest.NewRequest("GET", "http://www.khanacademy.org/some/endpoint", nil)
 	w := httptest.NewRecorder()
@@ -157,6 +289,7 @@ func (suite *handlerSuite) TestSelectedRequestsAreRecorded() {
 	))
 	w := httptest.NewRecorder()
 	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
 	suite.Require().Equal(
 		[]testReport{
 			{"query", "000001 operationToRecord"},
@@ -170,6 +303,7 @@ func (suite *handlerSuite) TestSelectedRequestsAreRecorded() {
 	))
 	w = httptest.NewRecorder()
 	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
 	suite.Require().Len(suite.reporter.reports, 1)
 
 	// Perform another GTP operation. The request number should now be 2.
@@ -178,6 +312,7 @@ func (suite *handlerSuite) TestSelectedRequestsAreRecorded() {
 	))
 	w = httptest.NewRecorder()
 	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
 	suite.Require().Equal(
 		[]testReport{
 			{"query", "000001 operationToRecord"},
@@ -198,6 +333,7 @@ func (suite *handlerSuite) TestSelectedRequestsAreSnapshotted() {
 	suite.snapshotter.snapshotInfo = `(snapshot description)`
 
 	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
 
 	suite.Require().Equal(
 		[]testReport{
@@ -219,6 +355,7 @@ func (suite *handlerSuite) TestDataIsRecorded() {
 	suite.snapshotter.snapshotContent = `{"test": "snapshot"}`
 
 	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
 
 	suite.Require().Equal(
 		[]requestRecord{
@@ -242,6 +379,453 @@ func (suite *handlerSuite) TestDataIsRecorded() {
 	)
 }
 
+func (suite *handlerSuite) TestRoutes() {
+	routeOrigin := httptest.NewServer(&staticHandler{Content: "content from the route"})
+	defer routeOrigin.Close()
+	routeURL, err := url.Parse(routeOrigin.URL)
+	suite.Require().NoError(err)
+
+	config := suite.proxyRecorder.Config()
+	config.Routes = []Route{{PathPrefix: "/api/internal/", Origin: routeURL}}
+	suite.proxyRecorder.SetConfig(config)
+	suite.origin.Content = "some content from the origin"
+
+	for path, expected := range map[string]string{
+		"/api/internal/graphql": "content from the route",
+		"/some/endpoint":        "some content from the origin",
+	} {
+		w := httptest.NewRecorder()
+		suite.proxyRecorder.ServeHTTP(w, httptest.NewRequest("GET", "http://www.khanacademy.org"+path, nil))
+		body, _ := ioutil.ReadAll(w.Result().Body)
+		suite.Equal(expected, string(body), path)
+	}
+}
+
+func (suite *handlerSuite) TestRequestsFinishWithTheConfigTheyStartedWith() {
+	config := suite.proxyRecorder.Config()
+	config.Selector = &ignoreAllSelector{}
+	suite.origin.Serving = func() {
+		suite.proxyRecorder.SetConfig(config)
+	}
+
+	body := `{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+	suite.Require().Len(suite.requestRecorder.records, 2)
+
+	req = httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+	suite.Require().Len(suite.requestRecorder.records, 2)
+}
+
+func (suite *handlerSuite) TestRedaction() {
+	config := suite.proxyRecorder.Config()
+	config.Redactor = &testRedactor{}
+	suite.proxyRecorder.SetConfig(config)
+	suite.origin.Content = "some content from the origin"
+
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(
+		`{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`,
+	))
+	w := httptest.NewRecorder()
+	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
+
+	body, _ := ioutil.ReadAll(w.Result().Body)
+	suite.Equal("some content from the origin", string(body), "the client gets the response unredacted")
+	suite.Require().Equal(
+		[]requestRecord{
+			{recordType: "request", requestID: 1, content: []byte("redacted request")},
+			{recordType: "response", requestID: 1, content: []byte("redacted response")},
+		},
+		suite.requestRecorder.records,
+	)
+}
+
+func (suite *handlerSuite) TestCredentialsAreNotRecorded() {
+	routeOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "KAID", Value: "secret"})
+		w.Header().Set("X-Request-Id", "1234")
+	}))
+	defer routeOrigin.Close()
+	routeURL, err := url.Parse(routeOrigin.URL)
+	suite.Require().NoError(err)
+	config := suite.proxyRecorder.Config()
+	config.Routes = []Route{{PathPrefix: "/api/internal/", Origin: routeURL}}
+	suite.proxyRecorder.SetConfig(config)
+
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(
+		`{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`,
+	))
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "KAID=secret")
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	suite.proxyRecorder.ServeHTTP(w, req)
+	suite.waitForSaves()
+
+	suite.Equal("KAID=secret", w.Result().Header.Get("Set-Cookie"), "the client gets the cookie")
+	meta := suite.requestRecorder.metas[1]
+	suite.Equal(redact.Placeholder, meta.RequestHeader.Get("Authorization"))
+	suite.Equal(redact.Placeholder, meta.RequestHeader.Get("Cookie"))
+	suite.Equal("application/json", meta.RequestHeader.Get("Accept"))
+	suite.Equal(redact.Placeholder, meta.ResponseHeader.Get("Set-Cookie"))
+	suite.Equal("1234", meta.ResponseHeader.Get("X-Request-Id"))
+}
+
+func (suite *handlerSuite) TestCheckpoints() {
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(
+		`{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`,
+	))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+	<-suite.requestInfoChan
+
+	suite.snapshotter.snapshotContent = `{"test": "snapshot"}`
+	info, err := suite.proxyRecorder.Checkpoint("cron ran")
+	suite.Require().NoError(err)
+	suite.Equal(2, info.RequestID)
+	suite.Equal("cron ran", info.Checkpoint)
+	suite.True(info.ShapshotComplete)
+
+	suite.Equal(
+		[]requestRecord{
+			{"checkpoint", 2, []byte("cron ran")},
+			{"snapshot", 2, []byte(`{"test": "snapshot"}`)},
+		},
+		suite.requestRecorder.records[2:],
+	)
+	suite.False((<-suite.requestInfoChan).ShapshotComplete)
+	suite.True((<-suite.requestInfoChan).ShapshotComplete)
+
+	suite.Require().NoError(suite.proxyRecorder.SetRecorder("", nil))
+	_, err = suite.proxyRecorder.Checkpoint("cron ran")
+	suite.True(errors.Is(err, ErrNotRecording))
+}
+
+func (suite *handlerSuite) TestDrift() {
+	var drifted []string
+	config := suite.proxyRecorder.Config()
+	config.DetectDrift = true
+	config.OnDrift = func(session string) {
+		drifted = append(drifted, session)
+	}
+	suite.proxyRecorder.SetConfig(config)
+	suite.Require().NoError(suite.proxyRecorder.SetRecorder("session", suite.requestRecorder))
+	suite.requestRecorder.SaveSnapshot(0, []byte("before"))
+	suite.snapshotter.snapshotContent = "after"
+
+	mutation := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(mutation))
+		suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+		suite.waitForSaves()
+	}
+
+	var recorded []string
+	for _, record := range suite.requestRecorder.records {
+		recorded = append(recorded, fmt.Sprintf("%d %s", record.requestID, record.recordType))
+	}
+	suite.Equal(
+		[]string{
+			"0 snapshot",
+			"1 checkpoint",
+			"1 snapshot",
+			"2 request",
+			"2 response",
+			"2 snapshot",
+			"3 request",
+			"3 response",
+			"3 snapshot",
+		},
+		recorded,
+		"only the first mutation is preceded by a drift checkpoint",
+	)
+	suite.Equal([]string{"session"}, drifted)
+}
+
+func (suite *handlerSuite) TestDriftComparesDecodedSnapshots() {
+	config := suite.proxyRecorder.Config()
+	config.DetectDrift = true
+	config.SnapshotNormalizer = &ignoreFieldNormalizer{"updatedAt"}
+	suite.proxyRecorder.SetConfig(config)
+	suite.Require().NoError(suite.proxyRecorder.SetRecorder("session", suite.requestRecorder))
+	suite.requestRecorder.SaveSnapshot(0, []byte(`{"a": 1, "b": 2, "updatedAt": 1}`))
+	suite.snapshotter.contentType = "application/json"
+	// Only the key order, whitespace and a normalized field differ.
+	suite.snapshotter.snapshotContent = `{"b":2,"a":1,"updatedAt":2}`
+
+	mutation := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(mutation))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+
+	for _, record := range suite.requestRecorder.records {
+		suite.NotEqual("checkpoint", record.recordType)
+	}
+}
+
+func (suite *handlerSuite) TestSnapshottedQueriesAreSerializedWhenDetectingDrift() {
+	config := suite.proxyRecorder.Config()
+	config.DetectDrift = true
+	config.Selector = &snapshotAllSelector{}
+	suite.proxyRecorder.SetConfig(config)
+
+	query := `{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`
+	mutation := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	send := func(body string) {
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+		suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// While a snapshotted query is at the origin, a mutation is sent. Its
+	// snapshot before can't be taken until the query's snapshot after, so
+	// the drift check doesn't pick up the query's changes.
+	var takenWhileServing int32
+	second := make(chan struct{})
+	suite.origin.Serving = func() {
+		suite.origin.Serving = nil
+		go func() {
+			send(mutation)
+			close(second)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		takenWhileServing = atomic.LoadInt32(&suite.snapshotter.taken)
+	}
+	send(query)
+	<-second
+	suite.waitForSaves()
+
+	suite.Equal(int32(0), takenWhileServing)
+	suite.Equal(int32(3), atomic.LoadInt32(&suite.snapshotter.taken))
+}
+
+func (suite *handlerSuite) TestSnapshotBefore() {
+	config := suite.proxyRecorder.Config()
+	config.SnapshotBefore = true
+	suite.proxyRecorder.SetConfig(config)
+	suite.snapshotter.snapshotContent = "snapshot"
+
+	mutation := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(mutation))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+
+	var recorded []string
+	for _, record := range suite.requestRecorder.records {
+		recorded = append(recorded, fmt.Sprintf("%d %s", record.requestID, record.recordType))
+	}
+	suite.Equal([]string{"1 request", "1 response", "1 pre-snapshot", "1 snapshot"}, recorded)
+}
+
+func (suite *handlerSuite) TestSnapshottedRequestsAreSerialized() {
+	config := suite.proxyRecorder.Config()
+	config.SnapshotBefore = true
+	suite.proxyRecorder.SetConfig(config)
+
+	mutation := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	send := func() {
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(mutation))
+		suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// While the first mutation is at the origin, a second one is sent. Its
+	// snapshot before can't be taken until the first one's snapshot after.
+	var takenWhileServing int32
+	second := make(chan struct{})
+	suite.origin.Serving = func() {
+		suite.origin.Serving = nil
+		go func() {
+			send()
+			close(second)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		takenWhileServing = atomic.LoadInt32(&suite.snapshotter.taken)
+	}
+	send()
+	<-second
+	suite.waitForSaves()
+
+	suite.Equal(int32(1), takenWhileServing)
+	suite.Equal(int32(4), atomic.LoadInt32(&suite.snapshotter.taken))
+}
+
+func (suite *handlerSuite) TestRequestsAreRecordedInTheOrderTheyArrived() {
+	send := func(operationName string) {
+		body := fmt.Sprintf(`{"operationName": "operationToRecord", "query": "query operationToRecord { %s }"}`, operationName)
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+		suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// While the first request is at the origin, a second one is sent and
+	// its response comes back. It isn't recorded until the first one is.
+	var recordedWhileServing int
+	suite.origin.Serving = func() {
+		suite.origin.Serving = nil
+		second := make(chan struct{})
+		go func() {
+			send("second")
+			close(second)
+		}()
+		<-second
+		recordedWhileServing = len(suite.requestRecorder.records)
+	}
+	send("first")
+	suite.waitForSaves()
+
+	suite.Equal(0, recordedWhileServing)
+	var recorded []string
+	for _, record := range suite.requestRecorder.records {
+		if record.recordType == "request" {
+			recorded = append(recorded, fmt.Sprintf("%d %s", record.requestID, record.content))
+		}
+	}
+	suite.Equal([]string{
+		`1 {"operationName": "operationToRecord", "query": "query operationToRecord { first }"}`,
+		`2 {"operationName": "operationToRecord", "query": "query operationToRecord { second }"}`,
+	}, recorded)
+}
+
+func (suite *handlerSuite) TestSnapshottedResponsesWaitForTheirSnapshot() {
+	send := func(field string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"operationName": "operationToRecord", "query": "mutation operationToRecord { %s }"}`, field)
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		suite.proxyRecorder.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first mutation's snapshot is slow. It's taken once the mutation
+	// has been saved, so it doesn't hold up the second one.
+	slowStarted := make(chan struct{})
+	slowDone := make(chan struct{})
+	suite.snapshotter.taking = func(r GraphQLRequest) {
+		if strings.Contains(r.Query, "slow") {
+			close(slowStarted)
+			<-slowDone
+		}
+	}
+	slow := make(chan struct{})
+	go func() {
+		send("slow")
+		close(slow)
+	}()
+	<-slowStarted
+
+	sent := make(chan *httptest.ResponseRecorder)
+	go func() {
+		sent <- send("fast")
+	}()
+	select {
+	case w := <-sent:
+		suite.Equal(http.StatusOK, w.Code)
+		suite.True(suite.requestRecorder.has("snapshot", 2), "the response came before its snapshot")
+	case <-time.After(time.Second):
+		suite.FailNow("the response waited for another request's snapshot")
+	}
+	select {
+	case <-slow:
+		suite.FailNow("the response came before its snapshot")
+	default:
+	}
+
+	close(slowDone)
+	<-slow
+	suite.True(suite.requestRecorder.has("snapshot", 1))
+}
+
+func (suite *handlerSuite) TestRequestsThatArentRecordedDontLeaveGaps() {
+	unreachable := httptest.NewServer(&staticHandler{})
+	unreachable.Close()
+	unreachableURL, err := url.Parse(unreachable.URL)
+	suite.Require().NoError(err)
+
+	config := suite.proxyRecorder.Config()
+	config.Routes = []Route{{PathPrefix: "/backend-graphql/", Origin: unreachableURL}}
+	suite.proxyRecorder.SetConfig(config)
+
+	body := `{"operationName": "operationToRecord", "query": "query operationToRecord { someQuery }"}`
+	for _, path := range []string{"/backend-graphql/", "/api/internal/graphql"} {
+		req := httptest.NewRequest("GET", "http://www.khanacademy.org"+path, strings.NewReader(body))
+		suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+		suite.waitForSaves()
+	}
+
+	suite.Require().Len(suite.requestRecorder.records, 2)
+	suite.Equal(1, suite.requestRecorder.records[0].requestID)
+	info := <-suite.requestInfoChan
+	suite.Require().NotNil(info.SentAt)
+	suite.Require().NotNil(info.CompletedAt)
+	suite.False(info.CompletedAt.Before(*info.SentAt))
+}
+
+func (suite *handlerSuite) TestReplay() {
+	body := `{"operationName": "operationToRecord", "query": "mutation operationToRecord { someMutation }"}`
+	req := httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	suite.proxyRecorder.ServeHTTP(httptest.NewRecorder(), req)
+	suite.waitForSaves()
+
+	suite.origin.Content = "replayed"
+	replayOf := recorder.ReplayOf{Session: "signup-flow", RequestID: 1}
+	req = httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	info, err := suite.proxyRecorder.Replay(req, replayOf)
+	suite.Require().NoError(err)
+	suite.Equal(2, info.RequestID)
+	suite.Equal(&replayOf, info.ReplayOf)
+	suite.True(info.ShapshotComplete)
+	suite.Equal(
+		[]requestRecord{
+			{"request", 2, []byte(body)},
+			{"response", 2, []byte("replayed")},
+			{"snapshot", 2, []byte("")},
+		},
+		suite.requestRecorder.records[3:],
+	)
+
+	// Requests the selector skips aren't sent.
+	sent := false
+	suite.origin.Serving = func() {
+		sent = true
+	}
+	req = httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(
+		`{"operationName": "someOperation", "query": "query someOperation { field }"}`,
+	))
+	_, err = suite.proxyRecorder.Replay(req, replayOf)
+	suite.True(errors.Is(err, ErrNotSelected))
+	suite.False(sent)
+	suite.origin.Serving = nil
+
+	// Nor are they while recording is paused, i.e. nothing is selected.
+	config := suite.proxyRecorder.Config()
+	config.Selector = &ignoreAllSelector{}
+	suite.proxyRecorder.SetConfig(config)
+	req = httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	_, err = suite.proxyRecorder.Replay(req, replayOf)
+	suite.True(errors.Is(err, ErrNotSelected))
+	config.Selector = suite.requestSelector
+	suite.proxyRecorder.SetConfig(config)
+
+	unreachable := httptest.NewServer(&staticHandler{})
+	unreachable.Close()
+	unreachableURL, err := url.Parse(unreachable.URL)
+	suite.Require().NoError(err)
+	config.Routes = []Route{{PathPrefix: "/api/internal/", Origin: unreachableURL}}
+	suite.proxyRecorder.SetConfig(config)
+	req = httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	_, err = suite.proxyRecorder.Replay(req, replayOf)
+	suite.True(errors.Is(err, ErrNotRecorded))
+	suite.Contains(err.Error(), "responded with 502")
+	config.Routes = nil
+	suite.proxyRecorder.SetConfig(config)
+
+	suite.Require().NoError(suite.proxyRecorder.SetRecorder("", nil))
+	req = httptest.NewRequest("POST", "http://www.khanacademy.org/api/internal/graphql", strings.NewReader(body))
+	_, err = suite.proxyRecorder.Replay(req, replayOf)
+	suite.True(errors.Is(err, ErrNotRecording))
+}
+
 func TestHandler(t *testing.T) {
 	suite.Run(t, new(handlerSuite))
 }
@@ -0,0 +1,176 @@
+// Package proxyrecordertest replays recordings made with the proxy recorder
+// against an http.Handler inside go test, so a recording can serve as an
+// integration test for the service that handled it, without running the
+// proxy.
+//
+//	func TestRecordedExam(t *testing.T) {
+//		proxyrecordertest.Replay(t, "testdata/exam", newServer(), proxyrecordertest.Options{
+//			Ignore: []string{"**.createdAt"},
+//		})
+//	}
+//
+// Running the tests with -proxyrecorder.update rewrites the recorded
+// responses and snapshots with what the handler returns instead of
+// comparing them, like a golden file update.
+package proxyrecordertest
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/verify"
+)
+
+var update = flag.Bool(
+	"proxyrecorder.update",
+	false,
+	"update recorded responses and snapshots with the replayed ones",
+)
+
+type Options struct {
+	// Session is the session to replay. It defaults to the active session,
+	// or the only one.
+	Session string
+	// Snapshotter takes snapshots of the handler's state after the requests
+	// that had one when recorded. Without one, snapshots aren't compared.
+	Snapshotter proxy.Snapshotter
+	// Reset puts the handler's state back to where the recording started.
+	// If it's nil and the snapshotter is a verify.SnapshotRestorer, the
+	// initial snapshot is restored instead.
+	Reset func() error
+	// Ignore are path patterns, e.g. "**.createdAt", for values left out
+	// when comparing. See the jsonpath package.
+	Ignore []string
+	// DecodeSnapshot decodes snapshots for comparison. It defaults to
+	// decoding JSON.
+	DecodeSnapshot func(snapshot []byte) (interface{}, error)
+}
+
+// Replay sends each request in a recording, in order, to handler, and
+// fails the test for every response or snapshot change that doesn't match
+// the recording.
+func Replay(t testing.TB, recordDir string, handler http.Handler, options Options) {
+	t.Helper()
+
+	ignore, err := jsonpath.CompileAll(options.Ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions := &recorder.Sessions{RootPath: recordDir}
+	session, err := sessions.Resolve(options.Session)
+	if err != nil {
+		t.Fatalf("%s: %s", recordDir, err)
+	}
+	rec, err := sessions.Recorder(session.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifyOptions := verify.Options{
+		Upstream:       "http://proxyrecordertest",
+		Client:         &http.Client{Transport: handlerTransport{handler}},
+		Snapshotter:    options.Snapshotter,
+		Reset:          options.Reset,
+		Ignore:         ignore,
+		DecodeSnapshot: options.DecodeSnapshot,
+	}
+
+	var updateErr error
+	if *update {
+		verifyOptions.OnReplay = func(requestID int, status int, response []byte, snapshot []byte) {
+			if updateErr == nil {
+				updateErr = updateRecording(rec, requestID, status, response, snapshot)
+			}
+		}
+	}
+
+	result, err := verify.Run(rec, verifyOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		if updateErr != nil {
+			t.Fatal(updateErr)
+		}
+		t.Logf("updated %d requests in %s", result.Requests, rec.RootPath)
+		return
+	}
+
+	for _, mismatch := range result.Mismatches {
+		t.Errorf("%s", describe(mismatch))
+	}
+}
+
+// updateRecording replaces a recorded response and snapshot with replayed
+// ones.
+func updateRecording(
+	rec *recorder.Recorder,
+	requestID int,
+	status int,
+	response []byte,
+	snapshot []byte,
+) error {
+	if requestID != 0 {
+		err := rec.SaveResponse(requestID, response)
+		if err != nil {
+			return err
+		}
+		meta, err := rec.MaybeGetMeta(requestID)
+		if err != nil {
+			return err
+		}
+		if meta != nil && meta.Status != status {
+			meta.Status = status
+			err = rec.SaveMeta(requestID, *meta)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if snapshot != nil {
+		return rec.SaveSnapshot(requestID, snapshot)
+	}
+	return nil
+}
+
+func describe(mismatch verify.Mismatch) string {
+	var b strings.Builder
+	b.WriteString(mismatch.String())
+	writeChanges := func(label string, changes interface{}) {
+		content, err := json.MarshalIndent(changes, "    ", "  ")
+		if err != nil {
+			return
+		}
+		b.WriteString("\n    " + label + ": ")
+		b.Write(content)
+	}
+	if len(mismatch.Changes) > 0 {
+		writeChanges("changes", mismatch.Changes)
+	}
+	if len(mismatch.Missing) > 0 {
+		writeChanges("missing", mismatch.Missing)
+	}
+	if len(mismatch.Unexpected) > 0 {
+		writeChanges("unexpected", mismatch.Unexpected)
+	}
+	return b.String()
+}
+
+// handlerTransport sends requests straight to a handler.
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	w := httptest.NewRecorder()
+	t.handler.ServeHTTP(w, req)
+	return w.Result(), nil
+}
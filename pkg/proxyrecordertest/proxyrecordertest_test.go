@@ -0,0 +1,135 @@
+package proxyrecordertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+	"github.com/stretchr/testify/suite"
+)
+
+// counterService is the handler under test. Its increment mutation adds
+// step to a counter.
+type counterService struct {
+	counter int
+	step    int
+}
+
+func (s *counterService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, _ := ioutil.ReadAll(r.Body)
+	graphQLRequest, err := proxy.ParseRequest(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if graphQLRequest.OperationType == proxy.OperationTypeMutation {
+		s.counter += s.step
+	}
+	fmt.Fprintf(w, `{"data": {"counter": %d}}`, s.counter)
+}
+
+func (s *counterService) TakeSnapshot(proxy.GraphQLRequest) ([]byte, error) {
+	return json.Marshal(map[string]int{"counter": s.counter})
+}
+
+func (s *counterService) SnapshotInfo() string {
+	return "counter"
+}
+
+func (s *counterService) SnapshotContentType() string {
+	return snapshotformat.JSON
+}
+
+// recordingTB records test failures instead of failing the test.
+type recordingTB struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+type replaySuite struct {
+	suite.Suite
+	rootPath string
+	rec      *recorder.Recorder
+	service  *counterService
+}
+
+func (suite *replaySuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "proxyrecordertest")
+	suite.Require().NoError(err)
+
+	sessions := &recorder.Sessions{RootPath: suite.rootPath}
+	_, err = sessions.Create(recorder.Session{Name: "test"})
+	suite.Require().NoError(err)
+	suite.rec, err = sessions.Recorder("test")
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, []byte(`{"counter": 0}`)))
+	suite.Require().NoError(suite.rec.SaveRequest(1, []byte(`{"operationName": "increment", "query": "mutation increment { counter }"}`)))
+	suite.Require().NoError(suite.rec.SaveResponse(1, []byte(`{"data": {"counter": 1}}`)))
+	suite.Require().NoError(suite.rec.SaveSnapshot(1, []byte(`{"counter": 1}`)))
+
+	suite.service = &counterService{step: 1}
+}
+
+func (suite *replaySuite) AfterTest(suiteName, testName string) {
+	*update = false
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *replaySuite) options() Options {
+	return Options{
+		Snapshotter: suite.service,
+		Reset: func() error {
+			suite.service.counter = 0
+			return nil
+		},
+	}
+}
+
+func (suite *replaySuite) TestReplay() {
+	t := &recordingTB{TB: suite.T()}
+	Replay(t, suite.rootPath, suite.service, suite.options())
+	suite.Empty(t.errors)
+}
+
+func (suite *replaySuite) TestMismatch() {
+	suite.service.step = 2
+
+	t := &recordingTB{TB: suite.T()}
+	Replay(t, suite.rootPath, suite.service, suite.options())
+
+	suite.Require().Len(t.errors, 2)
+	suite.Contains(t.errors[0], "data.counter")
+	suite.Contains(t.errors[1], "snapshot")
+}
+
+func (suite *replaySuite) TestUpdate() {
+	suite.service.step = 2
+
+	*update = true
+	Replay(suite.T(), suite.rootPath, suite.service, suite.options())
+	*update = false
+
+	response, err := suite.rec.GetResponse(1)
+	suite.Require().NoError(err)
+	suite.JSONEq(`{"data": {"counter": 2}}`, string(response))
+
+	t := &recordingTB{TB: suite.T()}
+	Replay(t, suite.rootPath, suite.service, suite.options())
+	suite.Empty(t.errors)
+}
+
+func TestReplay(t *testing.T) {
+	suite.Run(t, new(replaySuite))
+}
@@ -0,0 +1,136 @@
+package proxyrecordertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+)
+
+// Step is a recorded request and the response expected for it. Tests
+// generated with proxyrecorder gen-test are a list of steps.
+type Step struct {
+	RequestID     int
+	Path          string
+	OperationName string
+	Query         string
+	// Variables and Response are JSON.
+	Variables string
+	Response  string
+}
+
+// Runner sends steps to a handler and checks their responses and the
+// snapshot changes they make.
+type Runner struct {
+	t       testing.TB
+	handler http.Handler
+	ignore  []*jsonpath.Pattern
+	// snapshot is the value ExpectDelta diffs against.
+	snapshot interface{}
+}
+
+// NewRunner returns a runner for a handler. Values matching the ignore
+// patterns are left out when comparing.
+func NewRunner(t testing.TB, handler http.Handler, ignore ...string) *Runner {
+	t.Helper()
+	patterns, err := jsonpath.CompileAll(ignore)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Runner{t: t, handler: handler, ignore: patterns}
+}
+
+// Send sends a step's request and fails the test if the response differs
+// from the expected one.
+func (r *Runner) Send(step Step) {
+	r.t.Helper()
+
+	body := map[string]interface{}{
+		"operationName": step.OperationName,
+		"query":         step.Query,
+		"variables":     json.RawMessage(orNull(step.Variables)),
+	}
+	content, err := json.Marshal(body)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, step.Path, bytes.NewReader(content))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.handler.ServeHTTP(w, req)
+	response, _ := ioutil.ReadAll(w.Result().Body)
+
+	expected := r.decode(step.RequestID, "expected response", []byte(step.Response))
+	actual := r.decode(step.RequestID, "response", response)
+	for _, change := range jsondiff.Diff(expected, actual) {
+		r.t.Errorf(
+			"%06d %s: response %s %s: expected %v, got %v",
+			step.RequestID,
+			step.OperationName,
+			change.Path,
+			change.Kind,
+			change.Before,
+			change.After,
+		)
+	}
+}
+
+// Snapshot sets the snapshot the next ExpectDelta diffs against.
+func (r *Runner) Snapshot(snapshot []byte) {
+	r.t.Helper()
+	r.snapshot = r.decode(0, "snapshot", snapshot)
+}
+
+// ExpectDelta fails the test unless the changes between the previous
+// snapshot and this one are the expected ones, given as a JSON list of
+// jsondiff changes. The snapshot becomes the new previous snapshot.
+func (r *Runner) ExpectDelta(requestID int, snapshot []byte, expected string) {
+	r.t.Helper()
+
+	var expectedChanges []jsondiff.Change
+	decoder := json.NewDecoder(bytes.NewReader([]byte(expected)))
+	decoder.UseNumber()
+	err := decoder.Decode(&expectedChanges)
+	if err != nil {
+		r.t.Fatalf("%06d: invalid expected changes: %s", requestID, err)
+	}
+
+	current := r.decode(requestID, "snapshot", snapshot)
+	changes := jsondiff.Diff(r.snapshot, current)
+	r.snapshot = current
+
+	if len(changes) == 0 && len(expectedChanges) == 0 {
+		return
+	}
+	if !jsondiff.EqualChanges(expectedChanges, changes) {
+		expectedContent, _ := json.MarshalIndent(expectedChanges, "", "  ")
+		actualContent, _ := json.MarshalIndent(changes, "", "  ")
+		r.t.Errorf(
+			"%06d: snapshot changes differ\nexpected: %s\ngot: %s",
+			requestID,
+			expectedContent,
+			actualContent,
+		)
+	}
+}
+
+func (r *Runner) decode(requestID int, label string, content []byte) interface{} {
+	r.t.Helper()
+	value, err := jsondiff.Decode(content)
+	if err != nil {
+		r.t.Fatalf("%06d: %s isn't JSON: %s", requestID, label, err)
+	}
+	return jsonpath.Remove(value, r.ignore)
+}
+
+func orNull(content string) string {
+	if content == "" {
+		return "null"
+	}
+	return content
+}
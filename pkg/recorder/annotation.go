@@ -0,0 +1,189 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Annotation is what people add to a recorded request to document it, e.g.
+// before handing a recording to someone else as a repro. It's saved in the
+// request's directory as annotations.json.
+type Annotation struct {
+	Notes string `json:"notes,omitempty"`
+	// Tags are short labels such as "bug", "expected" or "flaky".
+	Tags    []string `json:"tags,omitempty"`
+	Starred bool     `json:"starred,omitempty"`
+}
+
+// IsEmpty reports whether there's nothing in the annotation.
+func (a Annotation) IsEmpty() bool {
+	return a.Notes == "" && len(a.Tags) == 0 && !a.Starred
+}
+
+// HasTag reports whether the annotation has a tag.
+func (a Annotation) HasTag(tag string) bool {
+	for _, t := range a.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanTags trims tags and drops empty and repeated ones.
+func cleanTags(tags []string) []string {
+	var cleaned []string
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+	return cleaned
+}
+
+// RequestEditor is implemented by recordings whose requests can be
+// annotated and deleted after they're recorded, e.g. Recorder.
+type RequestEditor interface {
+	SaveAnnotation(requestID int, annotation Annotation) error
+	DeleteRequest(requestID int) error
+}
+
+// ErrDeleted is returned when deleting a request that's already been
+// deleted.
+var ErrDeleted = errors.New("request already deleted")
+
+// SaveAnnotation saves a request's annotation, replacing the one it had.
+// Tags are trimmed and deduplicated, and an empty annotation removes it.
+// The request must exist.
+func (r *Recorder) SaveAnnotation(requestID int, annotation Annotation) error {
+	if _, err := os.Stat(r.requestPath(requestID)); err != nil {
+		return err
+	}
+	annotation.Tags = cleanTags(annotation.Tags)
+	if annotation.IsEmpty() {
+		return r.removeFile(requestID, "annotations.json")
+	}
+	content, err := json.MarshalIndent(annotation, "", "    ")
+	if err != nil {
+		return err
+	}
+	return r.saveFile(requestID, "annotations.json", content)
+}
+
+// MaybeGetAnnotation returns a request's annotation, or nil if it doesn't
+// have one.
+func (r *Recorder) MaybeGetAnnotation(requestID int) (*Annotation, error) {
+	content, err := r.loadFile(requestID, "annotations.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseAnnotation(content)
+}
+
+// ParseAnnotation decodes an annotations.json, for RecorderLoader
+// implementations.
+func ParseAnnotation(content []byte) (*Annotation, error) {
+	var annotation Annotation
+	err := json.Unmarshal(content, &annotation)
+	if err != nil {
+		return nil, err
+	}
+	return &annotation, nil
+}
+
+// DeleteRequest removes a request, or a checkpoint, from a recording. What
+// it leaves behind is a checkpoint marked as deleted, with the snapshot
+// taken after the request if there was one. The requests after it are then
+// still diffed against that snapshot, so their diffs don't pick up the
+// deleted request's changes, and the other requests keep their IDs.
+func (r *Recorder) DeleteRequest(requestID int) error {
+	if _, err := os.Stat(r.requestPath(requestID)); err != nil {
+		return err
+	}
+
+	checkpoint, err := r.MaybeGetCheckpoint(requestID)
+	if err != nil {
+		return err
+	}
+	var deleted Checkpoint
+	switch {
+	case checkpoint != nil && checkpoint.Deleted:
+		return ErrDeleted
+	case checkpoint != nil:
+		deleted = Checkpoint{Label: checkpoint.Label, TakenAt: checkpoint.TakenAt}
+	default:
+		deleted, err = r.deletedRequestCheckpoint(requestID)
+		if err != nil {
+			return err
+		}
+	}
+	deleted.Deleted = true
+
+	// The checkpoint is saved first, so that a crash part way through
+	// leaves a checkpoint rather than a request with missing files.
+	err = r.SaveCheckpoint(requestID, deleted)
+	if err != nil {
+		return err
+	}
+	for _, filename := range []string{
+		"request.txt",
+		"response.txt",
+		"meta.json",
+		"pre-snapshot.txt",
+		"annotations.json",
+	} {
+		err := r.removeFile(requestID, filename)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletedRequestCheckpoint returns the checkpoint a request is replaced with
+// when it's deleted, labeled with its operation name.
+func (r *Recorder) deletedRequestCheckpoint(requestID int) (Checkpoint, error) {
+	checkpoint := Checkpoint{Label: "request " + r.FormatRequestID(requestID)}
+
+	request, err := r.loadFile(requestID, "request.txt")
+	if err != nil && !os.IsNotExist(err) {
+		return Checkpoint{}, err
+	}
+	var operation struct {
+		OperationName string `json:"operationName"`
+	}
+	if json.Unmarshal(request, &operation) == nil && operation.OperationName != "" {
+		checkpoint.Label = operation.OperationName
+	}
+
+	meta, err := r.MaybeGetMeta(requestID)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if meta != nil {
+		checkpoint.TakenAt = meta.CompletedAt
+	}
+	if checkpoint.TakenAt.IsZero() {
+		checkpoint.TakenAt = time.Now()
+	}
+	return checkpoint, nil
+}
+
+func (r *Recorder) removeFile(requestID int, filename string) error {
+	err := os.Remove(filepath.Join(r.requestPath(requestID), filename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package recorder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type annotationSuite struct {
+	suite.Suite
+	rec *Recorder
+}
+
+func (suite *annotationSuite) BeforeTest(suiteName, testName string) {
+	rootPath, err := ioutil.TempDir("", "annotation")
+	suite.Require().NoError(err)
+	suite.rec = &Recorder{RootPath: rootPath}
+}
+
+func (suite *annotationSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rec.RootPath)
+}
+
+func (suite *annotationSuite) saveRequest(requestID int, snapshot string) {
+	suite.Require().NoError(suite.rec.SaveRequest(requestID, []byte(`{"operationName": "someMutation"}`)))
+	suite.Require().NoError(suite.rec.SaveResponse(requestID, []byte(`{}`)))
+	if snapshot != "" {
+		suite.Require().NoError(suite.rec.SaveSnapshot(requestID, []byte(snapshot)))
+	}
+}
+
+func (suite *annotationSuite) TestAnnotations() {
+	suite.saveRequest(1, "")
+
+	annotation, err := suite.rec.MaybeGetAnnotation(1)
+	suite.Require().NoError(err)
+	suite.Nil(annotation)
+
+	saved := Annotation{Notes: "the bug", Tags: []string{"bug"}, Starred: true}
+	suite.Require().NoError(suite.rec.SaveAnnotation(1, saved))
+	annotation, err = suite.rec.MaybeGetAnnotation(1)
+	suite.Require().NoError(err)
+	suite.Equal(&saved, annotation)
+
+	suite.Require().NoError(suite.rec.SaveAnnotation(1, Annotation{Tags: []string{" flaky", "", "flaky"}}))
+	annotation, err = suite.rec.MaybeGetAnnotation(1)
+	suite.Require().NoError(err)
+	suite.Equal(&Annotation{Tags: []string{"flaky"}}, annotation)
+
+	suite.Require().NoError(suite.rec.SaveAnnotation(1, Annotation{Tags: []string{" "}}))
+	annotation, err = suite.rec.MaybeGetAnnotation(1)
+	suite.Require().NoError(err)
+	suite.Nil(annotation)
+
+	err = suite.rec.SaveAnnotation(2, saved)
+	suite.True(os.IsNotExist(err))
+}
+
+func (suite *annotationSuite) TestDeletedRequestsKeepTheirSnapshot() {
+	suite.Require().NoError(suite.rec.SaveSnapshot(0, []byte("initial")))
+	suite.saveRequest(1, "after 1")
+	suite.Require().NoError(suite.rec.SaveMeta(1, RequestMeta{CompletedAt: time.Unix(100, 0).UTC()}))
+	suite.Require().NoError(suite.rec.SaveAnnotation(1, Annotation{Starred: true}))
+	suite.saveRequest(2, "after 2")
+	suite.saveRequest(3, "")
+
+	suite.Require().NoError(suite.rec.DeleteRequest(1))
+	suite.Require().NoError(suite.rec.DeleteRequest(3))
+	suite.True(errors.Is(suite.rec.DeleteRequest(3), ErrDeleted))
+
+	requestIDs, err := suite.rec.GetAllRequestIDs()
+	suite.Require().NoError(err)
+	suite.Equal([]int{1, 2, 3}, requestIDs)
+
+	checkpoint, err := suite.rec.MaybeGetCheckpoint(1)
+	suite.Require().NoError(err)
+	suite.Equal(&Checkpoint{Label: "someMutation", TakenAt: time.Unix(100, 0).UTC(), Deleted: true}, checkpoint)
+	for _, filename := range []string{"request.txt", "response.txt", "meta.json", "annotations.json"} {
+		exists, err := suite.rec.HasFile(1, filename)
+		suite.Require().NoError(err)
+		suite.False(exists, filename)
+	}
+
+	// Request 2 is still diffed against the deleted request's snapshot.
+	prior, err := suite.rec.GetPriorSnapshot(2)
+	suite.Require().NoError(err)
+	suite.Equal("after 1", string(prior))
+
+	snapshot, err := suite.rec.MaybeGetSnapshot(3)
+	suite.Require().NoError(err)
+	suite.Nil(snapshot)
+}
+
+func TestAnnotation(t *testing.T) {
+	suite.Run(t, new(annotationSuite))
+}
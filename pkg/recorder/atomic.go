@@ -0,0 +1,81 @@
+package recorder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// SyncMode controls how hard the recorder tries to make sure a write has
+// reached the disk before moving on.
+type SyncMode int
+
+const (
+	// SyncNone leaves flushing to the operating system. A crash can lose
+	// recent writes, but files are never left half written.
+	SyncNone SyncMode = iota
+	// SyncFile flushes each file before it replaces the old one.
+	SyncFile
+	// SyncAll also flushes the containing directory, so that the new file
+	// itself survives a crash.
+	SyncAll
+)
+
+func ParseSyncMode(s string) (SyncMode, error) {
+	switch s {
+	case "none":
+		return SyncNone, nil
+	case "file":
+		return SyncFile, nil
+	case "all":
+		return SyncAll, nil
+	}
+	return SyncNone, fmt.Errorf("invalid sync mode \"%s\", expected none, file or all", s)
+}
+
+// writeFileAtomic writes content to a temporary file in the same directory
+// as path and renames it into place, so readers and crashes never see a
+// partially written file.
+func writeFileAtomic(path string, content []byte, mode SyncMode) error {
+	dir := filepath.Dir(path)
+	tmpfile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpfile.Name()) // clean up if the rename doesn't happen
+
+	_, err = tmpfile.Write(content)
+	if err == nil && mode >= SyncFile {
+		err = tmpfile.Sync()
+	}
+	if closeErr := tmpfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmpfile.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpfile.Name(), path)
+	if err != nil {
+		return err
+	}
+
+	if mode >= SyncAll {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
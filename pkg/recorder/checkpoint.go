@@ -0,0 +1,57 @@
+package recorder
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Checkpoint is a snapshot taken on demand rather than after a request, e.g.
+// to capture changes made by a background job or a direct database edit.
+// It takes up a request ID, so it has a place in the timeline, but it has a
+// checkpoint.json and a snapshot instead of a request and a response.
+type Checkpoint struct {
+	Label   string    `json:"label"`
+	TakenAt time.Time `json:"takenAt"`
+	// Drift is set on checkpoints the proxy adds when a snapshot taken
+	// before a request differs from the previous snapshot, i.e. the
+	// upstream changed without a recorded request. The checkpoint's
+	// snapshot is the one taken before the request.
+	Drift bool `json:"drift,omitempty"`
+	// Deleted is set on what's left of a deleted request or checkpoint, see
+	// Recorder.DeleteRequest. Label is the deleted request's operation name
+	// or the deleted checkpoint's label.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+func (r *Recorder) SaveCheckpoint(requestID int, checkpoint Checkpoint) error {
+	content, err := json.MarshalIndent(checkpoint, "", "    ")
+	if err != nil {
+		return err
+	}
+	return r.saveFile(requestID, "checkpoint.json", content)
+}
+
+// MaybeGetCheckpoint returns the checkpoint with a request ID, or nil if the
+// request ID belongs to a request.
+func (r *Recorder) MaybeGetCheckpoint(requestID int) (*Checkpoint, error) {
+	content, err := r.loadFile(requestID, "checkpoint.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseCheckpoint(content)
+}
+
+// ParseCheckpoint decodes a checkpoint.json, for RecorderLoader
+// implementations.
+func ParseCheckpoint(content []byte) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	err := json.Unmarshal(content, &checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
@@ -0,0 +1,76 @@
+package recorder
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// combinedSessions presents the sessions of several record directories as
+// one, naming each session "<prefix>/<name>".
+type combinedSessions struct {
+	prefixes []string
+	loaders  map[string]SessionLoader
+}
+
+// CombineSessions returns a read-only SessionLoader for the sessions of
+// several loaders, keyed by the prefix their session names are given. It
+// has no active session.
+func CombineSessions(loaders map[string]SessionLoader) SessionLoader {
+	c := &combinedSessions{loaders: loaders}
+	for prefix := range loaders {
+		c.prefixes = append(c.prefixes, prefix)
+	}
+	sort.Strings(c.prefixes)
+	return c
+}
+
+func (c *combinedSessions) List() ([]Session, error) {
+	var sessions []Session
+	for _, prefix := range c.prefixes {
+		list, err := c.loaders[prefix].List()
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range list {
+			session.Name = prefix + "/" + session.Name
+			sessions = append(sessions, session)
+		}
+	}
+	return sessions, nil
+}
+
+func (c *combinedSessions) Get(name string) (Session, error) {
+	loader, prefix, sessionName, err := c.split(name)
+	if err != nil {
+		return Session{}, err
+	}
+	session, err := loader.Get(sessionName)
+	if err != nil {
+		return Session{}, err
+	}
+	session.Name = prefix + "/" + session.Name
+	return session, nil
+}
+
+func (c *combinedSessions) Active() (string, error) {
+	return "", ErrNoActiveSession
+}
+
+func (c *combinedSessions) Loader(name string) (RecorderLoader, error) {
+	loader, _, sessionName, err := c.split(name)
+	if err != nil {
+		return nil, err
+	}
+	return loader.Loader(sessionName)
+}
+
+func (c *combinedSessions) split(name string) (SessionLoader, string, string, error) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 {
+		if loader, ok := c.loaders[parts[0]]; ok {
+			return loader, parts[0], parts[1], nil
+		}
+	}
+	return nil, "", "", fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+}
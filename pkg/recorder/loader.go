@@ -0,0 +1,87 @@
+package recorder
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RecorderLoader is the read side of a recording. Recorder implements it for
+// record directories, and it can be implemented for recordings stored
+// elsewhere, e.g. in an archive.
+type RecorderLoader interface {
+	GetAllRequestIDs() ([]int, error)
+	GetRequest(requestID int) ([]byte, error)
+	GetResponse(requestID int) ([]byte, error)
+	MaybeGetSnapshot(requestID int) ([]byte, error)
+	MaybeGetPreSnapshot(requestID int) ([]byte, error)
+	GetPriorSnapshot(requestID int) ([]byte, error)
+	MaybeGetMeta(requestID int) (*RequestMeta, error)
+	MaybeGetCheckpoint(requestID int) (*Checkpoint, error)
+	MaybeGetAnnotation(requestID int) (*Annotation, error)
+	// HasFile reports whether a request has a file, e.g. "snapshot.txt",
+	// without reading it.
+	HasFile(requestID int, filename string) (bool, error)
+	FormatRequestID(requestID int) string
+}
+
+// SessionLoader is the read side of the sessions in a record directory.
+type SessionLoader interface {
+	List() ([]Session, error)
+	Get(name string) (Session, error)
+	Active() (string, error)
+	Loader(name string) (RecorderLoader, error)
+}
+
+// Loader returns a loader for the requests in a session.
+func (s *Sessions) Loader(name string) (RecorderLoader, error) {
+	return s.Recorder(name)
+}
+
+// FindPriorSnapshot returns the state of the upstream before a request, for
+// RecorderLoader implementations: the snapshot taken right before the
+// request was forwarded, if there is one, otherwise the most recent
+// snapshot recorded before the request.
+func FindPriorSnapshot(loader RecorderLoader, requestID int) ([]byte, error) {
+	if requestID <= 0 {
+		return nil, fmt.Errorf("invalid request ID, %d", requestID)
+	}
+
+	preSnapshot, err := loader.MaybeGetPreSnapshot(requestID)
+	if err != nil || preSnapshot != nil {
+		return preSnapshot, err
+	}
+
+	priorRequestID := requestID - 1
+
+	for priorRequestID >= 0 {
+		snapshot, err := loader.MaybeGetSnapshot(priorRequestID)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot != nil {
+			return snapshot, nil
+		}
+		priorRequestID -= 1
+	}
+
+	return nil, fmt.Errorf("%w, requestID %d", ErrNoPriorSnapshot, requestID)
+}
+
+// SnapshotAt returns the state of the upstream after a request: the
+// request's own snapshot, or the most recent one recorded before it. Request
+// 0 is the initial snapshot. It returns nil if there's no snapshot at or
+// before the request.
+func SnapshotAt(loader RecorderLoader, requestID int) ([]byte, error) {
+	if requestID < 0 {
+		return nil, fmt.Errorf("invalid request ID, %d", requestID)
+	}
+	snapshot, err := loader.MaybeGetSnapshot(requestID)
+	if err != nil || snapshot != nil || requestID == 0 {
+		return snapshot, err
+	}
+	snapshot, err = loader.GetPriorSnapshot(requestID)
+	if errors.Is(err, ErrNoPriorSnapshot) {
+		return nil, nil
+	}
+	return snapshot, err
+}
@@ -0,0 +1,202 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// LockFile is the name of the file that marks a record directory as in use
+// by a running proxy recorder.
+const LockFile = ".proxyrecorder.lock"
+
+var ErrLocked = errors.New("record directory is locked")
+
+// LockInfo describes the process holding a record directory's lock.
+type LockInfo struct {
+	PID       int       `json:"pid"`
+	Hostname  string    `json:"hostname"`
+	StartedAt time.Time `json:"startedAt"`
+	ToolURL   string    `json:"toolURL,omitempty"`
+}
+
+// LockedError is returned when another process holds the lock.
+type LockedError struct {
+	Path string
+	Info LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf(
+		"%s: %s by pid %d on %s since %s",
+		ErrLocked,
+		e.Path,
+		e.Info.PID,
+		e.Info.Hostname,
+		e.Info.StartedAt.Format("2006-01-02 15:04:05"),
+	)
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Lock is a held record directory lock.
+type Lock struct {
+	path string
+}
+
+// AcquireLock locks a record directory so that a second proxy recorder
+// can't write into it. A lock left behind by a process on this host that
+// no longer exists is taken over.
+func AcquireLock(rootPath string, info LockInfo) (*Lock, error) {
+	path := filepath.Join(rootPath, LockFile)
+
+	if info.PID == 0 {
+		info.PID = os.Getpid()
+	}
+	if info.Hostname == "" {
+		info.Hostname, _ = os.Hostname()
+	}
+	if info.StartedAt.IsZero() {
+		info.StartedAt = time.Now()
+	}
+	content, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		err = createLock(rootPath, path, content)
+		if err == nil {
+			return &Lock{path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, holder, err := readLock(path)
+		if err != nil {
+			return nil, err
+		}
+		if holder == nil {
+			// Released between our attempt and reading it.
+			continue
+		}
+		if !isStale(*holder) {
+			return nil, &LockedError{Path: rootPath, Info: *holder}
+		}
+		err = removeStaleLock(path, held)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %s, could not acquire lock", ErrLocked, rootPath)
+}
+
+// createLock writes the lock's content to a temporary file and links it into
+// place, so the lock file never exists without its content. Linking fails
+// with an os.IsExist error if the lock is held.
+func createLock(rootPath string, path string, content []byte) error {
+	f, err := ioutil.TempFile(rootPath, LockFile+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Link(f.Name(), path)
+}
+
+// removeStaleLock removes the lock file if it's still the stale one that was
+// read. Another process may have taken over the stale lock in the meantime,
+// so the lock is moved aside first and put back if its holder has changed.
+func removeStaleLock(path string, staleContent []byte) error {
+	aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+	err := os.Rename(path, aside)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer os.Remove(aside)
+
+	content, err := ioutil.ReadFile(aside)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(content, staleContent) {
+		return nil
+	}
+	err = os.Link(aside, path)
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// ReadLock returns information about the holder of a record directory's
+// lock, or nil if it isn't locked.
+func ReadLock(rootPath string) (*LockInfo, error) {
+	_, info, err := readLock(filepath.Join(rootPath, LockFile))
+	return info, err
+}
+
+func readLock(path string) ([]byte, *LockInfo, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var info LockInfo
+	err = json.Unmarshal(content, &info)
+	if err != nil {
+		// A lock file that can't be parsed was most likely left by a
+		// crash while it was being written.
+		return content, &LockInfo{}, nil
+	}
+	return content, &info, nil
+}
+
+// CheckUnlocked returns a *LockedError if another process holds the lock on
+// a record directory.
+func CheckUnlocked(rootPath string) error {
+	holder, err := ReadLock(rootPath)
+	if err != nil {
+		return err
+	}
+	if holder != nil && !isStale(*holder) {
+		return &LockedError{Path: rootPath, Info: *holder}
+	}
+	return nil
+}
+
+func (l *Lock) Release() error {
+	return os.Remove(l.path)
+}
+
+func isStale(info LockInfo) bool {
+	if info.PID == 0 {
+		return true
+	}
+	hostname, _ := os.Hostname()
+	if info.Hostname != hostname {
+		// We can't tell whether a process on another host is alive.
+		return false
+	}
+	return !processExists(info.PID)
+}
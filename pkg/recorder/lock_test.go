@@ -0,0 +1,106 @@
+package recorder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type lockSuite struct {
+	suite.Suite
+	rootPath string
+}
+
+func (suite *lockSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "lock")
+	suite.Require().NoError(err)
+}
+
+func (suite *lockSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *lockSuite) TestSecondLockIsRefused() {
+	lock, err := AcquireLock(suite.rootPath, LockInfo{ToolURL: "http://localhost:1234"})
+	suite.Require().NoError(err)
+
+	_, err = AcquireLock(suite.rootPath, LockInfo{})
+	suite.Require().True(errors.Is(err, ErrLocked))
+
+	var lockedErr *LockedError
+	suite.Require().True(errors.As(err, &lockedErr))
+	suite.Assert().Equal(os.Getpid(), lockedErr.Info.PID)
+	suite.Assert().Equal("http://localhost:1234", lockedErr.Info.ToolURL)
+
+	suite.Require().NoError(lock.Release())
+
+	lock, err = AcquireLock(suite.rootPath, LockInfo{})
+	suite.Require().NoError(err)
+	suite.Require().NoError(lock.Release())
+}
+
+func (suite *lockSuite) TestStaleLockIsTakenOver() {
+	hostname, _ := os.Hostname()
+	// PIDs are never this large, so the process can't exist.
+	stale := LockInfo{PID: 1 << 30, Hostname: hostname}
+	_, err := AcquireLock(suite.rootPath, stale)
+	suite.Require().NoError(err)
+
+	lock, err := AcquireLock(suite.rootPath, LockInfo{})
+	suite.Require().NoError(err)
+	suite.Require().NoError(lock.Release())
+}
+
+func (suite *lockSuite) TestReplacedStaleLockIsKept() {
+	hostname, _ := os.Hostname()
+	path := filepath.Join(suite.rootPath, LockFile)
+	_, err := AcquireLock(suite.rootPath, LockInfo{PID: 1 << 30, Hostname: hostname})
+	suite.Require().NoError(err)
+	stale, err := ioutil.ReadFile(path)
+	suite.Require().NoError(err)
+
+	// Another process takes over the stale lock before it's removed.
+	suite.Require().NoError(os.Remove(path))
+	lock, err := AcquireLock(suite.rootPath, LockInfo{})
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(removeStaleLock(path, stale))
+	holder, err := ReadLock(suite.rootPath)
+	suite.Require().NoError(err)
+	suite.Require().NotNil(holder)
+	suite.Assert().Equal(os.Getpid(), holder.PID)
+	suite.Require().NoError(lock.Release())
+
+	entries, err := ioutil.ReadDir(suite.rootPath)
+	suite.Require().NoError(err)
+	suite.Assert().Empty(entries, "temporary lock files should be removed")
+}
+
+func (suite *lockSuite) TestLockFileIsNotARequest() {
+	rec := &Recorder{RootPath: suite.rootPath, Sync: SyncAll}
+	_, err := AcquireLock(suite.rootPath, LockInfo{})
+	suite.Require().NoError(err)
+	suite.Require().NoError(rec.SaveRequest(1, []byte("request")))
+	suite.Require().NoError(rec.SaveRequest(1, []byte("replaced")))
+
+	requestIDs, err := rec.GetAllRequestIDs()
+	suite.Require().NoError(err)
+	suite.Assert().Equal([]int{1}, requestIDs)
+
+	entries, err := ioutil.ReadDir(filepath.Join(suite.rootPath, "request-000001"))
+	suite.Require().NoError(err)
+	suite.Require().Len(entries, 1, "temporary files should be renamed into place")
+
+	request, err := rec.GetRequest(1)
+	suite.Require().NoError(err)
+	suite.Assert().Equal("replaced", string(request))
+}
+
+func TestLock(t *testing.T) {
+	suite.Run(t, new(lockSuite))
+}
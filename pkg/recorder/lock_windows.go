@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package recorder
+
+// processExists can't cheaply check for a process on Windows, so locks are
+// never considered stale there. Remove the lock file by hand after a crash.
+func processExists(pid int) bool {
+	return true
+}
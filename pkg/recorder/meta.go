@@ -0,0 +1,57 @@
+package recorder
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+)
+
+// RequestMeta is the HTTP level information about a recorded request.
+// Recordings made before it was recorded don't have any.
+type RequestMeta struct {
+	Method         string      `json:"method"`
+	URL            string      `json:"url"`
+	RequestHeader  http.Header `json:"requestHeader,omitempty"`
+	Status         int         `json:"status"`
+	ResponseHeader http.Header `json:"responseHeader,omitempty"`
+	// SentAt is when the proxy received the request and CompletedAt is when
+	// the proxy received the response.
+	SentAt      time.Time `json:"sentAt"`
+	CompletedAt time.Time `json:"completedAt"`
+	// ReplayOf is set on requests that were replayed from the tool.
+	ReplayOf *ReplayOf `json:"replayOf,omitempty"`
+}
+
+// ReplayOf links a replayed request to the recorded request it was
+// replayed from, which may be in another session.
+type ReplayOf struct {
+	Session   string `json:"session"`
+	RequestID int    `json:"requestID"`
+}
+
+func (r *Recorder) SaveMeta(requestID int, meta RequestMeta) error {
+	content, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+	return r.saveFile(requestID, "meta.json", content)
+}
+
+// MaybeGetMeta returns the metadata for a request, or nil if it wasn't
+// recorded.
+func (r *Recorder) MaybeGetMeta(requestID int) (*RequestMeta, error) {
+	content, err := r.loadFile(requestID, "meta.json")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var meta RequestMeta
+	err = json.Unmarshal(content, &meta)
+	if err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
@@ -3,39 +3,94 @@
 package recorder
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
 )
 
+// LostAndFound is the directory that request directories are moved into
+// when they're removed from a recording by a repair.
+const LostAndFound = "lost+found"
+
+// ErrNoPriorSnapshot is returned when a request doesn't have a prior
+// snapshot, e.g. in an imported recording that has no snapshots at all.
+var ErrNoPriorSnapshot = errors.New("prior snapshot not found")
+
 type Recorder struct {
 	RootPath string
+	// Sync controls whether writes are flushed to disk. Writes are always
+	// atomic: a crash never leaves a partially written file behind.
+	Sync SyncMode
 }
 
 type RecorderSaver interface {
 	SaveRequest(requestID int, content []byte) error
 	SaveResponse(requestID int, content []byte) error
 	SaveSnapshot(requestID int, content []byte) error
+	SavePreSnapshot(requestID int, content []byte) error
+	SaveMeta(requestID int, meta RequestMeta) error
+	SaveCheckpoint(requestID int, checkpoint Checkpoint) error
 	FormatRequestID(requestID int) string
 	NextRequestID() (int, error)
 }
 
+// NextRequestID returns the ID the next recorded request should use.
+// Request 0 is reserved for the initial snapshot, so the first request is 1.
 func (r *Recorder) NextRequestID() (int, error) {
 	requestIDs, err := r.GetAllRequestIDs()
 	if err != nil {
 		return 0, err
 	}
 	if len(requestIDs) == 0 {
-		return 0, nil
+		return 1, nil
 	}
 	return requestIDs[len(requestIDs)-1] + 1, nil
 }
 
+// knownEntries are the files and directories other than request directories
+// that the proxy recorder keeps in a record directory. Session entries can
+// sit alongside request directories in recordings made before sessions
+// existed.
+var knownEntries = map[string]bool{
+	"sessions":      true,
+	"sessions.json": true,
+	"session.json":  true,
+	LostAndFound:    true,
+	LockFile:        true,
+}
+
+var requestDirRegex = regexp.MustCompile(`^request-(\d{6})$`)
+
+// IsRecordingEntry reports whether a directory entry in a record directory
+// belongs to the proxy recorder, i.e. it's a request directory or one of
+// the files and directories the recorder keeps alongside them.
+func IsRecordingEntry(entry os.FileInfo) bool {
+	if knownEntries[entry.Name()] {
+		return true
+	}
+	if strings.HasPrefix(entry.Name(), LockFile+".") {
+		// Temporary files left while the lock is acquired.
+		return true
+	}
+	return entry.IsDir() && requestDirRegex.MatchString(entry.Name())
+}
+
+// warnedEntries holds the paths of unrelated entries that have already been
+// warned about, so that each one is only reported once.
+var warnedEntries sync.Map
+
+// GetAllRequestIDs returns the IDs of all recorded requests in ascending
+// order, excluding request 0 (the initial snapshot). Entries that aren't
+// request directories, e.g. .DS_Store or a README, are ignored with a
+// warning.
 func (r *Recorder) GetAllRequestIDs() ([]int, error) {
-	requestDirRegex := regexp.MustCompile(`request-(\d{6})`)
 	requestDirs, err := ioutil.ReadDir(r.RootPath)
 	if err != nil {
 		return nil, err
@@ -45,9 +100,16 @@ func (r *Recorder) GetAllRequestIDs() ([]int, error) {
 	}
 	requestIDs := make([]int, 0, len(requestDirs))
 	for _, requestDir := range requestDirs {
+		if !IsRecordingEntry(requestDir) {
+			path := filepath.Join(r.RootPath, requestDir.Name())
+			if _, warned := warnedEntries.LoadOrStore(path, true); !warned {
+				log.Printf("warning: ignoring unrelated entry in record directory \"%s\"", path)
+			}
+			continue
+		}
 		matches := requestDirRegex.FindStringSubmatch(requestDir.Name())
-		if len(matches) == 0 {
-			return nil, fmt.Errorf("invalid request directory name \"%s\"", requestDir.Name())
+		if matches == nil {
+			continue
 		}
 		id, err := strconv.Atoi(matches[1])
 		if err != nil {
@@ -72,6 +134,13 @@ func (r *Recorder) SaveSnapshot(requestID int, content []byte) error {
 	return r.saveFile(requestID, "snapshot.txt", content)
 }
 
+// SavePreSnapshot saves a snapshot taken right before a request was
+// forwarded. Requests that have one are diffed against it instead of the
+// previous request's snapshot.
+func (r *Recorder) SavePreSnapshot(requestID int, content []byte) error {
+	return r.saveFile(requestID, "pre-snapshot.txt", content)
+}
+
 func (r *Recorder) GetRequest(requestID int) ([]byte, error) {
 	return r.loadFile(requestID, "request.txt")
 }
@@ -88,25 +157,55 @@ func (r *Recorder) MaybeGetSnapshot(requestID int) ([]byte, error) {
 	return snapshot, err
 }
 
-func (r *Recorder) GetPriorSnapshot(requestID int) ([]byte, error) {
-	if requestID <= 0 {
-		return nil, fmt.Errorf("invalid request ID, %d", requestID)
+func (r *Recorder) MaybeGetPreSnapshot(requestID int) ([]byte, error) {
+	snapshot, err := r.loadFile(requestID, "pre-snapshot.txt")
+	if os.IsNotExist(err) {
+		return nil, nil
 	}
+	return snapshot, err
+}
 
-	priorRequestID := requestID - 1
+func (r *Recorder) GetPriorSnapshot(requestID int) ([]byte, error) {
+	return FindPriorSnapshot(r, requestID)
+}
 
-	for priorRequestID >= 0 {
-		snapshot, err := r.MaybeGetSnapshot(priorRequestID)
-		if err != nil {
-			return nil, err
-		}
-		if snapshot != nil {
-			return snapshot, nil
+// HasFile reports whether a request directory contains a file, e.g.
+// "request.txt".
+func (r *Recorder) HasFile(requestID int, filename string) (bool, error) {
+	_, err := os.Stat(filepath.Join(r.requestPath(requestID), filename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Quarantine moves a request directory into the lost+found directory of the
+// recording, where it's no longer considered part of the recording but can
+// still be inspected by hand.
+func (r *Recorder) Quarantine(requestID int) (string, error) {
+	lostAndFound := filepath.Join(r.RootPath, LostAndFound)
+	err := os.MkdirAll(lostAndFound, 0755)
+	if err != nil {
+		return "", err
+	}
+	name := "request-" + r.FormatRequestID(requestID)
+	dest := filepath.Join(lostAndFound, name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dest); os.IsNotExist(err) {
+			break
 		}
-		priorRequestID -= 1
+		dest = filepath.Join(lostAndFound, fmt.Sprintf("%s.%d", name, i))
 	}
+	return dest, os.Rename(r.requestPath(requestID), dest)
+}
 
-	return nil, fmt.Errorf("prior snapshot not found, requestID %d", requestID)
+// Renumber moves a request directory to a new request ID. The new ID must
+// not be in use.
+func (r *Recorder) Renumber(requestID int, newRequestID int) error {
+	if _, err := os.Stat(r.requestPath(newRequestID)); err == nil {
+		return fmt.Errorf("request %s already exists", r.FormatRequestID(newRequestID))
+	}
+	return os.Rename(r.requestPath(requestID), r.requestPath(newRequestID))
 }
 
 func (r *Recorder) saveFile(requestID int, filename string, content []byte) error {
@@ -116,7 +215,7 @@ func (r *Recorder) saveFile(requestID int, filename string, content []byte) erro
 		return err
 	}
 	path := filepath.Join(dir, filename)
-	return ioutil.WriteFile(path, content, 0644)
+	return writeFileAtomic(path, content, r.Sync)
 }
 
 func (r *Recorder) loadFile(requestID int, filename string) ([]byte, error) {
@@ -0,0 +1,371 @@
+package recorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LegacySessionName is the name given to recordings made before sessions
+// existed, where request directories live directly in the record directory.
+const LegacySessionName = "default"
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExists   = errors.New("session already exists")
+	ErrNoActiveSession = errors.New("no active session")
+)
+
+var (
+	sessionNameRegex    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	invalidSessionChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// SessionName makes a session name from s, e.g. a file name, replacing
+// the characters that aren't allowed in one with "-". It returns "" if
+// nothing of s can be used.
+func SessionName(s string) string {
+	name := invalidSessionChars.ReplaceAllString(s, "-")
+	return strings.Trim(name, "._-")
+}
+
+// Session describes a named recording within a record directory. Context
+// holds whatever the snapshotter needs to know to reproduce the recording,
+// e.g. the kaid and exam group used for snapshots.
+type Session struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	StartedAt   time.Time         `json:"startedAt"`
+	EndedAt     *time.Time        `json:"endedAt,omitempty"`
+	Context     map[string]string `json:"context,omitempty"`
+	// SnapshotType is the content type of the session's snapshots, if the
+	// snapshotter declared one.
+	SnapshotType string `json:"snapshotType,omitempty"`
+	// Drifted is set when the upstream changed between snapshots without a
+	// recorded request, see Checkpoint.Drift.
+	Drifted bool `json:"drifted,omitempty"`
+}
+
+func (s Session) Closed() bool {
+	return s.EndedAt != nil
+}
+
+// Sessions manages the sessions stored in a record directory. Each session
+// lives in its own directory under sessions/ and has a session.json file
+// with its metadata. The name of the active session, the one the proxy
+// records into, is kept in sessions.json in the root of the record
+// directory.
+type Sessions struct {
+	RootPath string
+	// Sync is used for session metadata and the recorders of all sessions.
+	Sync SyncMode
+	// Hold when reading or writing session metadata
+	mu sync.Mutex
+}
+
+type sessionsIndex struct {
+	Active string `json:"active"`
+}
+
+func (s *Sessions) List() ([]Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var sessions []Session
+
+	legacy, err := s.legacySession()
+	if err != nil {
+		return nil, err
+	}
+	if legacy != nil {
+		sessions = append(sessions, *legacy)
+	}
+
+	entries, err := ioutil.ReadDir(s.sessionsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !sessionNameRegex.MatchString(entry.Name()) {
+			continue
+		}
+		session, err := s.loadSession(entry.Name())
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+
+	return sessions, nil
+}
+
+func (s *Sessions) Get(name string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.get(name)
+}
+
+func (s *Sessions) get(name string) (Session, error) {
+	// Names come from requests to the tool, so ones that aren't valid,
+	// e.g. paths outside the record directory, don't name a session.
+	if !sessionNameRegex.MatchString(name) {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+	}
+	if name == LegacySessionName {
+		legacy, err := s.legacySession()
+		if err != nil {
+			return Session{}, err
+		}
+		if legacy != nil {
+			return *legacy, nil
+		}
+	}
+	session, err := s.loadSession(name)
+	if os.IsNotExist(err) {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+	}
+	return session, err
+}
+
+// Create creates a new session. StartedAt defaults to the current time.
+func (s *Sessions) Create(session Session) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !sessionNameRegex.MatchString(session.Name) {
+		return Session{}, fmt.Errorf("invalid session name \"%s\"", session.Name)
+	}
+	if _, err := s.get(session.Name); err == nil {
+		return Session{}, fmt.Errorf("%w: %s", ErrSessionExists, session.Name)
+	} else if !errors.Is(err, ErrSessionNotFound) {
+		return Session{}, err
+	}
+
+	if session.StartedAt.IsZero() {
+		session.StartedAt = time.Now()
+	}
+	session.EndedAt = nil
+
+	err := os.MkdirAll(s.sessionPath(session.Name), 0755)
+	if err != nil {
+		return Session{}, err
+	}
+	return session, s.saveSession(session)
+}
+
+// Close records the end time of a session. Closing a session that is
+// already closed is a no-op.
+func (s *Sessions) Close(name string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.get(name)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.Closed() {
+		return session, nil
+	}
+	now := time.Now()
+	session.EndedAt = &now
+	return session, s.saveSession(session)
+}
+
+// MarkDrifted flags a session as having drifted.
+func (s *Sessions) MarkDrifted(name string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.get(name)
+	if err != nil {
+		return Session{}, err
+	}
+	if session.Drifted {
+		return session, nil
+	}
+	session.Drifted = true
+	return session, s.saveSession(session)
+}
+
+// Reopen clears the end time of a closed session so it can be recorded into
+// again.
+func (s *Sessions) Reopen(name string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.get(name)
+	if err != nil {
+		return Session{}, err
+	}
+	if !session.Closed() {
+		return session, nil
+	}
+	session.EndedAt = nil
+	return session, s.saveSession(session)
+}
+
+// Active returns the name of the active session, or ErrNoActiveSession.
+func (s *Sessions) Active() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, err := ioutil.ReadFile(s.indexPath())
+	if os.IsNotExist(err) {
+		return "", ErrNoActiveSession
+	}
+	if err != nil {
+		return "", err
+	}
+	var index sessionsIndex
+	err = json.Unmarshal(content, &index)
+	if err != nil {
+		return "", err
+	}
+	if index.Active == "" {
+		return "", ErrNoActiveSession
+	}
+	return index.Active, nil
+}
+
+// SetActive marks a session as active. An empty name clears the active
+// session.
+func (s *Sessions) SetActive(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if name != "" {
+		if _, err := s.get(name); err != nil {
+			return err
+		}
+	}
+	content, err := json.MarshalIndent(sessionsIndex{Active: name}, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.indexPath(), content, s.Sync)
+}
+
+// Resolve returns the named session, or the active session if name is
+// empty. Recordings with a single session don't need an active one.
+func (s *Sessions) Resolve(name string) (Session, error) {
+	if name != "" {
+		return s.Get(name)
+	}
+	active, err := s.Active()
+	if err == nil {
+		return s.Get(active)
+	}
+	if !errors.Is(err, ErrNoActiveSession) {
+		return Session{}, err
+	}
+	list, err := s.List()
+	if err != nil {
+		return Session{}, err
+	}
+	if len(list) == 1 {
+		return list[0], nil
+	}
+	if len(list) == 0 {
+		return Session{}, fmt.Errorf("%w, there are no sessions", ErrSessionNotFound)
+	}
+	return Session{}, fmt.Errorf("%w, and there is more than one session", ErrNoActiveSession)
+}
+
+// Recorder returns a recorder for the requests in a session.
+func (s *Sessions) Recorder(name string) (*Recorder, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{RootPath: s.pathFor(session.Name), Sync: s.Sync}, nil
+}
+
+func (s *Sessions) pathFor(name string) string {
+	if name == LegacySessionName && s.hasLegacyRequests() {
+		return s.RootPath
+	}
+	return s.sessionPath(name)
+}
+
+// hasLegacyRequests reports whether requests were recorded directly into the
+// record directory, before sessions existed.
+func (s *Sessions) hasLegacyRequests() bool {
+	_, err := os.Stat((&Recorder{RootPath: s.RootPath}).requestPath(0))
+	return err == nil
+}
+
+// legacySession returns the session for request directories that live
+// directly in the record directory, or nil if there aren't any. Its
+// metadata is synthesized unless it has been given a session.json.
+func (s *Sessions) legacySession() (*Session, error) {
+	if !s.hasLegacyRequests() {
+		return nil, nil
+	}
+	session, err := s.loadSession(LegacySessionName)
+	if err == nil {
+		return &session, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	info, err := os.Stat((&Recorder{RootPath: s.RootPath}).requestPath(0))
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		Name:      LegacySessionName,
+		StartedAt: info.ModTime(),
+	}, nil
+}
+
+func (s *Sessions) loadSession(name string) (Session, error) {
+	content, err := ioutil.ReadFile(filepath.Join(s.pathFor(name), "session.json"))
+	if err != nil {
+		return Session{}, err
+	}
+	var session Session
+	err = json.Unmarshal(content, &session)
+	if err != nil {
+		return Session{}, fmt.Errorf("session %s: %w", name, err)
+	}
+	session.Name = name
+	return session, nil
+}
+
+func (s *Sessions) saveSession(session Session) error {
+	content, err := json.MarshalIndent(session, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.pathFor(session.Name), "session.json"), content, s.Sync)
+}
+
+func (s *Sessions) sessionsPath() string {
+	return filepath.Join(s.RootPath, "sessions")
+}
+
+func (s *Sessions) sessionPath(name string) string {
+	return filepath.Join(s.sessionsPath(), name)
+}
+
+func (s *Sessions) indexPath() string {
+	return filepath.Join(s.RootPath, "sessions.json")
+}
@@ -0,0 +1,170 @@
+package recorder
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type sessionSuite struct {
+	suite.Suite
+	rootPath string
+	sessions *Sessions
+}
+
+func (suite *sessionSuite) BeforeTest(suiteName, testName string) {
+	rootPath, err := ioutil.TempDir("", "session")
+	suite.Require().NoError(err)
+	suite.rootPath = rootPath
+	suite.sessions = &Sessions{RootPath: rootPath}
+}
+
+func (suite *sessionSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *sessionSuite) TestCreate() {
+	startedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	session, err := suite.sessions.Create(Session{
+		Name:         "signup-flow",
+		Description:  "signing up",
+		StartedAt:    startedAt,
+		SnapshotType: "application/json",
+	})
+	suite.Require().NoError(err)
+	suite.Equal("signup-flow", session.Name)
+	suite.FileExists(filepath.Join(suite.rootPath, "sessions", "signup-flow", "session.json"))
+
+	loaded, err := suite.sessions.Get("signup-flow")
+	suite.Require().NoError(err)
+	suite.Equal("signing up", loaded.Description)
+	suite.True(startedAt.Equal(loaded.StartedAt))
+	suite.Equal("application/json", loaded.SnapshotType)
+	suite.False(loaded.Closed())
+
+	_, err = suite.sessions.Create(Session{Name: "signup-flow"})
+	suite.True(errors.Is(err, ErrSessionExists))
+
+	_, err = suite.sessions.Get("login-flow")
+	suite.True(errors.Is(err, ErrSessionNotFound))
+}
+
+func (suite *sessionSuite) TestList() {
+	sessions, err := suite.sessions.List()
+	suite.Require().NoError(err)
+	suite.Empty(sessions)
+
+	now := time.Now()
+	_, err = suite.sessions.Create(Session{Name: "second", StartedAt: now})
+	suite.Require().NoError(err)
+	_, err = suite.sessions.Create(Session{Name: "first", StartedAt: now.Add(-time.Hour)})
+	suite.Require().NoError(err)
+	// Directories that aren't sessions are skipped.
+	suite.Require().NoError(os.Mkdir(filepath.Join(suite.rootPath, "sessions", "empty"), 0755))
+	suite.Require().NoError(os.Mkdir(filepath.Join(suite.rootPath, "sessions", ".hidden"), 0755))
+
+	sessions, err = suite.sessions.List()
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 2)
+	suite.Equal("first", sessions[0].Name, "sessions are listed in the order they started")
+	suite.Equal("second", sessions[1].Name)
+}
+
+func (suite *sessionSuite) TestActive() {
+	_, err := suite.sessions.Active()
+	suite.True(errors.Is(err, ErrNoActiveSession))
+
+	_, err = suite.sessions.Create(Session{Name: "signup-flow"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.sessions.SetActive("signup-flow"))
+	active, err := suite.sessions.Active()
+	suite.Require().NoError(err)
+	suite.Equal("signup-flow", active)
+
+	err = suite.sessions.SetActive("login-flow")
+	suite.True(errors.Is(err, ErrSessionNotFound))
+	active, err = suite.sessions.Active()
+	suite.Require().NoError(err)
+	suite.Equal("signup-flow", active, "the active session doesn't change")
+
+	suite.Require().NoError(suite.sessions.SetActive(""))
+	_, err = suite.sessions.Active()
+	suite.True(errors.Is(err, ErrNoActiveSession))
+}
+
+func (suite *sessionSuite) TestLegacyDefault() {
+	legacy := &Recorder{RootPath: suite.rootPath}
+	suite.Require().NoError(legacy.SaveSnapshot(0, []byte(`{}`)))
+
+	sessions, err := suite.sessions.List()
+	suite.Require().NoError(err)
+	suite.Require().Len(sessions, 1)
+	suite.Equal(LegacySessionName, sessions[0].Name)
+
+	session, err := suite.sessions.Resolve("")
+	suite.Require().NoError(err)
+	suite.Equal(LegacySessionName, session.Name, "a single session doesn't need to be active")
+
+	rec, err := suite.sessions.Recorder(LegacySessionName)
+	suite.Require().NoError(err)
+	suite.Equal(suite.rootPath, rec.RootPath, "requests are in the record directory")
+
+	// Closing it gives it a session.json, in the record directory.
+	closed, err := suite.sessions.Close(LegacySessionName)
+	suite.Require().NoError(err)
+	suite.True(closed.Closed())
+	suite.FileExists(filepath.Join(suite.rootPath, "session.json"))
+	session, err = suite.sessions.Get(LegacySessionName)
+	suite.Require().NoError(err)
+	suite.True(session.Closed())
+}
+
+func (suite *sessionSuite) TestInvalidNames() {
+	// A session.json outside the sessions directory, which names with
+	// paths would otherwise load.
+	outside := filepath.Join(suite.rootPath, "outside")
+	suite.Require().NoError(os.Mkdir(outside, 0755))
+	suite.Require().NoError(ioutil.WriteFile(filepath.Join(outside, "session.json"), []byte(`{}`), 0644))
+
+	for _, name := range []string{"", "../outside", "a/b", ".hidden", "-flag", "with space"} {
+		_, err := suite.sessions.Create(Session{Name: name})
+		suite.Error(err, name)
+
+		_, err = suite.sessions.Get(name)
+		suite.True(errors.Is(err, ErrSessionNotFound), name)
+		_, err = suite.sessions.Recorder(name)
+		suite.True(errors.Is(err, ErrSessionNotFound), name)
+		_, err = suite.sessions.Close(name)
+		suite.True(errors.Is(err, ErrSessionNotFound), name)
+	}
+	suite.NoDirExists(filepath.Join(suite.rootPath, "sessions"))
+}
+
+func (suite *sessionSuite) TestSessionName() {
+	for s, expected := range map[string]string{
+		"signup-flow":     "signup-flow",
+		"capture (1)":     "capture-1",
+		"www.example.com": "www.example.com",
+		"Ünïcode capture": "n-code-capture",
+		".hidden":         "hidden",
+		"../outside":      "outside",
+		"a/b":             "a-b",
+		"()":              "",
+	} {
+		name := SessionName(s)
+		suite.Equal(expected, name, s)
+		if name != "" {
+			_, err := suite.sessions.Create(Session{Name: name})
+			suite.NoError(err, s)
+		}
+	}
+}
+
+func TestSession(t *testing.T) {
+	suite.Run(t, new(sessionSuite))
+}
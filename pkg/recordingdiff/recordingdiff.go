@@ -0,0 +1,323 @@
+// Package recordingdiff compares two recordings of the same flow, e.g. one
+// made on main and one on a feature branch. Requests are aligned by
+// operation name and order, then each aligned pair is compared by response
+// and by what its snapshot changed.
+package recordingdiff
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+type Options struct {
+	// Ignore matches values left out when comparing responses and
+	// snapshots.
+	Ignore []*jsonpath.Pattern
+	// DecodeSnapshot decodes a snapshot for comparison. It defaults to
+	// decoding JSON.
+	DecodeSnapshot func(snapshot []byte) (interface{}, error)
+	// DecodeSnapshotB decodes the snapshots of recording b, if they're in
+	// a different format. It defaults to DecodeSnapshot.
+	DecodeSnapshotB func(snapshot []byte) (interface{}, error)
+}
+
+type PairKind string
+
+const (
+	PairSame    PairKind = "same"
+	PairChanged PairKind = "changed"
+	// PairAdded requests are only in the second recording and PairRemoved
+	// requests are only in the first.
+	PairAdded   PairKind = "added"
+	PairRemoved PairKind = "removed"
+)
+
+type Step struct {
+	RequestID     int                 `json:"requestID"`
+	OperationType proxy.OperationType `json:"operationType"`
+	OperationName string              `json:"operationName"`
+}
+
+// Pair is a request in either or both recordings.
+type Pair struct {
+	Kind PairKind `json:"kind"`
+	A    *Step    `json:"a,omitempty"`
+	B    *Step    `json:"b,omitempty"`
+	// ResponseChanges are the differences between the two responses.
+	ResponseChanges []jsondiff.Change `json:"responseChanges,omitempty"`
+	// SnapshotOnlyA are changes the request's snapshot made only in the
+	// first recording, and SnapshotOnlyB only in the second.
+	SnapshotOnlyA []jsondiff.Change `json:"snapshotOnlyA,omitempty"`
+	SnapshotOnlyB []jsondiff.Change `json:"snapshotOnlyB,omitempty"`
+	// Message describes differences that can't be expressed as changes,
+	// e.g. a snapshot taken in only one recording.
+	Message string `json:"message,omitempty"`
+}
+
+type Diff struct {
+	Pairs   []Pair `json:"pairs"`
+	Same    int    `json:"same"`
+	Changed int    `json:"changed"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Summary string `json:"summary"`
+}
+
+// step is a loaded request.
+type step struct {
+	Step
+	response interface{}
+	// delta is what the request's snapshot changed since the previous
+	// snapshot. hasSnapshot is false if the request had no snapshot.
+	delta       []jsondiff.Change
+	hasSnapshot bool
+	err         error
+}
+
+// Compare diffs recording b against recording a.
+func Compare(a, b recorder.RecorderLoader, options Options) (*Diff, error) {
+	if options.DecodeSnapshot == nil {
+		options.DecodeSnapshot = jsondiff.Decode
+	}
+	if options.DecodeSnapshotB == nil {
+		options.DecodeSnapshotB = options.DecodeSnapshot
+	}
+
+	stepsA, err := load(a, options.Ignore, options.DecodeSnapshot)
+	if err != nil {
+		return nil, err
+	}
+	stepsB, err := load(b, options.Ignore, options.DecodeSnapshotB)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Diff{Pairs: []Pair{}}
+	for _, pair := range align(stepsA, stepsB) {
+		i, j := pair[0], pair[1]
+		var p Pair
+		switch {
+		case j < 0:
+			p = Pair{Kind: PairRemoved, A: &stepsA[i].Step}
+			d.Removed++
+		case i < 0:
+			p = Pair{Kind: PairAdded, B: &stepsB[j].Step}
+			d.Added++
+		default:
+			p = comparePair(&stepsA[i], &stepsB[j])
+			if p.Kind == PairSame {
+				d.Same++
+			} else {
+				d.Changed++
+			}
+		}
+		d.Pairs = append(d.Pairs, p)
+	}
+
+	d.Summary = fmt.Sprintf(
+		"%d same, %d changed, %d added, %d removed",
+		d.Same,
+		d.Changed,
+		d.Added,
+		d.Removed,
+	)
+	return d, nil
+}
+
+func load(
+	rec recorder.RecorderLoader,
+	ignore []*jsonpath.Pattern,
+	decode func(snapshot []byte) (interface{}, error),
+) ([]step, error) {
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+
+	var prior interface{}
+	initial, err := rec.MaybeGetSnapshot(0)
+	if err != nil {
+		return nil, err
+	}
+	if initial != nil {
+		prior, err = decodeSnapshot(initial, ignore, decode)
+		if err != nil {
+			prior = nil
+		}
+	}
+
+	steps := make([]step, 0, len(requestIDs))
+	for _, requestID := range requestIDs {
+		// Checkpoints aren't compared, but their snapshots are the state
+		// the next request's snapshot changed, so changes made outside of
+		// the recording aren't attributed to it.
+		checkpoint, err := rec.MaybeGetCheckpoint(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if checkpoint != nil {
+			snapshot, err := rec.MaybeGetSnapshot(requestID)
+			if err != nil {
+				return nil, err
+			}
+			if snapshot != nil {
+				prior, err = decodeSnapshot(snapshot, ignore, decode)
+				if err != nil {
+					prior = nil
+				}
+			}
+			continue
+		}
+
+		s := step{Step: Step{RequestID: requestID}}
+
+		content, err := rec.GetRequest(requestID)
+		if err != nil {
+			return nil, err
+		}
+		graphQLRequest, err := proxy.ParseRequest(content)
+		if err != nil {
+			return nil, fmt.Errorf("request %s: %w", rec.FormatRequestID(requestID), err)
+		}
+		s.OperationType = graphQLRequest.OperationType
+		s.OperationName = graphQLRequest.OperationName
+
+		response, err := rec.GetResponse(requestID)
+		if err != nil {
+			return nil, err
+		}
+		s.response, err = jsondiff.Decode(response)
+		if err != nil {
+			s.err = fmt.Errorf("response isn't JSON")
+		} else {
+			s.response = jsonpath.Remove(s.response, ignore)
+		}
+
+		// Requests snapshotted before they were forwarded are diffed
+		// against that snapshot.
+		preSnapshot, err := rec.MaybeGetPreSnapshot(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if preSnapshot != nil {
+			prior, err = decodeSnapshot(preSnapshot, ignore, decode)
+			if err != nil {
+				prior = nil
+			}
+		}
+
+		snapshot, err := rec.MaybeGetSnapshot(requestID)
+		if err != nil {
+			return nil, err
+		}
+		if snapshot != nil {
+			s.hasSnapshot = true
+			current, err := decodeSnapshot(snapshot, ignore, decode)
+			switch {
+			case err != nil:
+				s.err = err
+			case prior != nil:
+				s.delta = jsondiff.Diff(prior, current)
+			}
+			prior = current
+		}
+
+		steps = append(steps, s)
+	}
+	return steps, nil
+}
+
+func decodeSnapshot(
+	snapshot []byte,
+	ignore []*jsonpath.Pattern,
+	decode func(snapshot []byte) (interface{}, error),
+) (interface{}, error) {
+	value, err := decode(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return jsonpath.Remove(value, ignore), nil
+}
+
+func comparePair(a, b *step) Pair {
+	p := Pair{Kind: PairSame, A: &a.Step, B: &b.Step}
+	var messages []string
+
+	switch {
+	case a.err != nil || b.err != nil:
+		for _, err := range []error{a.err, b.err} {
+			if err != nil {
+				messages = append(messages, err.Error())
+			}
+		}
+	default:
+		p.ResponseChanges = jsondiff.Diff(a.response, b.response)
+		if a.hasSnapshot != b.hasSnapshot {
+			messages = append(messages, "snapshot taken in only one recording")
+		} else {
+			p.SnapshotOnlyA = jsondiff.Subtract(a.delta, b.delta)
+			p.SnapshotOnlyB = jsondiff.Subtract(b.delta, a.delta)
+		}
+	}
+
+	p.Message = strings.Join(messages, ", ")
+	if len(p.ResponseChanges) > 0 ||
+		len(p.SnapshotOnlyA) > 0 ||
+		len(p.SnapshotOnlyB) > 0 ||
+		p.Message != "" {
+		p.Kind = PairChanged
+	}
+	return p
+}
+
+// align matches the steps of two recordings by operation name, keeping
+// their order, using the longest common subsequence. It returns index pairs
+// in order, with -1 for a step that's only in one recording.
+func align(a, b []step) [][2]int {
+	// lengths[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i].OperationName == b[j].OperationName {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	var pairs [][2]int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i].OperationName == b[j].OperationName:
+			pairs = append(pairs, [2]int{i, j})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			pairs = append(pairs, [2]int{i, -1})
+			i++
+		default:
+			pairs = append(pairs, [2]int{-1, j})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		pairs = append(pairs, [2]int{i, -1})
+	}
+	for ; j < len(b); j++ {
+		pairs = append(pairs, [2]int{-1, j})
+	}
+	return pairs
+}
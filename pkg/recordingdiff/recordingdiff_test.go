@@ -0,0 +1,125 @@
+package recordingdiff
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+type recordingDiffSuite struct {
+	suite.Suite
+	rootPath string
+	a        *recorder.Recorder
+	b        *recorder.Recorder
+}
+
+func (suite *recordingDiffSuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "recordingdiff")
+	suite.Require().NoError(err)
+	suite.a = &recorder.Recorder{RootPath: suite.rootPath + "/a"}
+	suite.b = &recorder.Recorder{RootPath: suite.rootPath + "/b"}
+}
+
+func (suite *recordingDiffSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *recordingDiffSuite) save(
+	rec *recorder.Recorder,
+	requestID int,
+	operation string,
+	response string,
+	snapshot string,
+) {
+	if operation != "" {
+		request := fmt.Sprintf(`{"operationName": "%s", "query": "query %s { field }"}`, operation, operation)
+		suite.Require().NoError(rec.SaveRequest(requestID, []byte(request)))
+		suite.Require().NoError(rec.SaveResponse(requestID, []byte(response)))
+	}
+	if snapshot != "" {
+		suite.Require().NoError(rec.SaveSnapshot(requestID, []byte(snapshot)))
+	}
+}
+
+func (suite *recordingDiffSuite) TestCompare() {
+	suite.save(suite.a, 0, "", "", `{"points": 0, "streak": 0}`)
+	suite.save(suite.a, 1, "getUser", `{"data": {"name": "a"}}`, "")
+	suite.save(suite.a, 2, "removedOperation", `{}`, "")
+	suite.save(suite.a, 3, "answer", `{"data": {"correct": true}}`, `{"points": 10, "streak": 1}`)
+	suite.save(suite.a, 4, "getPoints", `{"data": {"points": 10}}`, "")
+
+	suite.save(suite.b, 0, "", "", `{"points": 0, "streak": 0}`)
+	suite.save(suite.b, 1, "getUser", `{"data": {"name": "a"}}`, "")
+	suite.save(suite.b, 2, "answer", `{"data": {"correct": true}}`, `{"points": 10, "streak": 0}`)
+	suite.save(suite.b, 3, "addedOperation", `{}`, "")
+	suite.save(suite.b, 4, "getPoints", `{"data": {"points": 11}}`, "")
+
+	d, err := Compare(suite.a, suite.b, Options{})
+	suite.Require().NoError(err)
+
+	suite.Equal("1 same, 2 changed, 1 added, 1 removed", d.Summary)
+	suite.Require().Len(d.Pairs, 5)
+
+	suite.Equal(PairSame, d.Pairs[0].Kind)
+	suite.Equal("getUser", d.Pairs[0].A.OperationName)
+
+	suite.Equal(PairRemoved, d.Pairs[1].Kind)
+	suite.Equal("removedOperation", d.Pairs[1].A.OperationName)
+	suite.Nil(d.Pairs[1].B)
+
+	suite.Equal(PairChanged, d.Pairs[2].Kind)
+	suite.Equal(3, d.Pairs[2].A.RequestID)
+	suite.Equal(2, d.Pairs[2].B.RequestID)
+	suite.Empty(d.Pairs[2].ResponseChanges)
+	suite.Require().Len(d.Pairs[2].SnapshotOnlyA, 1)
+	suite.Equal("streak", d.Pairs[2].SnapshotOnlyA[0].Path)
+	suite.Empty(d.Pairs[2].SnapshotOnlyB)
+
+	suite.Equal(PairAdded, d.Pairs[3].Kind)
+	suite.Equal("addedOperation", d.Pairs[3].B.OperationName)
+
+	suite.Equal(PairChanged, d.Pairs[4].Kind)
+	suite.Require().Len(d.Pairs[4].ResponseChanges, 1)
+	suite.Equal("data.points", d.Pairs[4].ResponseChanges[0].Path)
+}
+
+func (suite *recordingDiffSuite) TestCheckpointsArentCompared() {
+	suite.save(suite.a, 0, "", "", `{"points": 0, "jobs": 0}`)
+	suite.save(suite.a, 1, "answer", `{}`, `{"points": 10, "jobs": 0}`)
+	suite.Require().NoError(suite.a.SaveCheckpoint(2, recorder.Checkpoint{Label: "cron ran"}))
+	suite.save(suite.a, 2, "", "", `{"points": 10, "jobs": 1}`)
+	suite.save(suite.a, 3, "answer", `{}`, `{"points": 20, "jobs": 1}`)
+
+	suite.save(suite.b, 0, "", "", `{"points": 0, "jobs": 0}`)
+	suite.save(suite.b, 1, "answer", `{}`, `{"points": 10, "jobs": 0}`)
+	suite.save(suite.b, 2, "answer", `{}`, `{"points": 20, "jobs": 0}`)
+
+	d, err := Compare(suite.a, suite.b, Options{})
+	suite.Require().NoError(err)
+
+	suite.Equal("2 same, 0 changed, 0 added, 0 removed", d.Summary)
+	suite.Equal(3, d.Pairs[1].A.RequestID)
+}
+
+func (suite *recordingDiffSuite) TestPreSnapshots() {
+	suite.save(suite.a, 0, "", "", `{"points": 0, "jobs": 0}`)
+	suite.Require().NoError(suite.a.SavePreSnapshot(1, []byte(`{"points": 0, "jobs": 1}`)))
+	suite.save(suite.a, 1, "answer", `{}`, `{"points": 10, "jobs": 1}`)
+
+	suite.save(suite.b, 0, "", "", `{"points": 0, "jobs": 0}`)
+	suite.save(suite.b, 1, "answer", `{}`, `{"points": 10, "jobs": 0}`)
+
+	d, err := Compare(suite.a, suite.b, Options{})
+	suite.Require().NoError(err)
+
+	suite.Equal("1 same, 0 changed, 0 added, 0 removed", d.Summary)
+}
+
+func TestRecordingDiff(t *testing.T) {
+	suite.Run(t, new(recordingDiffSuite))
+}
@@ -0,0 +1,130 @@
+// Package redact removes secrets, like auth tokens, cookies and passwords,
+// from requests and responses before they're recorded, so recordings can be
+// shared.
+package redact
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/textproto"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+)
+
+// Placeholder replaces redacted values.
+const Placeholder = "<redacted>"
+
+// CredentialHeaders are redacted whatever the rules, since recordings are
+// shared and these would let anyone with a recording act as its user.
+var CredentialHeaders = []string{"Authorization", "Cookie", "Set-Cookie"}
+
+var credentialHeaders = headerSet(CredentialHeaders)
+
+type Rules struct {
+	// Headers are the names of request and response headers whose values
+	// are redacted, e.g. "X-Api-Key", as well as CredentialHeaders.
+	Headers []string `json:"headers,omitempty"`
+	// Request and Response are jsonpath patterns of the values redacted in
+	// request and response bodies, e.g. "variables.input.password" or
+	// "data.**.token".
+	Request  []string `json:"request,omitempty"`
+	Response []string `json:"response,omitempty"`
+}
+
+// Redactor applies compiled rules. It implements proxy.Redactor.
+type Redactor struct {
+	headers  map[string]bool
+	request  []*jsonpath.Pattern
+	response []*jsonpath.Pattern
+}
+
+func New(rules Rules) (*Redactor, error) {
+	r := &Redactor{headers: headerSet(append(rules.Headers, CredentialHeaders...))}
+	var err error
+	r.request, err = jsonpath.CompileAll(rules.Request)
+	if err != nil {
+		return nil, err
+	}
+	r.response, err = jsonpath.CompileAll(rules.Response)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func headerSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[textproto.CanonicalMIMEHeaderKey(name)] = true
+	}
+	return set
+}
+
+// RedactHeader returns header with the values of redacted headers
+// replaced. header itself isn't changed.
+func (r *Redactor) RedactHeader(header http.Header) http.Header {
+	return redactHeader(header, r.headers)
+}
+
+// RedactCredentials returns header with the values of CredentialHeaders
+// replaced, for recording without a Redactor. header itself isn't changed.
+func RedactCredentials(header http.Header) http.Header {
+	return redactHeader(header, credentialHeaders)
+}
+
+func redactHeader(header http.Header, names map[string]bool) http.Header {
+	redacted := header
+	cloned := false
+	for name, values := range header {
+		if !names[textproto.CanonicalMIMEHeaderKey(name)] {
+			continue
+		}
+		if !cloned {
+			redacted = header.Clone()
+			cloned = true
+		}
+		masked := make([]string, len(values))
+		for i := range masked {
+			masked[i] = Placeholder
+		}
+		redacted[name] = masked
+	}
+	return redacted
+}
+
+// RedactRequest redacts a request body.
+func (r *Redactor) RedactRequest(content []byte) []byte {
+	return redactJSON(content, r.request)
+}
+
+// RedactResponse redacts a response body.
+func (r *Redactor) RedactResponse(content []byte) []byte {
+	return redactJSON(content, r.response)
+}
+
+// redactJSON replaces the values at paths matching the patterns. Content
+// that isn't JSON, or that doesn't have any values to redact, is returned
+// as is; otherwise it's re-encoded, with object keys sorted.
+func redactJSON(content []byte, patterns []*jsonpath.Pattern) []byte {
+	if len(patterns) == 0 {
+		return content
+	}
+	value, err := jsondiff.Decode(content)
+	if err != nil {
+		return content
+	}
+	redacted := false
+	value = jsonpath.Transform(value, patterns, func([]string, interface{}) (interface{}, bool) {
+		redacted = true
+		return Placeholder, true
+	})
+	if !redacted {
+		return content
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return content
+	}
+	return encoded
+}
@@ -0,0 +1,87 @@
+package redact
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type redactSuite struct {
+	suite.Suite
+}
+
+func (suite *redactSuite) TestHeaders() {
+	r, err := New(Rules{Headers: []string{"authorization", "Cookie"}})
+	suite.Require().NoError(err)
+
+	header := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"a=1", "b=2"},
+		"Accept":        {"*/*"},
+	}
+	suite.Equal(http.Header{
+		"Authorization": {Placeholder},
+		"Cookie":        {Placeholder, Placeholder},
+		"Accept":        {"*/*"},
+	}, r.RedactHeader(header))
+	suite.Equal("Bearer secret", header.Get("Authorization"), "the header isn't changed")
+}
+
+func (suite *redactSuite) TestCredentialsAreAlwaysRedacted() {
+	r, err := New(Rules{Headers: []string{"X-Api-Key"}})
+	suite.Require().NoError(err)
+
+	header := http.Header{
+		"Authorization": {"Bearer secret"},
+		"Cookie":        {"a=1"},
+		"Set-Cookie":    {"b=2; HttpOnly"},
+		"X-Api-Key":     {"key"},
+		"Accept":        {"*/*"},
+	}
+	suite.Equal(http.Header{
+		"Authorization": {Placeholder},
+		"Cookie":        {Placeholder},
+		"Set-Cookie":    {Placeholder},
+		"X-Api-Key":     {Placeholder},
+		"Accept":        {"*/*"},
+	}, r.RedactHeader(header))
+	suite.Equal(http.Header{
+		"Authorization": {Placeholder},
+		"Cookie":        {Placeholder},
+		"Set-Cookie":    {Placeholder},
+		"X-Api-Key":     {"key"},
+		"Accept":        {"*/*"},
+	}, RedactCredentials(header))
+	suite.Equal("a=1", header.Get("Cookie"), "the header isn't changed")
+}
+
+func (suite *redactSuite) TestBodies() {
+	r, err := New(Rules{
+		Request:  []string{"variables.input.password"},
+		Response: []string{"data.**.token"},
+	})
+	suite.Require().NoError(err)
+
+	suite.JSONEq(
+		`{"query": "mutation { login }", "variables": {"input": {"email": "a@b.c", "password": "<redacted>"}}}`,
+		string(r.RedactRequest([]byte(`{"query": "mutation { login }", "variables": {"input": {"email": "a@b.c", "password": "hunter2"}}}`))),
+	)
+	suite.JSONEq(
+		`{"data": {"login": {"user": {"token": "<redacted>"}, "id": 12345678901234567890}}}`,
+		string(r.RedactResponse([]byte(`{"data": {"login": {"user": {"token": "t"}, "id": 12345678901234567890}}}`))),
+	)
+
+	unchanged := []byte(`{"data":  {"b": 1, "a": 2}}`)
+	suite.Equal(string(unchanged), string(r.RedactResponse(unchanged)))
+	suite.Equal("not json", string(r.RedactResponse([]byte("not json"))))
+}
+
+func (suite *redactSuite) TestInvalidRules() {
+	_, err := New(Rules{Request: []string{"a..b"}})
+	suite.Error(err)
+}
+
+func TestRedact(t *testing.T) {
+	suite.Run(t, new(redactSuite))
+}
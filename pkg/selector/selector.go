@@ -0,0 +1,455 @@
+// Package selector decides which requests are recorded and which are
+// snapshotted, from rules that match on the operation, its variables and
+// the HTTP request. Rules are usually loaded from the config file, see the
+// config package.
+package selector
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/textproto"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+)
+
+// Action is what's done with a request a rule matches.
+type Action string
+
+const (
+	// Ignore doesn't record the request.
+	Ignore Action = "ignore"
+	// Record records the request and its response.
+	Record Action = "record"
+	// Snapshot records the request and takes a snapshot after it.
+	Snapshot Action = "snapshot"
+)
+
+// The operators of variable conditions.
+const (
+	OpEqual        = "eq"
+	OpNotEqual     = "ne"
+	OpLess         = "lt"
+	OpLessEqual    = "le"
+	OpGreater      = "gt"
+	OpGreaterEqual = "ge"
+	OpIn           = "in"
+	OpMatches      = "matches"
+	OpExists       = "exists"
+	OpMissing      = "missing"
+)
+
+// Rule matches requests and decides what's done with them. A rule matches
+// a request if all of its conditions do; a rule without conditions matches
+// every request.
+//
+// Name patterns are globs, in which "*" matches any run of characters and
+// "?" any one character, or regular expressions when prefixed with "~",
+// e.g. "~^(Create|Update)Task$". Globs match the whole string; regular
+// expressions match anywhere unless anchored.
+type Rule struct {
+	// Name identifies the rule in errors.
+	Name string `json:"name,omitempty"`
+	// Priority orders the rules. Rules with a higher priority are tried
+	// first, and rules with the same priority are tried in the order
+	// they're listed. The first rule that matches decides.
+	Priority int `json:"priority,omitempty"`
+
+	// OperationType is "query", "mutation" or "unknown".
+	OperationType proxy.OperationType `json:"operationType,omitempty"`
+	// OperationName is a name pattern.
+	OperationName string `json:"operationName,omitempty"`
+	// RootFields are name patterns. The rule matches if any of the fields
+	// the operation selects at its root matches any of them.
+	RootFields []string `json:"rootFields,omitempty"`
+	// Variables are conditions on the operation's variables. All of them
+	// have to hold.
+	Variables []VariableCondition `json:"variables,omitempty"`
+	// Path is a name pattern for the URL path.
+	Path string `json:"path,omitempty"`
+	// Headers map header names to name patterns for their values. A header
+	// that isn't sent matches only "".
+	Headers map[string]string `json:"headers,omitempty"`
+
+	Action Action `json:"action"`
+}
+
+// VariableCondition compares the variable values at a path with a value.
+type VariableCondition struct {
+	// Path is a jsonpath pattern, e.g. "input.taskID" or "ids.*". When it
+	// matches several values, the condition holds if it holds for any of
+	// them, except for "ne", which holds if none of them are equal.
+	Path string `json:"path"`
+	// Op is one of "eq" (the default), "ne", "lt", "le", "gt", "ge", "in"
+	// (Value is a list), "matches" (Value is a name pattern matched against
+	// strings and numbers), "exists" and "missing".
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Config is a selector's rules.
+type Config struct {
+	// Default is the action for requests no rule matches. It defaults to
+	// Record.
+	Default Action `json:"default,omitempty"`
+	Rules   []Rule `json:"rules,omitempty"`
+}
+
+// DefaultConfig records every request and snapshots mutations.
+func DefaultConfig() Config {
+	return Config{
+		Default: Record,
+		Rules: []Rule{
+			{Name: "snapshot mutations", OperationType: proxy.OperationTypeMutation, Action: Snapshot},
+		},
+	}
+}
+
+// pattern matches names.
+type pattern interface {
+	MatchString(s string) bool
+}
+
+func compilePattern(p string) (pattern, error) {
+	if strings.HasPrefix(p, "~") {
+		return regexp.Compile(p[1:])
+	}
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, r := range p {
+		switch r {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+type compiledCondition struct {
+	VariableCondition
+	path    *jsonpath.Pattern
+	pattern pattern
+}
+
+type compiledRule struct {
+	Rule
+	operationName pattern
+	rootFields    []pattern
+	variables     []compiledCondition
+	path          pattern
+	headers       map[string]pattern
+}
+
+// Selector is a proxy.RequestSelector that applies rules.
+type Selector struct {
+	config Config
+	rules  []compiledRule
+}
+
+// New compiles a selector's rules.
+func New(config Config) (*Selector, error) {
+	if config.Default == "" {
+		config.Default = Record
+	}
+	if err := checkAction(config.Default); err != nil {
+		return nil, fmt.Errorf("default: %w", err)
+	}
+
+	s := &Selector{config: config}
+	for i, rule := range config.Rules {
+		compiled, err := compileRule(rule)
+		if err != nil {
+			name := rule.Name
+			if name == "" {
+				name = fmt.Sprintf("%d", i+1)
+			}
+			return nil, fmt.Errorf("rule %s: %w", name, err)
+		}
+		s.rules = append(s.rules, compiled)
+	}
+	sort.SliceStable(s.rules, func(i, j int) bool {
+		return s.rules[i].Priority > s.rules[j].Priority
+	})
+	return s, nil
+}
+
+func checkAction(action Action) error {
+	switch action {
+	case Ignore, Record, Snapshot:
+		return nil
+	case "":
+		return fmt.Errorf("missing action, expected %s, %s or %s", Ignore, Record, Snapshot)
+	}
+	return fmt.Errorf("invalid action \"%s\", expected %s, %s or %s", action, Ignore, Record, Snapshot)
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	compiled := compiledRule{Rule: rule}
+	if err := checkAction(rule.Action); err != nil {
+		return compiled, err
+	}
+
+	switch rule.OperationType {
+	case "", proxy.OperationTypeQuery, proxy.OperationTypeMutation, proxy.OperationTypeUnknown:
+	default:
+		return compiled, fmt.Errorf(
+			"invalid operationType \"%s\", expected %s, %s or %s",
+			rule.OperationType,
+			proxy.OperationTypeQuery,
+			proxy.OperationTypeMutation,
+			proxy.OperationTypeUnknown,
+		)
+	}
+
+	var err error
+	if rule.OperationName != "" {
+		compiled.operationName, err = compilePattern(rule.OperationName)
+		if err != nil {
+			return compiled, fmt.Errorf("operationName: %w", err)
+		}
+	}
+	for _, field := range rule.RootFields {
+		p, err := compilePattern(field)
+		if err != nil {
+			return compiled, fmt.Errorf("rootFields: %w", err)
+		}
+		compiled.rootFields = append(compiled.rootFields, p)
+	}
+	for _, condition := range rule.Variables {
+		c, err := compileCondition(condition)
+		if err != nil {
+			return compiled, fmt.Errorf("variables: %w", err)
+		}
+		compiled.variables = append(compiled.variables, c)
+	}
+	if rule.Path != "" {
+		compiled.path, err = compilePattern(rule.Path)
+		if err != nil {
+			return compiled, fmt.Errorf("path: %w", err)
+		}
+	}
+	if len(rule.Headers) > 0 {
+		compiled.headers = map[string]pattern{}
+		for name, value := range rule.Headers {
+			p, err := compilePattern(value)
+			if err != nil {
+				return compiled, fmt.Errorf("headers: %s: %w", name, err)
+			}
+			compiled.headers[textproto.CanonicalMIMEHeaderKey(name)] = p
+		}
+	}
+	return compiled, nil
+}
+
+func compileCondition(condition VariableCondition) (compiledCondition, error) {
+	if condition.Op == "" {
+		condition.Op = OpEqual
+	}
+	compiled := compiledCondition{VariableCondition: condition}
+
+	var err error
+	compiled.path, err = jsonpath.Compile(condition.Path)
+	if err != nil {
+		return compiled, err
+	}
+
+	switch condition.Op {
+	case OpEqual, OpNotEqual, OpExists, OpMissing:
+	case OpLess, OpLessEqual, OpGreater, OpGreaterEqual:
+		if _, ok := toFloat(condition.Value); !ok {
+			if _, ok := condition.Value.(string); !ok {
+				return compiled, fmt.Errorf("%s: %s needs a number or string value", condition.Path, condition.Op)
+			}
+		}
+	case OpIn:
+		if _, ok := condition.Value.([]interface{}); !ok {
+			return compiled, fmt.Errorf("%s: %s needs a list value", condition.Path, condition.Op)
+		}
+	case OpMatches:
+		p, ok := condition.Value.(string)
+		if !ok {
+			return compiled, fmt.Errorf("%s: %s needs a pattern", condition.Path, condition.Op)
+		}
+		compiled.pattern, err = compilePattern(p)
+		if err != nil {
+			return compiled, fmt.Errorf("%s: %w", condition.Path, err)
+		}
+	default:
+		return compiled, fmt.Errorf("%s: unknown op \"%s\"", condition.Path, condition.Op)
+	}
+	return compiled, nil
+}
+
+// Config returns the rules the selector was created with.
+func (s *Selector) Config() Config {
+	return s.config
+}
+
+// Action returns what's done with a request, and the name of the rule that
+// decided it, or "" if no rule matched.
+func (s *Selector) Action(r proxy.GraphQLRequest) (Action, string) {
+	for _, rule := range s.rules {
+		if rule.match(r) {
+			return rule.Action, rule.Name
+		}
+	}
+	return s.config.Default, ""
+}
+
+func (s *Selector) ShouldRecordRequest(r proxy.GraphQLRequest) bool {
+	action, _ := s.Action(r)
+	return action != Ignore
+}
+
+func (s *Selector) ShouldSnapshotRequest(r proxy.GraphQLRequest) bool {
+	action, _ := s.Action(r)
+	return action == Snapshot
+}
+
+func (rule *compiledRule) match(r proxy.GraphQLRequest) bool {
+	if rule.OperationType != "" && rule.OperationType != r.OperationType {
+		return false
+	}
+	if rule.operationName != nil && !rule.operationName.MatchString(r.OperationName) {
+		return false
+	}
+	if len(rule.rootFields) > 0 && !matchAnyField(rule.rootFields, r.RootFields) {
+		return false
+	}
+	if rule.path != nil && !rule.path.MatchString(r.Path) {
+		return false
+	}
+	for name, p := range rule.headers {
+		if !p.MatchString(r.Header.Get(name)) {
+			return false
+		}
+	}
+	for _, condition := range rule.variables {
+		if !condition.match(r.Variables) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAnyField(patterns []pattern, fields []string) bool {
+	for _, field := range fields {
+		for _, p := range patterns {
+			if p.MatchString(field) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *compiledCondition) match(variables map[string]interface{}) bool {
+	var values []interface{}
+	if variables != nil {
+		jsonpath.Transform(variables, []*jsonpath.Pattern{c.path}, func(_ []string, value interface{}) (interface{}, bool) {
+			values = append(values, value)
+			return value, true
+		})
+	}
+
+	switch c.Op {
+	case OpExists:
+		return len(values) > 0
+	case OpMissing:
+		return len(values) == 0
+	case OpNotEqual:
+		for _, value := range values {
+			if jsondiff.Equal(value, c.Value) {
+				return false
+			}
+		}
+		return true
+	}
+	for _, value := range values {
+		if c.matchValue(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *compiledCondition) matchValue(value interface{}) bool {
+	switch c.Op {
+	case OpEqual:
+		return jsondiff.Equal(value, c.Value)
+	case OpIn:
+		for _, item := range c.Value.([]interface{}) {
+			if jsondiff.Equal(value, item) {
+				return true
+			}
+		}
+		return false
+	case OpMatches:
+		switch v := value.(type) {
+		case string:
+			return c.pattern.MatchString(v)
+		case float64, json.Number:
+			return c.pattern.MatchString(fmt.Sprint(v))
+		}
+		return false
+	}
+
+	cmp, ok := compare(value, c.Value)
+	if !ok {
+		return false
+	}
+	switch c.Op {
+	case OpLess:
+		return cmp < 0
+	case OpLessEqual:
+		return cmp <= 0
+	case OpGreater:
+		return cmp > 0
+	case OpGreaterEqual:
+		return cmp >= 0
+	}
+	return false
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b interface{}) (int, bool) {
+	af, aIsNumber := toFloat(a)
+	bf, bIsNumber := toFloat(b)
+	if aIsNumber && bIsNumber {
+		switch {
+		case af < bf:
+			return -1, true
+		case af > bf:
+			return 1, true
+		}
+		return 0, true
+	}
+	as, aIsString := a.(string)
+	bs, bIsString := b.(string)
+	if aIsString && bIsString {
+		return strings.Compare(as, bs), true
+	}
+	return 0, false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case int:
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,146 @@
+package selector
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/stretchr/testify/suite"
+)
+
+type selectorSuite struct {
+	suite.Suite
+}
+
+func (suite *selectorSuite) newSelector(rules string) *Selector {
+	var config Config
+	suite.Require().NoError(json.Unmarshal([]byte(rules), &config))
+	s, err := New(config)
+	suite.Require().NoError(err)
+	return s
+}
+
+func (suite *selectorSuite) request(content string) proxy.GraphQLRequest {
+	r, err := proxy.ParseRequest([]byte(content))
+	suite.Require().NoError(err)
+	return r
+}
+
+func (suite *selectorSuite) requireAction(s *Selector, expected Action, r proxy.GraphQLRequest) {
+	action, rule := s.Action(r)
+	suite.Require().Equal(expected, action, "rule %q", rule)
+}
+
+func (suite *selectorSuite) TestDefaultConfig() {
+	s, err := New(DefaultConfig())
+	suite.Require().NoError(err)
+
+	query := suite.request(`{"operationName": "Q", "query": "query Q { a }"}`)
+	mutation := suite.request(`{"operationName": "M", "query": "mutation M { a }"}`)
+	suite.True(s.ShouldRecordRequest(query))
+	suite.False(s.ShouldSnapshotRequest(query))
+	suite.True(s.ShouldRecordRequest(mutation))
+	suite.True(s.ShouldSnapshotRequest(mutation))
+}
+
+func (suite *selectorSuite) TestOperationNamePatterns() {
+	s := suite.newSelector(`{
+		"default": "ignore",
+		"rules": [
+			{"operationName": "get*", "action": "record"},
+			{"operationName": "~^(Create|Update)Task$", "action": "snapshot"}
+		]
+	}`)
+
+	suite.requireAction(s, Record, proxy.GraphQLRequest{OperationName: "getTasks"})
+	suite.requireAction(s, Ignore, proxy.GraphQLRequest{OperationName: "forgetTasks"})
+	suite.requireAction(s, Snapshot, proxy.GraphQLRequest{OperationName: "UpdateTask"})
+	suite.requireAction(s, Ignore, proxy.GraphQLRequest{OperationName: "UpdateTasks"})
+}
+
+func (suite *selectorSuite) TestPrecedence() {
+	s := suite.newSelector(`{
+		"rules": [
+			{"name": "mutations", "operationType": "mutation", "action": "snapshot"},
+			{"name": "analytics", "operationName": "Log*", "action": "ignore", "priority": 10},
+			{"name": "first wins", "operationType": "mutation", "action": "record"}
+		]
+	}`)
+
+	suite.requireAction(s, Snapshot, suite.request(`{"operationName": "Save", "query": "mutation Save { a }"}`))
+	suite.requireAction(s, Ignore, suite.request(`{"operationName": "LogEvent", "query": "mutation LogEvent { a }"}`))
+	suite.requireAction(s, Record, suite.request(`{"operationName": "getTasks", "query": "query getTasks { a }"}`))
+
+	_, rule := s.Action(suite.request(`{"operationName": "LogEvent", "query": "mutation LogEvent { a }"}`))
+	suite.Equal("analytics", rule)
+}
+
+func (suite *selectorSuite) TestRootFields() {
+	s := suite.newSelector(`{"rules": [{"rootFields": ["update*", "deleteTask"], "action": "snapshot"}]}`)
+
+	suite.requireAction(s, Snapshot, suite.request(`{"query": "mutation { x: updateTask { id } }"}`))
+	suite.requireAction(s, Record, suite.request(`{"query": "mutation { createTask { id } }"}`))
+}
+
+func (suite *selectorSuite) TestVariables() {
+	s := suite.newSelector(`{
+		"rules": [
+			{"variables": [{"path": "input.kind", "value": "exercise"}, {"path": "input.points", "op": "ge", "value": 10}], "action": "snapshot"},
+			{"variables": [{"path": "ids.*", "op": "in", "value": [1, 2]}], "action": "snapshot"},
+			{"variables": [{"path": "input.title", "op": "matches", "value": "test *"}], "action": "ignore"},
+			{"variables": [{"path": "input", "op": "missing"}], "action": "ignore"}
+		]
+	}`)
+
+	suite.requireAction(s, Snapshot, suite.request(`{"variables": {"input": {"kind": "exercise", "points": 10}}}`))
+	suite.requireAction(s, Record, suite.request(`{"variables": {"input": {"kind": "exercise", "points": 9}}}`))
+	suite.requireAction(s, Snapshot, suite.request(`{"variables": {"input": {}, "ids": [5, 2]}}`))
+	suite.requireAction(s, Ignore, suite.request(`{"variables": {"input": {"title": "test 1"}}}`))
+	suite.requireAction(s, Ignore, suite.request(`{"query": "{ a }"}`))
+}
+
+func (suite *selectorSuite) TestNotEqual() {
+	s := suite.newSelector(`{"rules": [{"variables": [{"path": "ids.*", "op": "ne", "value": 1}], "action": "ignore"}]}`)
+
+	suite.requireAction(s, Ignore, suite.request(`{"variables": {"ids": [2, 3]}}`))
+	suite.requireAction(s, Record, suite.request(`{"variables": {"ids": [2, 1]}}`))
+}
+
+func (suite *selectorSuite) TestPathAndHeaders() {
+	s := suite.newSelector(`{
+		"rules": [
+			{"path": "*/api/internal/graphql*", "headers": {"x-ka-fkey": "", "user-agent": "~(?i)bot"}, "action": "ignore"},
+			{"path": "/backend-graphql/*", "action": "snapshot"}
+		]
+	}`)
+
+	bot := proxy.GraphQLRequest{Path: "/api/internal/graphql/getTasks", Header: http.Header{"User-Agent": {"GoogleBot/2"}}}
+	suite.requireAction(s, Ignore, bot)
+
+	bot.Header.Set("X-KA-FKey", "key")
+	suite.requireAction(s, Record, bot)
+
+	suite.requireAction(s, Snapshot, proxy.GraphQLRequest{Path: "/backend-graphql/getTasks"})
+}
+
+func (suite *selectorSuite) TestInvalidRules() {
+	for _, rules := range []string{
+		`{"default": "keep"}`,
+		`{"rules": [{"operationName": "a"}]}`,
+		`{"rules": [{"operationType": "subscription", "action": "record"}]}`,
+		`{"rules": [{"operationName": "~(", "action": "record"}]}`,
+		`{"rules": [{"variables": [{"path": "a", "op": "like"}], "action": "record"}]}`,
+		`{"rules": [{"variables": [{"path": "a", "op": "in", "value": 1}], "action": "record"}]}`,
+		`{"rules": [{"variables": [{"path": "", "op": "exists"}], "action": "record"}]}`,
+	} {
+		var config Config
+		suite.Require().NoError(json.Unmarshal([]byte(rules), &config))
+		_, err := New(config)
+		suite.Error(err, rules)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	suite.Run(t, new(selectorSuite))
+}
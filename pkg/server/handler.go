@@ -4,23 +4,79 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"sync"
 
+	"github.com/dnerdy/proxyrecorder/pkg/config"
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
 	"github.com/dnerdy/proxyrecorder/pkg/proxy"
 	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
 	"github.com/dnerdy/proxyrecorder/pkg/tool"
 	"golang.org/x/sync/errgroup"
 )
 
 type Server struct {
-	snapshotter proxy.Snapshotter
-	selector    proxy.RequestSelector
-	recorder    *recorder.Recorder
-	reporter    proxy.Reporter
-	mux         *http.ServeMux
+	ProxyPort int
+	ToolPort  int
+	// SnapshotDiff configures the semantic snapshot diffs shown in the tool.
+	SnapshotDiff snapshotdiff.Options
+	// SnapshotNormalizer, if set, normalizes the snapshots shown in the
+	// tool.
+	SnapshotNormalizer *normalize.Normalizer
+	// SnapshotFormats are the registries sessions' snapshots are decoded
+	// with, normally one for each record directory so that protobuf
+	// descriptors are loaded from the session's own.
+	SnapshotFormats snapshotformat.Registries
+	settings        Settings
+	switchSelector  *switchSelector
+	configErr       string
+	sessions        *recorder.Sessions
+	viewSessions    recorder.SessionLoader
+	reporter        proxy.Reporter
+	mux             *http.ServeMux
+	proxyHandler    *proxy.Handler
+	toolHandler     *tool.Handler
+	// Hold when switching sessions
+	mu sync.Mutex
+	// Hold when reading or replacing settings, switchSelector, configErr,
+	// proxyHandler or toolHandler
+	configMu sync.RWMutex
 }
 
+// Settings are the parts of a server's configuration that can be replaced
+// while it runs, see Reload.
+type Settings struct {
+	Snapshotter proxy.Snapshotter
+	// RuleSets are the selectors that can be switched between from the
+	// tool, by name. RuleSet names the one requests are selected with until
+	// another is chosen.
+	RuleSets map[string]proxy.RequestSelector
+	RuleSet  string
+	// Redactor, if set, redacts what's recorded.
+	Redactor proxy.Redactor
+	// Upstream is where requests are proxied to, http://localhost:8309 if
+	// it isn't set. Routes send some requests elsewhere.
+	Upstream *url.URL
+	Routes   []proxy.Route
+	// SessionContext is given to new sessions created from the tool.
+	SessionContext map[string]string
+	// DetectDrift flags sessions whose upstream changed without a recorded
+	// request, see proxy.Config.DetectDrift.
+	DetectDrift bool
+	// SnapshotBefore snapshots requests before they're forwarded too, see
+	// proxy.Config.SnapshotBefore.
+	SnapshotBefore bool
+}
+
+// defaultUpstream is where requests are proxied if Settings.Upstream isn't
+// set.
+var defaultUpstream, _ = url.Parse(config.DefaultOrigin)
+
 type Reporter struct{}
 
 func (r *Reporter) Report(label string, message string) {
@@ -30,42 +86,55 @@ func (r *Reporter) Report(label string, message string) {
 	fmt.Printf("%-10s %s\n", label, message)
 }
 
-func NewServer(
-	snapshotter proxy.Snapshotter,
-	selector proxy.RequestSelector,
-	rec *recorder.Recorder,
-) *Server {
+// NewServer creates a server that records into the active session of
+// sessions.
+func NewServer(settings Settings, sessions *recorder.Sessions) *Server {
 	return &Server{
-		snapshotter: snapshotter,
-		selector:    selector,
-		recorder:    rec,
-		reporter:    &Reporter{},
+		ProxyPort:    8109,
+		ToolPort:     1234,
+		SnapshotDiff: snapshotdiff.DefaultOptions(),
+		settings:     settings,
+		sessions:     sessions,
+		reporter:     &Reporter{},
 	}
 }
 
-func (s *Server) ListenAndServe(ctx context.Context) error {
-	proxyPort := 8109
-	toolPort := 1234
+// NewViewer creates a server that only serves the tool, for browsing
+// sessions without recording, e.g. in a record directory that another
+// process is recording into or in an archive.
+func NewViewer(sessions recorder.SessionLoader) *Server {
+	return &Server{
+		ToolPort:     1234,
+		SnapshotDiff: snapshotdiff.DefaultOptions(),
+		viewSessions: sessions,
+		reporter:     &Reporter{},
+	}
+}
 
-	requestInfoChan := make(chan proxy.RequestInfo)
+// ListenAndServeViewer serves the tool without a proxy. Sessions can be
+// browsed but not changed.
+func (s *Server) ListenAndServeViewer(ctx context.Context) error {
+	toolHandler := tool.NewHandlerAndStartWebsocketWorker(s.viewSessions, nil, nil)
+	toolHandler.SetSnapshotDiffOptions(s.SnapshotDiff)
+	toolHandler.SetSnapshotNormalizer(s.SnapshotNormalizer)
+	toolHandler.SetSnapshotFormats(s.SnapshotFormats)
 
-	proxyHandler, err := proxy.NewHandler(
-		"localhost:8309",
-		"http://localhost:8309",
-		s.snapshotter,
-		s.recorder,
-		s.selector,
-		s.reporter,
-		requestInfoChan,
+	fmt.Printf("tool:  listening on http://localhost:%d (read-only)\n", s.ToolPort)
+
+	return http.ListenAndServe(
+		fmt.Sprintf(":%d", s.ToolPort),
+		toolHandler,
 	)
+}
+
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	proxyPort := s.ProxyPort
+	toolPort := s.ToolPort
+
+	proxyHandler, toolHandler, err := s.start()
 	if err != nil {
 		return err
 	}
-	err = s.takeInitialSnapshotIfNeeded()
-	if err != nil {
-		return err
-	}
-	toolHandler := tool.NewHandlerAndStartWebsocketWorker(s.recorder, requestInfoChan)
 
 	g, ctx := errgroup.WithContext(ctx)
 
@@ -89,25 +158,182 @@ func (s *Server) ListenAndServe(ctx context.Context) error {
 	return g.Wait()
 }
 
-func (s *Server) takeInitialSnapshotIfNeeded() error {
-	nextRequestID, err := s.recorder.NextRequestID()
+// start creates the proxy and tool handlers and starts recording into the
+// active session.
+func (s *Server) start() (*proxy.Handler, *tool.Handler, error) {
+	requestInfoChan := make(chan proxy.RequestInfo)
+
+	active, err := s.sessions.Active()
+	if err != nil {
+		return nil, nil, err
+	}
+	rec, err := s.sessions.Recorder(active)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.configMu.Lock()
+	s.switchSelector, err = newSwitchSelector(s.settings, nil)
+	if err != nil {
+		s.configMu.Unlock()
+		return nil, nil, err
+	}
+	config := s.proxyConfig()
+	proxyHandler, err := proxy.NewHandler(
+		config.Host,
+		config.Origin.String(),
+		config.Snapshotter,
+		rec,
+		config.Selector,
+		s.reporter,
+		requestInfoChan,
+	)
+	if err != nil {
+		s.configMu.Unlock()
+		return nil, nil, err
+	}
+	proxyHandler.SetConfig(config)
+	s.proxyHandler = proxyHandler
+	s.configMu.Unlock()
+
+	err = s.activate(active)
+	if err != nil {
+		return nil, nil, err
+	}
+	toolHandler := tool.NewHandlerAndStartWebsocketWorker(s.sessions, s, requestInfoChan)
+	toolHandler.SetSnapshotDiffOptions(s.SnapshotDiff)
+	toolHandler.SetSnapshotNormalizer(s.SnapshotNormalizer)
+	toolHandler.SetSnapshotFormats(s.SnapshotFormats)
+	s.configMu.Lock()
+	s.toolHandler = toolHandler
+	s.configMu.Unlock()
+
+	return proxyHandler, toolHandler, nil
+}
+
+// NewSession creates a session and starts recording into it.
+func (s *Server) NewSession(session recorder.Session) (recorder.Session, error) {
+	settings := s.currentSettings()
+	if session.Context == nil {
+		session.Context = settings.SessionContext
+	}
+	if session.SnapshotType == "" {
+		session.SnapshotType = settings.Snapshotter.SnapshotContentType()
+	}
+	session, err := s.sessions.Create(session)
+	if err != nil {
+		return recorder.Session{}, err
+	}
+	return session, s.SwitchSession(session.Name)
+}
+
+// SwitchSession starts recording into an existing session, reopening it if
+// it was closed.
+func (s *Server) SwitchSession(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.sessions.Reopen(name)
+	if err != nil {
+		return err
+	}
+	err = s.sessions.SetActive(name)
+	if err != nil {
+		return err
+	}
+	return s.activate(name)
+}
+
+// CloseSession closes a session. If it's the active session, the proxy
+// stops recording until another session is started or switched to.
+func (s *Server) CloseSession(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.sessions.Close(name)
+	if err != nil {
+		return err
+	}
+	active, err := s.sessions.Active()
+	if errors.Is(err, recorder.ErrNoActiveSession) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if active != name {
+		return nil
+	}
+	err = s.sessions.SetActive("")
+	if err != nil {
+		return err
+	}
+	s.reporter.Report("", fmt.Sprintf("closed session %s, not recording", name))
+	return s.proxyHandler.SetRecorder("", nil)
+}
+
+// Checkpoint takes a snapshot now and records it as a labeled checkpoint in
+// the active session.
+func (s *Server) Checkpoint(label string) (proxy.RequestInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := s.proxyHandler.Checkpoint(label)
+	if errors.Is(err, proxy.ErrNotRecording) {
+		return info, fmt.Errorf("%w, %s", tool.Conflict, err)
+	}
+	return info, err
+}
+
+// Replay sends a request through the proxy and records it in the active
+// session, linked to the request it replays.
+func (s *Server) Replay(req *http.Request, replayOf recorder.ReplayOf) (proxy.RequestInfo, error) {
+	info, err := s.proxyHandler.Replay(req, replayOf)
+	if errors.Is(err, proxy.ErrNotRecording) ||
+		errors.Is(err, proxy.ErrNotSelected) ||
+		errors.Is(err, proxy.ErrNotRecorded) {
+		return info, fmt.Errorf("%w, %s", tool.Conflict, err)
+	}
+	return info, err
+}
+
+func (s *Server) activate(name string) error {
+	rec, err := s.sessions.Recorder(name)
+	if err != nil {
+		return err
+	}
+	err = s.takeInitialSnapshotIfNeeded(rec)
+	if err != nil {
+		return err
+	}
+	err = s.proxyHandler.SetRecorder(name, rec)
+	if err != nil {
+		return err
+	}
+	s.reporter.Report("", fmt.Sprintf("recording session %s", name))
+	return nil
+}
+
+func (s *Server) takeInitialSnapshotIfNeeded(rec *recorder.Recorder) error {
+	snapshot, err := rec.MaybeGetSnapshot(0)
 	if err != nil {
 		return err
 	}
-	if nextRequestID != 0 {
+	if snapshot != nil {
 		return nil
 	}
-	return s.takeSnapshot(0, proxy.GraphQLRequest{})
+	return s.takeSnapshot(rec, 0, proxy.GraphQLRequest{})
 }
 
-func (s *Server) takeSnapshot(requestID int, graphQLRequest proxy.GraphQLRequest) error {
-	s.reporter.Report("", fmt.Sprintf("taking a snapshot, %s...", s.snapshotter.SnapshotInfo()))
-	snapshot, err := s.snapshotter.TakeSnapshot(graphQLRequest)
+func (s *Server) takeSnapshot(rec *recorder.Recorder, requestID int, graphQLRequest proxy.GraphQLRequest) error {
+	snapshotter := s.currentSettings().Snapshotter
+	s.reporter.Report("", fmt.Sprintf("taking a snapshot, %s...", snapshotter.SnapshotInfo()))
+	snapshot, err := snapshotter.TakeSnapshot(graphQLRequest)
 	if err != nil {
 		return err
 	} else {
 		s.reporter.Report("", "...done")
 	}
-	s.recorder.SaveSnapshot(requestID, snapshot)
+	rec.SaveSnapshot(requestID, snapshot)
 	return nil
 }
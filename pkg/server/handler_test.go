@@ -0,0 +1,155 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+type testSnapshotter struct {
+	snapshot string
+}
+
+func (s *testSnapshotter) TakeSnapshot(r proxy.GraphQLRequest) ([]byte, error) {
+	return []byte(s.snapshot), nil
+}
+
+func (s *testSnapshotter) SnapshotInfo() string {
+	return "test snapshot"
+}
+
+func (s *testSnapshotter) SnapshotContentType() string {
+	return "application/json"
+}
+
+type recordAllSelector struct{}
+
+func (s *recordAllSelector) ShouldRecordRequest(r proxy.GraphQLRequest) bool {
+	return true
+}
+
+func (s *recordAllSelector) ShouldSnapshotRequest(r proxy.GraphQLRequest) bool {
+	return r.OperationType == "mutation"
+}
+
+type quietReporter struct{}
+
+func (r *quietReporter) Report(label string, message string) {}
+
+type serverSuite struct {
+	suite.Suite
+	sessions *recorder.Sessions
+	server   *Server
+}
+
+func (suite *serverSuite) settings(snapshot string) Settings {
+	return Settings{
+		Snapshotter: &testSnapshotter{snapshot: snapshot},
+		RuleSets: map[string]proxy.RequestSelector{
+			"default":   &recordAllSelector{},
+			"mutations": &recordAllSelector{},
+		},
+	}
+}
+
+func (suite *serverSuite) BeforeTest(suiteName, testName string) {
+	rootPath, err := ioutil.TempDir("", "server")
+	suite.Require().NoError(err)
+	suite.sessions = &recorder.Sessions{RootPath: rootPath}
+	_, err = suite.sessions.Create(recorder.Session{Name: "first"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.sessions.SetActive("first"))
+
+	suite.server = NewServer(suite.settings(`{"version": 1}`), suite.sessions)
+	suite.server.reporter = &quietReporter{}
+	_, _, err = suite.server.start()
+	suite.Require().NoError(err)
+}
+
+func (suite *serverSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.sessions.RootPath)
+}
+
+// snapshot returns a session's snapshot after a request, or "" if it
+// doesn't have one.
+func (suite *serverSuite) snapshot(session string, requestID int) string {
+	rec, err := suite.sessions.Recorder(session)
+	suite.Require().NoError(err)
+	snapshot, err := rec.MaybeGetSnapshot(requestID)
+	suite.Require().NoError(err)
+	return string(snapshot)
+}
+
+func (suite *serverSuite) TestSelectorChangesSurviveConcurrentReloads() {
+	for i := 0; i < 100; i++ {
+		recording := i%2 == 1
+		ruleSet := []string{"default", "mutations"}[i%2]
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			suite.NoError(suite.server.Reload(suite.settings(`{"version": 1}`)))
+		}()
+		suite.Require().NoError(suite.server.SetRecording(recording))
+		suite.Require().NoError(suite.server.SetSnapshotting(recording))
+		suite.Require().NoError(suite.server.SetRuleSet(ruleSet))
+		wg.Wait()
+
+		status := suite.server.RecordingStatus()
+		suite.Require().Equal(recording, status.Recording, "iteration %d", i)
+		suite.Require().Equal(recording, status.Snapshotting, "iteration %d", i)
+		suite.Require().Equal(ruleSet, status.RuleSet, "iteration %d", i)
+	}
+}
+
+func (suite *serverSuite) TestReloadKeepsTheChosenRuleSetIfItStillExists() {
+	suite.Require().NoError(suite.server.SetRuleSet("mutations"))
+	suite.Require().NoError(suite.server.Reload(suite.settings(`{}`)))
+	suite.Equal("mutations", suite.server.RecordingStatus().RuleSet)
+
+	settings := suite.settings(`{}`)
+	delete(settings.RuleSets, "mutations")
+	suite.Require().NoError(suite.server.Reload(settings))
+	suite.Equal("default", suite.server.RecordingStatus().RuleSet)
+
+	settings.RuleSet = "missing"
+	suite.Error(suite.server.Reload(settings))
+	suite.Equal("default", suite.server.RecordingStatus().RuleSet, "a config that can't be used isn't applied")
+}
+
+func (suite *serverSuite) TestInitialSnapshotAfterASwitch() {
+	suite.Equal(`{"version": 1}`, suite.snapshot("first", 0), "the active session gets an initial snapshot")
+
+	// Sessions switched to are snapshotted with the current settings.
+	suite.Require().NoError(suite.server.Reload(suite.settings(`{"version": 2}`)))
+	session, err := suite.server.NewSession(recorder.Session{Name: "second"})
+	suite.Require().NoError(err)
+	suite.Equal("application/json", session.SnapshotType)
+	suite.Equal(`{"version": 2}`, suite.snapshot("second", 0))
+	active, err := suite.sessions.Active()
+	suite.Require().NoError(err)
+	suite.Equal("second", active)
+
+	// Sessions that already have an initial snapshot keep it.
+	suite.Require().NoError(suite.server.Reload(suite.settings(`{"version": 3}`)))
+	suite.Require().NoError(suite.server.SwitchSession("first"))
+	suite.Equal(`{"version": 1}`, suite.snapshot("first", 0))
+
+	// Sessions created outside the server, e.g. by another command, are
+	// snapshotted once they're switched to.
+	_, err = suite.sessions.Create(recorder.Session{Name: "third"})
+	suite.Require().NoError(err)
+	suite.Equal("", suite.snapshot("third", 0))
+	suite.Require().NoError(suite.server.SwitchSession("third"))
+	suite.Equal(`{"version": 3}`, suite.snapshot("third", 0))
+}
+
+func TestServer(t *testing.T) {
+	suite.Run(t, new(serverSuite))
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/tool"
+)
+
+// defaultRuleSet names the only rule set if Settings.RuleSet isn't set.
+const defaultRuleSet = "default"
+
+// switchSelector is the selector the proxy uses. It applies one of the
+// rule sets, unless recording is paused, and drops snapshots while
+// they're off. Reloading the settings replaces it, so requests in flight
+// keep the rule sets they started with.
+type switchSelector struct {
+	ruleSets     map[string]proxy.RequestSelector
+	ruleSet      string
+	paused       bool
+	snapshotsOff bool
+	// Hold when reading or changing ruleSet, paused or snapshotsOff
+	mu sync.RWMutex
+}
+
+// newSwitchSelector returns a selector for the rule sets in settings. It
+// keeps whether recording is paused, whether snapshots are off and, if it
+// still exists, the rule set chosen in previous, which may be nil.
+func newSwitchSelector(settings Settings, previous *switchSelector) (*switchSelector, error) {
+	ruleSet := settings.RuleSet
+	if ruleSet == "" {
+		ruleSet = defaultRuleSet
+	}
+	if _, ok := settings.RuleSets[ruleSet]; !ok {
+		return nil, fmt.Errorf("no rule set named \"%s\"", ruleSet)
+	}
+	s := &switchSelector{
+		ruleSets: settings.RuleSets,
+		ruleSet:  ruleSet,
+	}
+	if previous != nil {
+		previous.mu.RLock()
+		defer previous.mu.RUnlock()
+		s.paused = previous.paused
+		s.snapshotsOff = previous.snapshotsOff
+		if _, ok := s.ruleSets[previous.ruleSet]; ok {
+			s.ruleSet = previous.ruleSet
+		}
+	}
+	return s, nil
+}
+
+func (s *switchSelector) ShouldRecordRequest(r proxy.GraphQLRequest) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.paused && s.ruleSets[s.ruleSet].ShouldRecordRequest(r)
+}
+
+func (s *switchSelector) ShouldSnapshotRequest(r proxy.GraphQLRequest) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return !s.snapshotsOff && s.ruleSets[s.ruleSet].ShouldSnapshotRequest(r)
+}
+
+func (s *switchSelector) status() tool.RecordingStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.ruleSets))
+	for name := range s.ruleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return tool.RecordingStatus{
+		Recording:    !s.paused,
+		Snapshotting: !s.snapshotsOff,
+		RuleSet:      s.ruleSet,
+		RuleSets:     names,
+	}
+}
+
+// currentSettings returns the settings the server currently uses.
+func (s *Server) currentSettings() Settings {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.settings
+}
+
+func (s *Server) currentSwitchSelector() *switchSelector {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.switchSelector
+}
+
+// proxyConfig returns the config for the proxy handler. Hold configMu.
+func (s *Server) proxyConfig() proxy.Config {
+	upstream := s.settings.Upstream
+	if upstream == nil {
+		upstream = defaultUpstream
+	}
+	return proxy.Config{
+		Host:           upstream.Host,
+		Origin:         upstream,
+		Routes:         s.settings.Routes,
+		Selector:       s.switchSelector,
+		Snapshotter:    s.settings.Snapshotter,
+		Redactor:       s.settings.Redactor,
+		DetectDrift:    s.settings.DetectDrift,
+		OnDrift:        s.markDrifted,
+		SnapshotBefore: s.settings.SnapshotBefore,
+		// A nil *Normalizer leaves snapshots as they are.
+		SnapshotNormalizer: s.SnapshotNormalizer,
+		SnapshotFormats:    s.SnapshotFormats,
+	}
+}
+
+// markDrifted flags a session as having drifted and tells the tool.
+func (s *Server) markDrifted(session string) {
+	_, err := s.sessions.MarkDrifted(session)
+	if err != nil {
+		s.reporter.Report("error", fmt.Sprintf("flagging session %s as drifted: %s", session, err))
+		return
+	}
+
+	s.configMu.RLock()
+	toolHandler := s.toolHandler
+	s.configMu.RUnlock()
+
+	if toolHandler != nil {
+		toolHandler.BroadcastSessions()
+	}
+}
+
+// Reload replaces the server's settings while it runs, e.g. after the
+// config file changes. Requests already in flight finish with the settings
+// they started with. Whether recording is paused, whether snapshots are off
+// and the rule set chosen in the tool are kept.
+func (s *Server) Reload(settings Settings) error {
+	// configMu is held from copying the selector's state until it's
+	// replaced, so that a change made in the meantime isn't lost, see
+	// updateSelector.
+	s.configMu.Lock()
+	selector, err := newSwitchSelector(settings, s.switchSelector)
+	if err != nil {
+		s.configMu.Unlock()
+		return err
+	}
+	s.settings = settings
+	s.configErr = ""
+	if s.proxyHandler != nil {
+		s.switchSelector = selector
+		s.proxyHandler.SetConfig(s.proxyConfig())
+	}
+	s.configMu.Unlock()
+
+	s.reporter.Report("", "reloaded config")
+	s.broadcastRecordingStatus()
+	return nil
+}
+
+// ConfigError reports that the config couldn't be reloaded. The server
+// keeps running with its current settings, and the error is shown in the
+// tool until the config is reloaded.
+func (s *Server) ConfigError(err error) {
+	s.configMu.Lock()
+	s.configErr = err.Error()
+	s.configMu.Unlock()
+
+	s.reporter.Report("error", fmt.Sprintf("config not reloaded: %s", err))
+	s.broadcastRecordingStatus()
+}
+
+func (s *Server) broadcastRecordingStatus() {
+	s.configMu.RLock()
+	toolHandler := s.toolHandler
+	s.configMu.RUnlock()
+
+	if toolHandler != nil {
+		toolHandler.BroadcastRecordingStatus()
+	}
+}
+
+// updateSelector changes the current selector. It holds configMu, like
+// Reload does while it copies the selector's state into its replacement, so
+// the change can't be lost to a reload.
+func (s *Server) updateSelector(update func(selector *switchSelector) error) error {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	s.switchSelector.mu.Lock()
+	defer s.switchSelector.mu.Unlock()
+	return update(s.switchSelector)
+}
+
+// RecordingStatus implements tool.RecordingController.
+func (s *Server) RecordingStatus() tool.RecordingStatus {
+	status := s.currentSwitchSelector().status()
+	s.configMu.RLock()
+	status.ConfigError = s.configErr
+	s.configMu.RUnlock()
+	return status
+}
+
+// SetRecording implements tool.RecordingController.
+func (s *Server) SetRecording(recording bool) error {
+	s.updateSelector(func(selector *switchSelector) error {
+		selector.paused = !recording
+		return nil
+	})
+
+	if recording {
+		s.reporter.Report("", "recording resumed")
+	} else {
+		s.reporter.Report("", "recording paused, requests are proxied but not recorded")
+	}
+	return nil
+}
+
+// SetSnapshotting implements tool.RecordingController.
+func (s *Server) SetSnapshotting(snapshotting bool) error {
+	s.updateSelector(func(selector *switchSelector) error {
+		selector.snapshotsOff = !snapshotting
+		return nil
+	})
+
+	if snapshotting {
+		s.reporter.Report("", "snapshots on")
+	} else {
+		s.reporter.Report("", "snapshots off")
+	}
+	return nil
+}
+
+// SetRuleSet implements tool.RecordingController.
+func (s *Server) SetRuleSet(name string) error {
+	err := s.updateSelector(func(selector *switchSelector) error {
+		if _, ok := selector.ruleSets[name]; !ok {
+			return fmt.Errorf("%w, no rule set named \"%s\"", tool.NotFound, name)
+		}
+		selector.ruleSet = name
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.reporter.Report("", fmt.Sprintf("selecting requests with rule set %s", name))
+	return nil
+}
@@ -0,0 +1,313 @@
+// Package snapshotdiff computes a semantic diff between two snapshots.
+// Snapshots hold lists of datastore entities; rather than diffing them as
+// text, where reordered keys or lists show up as spurious changes, entities
+// are matched by their identity fields and reported as added, removed or
+// changed. Everything in a snapshot that isn't an entity is diffed
+// structurally.
+package snapshotdiff
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+)
+
+type Options struct {
+	// Identity are the paths, within an entity, of the fields that together
+	// identify it. Any list of objects that have at least one of them is a
+	// list of entities.
+	Identity []string `json:"identity"`
+	// KeyedLists maps paths of lists within an entity to the field that
+	// identifies their items, so the items are matched by that field rather
+	// than by position.
+	KeyedLists map[string]string `json:"keyedLists"`
+	// Ignore matches values left out of the diff. Paths are relative to the
+	// snapshot for values outside entities and to the entity for values
+	// inside them.
+	Ignore []*jsonpath.Pattern `json:"-"`
+}
+
+// DefaultOptions match the snapshots of GTP user data, where entities are
+// identified by their datastore key and properties are a list of name and
+// value pairs.
+func DefaultOptions() Options {
+	return Options{
+		Identity: []string{
+			"key.value.kind",
+			"key.value.id",
+			"key.value.name",
+		},
+		KeyedLists: map[string]string{
+			"properties": "name",
+		},
+	}
+}
+
+type EntityChangeKind string
+
+const (
+	EntityAdded   EntityChangeKind = "added"
+	EntityRemoved EntityChangeKind = "removed"
+	EntityChanged EntityChangeKind = "changed"
+)
+
+type EntityChange struct {
+	// List is the path of the list of entities the entity is in.
+	List string `json:"list"`
+	// Identity is the entity's identity fields joined with colons, e.g.
+	// "Exam:123".
+	Identity string           `json:"identity"`
+	Kind     EntityChangeKind `json:"kind"`
+	// Changes are the changes within a changed entity.
+	Changes []jsondiff.Change `json:"changes,omitempty"`
+}
+
+type Diff struct {
+	Added    int            `json:"added"`
+	Removed  int            `json:"removed"`
+	Changed  int            `json:"changed"`
+	Entities []EntityChange `json:"entities"`
+	// Other are the changes outside entities.
+	Other   []jsondiff.Change `json:"other"`
+	Summary string            `json:"summary"`
+}
+
+// Empty reports whether the snapshots are the same.
+func (d *Diff) Empty() bool {
+	return len(d.Entities) == 0 && len(d.Other) == 0
+}
+
+// DiffJSON decodes two JSON snapshots and diffs them.
+func DiffJSON(prior, current []byte, options Options) (*Diff, error) {
+	priorValue, err := jsondiff.Decode(prior)
+	if err != nil {
+		return nil, fmt.Errorf("decoding prior snapshot: %w", err)
+	}
+	currentValue, err := jsondiff.Decode(current)
+	if err != nil {
+		return nil, fmt.Errorf("decoding current snapshot: %w", err)
+	}
+	return Compute(priorValue, currentValue, options), nil
+}
+
+// Compute diffs two decoded snapshots.
+func Compute(prior, current interface{}, options Options) *Diff {
+	d := &Diff{Entities: []EntityChange{}}
+
+	priorEntities := map[string]map[string]interface{}{}
+	currentEntities := map[string]map[string]interface{}{}
+	priorRest := options.extract(nil, prior, priorEntities)
+	currentRest := options.extract(nil, current, currentEntities)
+
+	d.Other = jsondiff.Diff(
+		jsonpath.Remove(priorRest, options.Ignore),
+		jsonpath.Remove(currentRest, options.Ignore),
+	)
+
+	var lists []string
+	for list := range priorEntities {
+		lists = append(lists, list)
+	}
+	for list := range currentEntities {
+		if _, ok := priorEntities[list]; !ok {
+			lists = append(lists, list)
+		}
+	}
+	sort.Strings(lists)
+
+	for _, list := range lists {
+		before := priorEntities[list]
+		after := currentEntities[list]
+		var identities []string
+		for identity := range before {
+			identities = append(identities, identity)
+		}
+		for identity := range after {
+			if _, ok := before[identity]; !ok {
+				identities = append(identities, identity)
+			}
+		}
+		sort.Strings(identities)
+
+		for _, identity := range identities {
+			b, inBefore := before[identity]
+			a, inAfter := after[identity]
+			change := EntityChange{List: list, Identity: identity}
+			switch {
+			case !inAfter:
+				change.Kind = EntityRemoved
+				d.Removed++
+			case !inBefore:
+				change.Kind = EntityAdded
+				d.Added++
+			default:
+				change.Changes = jsondiff.Diff(
+					options.normalize(b),
+					options.normalize(a),
+				)
+				if len(change.Changes) == 0 {
+					continue
+				}
+				change.Kind = EntityChanged
+				d.Changed++
+			}
+			d.Entities = append(d.Entities, change)
+		}
+	}
+
+	d.Summary = d.summarize()
+	return d
+}
+
+// extract moves the entities in value into entities, keyed by the path of
+// their list and their identity, and returns what's left.
+func (o Options) extract(
+	path []string,
+	value interface{},
+	entities map[string]map[string]interface{},
+) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		rest := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			childPath := append(path[:len(path):len(path)], key)
+			if list, ok := o.entityList(child); ok {
+				entities[jsonpath.Join(childPath)] = list
+				continue
+			}
+			rest[key] = o.extract(childPath, child, entities)
+		}
+		return rest
+	}
+	return value
+}
+
+// entityList returns the entities in a list keyed by identity, if value is
+// a list of entities.
+func (o Options) entityList(value interface{}) (map[string]interface{}, bool) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make(map[string]interface{}, len(items))
+	for i, item := range items {
+		if _, ok := item.(map[string]interface{}); !ok {
+			return nil, false
+		}
+		identity, ok := o.identity(item)
+		if !ok {
+			return nil, false
+		}
+		// Entities without distinct identities are still diffed, by
+		// position among their duplicates.
+		if _, duplicate := list[identity]; duplicate {
+			identity = fmt.Sprintf("%s#%d", identity, i)
+		}
+		list[identity] = item
+	}
+	// Empty lists are treated as lists of entities so that a list going
+	// from empty to holding entities shows up as entities being added.
+	return list, true
+}
+
+func (o Options) identity(entity interface{}) (string, bool) {
+	var parts []string
+	found := false
+	for _, path := range o.Identity {
+		value, ok := jsonpath.Get(entity, jsonpath.Split(path))
+		if !ok || value == nil {
+			continue
+		}
+		found = true
+		part := fmt.Sprint(value)
+		if part == "" || part == "0" {
+			continue
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ":"), found
+}
+
+// normalize turns keyed lists in an entity into objects keyed by their
+// items' key field, and drops ignored values.
+func (o Options) normalize(entity interface{}) interface{} {
+	m, ok := entity.(map[string]interface{})
+	if !ok {
+		return entity
+	}
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		result[key] = value
+	}
+	for path, field := range o.KeyedLists {
+		segments := jsonpath.Split(path)
+		value, ok := jsonpath.Get(result, segments)
+		if !ok {
+			continue
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			continue
+		}
+		keyed := make(map[string]interface{}, len(items))
+		for i, item := range items {
+			key, ok := jsonpath.Get(item, []string{field})
+			if !ok {
+				key = i
+			}
+			keyed[fmt.Sprint(key)] = item
+		}
+		set(result, segments, keyed)
+	}
+	return jsonpath.Remove(result, o.Ignore)
+}
+
+// set replaces the value at a path that's known to exist, copying the
+// objects along the way so the original entity isn't modified.
+func set(m map[string]interface{}, path []string, value interface{}) {
+	if len(path) == 1 {
+		m[path[0]] = value
+		return
+	}
+	child, ok := m[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	copied := make(map[string]interface{}, len(child))
+	for key, v := range child {
+		copied[key] = v
+	}
+	m[path[0]] = copied
+	set(copied, path[1:], value)
+}
+
+func (d *Diff) summarize() string {
+	var parts []string
+	add := func(n int, label string) {
+		if n == 0 {
+			return
+		}
+		noun := "entities"
+		if n == 1 {
+			noun = "entity"
+		}
+		parts = append(parts, fmt.Sprintf("%d %s %s", n, noun, label))
+	}
+	add(d.Changed, "changed")
+	add(d.Added, "added")
+	add(d.Removed, "removed")
+	if len(d.Other) > 0 {
+		noun := "changes"
+		if len(d.Other) == 1 {
+			noun = "change"
+		}
+		parts = append(parts, fmt.Sprintf("%d other %s", len(d.Other), noun))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,126 @@
+package snapshotdiff
+
+import (
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/stretchr/testify/suite"
+)
+
+type snapshotDiffSuite struct {
+	suite.Suite
+}
+
+const priorSnapshot = `{
+	"version": 1,
+	"task_entities": [
+		{
+			"key": {"type": "key", "value": {"kind": "Task", "id": 1, "name": ""}},
+			"properties": [
+				{"name": "points", "value": {"type": "int", "value": 10}},
+				{"name": "updated", "value": {"type": "datetime", "value": "2020-08-01"}}
+			]
+		},
+		{
+			"key": {"type": "key", "value": {"kind": "Task", "id": 2, "name": ""}},
+			"properties": [{"name": "points", "value": {"type": "int", "value": 20}}]
+		}
+	],
+	"non_task_entities": []
+}`
+
+func (suite *snapshotDiffSuite) TestReorderingIsNotAChange() {
+	current := `{
+		"non_task_entities": [],
+		"task_entities": [
+			{
+				"properties": [{"name": "points", "value": {"value": 20, "type": "int"}}],
+				"key": {"type": "key", "value": {"kind": "Task", "id": 2, "name": ""}}
+			},
+			{
+				"key": {"type": "key", "value": {"kind": "Task", "id": 1, "name": ""}},
+				"properties": [
+					{"name": "updated", "value": {"type": "datetime", "value": "2020-08-01"}},
+					{"name": "points", "value": {"type": "int", "value": 10}}
+				]
+			}
+		],
+		"version": 1
+	}`
+
+	d, err := DiffJSON([]byte(priorSnapshot), []byte(current), DefaultOptions())
+	suite.Require().NoError(err)
+	suite.True(d.Empty())
+	suite.Equal("no changes", d.Summary)
+}
+
+func (suite *snapshotDiffSuite) TestEntityChanges() {
+	current := `{
+		"version": 2,
+		"task_entities": [
+			{
+				"key": {"type": "key", "value": {"kind": "Task", "id": 1, "name": ""}},
+				"properties": [
+					{"name": "points", "value": {"type": "int", "value": 15}},
+					{"name": "updated", "value": {"type": "datetime", "value": "2020-08-02"}}
+				]
+			}
+		],
+		"non_task_entities": [
+			{
+				"key": {"type": "key", "value": {"kind": "Exam", "id": 0, "name": "final"}},
+				"properties": []
+			}
+		]
+	}`
+
+	d, err := DiffJSON([]byte(priorSnapshot), []byte(current), DefaultOptions())
+	suite.Require().NoError(err)
+
+	suite.Equal(1, d.Added)
+	suite.Equal(1, d.Removed)
+	suite.Equal(1, d.Changed)
+	suite.Equal("1 entity changed, 1 entity added, 1 entity removed, 1 other change", d.Summary)
+
+	suite.Require().Len(d.Entities, 3)
+	suite.Equal(EntityChange{List: "non_task_entities", Identity: "Exam:final", Kind: EntityAdded}, d.Entities[0])
+	suite.Equal("Task:1", d.Entities[1].Identity)
+	suite.Equal(EntityChanged, d.Entities[1].Kind)
+	suite.Require().Len(d.Entities[1].Changes, 2)
+	suite.Equal("properties.points.value.value", d.Entities[1].Changes[0].Path)
+	suite.Equal("Task:2", d.Entities[2].Identity)
+	suite.Equal(EntityRemoved, d.Entities[2].Kind)
+
+	suite.Require().Len(d.Other, 1)
+	suite.Equal("version", d.Other[0].Path)
+}
+
+func (suite *snapshotDiffSuite) TestIgnore() {
+	current := `{
+		"version": 1,
+		"task_entities": [
+			{
+				"key": {"type": "key", "value": {"kind": "Task", "id": 1, "name": ""}},
+				"properties": [
+					{"name": "points", "value": {"type": "int", "value": 10}},
+					{"name": "updated", "value": {"type": "datetime", "value": "2020-08-02"}}
+				]
+			},
+			{
+				"key": {"type": "key", "value": {"kind": "Task", "id": 2, "name": ""}},
+				"properties": [{"name": "points", "value": {"type": "int", "value": 20}}]
+			}
+		],
+		"non_task_entities": []
+	}`
+
+	options := DefaultOptions()
+	options.Ignore = []*jsonpath.Pattern{jsonpath.MustCompile("properties.updated")}
+	d, err := DiffJSON([]byte(priorSnapshot), []byte(current), options)
+	suite.Require().NoError(err)
+	suite.True(d.Empty())
+}
+
+func TestSnapshotDiff(t *testing.T) {
+	suite.Run(t, new(snapshotDiffSuite))
+}
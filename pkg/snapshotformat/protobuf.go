@@ -0,0 +1,521 @@
+package snapshotformat
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// Field types from descriptor.proto.
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+
+	labelRepeated = 3
+)
+
+type wireField struct {
+	number   int
+	wireType int
+	varint   uint64
+	bytes    []byte
+}
+
+// parseWire splits a message into its fields.
+func parseWire(content []byte) ([]wireField, error) {
+	var fields []wireField
+	for len(content) > 0 {
+		key, n := binary.Uvarint(content)
+		if n <= 0 {
+			return nil, errors.New("invalid field key")
+		}
+		content = content[n:]
+		field := wireField{number: int(key >> 3), wireType: int(key & 7)}
+		if field.number == 0 {
+			return nil, errors.New("invalid field number 0")
+		}
+		switch field.wireType {
+		case wireVarint:
+			field.varint, n = binary.Uvarint(content)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			content = content[n:]
+		case wireFixed64:
+			if len(content) < 8 {
+				return nil, errors.New("truncated fixed64")
+			}
+			field.varint = binary.LittleEndian.Uint64(content)
+			content = content[8:]
+		case wireFixed32:
+			if len(content) < 4 {
+				return nil, errors.New("truncated fixed32")
+			}
+			field.varint = uint64(binary.LittleEndian.Uint32(content))
+			content = content[4:]
+		case wireBytes:
+			length, n := binary.Uvarint(content)
+			if n <= 0 || uint64(len(content)-n) < length {
+				return nil, errors.New("truncated length delimited field")
+			}
+			field.bytes = content[n : n+int(length)]
+			content = content[n+int(length):]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", field.wireType)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// protobufDecoder decodes protobuf snapshots with the descriptors in its
+// registry's descriptor directory.
+type protobufDecoder struct {
+	registry *Registry
+}
+
+func (d protobufDecoder) Decode(content []byte, params map[string]string) (interface{}, error) {
+	dir := d.registry.descriptorDir
+	if params["descriptor"] == "" || dir == "" {
+		return decodeRawMessage(content)
+	}
+	if params["message"] == "" {
+		return nil, errors.New("protobuf snapshots with a descriptor need a message parameter")
+	}
+	path, err := resolveDescriptor(dir, params["descriptor"])
+	if err != nil {
+		return nil, err
+	}
+	descriptors, err := loadDescriptors(path)
+	if err != nil {
+		return nil, err
+	}
+	message, ok := descriptors.messages[strings.TrimPrefix(params["message"], ".")]
+	if !ok {
+		return nil, fmt.Errorf("message %s isn't in %s", params["message"], params["descriptor"])
+	}
+	return descriptors.decode(message, content)
+}
+
+// decodeRawMessage decodes a message without its descriptor, keyed by
+// field number. Length delimited fields are decoded as messages if they
+// parse as one, then as strings if they're UTF-8, and otherwise as base64.
+func decodeRawMessage(content []byte) (map[string]interface{}, error) {
+	fields, err := parseWire(content)
+	if err != nil {
+		return nil, err
+	}
+	message := map[string]interface{}{}
+	for _, field := range fields {
+		var value interface{}
+		switch field.wireType {
+		case wireBytes:
+			if nested, err := decodeRawMessage(field.bytes); err == nil && len(field.bytes) > 0 {
+				value = nested
+			} else if utf8.Valid(field.bytes) {
+				value = string(field.bytes)
+			} else {
+				value = base64.StdEncoding.EncodeToString(field.bytes)
+			}
+		default:
+			value = json.Number(strconv.FormatUint(field.varint, 10))
+		}
+		addField(message, strconv.Itoa(field.number), value, false)
+	}
+	return message, nil
+}
+
+// addField adds a value to a message. Fields seen more than once become
+// lists, as do repeated fields.
+func addField(message map[string]interface{}, name string, value interface{}, repeated bool) {
+	existing, ok := message[name]
+	if list, isList := existing.([]interface{}); ok && isList {
+		message[name] = append(list, value)
+		return
+	}
+	if ok && !repeated {
+		message[name] = []interface{}{existing, value}
+		return
+	}
+	if repeated {
+		message[name] = []interface{}{value}
+		return
+	}
+	message[name] = value
+}
+
+type fieldDescriptor struct {
+	name     string
+	number   int
+	label    int
+	typ      int
+	typeName string
+}
+
+type messageDescriptor struct {
+	fields   map[int]*fieldDescriptor
+	mapEntry bool
+}
+
+type descriptorSet struct {
+	messages map[string]*messageDescriptor
+	enums    map[string]map[int64]string
+}
+
+var (
+	descriptorCache   = map[string]*descriptorSet{}
+	descriptorCacheMu sync.Mutex
+)
+
+// resolveDescriptor returns the path of a descriptor in dir, following
+// symlinks, or an error if it's outside dir.
+func resolveDescriptor(dir string, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return "", fmt.Errorf("descriptor %s: must be relative to the record directory", name)
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, name))
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("descriptor %s: outside the record directory", name)
+	}
+	return path, nil
+}
+
+// loadDescriptors loads a FileDescriptorSet, caching it by path.
+func loadDescriptors(path string) (*descriptorSet, error) {
+	descriptorCacheMu.Lock()
+	defer descriptorCacheMu.Unlock()
+	if set, ok := descriptorCache[path]; ok {
+		return set, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := parseDescriptorSet(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	descriptorCache[path] = set
+	return set, nil
+}
+
+func parseDescriptorSet(content []byte) (*descriptorSet, error) {
+	set := &descriptorSet{
+		messages: map[string]*messageDescriptor{},
+		enums:    map[string]map[int64]string{},
+	}
+	files, err := parseWire(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if file.number != 1 || file.wireType != wireBytes {
+			continue
+		}
+		fields, err := parseWire(file.bytes)
+		if err != nil {
+			return nil, err
+		}
+		pkg := ""
+		for _, field := range fields {
+			if field.number == 2 {
+				pkg = string(field.bytes)
+			}
+		}
+		for _, field := range fields {
+			var err error
+			switch field.number {
+			case 4:
+				err = set.addMessage(pkg, field.bytes)
+			case 5:
+				err = set.addEnum(pkg, field.bytes)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return set, nil
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+func (s *descriptorSet) addMessage(scope string, content []byte) error {
+	fields, err := parseWire(content)
+	if err != nil {
+		return err
+	}
+	message := &messageDescriptor{fields: map[int]*fieldDescriptor{}}
+	name := ""
+	for _, field := range fields {
+		if field.number == 1 {
+			name = qualify(scope, string(field.bytes))
+		}
+	}
+	for _, field := range fields {
+		switch field.number {
+		case 2:
+			f, err := parseFieldDescriptor(field.bytes)
+			if err != nil {
+				return err
+			}
+			message.fields[f.number] = f
+		case 3:
+			err = s.addMessage(name, field.bytes)
+		case 4:
+			err = s.addEnum(name, field.bytes)
+		case 7:
+			options, optionsErr := parseWire(field.bytes)
+			for _, option := range options {
+				if option.number == 7 && option.varint != 0 {
+					message.mapEntry = true
+				}
+			}
+			err = optionsErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.messages[name] = message
+	return nil
+}
+
+func parseFieldDescriptor(content []byte) (*fieldDescriptor, error) {
+	fields, err := parseWire(content)
+	if err != nil {
+		return nil, err
+	}
+	f := &fieldDescriptor{}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			f.name = string(field.bytes)
+		case 3:
+			f.number = int(field.varint)
+		case 4:
+			f.label = int(field.varint)
+		case 5:
+			f.typ = int(field.varint)
+		case 6:
+			f.typeName = strings.TrimPrefix(string(field.bytes), ".")
+		}
+	}
+	return f, nil
+}
+
+func (s *descriptorSet) addEnum(scope string, content []byte) error {
+	fields, err := parseWire(content)
+	if err != nil {
+		return err
+	}
+	name := ""
+	values := map[int64]string{}
+	for _, field := range fields {
+		switch field.number {
+		case 1:
+			name = qualify(scope, string(field.bytes))
+		case 2:
+			valueFields, err := parseWire(field.bytes)
+			if err != nil {
+				return err
+			}
+			valueName := ""
+			var number int64
+			for _, valueField := range valueFields {
+				switch valueField.number {
+				case 1:
+					valueName = string(valueField.bytes)
+				case 2:
+					number = int64(int32(valueField.varint))
+				}
+			}
+			values[number] = valueName
+		}
+	}
+	s.enums[name] = values
+	return nil
+}
+
+// decode decodes a message by field name. Fields missing from the
+// descriptor are keyed by number, and enums are decoded by value name.
+func (s *descriptorSet) decode(message *messageDescriptor, content []byte) (map[string]interface{}, error) {
+	fields, err := parseWire(content)
+	if err != nil {
+		return nil, err
+	}
+	result := map[string]interface{}{}
+	for _, field := range fields {
+		descriptor, ok := message.fields[field.number]
+		if !ok {
+			value := interface{}(json.Number(strconv.FormatUint(field.varint, 10)))
+			if field.wireType == wireBytes {
+				value = base64.StdEncoding.EncodeToString(field.bytes)
+			}
+			addField(result, strconv.Itoa(field.number), value, false)
+			continue
+		}
+
+		repeated := descriptor.label == labelRepeated
+		if field.wireType == wireBytes && isPackable(descriptor.typ) {
+			values, err := s.decodePacked(descriptor, field.bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", descriptor.name, err)
+			}
+			for _, value := range values {
+				addField(result, descriptor.name, value, repeated)
+			}
+			continue
+		}
+
+		value, err := s.decodeValue(descriptor, field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", descriptor.name, err)
+		}
+
+		if entry, ok := s.messages[descriptor.typeName]; ok && entry.mapEntry && repeated {
+			m, _ := result[descriptor.name].(map[string]interface{})
+			if m == nil {
+				m = map[string]interface{}{}
+				result[descriptor.name] = m
+			}
+			pair, _ := value.(map[string]interface{})
+			m[fmt.Sprint(pair["key"])] = pair["value"]
+			continue
+		}
+		addField(result, descriptor.name, value, repeated)
+	}
+	return result, nil
+}
+
+func isPackable(typ int) bool {
+	switch typ {
+	case typeString, typeBytes, typeMessage:
+		return false
+	}
+	return true
+}
+
+func (s *descriptorSet) decodePacked(descriptor *fieldDescriptor, content []byte) ([]interface{}, error) {
+	var values []interface{}
+	for len(content) > 0 {
+		field := wireField{number: descriptor.number}
+		switch descriptor.typ {
+		case typeDouble, typeFixed64, typeSfixed64:
+			if len(content) < 8 {
+				return nil, errors.New("truncated packed field")
+			}
+			field.wireType = wireFixed64
+			field.varint = binary.LittleEndian.Uint64(content)
+			content = content[8:]
+		case typeFloat, typeFixed32, typeSfixed32:
+			if len(content) < 4 {
+				return nil, errors.New("truncated packed field")
+			}
+			field.wireType = wireFixed32
+			field.varint = uint64(binary.LittleEndian.Uint32(content))
+			content = content[4:]
+		default:
+			var n int
+			field.varint, n = binary.Uvarint(content)
+			if n <= 0 {
+				return nil, errors.New("invalid varint")
+			}
+			content = content[n:]
+		}
+		value, err := s.decodeValue(descriptor, field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func (s *descriptorSet) decodeValue(descriptor *fieldDescriptor, field wireField) (interface{}, error) {
+	v := field.varint
+	switch descriptor.typ {
+	case typeDouble:
+		return jsonFloat(math.Float64frombits(v)), nil
+	case typeFloat:
+		return jsonFloat(float64(math.Float32frombits(uint32(v)))), nil
+	case typeInt64, typeSfixed64:
+		return json.Number(strconv.FormatInt(int64(v), 10)), nil
+	case typeInt32, typeSfixed32:
+		return json.Number(strconv.FormatInt(int64(int32(v)), 10)), nil
+	case typeUint64, typeFixed64, typeUint32, typeFixed32:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case typeSint32, typeSint64:
+		return json.Number(strconv.FormatInt(int64(v>>1)^-int64(v&1), 10)), nil
+	case typeBool:
+		return v != 0, nil
+	case typeEnum:
+		if name, ok := s.enums[descriptor.typeName][int64(int32(v))]; ok {
+			return name, nil
+		}
+		return json.Number(strconv.FormatInt(int64(int32(v)), 10)), nil
+	case typeString:
+		return string(field.bytes), nil
+	case typeBytes:
+		return base64.StdEncoding.EncodeToString(field.bytes), nil
+	case typeMessage:
+		message, ok := s.messages[descriptor.typeName]
+		if !ok {
+			return decodeRawMessage(field.bytes)
+		}
+		return s.decode(message, field.bytes)
+	}
+	return nil, fmt.Errorf("unsupported field type %d", descriptor.typ)
+}
+
+func jsonFloat(f float64) interface{} {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return json.Number(strconv.FormatFloat(f, 'g', -1, 64))
+}
@@ -0,0 +1,300 @@
+// Package snapshotformat decodes snapshots of different formats into a
+// common tree, the values encoding/json decodes into with UseNumber, so
+// they can be diffed and shown the same way whatever the snapshotter
+// produced.
+//
+// Formats are identified by content types. Snapshotters declare the content
+// type of their snapshots (see proxy.Snapshotter) and it's kept
+// with each session. Decoders for more formats can be registered.
+package snapshotformat
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"sync"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/pickle"
+)
+
+// The content types decoders are registered for by default.
+const (
+	JSON   = "application/json"
+	NDJSON = "application/x-ndjson"
+	CSV    = "text/csv"
+	Pickle = "application/x-python-pickle"
+	// Protobuf snapshots are decoded by field number, or by field name
+	// given the descriptor and message parameters, e.g.
+	// `application/x-protobuf; descriptor="snapshot.pb"; message=gtp.Snapshot`.
+	// The descriptor is a FileDescriptorSet file, as written by protoc
+	// --descriptor_set_out --include_imports, in the registry's descriptor
+	// directory, see Registry.WithDescriptorDir. Registries without one
+	// decode by field number.
+	Protobuf = "application/x-protobuf"
+	// SQL snapshots are dumps. The rows of their INSERT and COPY statements
+	// are decoded, keyed by table.
+	SQL = "application/sql"
+)
+
+var ErrUnknownFormat = errors.New("unknown snapshot format")
+
+// Decoder decodes snapshots of one format. params are the parameters of
+// the content type.
+type Decoder interface {
+	Decode(content []byte, params map[string]string) (interface{}, error)
+}
+
+// DecoderFunc adapts a function to a Decoder.
+type DecoderFunc func(content []byte, params map[string]string) (interface{}, error)
+
+func (f DecoderFunc) Decode(content []byte, params map[string]string) (interface{}, error) {
+	return f(content, params)
+}
+
+// Registry maps content types to decoders.
+type Registry struct {
+	decoders      map[string]Decoder
+	descriptorDir string
+	// Hold when reading or writing decoders
+	mu sync.RWMutex
+}
+
+// NewRegistry returns a registry with decoders for the built-in formats.
+func NewRegistry() *Registry {
+	r := &Registry{decoders: map[string]Decoder{}}
+	r.Register(JSON, DecoderFunc(decodeJSON))
+	r.Register(NDJSON, DecoderFunc(decodeNDJSON))
+	r.Register(CSV, DecoderFunc(decodeCSV))
+	r.Register(Pickle, DecoderFunc(decodePickle))
+	r.Register(Protobuf, protobufDecoder{r})
+	r.Register(SQL, DecoderFunc(decodeSQL))
+	return r
+}
+
+// Register sets the decoder for a content type, replacing any decoder
+// already registered for it. Parameters of the content type are ignored.
+func (r *Registry) Register(contentType string, decoder Decoder) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.decoders[mediaType] = decoder
+}
+
+// WithDescriptorDir returns a copy of the registry that loads protobuf
+// descriptors from dir, normally the record directory of the sessions it
+// decodes, so each recording gets its own. Descriptor paths are relative to
+// it and descriptors outside it aren't loaded, since content types are read
+// from session files that may have come from someone else.
+func (r *Registry) WithDescriptorDir(dir string) *Registry {
+	copied := &Registry{decoders: map[string]Decoder{}, descriptorDir: dir}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for mediaType, decoder := range r.decoders {
+		if _, ok := decoder.(protobufDecoder); ok {
+			decoder = protobufDecoder{copied}
+		}
+		copied.decoders[mediaType] = decoder
+	}
+	return copied
+}
+
+// Decode decodes a snapshot. If contentType is empty, the format is
+// detected from the content.
+func (r *Registry) Decode(contentType string, content []byte) (interface{}, error) {
+	if contentType == "" {
+		contentType = Detect(content)
+		if contentType == "" {
+			return nil, fmt.Errorf("%w, and it couldn't be detected", ErrUnknownFormat)
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, fmt.Errorf("invalid content type %q: %w", contentType, err)
+	}
+
+	r.mu.RLock()
+	decoder, ok := r.decoders[mediaType]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, mediaType)
+	}
+	return decoder.Decode(content, params)
+}
+
+// ToJSON decodes a snapshot and encodes it as canonical JSON, with object
+// keys sorted and no insignificant whitespace.
+func (r *Registry) ToJSON(contentType string, content []byte) ([]byte, error) {
+	value, err := r.Decode(contentType, content)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// Readable returns a snapshot as canonical JSON and true, or the snapshot
+// unchanged and false if it can't be decoded.
+func (r *Registry) Readable(contentType string, content []byte) ([]byte, bool) {
+	if len(content) == 0 {
+		return content, false
+	}
+	decoded, err := r.ToJSON(contentType, content)
+	if err != nil {
+		return content, false
+	}
+	return decoded, true
+}
+
+// Default is the registry used by the package level functions. It has no
+// descriptor directory.
+var Default = NewRegistry()
+
+// Registries returns the registry a session's snapshots are decoded with,
+// e.g. the one for the record directory the session is in.
+type Registries func(session string) *Registry
+
+// For returns the registry for a session, or Default if r is nil or has
+// none for it.
+func (r Registries) For(session string) *Registry {
+	if r == nil {
+		return Default
+	}
+	if registry := r(session); registry != nil {
+		return registry
+	}
+	return Default
+}
+
+// Register sets the decoder for a content type in the default registry.
+func Register(contentType string, decoder Decoder) {
+	Default.Register(contentType, decoder)
+}
+
+// Decode decodes a snapshot with the default registry.
+func Decode(contentType string, content []byte) (interface{}, error) {
+	return Default.Decode(contentType, content)
+}
+
+// ToJSON decodes a snapshot to canonical JSON with the default registry.
+func ToJSON(contentType string, content []byte) ([]byte, error) {
+	return Default.ToJSON(contentType, content)
+}
+
+// Readable returns a snapshot as canonical JSON with the default registry,
+// or unchanged and false if it can't be decoded.
+func Readable(contentType string, content []byte) ([]byte, bool) {
+	return Default.Readable(contentType, content)
+}
+
+// Detect guesses the content type of a snapshot, for snapshots recorded
+// before snapshotters declared one. Only pickles, JSON and NDJSON are
+// detected; it returns "" for anything else.
+func Detect(content []byte) string {
+	switch {
+	case pickle.IsPickle(content):
+		return Pickle
+	case json.Valid(content):
+		return JSON
+	case isNDJSON(content):
+		return NDJSON
+	}
+	return ""
+}
+
+func isNDJSON(content []byte) bool {
+	lines := 0
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !json.Valid(line) {
+			return false
+		}
+		lines++
+	}
+	return lines > 0
+}
+
+func decodeJSON(content []byte, _ map[string]string) (interface{}, error) {
+	return jsondiff.Decode(content)
+}
+
+// decodeNDJSON decodes newline delimited JSON as a list.
+func decodeNDJSON(content []byte, _ map[string]string) (interface{}, error) {
+	values := []interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, len(content)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		value, err := jsondiff.Decode(text)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		values = append(values, value)
+	}
+	return values, scanner.Err()
+}
+
+// decodeCSV decodes CSV as a list of objects keyed by the header row, or
+// with the header=absent parameter, a list of lists.
+func decodeCSV(content []byte, params map[string]string) (interface{}, error) {
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.FieldsPerRecord = -1
+
+	var header []string
+	if params["header"] != "absent" {
+		var err error
+		header, err = reader.Read()
+		if err == io.EOF {
+			return []interface{}{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rows := []interface{}{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header == nil {
+			row := make([]interface{}, len(record))
+			for i, field := range record {
+				row[i] = field
+			}
+			rows = append(rows, row)
+			continue
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, field := range record {
+			name := fmt.Sprintf("column%d", i+1)
+			if i < len(header) {
+				name = header[i]
+			}
+			row[name] = field
+		}
+		rows = append(rows, row)
+	}
+}
+
+func decodePickle(content []byte, _ map[string]string) (interface{}, error) {
+	return pickle.Decode(content)
+}
@@ -0,0 +1,299 @@
+package snapshotformat
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type snapshotFormatSuite struct {
+	suite.Suite
+	tempDir string
+}
+
+func (suite *snapshotFormatSuite) BeforeTest(suiteName, testName string) {
+	tempDir, err := ioutil.TempDir("", "snapshotformat")
+	suite.Require().NoError(err)
+	suite.tempDir = tempDir
+}
+
+func (suite *snapshotFormatSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.tempDir)
+}
+
+func (suite *snapshotFormatSuite) requireJSON(expected string, contentType string, content string) {
+	decoded, err := ToJSON(contentType, []byte(content))
+	suite.Require().NoError(err)
+	suite.JSONEq(expected, string(decoded))
+}
+
+func (suite *snapshotFormatSuite) TestJSON() {
+	suite.requireJSON(`{"a": [1, 2.5]}`, JSON, `{"a": [1, 2.5]}`)
+	suite.requireJSON(`{"a": 1}`, "application/json; charset=utf-8", `{"a": 1}`)
+
+	decoded, err := ToJSON(JSON, []byte(`{"b": 12345678901234567890, "a": 1}`))
+	suite.Require().NoError(err)
+	suite.Equal(`{"a":1,"b":12345678901234567890}`, string(decoded))
+}
+
+func (suite *snapshotFormatSuite) TestNDJSON() {
+	suite.requireJSON(`[{"a": 1}, {"a": 2}]`, NDJSON, "{\"a\": 1}\n\n{\"a\": 2}\n")
+
+	_, err := Decode(NDJSON, []byte("{\"a\": 1}\n{"))
+	suite.Error(err)
+}
+
+func (suite *snapshotFormatSuite) TestCSV() {
+	suite.requireJSON(
+		`[{"id": "1", "name": "a, b"}, {"id": "2", "name": ""}]`,
+		CSV,
+		"id,name\n1,\"a, b\"\n2,\n",
+	)
+	suite.requireJSON(`[["1", "a"], ["2", "b"]]`, "text/csv; header=absent", "1,a\n2,b\n")
+	suite.requireJSON(`[]`, CSV, "")
+}
+
+func (suite *snapshotFormatSuite) TestPickle() {
+	suite.requireJSON(`{"a": 1}`, Pickle, "\x80\x04\x95\n\x00\x00\x00\x00\x00\x00\x00}\x94\x8c\x01a\x94K\x01s.")
+}
+
+func (suite *snapshotFormatSuite) TestDetect() {
+	suite.Equal(Pickle, Detect([]byte("\x80\x04\x95")))
+	suite.Equal(JSON, Detect([]byte(`{"a": 1}`)))
+	suite.Equal(NDJSON, Detect([]byte("{\"a\": 1}\n{\"a\": 2}\n")))
+	suite.Equal("", Detect([]byte("id,name\n1,a\n")))
+
+	suite.requireJSON(`{"a": 1}`, "", `{"a": 1}`)
+}
+
+func (suite *snapshotFormatSuite) TestUnknownFormat() {
+	_, err := Decode("application/x-unknown", []byte("x"))
+	suite.True(errors.Is(err, ErrUnknownFormat))
+
+	_, err = Decode("", []byte("id,name\n1,a\n"))
+	suite.True(errors.Is(err, ErrUnknownFormat))
+
+	content, ok := Readable("application/x-unknown", []byte("x"))
+	suite.False(ok)
+	suite.Equal("x", string(content))
+}
+
+func (suite *snapshotFormatSuite) TestRegister() {
+	registry := NewRegistry()
+	registry.Register("text/x-lines; charset=utf-8", DecoderFunc(func(content []byte, params map[string]string) (interface{}, error) {
+		return []interface{}{string(content), params["sep"]}, nil
+	}))
+
+	decoded, err := registry.ToJSON("text/x-lines; sep=tab", []byte("a"))
+	suite.Require().NoError(err)
+	suite.Equal(`["a","tab"]`, string(decoded))
+
+	decoded, err = registry.WithDescriptorDir(suite.tempDir).ToJSON("text/x-lines; sep=tab", []byte("a"))
+	suite.Require().NoError(err)
+	suite.Equal(`["a","tab"]`, string(decoded), "copies keep registered decoders")
+
+	_, err = Decode("text/x-lines", []byte("a"))
+	suite.True(errors.Is(err, ErrUnknownFormat), "registries are independent")
+}
+
+// protobuf encodes the fields of a message for tests. Values are ints
+// for varints, strings and []byte for length delimited fields, and
+// fixed32 and fixed64 for fixed width fields.
+type fixed32 uint32
+type fixed64 uint64
+
+func protobuf(fields ...interface{}) []byte {
+	var b []byte
+	for i := 0; i < len(fields); i += 2 {
+		number := uint64(fields[i].(int))
+		switch value := fields[i+1].(type) {
+		case int:
+			b = appendUvarint(b, number<<3|wireVarint)
+			b = appendUvarint(b, uint64(value))
+		case fixed32:
+			b = appendUvarint(b, number<<3|wireFixed32)
+			b = append(b, make([]byte, 4)...)
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(value))
+		case fixed64:
+			b = appendUvarint(b, number<<3|wireFixed64)
+			b = append(b, make([]byte, 8)...)
+			binary.LittleEndian.PutUint64(b[len(b)-8:], uint64(value))
+		case string:
+			b = appendUvarint(b, number<<3|wireBytes)
+			b = appendUvarint(b, uint64(len(value)))
+			b = append(b, value...)
+		case []byte:
+			b = appendUvarint(b, number<<3|wireBytes)
+			b = appendUvarint(b, uint64(len(value)))
+			b = append(b, value...)
+		}
+	}
+	return b
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return append(b, buf[:binary.PutUvarint(buf, v)]...)
+}
+
+func (suite *snapshotFormatSuite) TestProtobufWithoutDescriptor() {
+	content := protobuf(
+		1, 150,
+		2, "task",
+		3, protobuf(1, 7),
+		3, protobuf(1, 8),
+	)
+	suite.requireJSON(`{"1": 150, "2": "task", "3": [{"1": 7}, {"1": 8}]}`, Protobuf, string(content))
+}
+
+func (suite *snapshotFormatSuite) TestProtobufWithDescriptor() {
+	field := func(name string, number, label, typ int, typeName string) []byte {
+		return protobuf(1, name, 3, number, 4, label, 5, typ, 6, typeName)
+	}
+	const optional, repeated = 1, labelRepeated
+	descriptorSet := protobuf(1, protobuf(
+		1, "snapshot.proto",
+		2, "gtp",
+		4, protobuf(
+			1, "Snapshot",
+			2, field("tasks", 1, repeated, typeMessage, ".gtp.Task"),
+			2, field("counts", 2, repeated, typeMessage, ".gtp.Snapshot.CountsEntry"),
+			3, protobuf(
+				1, "CountsEntry",
+				2, field("key", 1, optional, typeString, ""),
+				2, field("value", 2, optional, typeInt32, ""),
+				7, protobuf(7, 1),
+			),
+		),
+		4, protobuf(
+			1, "Task",
+			2, field("id", 1, optional, typeInt64, ""),
+			2, field("title", 2, optional, typeString, ""),
+			2, field("state", 3, optional, typeEnum, ".gtp.State"),
+			2, field("scores", 4, repeated, typeSint32, ""),
+			2, field("ratio", 5, optional, typeDouble, ""),
+			2, field("done", 6, optional, typeBool, ""),
+		),
+		5, protobuf(
+			1, "State",
+			2, protobuf(1, "STARTED", 2, 0),
+			2, protobuf(1, "FINISHED", 2, 1),
+		),
+	))
+	recordDir := filepath.Join(suite.tempDir, "output")
+	suite.Require().NoError(os.Mkdir(recordDir, 0755))
+	descriptorPath := filepath.Join(recordDir, "snapshot.pb")
+	suite.Require().NoError(ioutil.WriteFile(descriptorPath, descriptorSet, 0644))
+	base := NewRegistry()
+	registry := base.WithDescriptorDir(recordDir)
+
+	content := protobuf(
+		1, protobuf(
+			1, 12,
+			2, "Diagnostic",
+			3, 1,
+			4, []byte{2, 3}, // packed zigzag 1, -2
+			5, fixed64(0x3fe0000000000000),
+			6, 1,
+			9, 4,
+		),
+		2, protobuf(1, "done", 2, 3),
+	)
+	decoded, err := registry.ToJSON(`application/x-protobuf; descriptor="snapshot.pb"; message=gtp.Snapshot`, content)
+	suite.Require().NoError(err)
+	suite.JSONEq(
+		`{
+			"tasks": [{
+				"id": 12,
+				"title": "Diagnostic",
+				"state": "FINISHED",
+				"scores": [1, -2],
+				"ratio": 0.5,
+				"done": true,
+				"9": 4
+			}],
+			"counts": {"done": 3}
+		}`,
+		string(decoded),
+	)
+
+	_, err = registry.Decode(`application/x-protobuf; descriptor="snapshot.pb"; message=gtp.Missing`, content)
+	suite.Error(err)
+
+	// Descriptors are only loaded from the record directory.
+	outside := filepath.Join(suite.tempDir, "outside.pb")
+	suite.Require().NoError(ioutil.WriteFile(outside, descriptorSet, 0644))
+	suite.Require().NoError(os.Symlink(outside, filepath.Join(recordDir, "link.pb")))
+	for _, descriptor := range []string{outside, "../outside.pb", "link.pb"} {
+		_, err = registry.Decode(`application/x-protobuf; descriptor="`+descriptor+`"; message=gtp.Snapshot`, content)
+		suite.Error(err, descriptor)
+	}
+
+	// Without a descriptor directory, snapshots are decoded by field number.
+	decoded, err = base.ToJSON(`application/x-protobuf; descriptor="snapshot.pb"; message=gtp.Snapshot`, protobuf(1, protobuf(1, 12)))
+	suite.Require().NoError(err)
+	suite.JSONEq(`{"1": {"1": 12}}`, string(decoded))
+}
+
+const mysqlDump = `-- MySQL dump
+/*!40101 SET NAMES utf8 */;
+DROP TABLE IF EXISTS ` + "`task`" + `;
+CREATE TABLE ` + "`task`" + ` (
+  ` + "`id`" + ` int(11) NOT NULL,
+  ` + "`title`" + ` varchar(255) DEFAULT NULL,
+  ` + "`points`" + ` decimal(10,2) DEFAULT 0,
+  PRIMARY KEY (` + "`id`" + `),
+  KEY ` + "`title`" + ` (` + "`title`" + `)
+);
+INSERT INTO ` + "`task`" + ` VALUES (1,'It\'s; done',1.50),(2,NULL,-3);
+INSERT INTO ` + "`task`" + ` (` + "`id`" + `, ` + "`title`" + `) VALUES (3, _binary 'x');
+`
+
+const postgresDump = `--
+-- PostgreSQL database dump
+--
+CREATE TABLE public.attempt (
+    id integer NOT NULL,
+    state text,
+    CONSTRAINT positive CHECK ((id > 0))
+);
+
+COPY public.attempt (id, state) FROM stdin;
+1	started
+2	\N
+3	a\tb
+\.
+
+INSERT INTO public.attempt VALUES (4, 'done'::text);
+`
+
+func (suite *snapshotFormatSuite) TestSQL() {
+	suite.requireJSON(`{
+		"task": [
+			{"id": 1, "title": "It's; done", "points": 1.50},
+			{"id": 2, "title": null, "points": -3},
+			{"id": 3, "title": "x"}
+		]
+	}`, SQL, mysqlDump)
+
+	suite.requireJSON(`{
+		"public.attempt": [
+			{"id": "1", "state": "started"},
+			{"id": "2", "state": null},
+			{"id": "3", "state": "a\tb"},
+			{"id": 4, "state": "done"}
+		]
+	}`, SQL, postgresDump)
+
+	_, err := Decode(SQL, []byte("INSERT INTO t VALUES ('unterminated);"))
+	suite.Error(err)
+}
+
+func TestSnapshotFormat(t *testing.T) {
+	suite.Run(t, new(snapshotFormatSuite))
+}
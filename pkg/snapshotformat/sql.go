@@ -0,0 +1,469 @@
+package snapshotformat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type sqlTokenKind int
+
+const (
+	sqlWord sqlTokenKind = iota
+	sqlIdentifier
+	sqlString
+	sqlNumber
+	sqlPunct
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t sqlToken) is(word string) bool {
+	return t.kind == sqlWord && strings.EqualFold(t.text, word)
+}
+
+// sqlScanner splits a SQL dump into statements of tokens.
+type sqlScanner struct {
+	s   string
+	pos int
+}
+
+// next returns the tokens of the next statement, without its semicolon,
+// or nil at the end of the dump.
+func (sc *sqlScanner) next() ([]sqlToken, error) {
+	var tokens []sqlToken
+	for {
+		sc.skipSpaceAndComments()
+		if sc.pos >= len(sc.s) {
+			return tokens, nil
+		}
+		c := sc.s[sc.pos]
+		switch {
+		case c == ';':
+			sc.pos++
+			if len(tokens) > 0 {
+				return tokens, nil
+			}
+		case c == '\'':
+			text, err := sc.quoted('\'')
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, sqlToken{sqlString, text})
+		case c == '"' || c == '`':
+			text, err := sc.quoted(c)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, sqlToken{sqlIdentifier, text})
+		case isDigit(c) || (c == '-' || c == '.') && sc.pos+1 < len(sc.s) && isDigit(sc.s[sc.pos+1]):
+			start := sc.pos
+			sc.pos++
+			for sc.pos < len(sc.s) && (isWordByte(sc.s[sc.pos]) || sc.s[sc.pos] == '.' ||
+				(sc.s[sc.pos] == '-' || sc.s[sc.pos] == '+') && (sc.s[sc.pos-1] == 'e' || sc.s[sc.pos-1] == 'E')) {
+				sc.pos++
+			}
+			tokens = append(tokens, sqlToken{sqlNumber, sc.s[start:sc.pos]})
+		case isWordByte(c):
+			start := sc.pos
+			for sc.pos < len(sc.s) && isWordByte(sc.s[sc.pos]) {
+				sc.pos++
+			}
+			tokens = append(tokens, sqlToken{sqlWord, sc.s[start:sc.pos]})
+		default:
+			sc.pos++
+			tokens = append(tokens, sqlToken{sqlPunct, string(c)})
+		}
+	}
+}
+
+func (sc *sqlScanner) skipSpaceAndComments() {
+	for sc.pos < len(sc.s) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(sc.s[sc.pos])):
+			sc.pos++
+		case strings.HasPrefix(sc.s[sc.pos:], "--") || sc.s[sc.pos] == '#':
+			sc.skipLine()
+		case strings.HasPrefix(sc.s[sc.pos:], "/*"):
+			end := strings.Index(sc.s[sc.pos+2:], "*/")
+			if end < 0 {
+				sc.pos = len(sc.s)
+			} else {
+				sc.pos += end + 4
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (sc *sqlScanner) skipLine() {
+	end := strings.IndexByte(sc.s[sc.pos:], '\n')
+	if end < 0 {
+		sc.pos = len(sc.s)
+	} else {
+		sc.pos += end + 1
+	}
+}
+
+// quoted reads a quoted string or identifier. Quotes are escaped by
+// doubling them, and in strings by backslashes as MySQL dumps do.
+func (sc *sqlScanner) quoted(quote byte) (string, error) {
+	var b strings.Builder
+	sc.pos++
+	for sc.pos < len(sc.s) {
+		c := sc.s[sc.pos]
+		switch {
+		case c == quote && sc.pos+1 < len(sc.s) && sc.s[sc.pos+1] == quote:
+			b.WriteByte(quote)
+			sc.pos += 2
+		case c == quote:
+			sc.pos++
+			return b.String(), nil
+		case c == '\\' && quote == '\'' && sc.pos+1 < len(sc.s):
+			b.WriteString(unescapeSQL(sc.s[sc.pos+1]))
+			sc.pos += 2
+		default:
+			b.WriteByte(c)
+			sc.pos++
+		}
+	}
+	return "", errors.New("unterminated quoted string")
+}
+
+func unescapeSQL(c byte) string {
+	switch c {
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case '0':
+		return "\x00"
+	case 'Z':
+		return "\x1a"
+	}
+	return string(c)
+}
+
+// copyData reads the rows of a COPY ... FROM stdin statement, up to the \.
+// line that ends them.
+func (sc *sqlScanner) copyData() []string {
+	sc.skipLine()
+	var lines []string
+	for sc.pos < len(sc.s) {
+		end := strings.IndexByte(sc.s[sc.pos:], '\n')
+		line := sc.s[sc.pos:]
+		if end < 0 {
+			sc.pos = len(sc.s)
+		} else {
+			line = sc.s[sc.pos : sc.pos+end]
+			sc.pos += end + 1
+		}
+		line = strings.TrimSuffix(line, "\r")
+		if line == `\.` {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || isDigit(c) || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// decodeSQL decodes the rows of a SQL dump's INSERT and COPY statements
+// into an object of tables, each a list of rows. Rows are objects keyed by
+// column if the columns are known, from the statement or the table's
+// CREATE TABLE statement, and lists otherwise.
+func decodeSQL(content []byte, _ map[string]string) (interface{}, error) {
+	sc := &sqlScanner{s: string(content)}
+	columns := map[string][]string{}
+	tables := map[string]interface{}{}
+
+	addRow := func(table string, statementColumns []string, values []interface{}) {
+		names := statementColumns
+		if names == nil {
+			names = columns[table]
+		}
+		rows, _ := tables[table].([]interface{})
+		if len(names) != len(values) {
+			tables[table] = append(rows, values)
+			return
+		}
+		row := make(map[string]interface{}, len(values))
+		for i, name := range names {
+			row[name] = values[i]
+		}
+		tables[table] = append(rows, row)
+	}
+
+	for {
+		tokens, err := sc.next()
+		if err != nil {
+			return nil, err
+		}
+		if tokens == nil {
+			return tables, nil
+		}
+		p := &sqlParser{tokens: tokens}
+		switch {
+		case p.accept("CREATE"):
+			p.accept("TEMPORARY")
+			if !p.accept("TABLE") {
+				continue
+			}
+			if p.accept("IF") {
+				p.accept("NOT")
+				p.accept("EXISTS")
+			}
+			table := p.name()
+			if table == "" {
+				continue
+			}
+			columns[table] = p.columnDefinitions()
+			if _, ok := tables[table]; !ok {
+				tables[table] = []interface{}{}
+			}
+		case p.accept("INSERT"):
+			p.accept("IGNORE")
+			if !p.accept("INTO") {
+				continue
+			}
+			table := p.name()
+			statementColumns := p.columnList()
+			if !p.accept("VALUES") && !p.accept("VALUE") {
+				continue
+			}
+			for {
+				values, ok := p.valueList()
+				if !ok {
+					break
+				}
+				addRow(table, statementColumns, values)
+				if !p.acceptPunct(",") {
+					break
+				}
+			}
+		case p.accept("COPY"):
+			table := p.name()
+			statementColumns := p.columnList()
+			if !(p.accept("FROM") && p.accept("stdin")) {
+				continue
+			}
+			for _, line := range sc.copyData() {
+				addRow(table, statementColumns, copyValues(line))
+			}
+		}
+	}
+}
+
+type sqlParser struct {
+	tokens []sqlToken
+	pos    int
+}
+
+func (p *sqlParser) peek() (sqlToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return sqlToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *sqlParser) accept(word string) bool {
+	if t, ok := p.peek(); ok && t.is(word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) acceptPunct(punct string) bool {
+	if t, ok := p.peek(); ok && t.kind == sqlPunct && t.text == punct {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// name parses a possibly qualified table name, e.g. public.users.
+func (p *sqlParser) name() string {
+	var parts []string
+	for {
+		t, ok := p.peek()
+		if !ok || (t.kind != sqlWord && t.kind != sqlIdentifier) {
+			break
+		}
+		p.pos++
+		parts = append(parts, t.text)
+		if !p.acceptPunct(".") {
+			break
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// columnList parses an optional parenthesized list of column names.
+func (p *sqlParser) columnList() []string {
+	if !p.acceptPunct("(") {
+		return nil
+	}
+	names := []string{}
+	for {
+		t, ok := p.peek()
+		if !ok {
+			return names
+		}
+		p.pos++
+		switch {
+		case t.kind == sqlPunct && t.text == ")":
+			return names
+		case t.kind == sqlWord || t.kind == sqlIdentifier:
+			names = append(names, t.text)
+		}
+	}
+}
+
+// tableConstraints are the words that start the parts of a CREATE TABLE
+// statement that aren't columns.
+var tableConstraints = map[string]bool{
+	"PRIMARY": true, "KEY": true, "UNIQUE": true, "CONSTRAINT": true,
+	"INDEX": true, "FOREIGN": true, "CHECK": true, "FULLTEXT": true,
+	"SPATIAL": true, "EXCLUDE": true, "LIKE": true,
+}
+
+// columnDefinitions parses the column names from the body of a CREATE
+// TABLE statement.
+func (p *sqlParser) columnDefinitions() []string {
+	if !p.acceptPunct("(") {
+		return nil
+	}
+	var names []string
+	depth := 0
+	startOfPart := true
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+		if t.kind == sqlPunct {
+			switch t.text {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					return names
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					startOfPart = true
+					continue
+				}
+			}
+		}
+		if startOfPart && (t.kind == sqlIdentifier || t.kind == sqlWord && !tableConstraints[strings.ToUpper(t.text)]) {
+			names = append(names, t.text)
+		}
+		startOfPart = false
+	}
+	return names
+}
+
+// valueList parses a parenthesized list of values.
+func (p *sqlParser) valueList() ([]interface{}, bool) {
+	if !p.acceptPunct("(") {
+		return nil, false
+	}
+	values := []interface{}{}
+	var current []sqlToken
+	depth := 0
+	for p.pos < len(p.tokens) {
+		t := p.tokens[p.pos]
+		p.pos++
+		if t.kind == sqlPunct {
+			switch {
+			case t.text == "(":
+				depth++
+			case t.text == ")" && depth > 0:
+				depth--
+			case t.text == ")" || t.text == "," && depth == 0:
+				values = append(values, sqlValue(current))
+				current = nil
+				if t.text == ")" {
+					return values, true
+				}
+				continue
+			}
+		}
+		current = append(current, t)
+	}
+	return nil, false
+}
+
+// sqlValue converts the tokens of a value. Casts like '1'::int are left
+// out, and expressions are kept as text.
+func sqlValue(tokens []sqlToken) interface{} {
+	if len(tokens) == 0 {
+		return nil
+	}
+	first := tokens[0]
+	if len(tokens) > 1 && first.kind == sqlWord && strings.HasPrefix(first.text, "_") && tokens[1].kind == sqlString {
+		// A character set introducer, e.g. _binary 'abc'.
+		first = tokens[1]
+		tokens = tokens[1:]
+	}
+	if len(tokens) == 1 || tokens[1].kind == sqlPunct && tokens[1].text == ":" {
+		switch {
+		case first.kind == sqlString:
+			return first.text
+		case first.kind == sqlNumber:
+			return json.Number(first.text)
+		case first.is("NULL"):
+			return nil
+		case first.is("TRUE"):
+			return true
+		case first.is("FALSE"):
+			return false
+		}
+	}
+	texts := make([]string, len(tokens))
+	for i, t := range tokens {
+		texts[i] = t.text
+		if t.kind == sqlString {
+			texts[i] = fmt.Sprintf("'%s'", strings.Replace(t.text, "'", "''", -1))
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+// copyValues splits a row of COPY data: tab separated, with \N for NULL
+// and backslash escapes.
+func copyValues(line string) []interface{} {
+	fields := strings.Split(line, "\t")
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if field == `\N` {
+			continue
+		}
+		var b strings.Builder
+		for j := 0; j < len(field); j++ {
+			if field[j] == '\\' && j+1 < len(field) {
+				j++
+				b.WriteString(unescapeSQL(field[j]))
+				continue
+			}
+			b.WriteByte(field[j])
+		}
+		values[i] = b.String()
+	}
+	return values
+}
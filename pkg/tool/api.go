@@ -0,0 +1,488 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+// The versioned JSON API, for scripts and editor plugins as well as the
+// interface. Every endpoint takes an optional session query param, which
+// defaults to the active session:
+//
+//	GET    /api/v1/requests                     a page of requests, see _listRequests
+//	GET    /api/v1/requests/{id}                a request's full Record
+//	GET    /api/v1/requests/{id}/snapshot-diff  the diff of a request's snapshots
+//	DELETE /api/v1/requests/{id}                deletes a request, see Recorder.DeleteRequest
+//	PUT    /api/v1/requests/{id}/annotation     replaces a request's recorder.Annotation
+//	POST   /api/v1/requests/{id}/replay         sends a request again, see replayRequest
+//
+// Deleting and annotating return the request's new RequestInfo and tell all
+// clients about it. Replaying returns the RequestInfo of the new request,
+// which is recorded in the active session. None of them are allowed in a
+// viewer.
+const apiRequestsPath = "/api/v1/requests"
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// RequestSummary is a request, or a checkpoint, as listed by the API.
+type RequestSummary struct {
+	proxy.RequestInfo
+	// Status is the HTTP status of the response, if it was recorded.
+	Status int `json:"status,omitempty"`
+}
+
+// RequestPage is a page of the requests matching a query. Total is the
+// number of matching requests across all pages.
+type RequestPage struct {
+	Total    int              `json:"total"`
+	Offset   int              `json:"offset"`
+	Limit    int              `json:"limit"`
+	Requests []RequestSummary `json:"requests"`
+}
+
+func (h *Handler) listRequests(w http.ResponseWriter, r *http.Request) {
+	page, err := h._listRequests(r)
+	writeJSON(w, page, err)
+}
+
+// _listRequests returns a page of the requests in a session, in request ID
+// order, or newest first with order=desc. Paging is done with offset and
+// limit. The other query params filter the requests, see parseRequestFilter.
+func (h *Handler) _listRequests(r *http.Request) (*RequestPage, error) {
+	session, err := h.sessionName(r)
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	filter, err := parseRequestFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := intParam(query, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+	limit, err := intParam(query, "limit", defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	descending := false
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return nil, fmt.Errorf("%w, invalid order \"%s\", expected asc or desc", BadRequest, order)
+	}
+
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+	if descending {
+		for i, j := 0, len(requestIDs)-1; i < j; i, j = i+1, j-1 {
+			requestIDs[i], requestIDs[j] = requestIDs[j], requestIDs[i]
+		}
+	}
+
+	page := &RequestPage{
+		Offset:   offset,
+		Limit:    limit,
+		Requests: []RequestSummary{},
+	}
+
+	// Without a filter, only the requests on the page are loaded.
+	if filter.isEmpty() {
+		page.Total = len(requestIDs)
+		for i := offset; i < len(requestIDs) && i < offset+limit; i++ {
+			entry, err := loadRequestEntry(session, rec, requestIDs[i])
+			if err != nil {
+				return nil, err
+			}
+			page.Requests = append(page.Requests, entry.summary())
+		}
+		return page, nil
+	}
+
+	for _, requestID := range requestIDs {
+		entry, err := loadRequestEntry(session, rec, requestID)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := filter.matches(rec, entry)
+		if err != nil {
+			return nil, err
+		}
+		if !matches {
+			continue
+		}
+		if page.Total >= offset && len(page.Requests) < limit {
+			page.Requests = append(page.Requests, entry.summary())
+		}
+		page.Total += 1
+	}
+	return page, nil
+}
+
+// summary returns how a request is listed by the API.
+func (e requestEntry) summary() RequestSummary {
+	summary := RequestSummary{RequestInfo: e.info}
+	if e.meta != nil {
+		summary.Status = e.meta.Status
+	}
+	return summary
+}
+
+// apiRequest serves the endpoints for a single request.
+func (h *Handler) apiRequest(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiRequestsPath+"/")
+	requestIDString, endpoint := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		requestIDString, endpoint = path[:i], path[i+1:]
+	}
+
+	requestID, err := parseRequestID(requestIDString)
+	if err != nil {
+		writeJSON(w, nil, err)
+		return
+	}
+	session, err := h.sessionName(r)
+	if err != nil {
+		writeJSON(w, nil, err)
+		return
+	}
+
+	switch {
+	case endpoint == "" && r.Method == http.MethodDelete:
+		info, err := h.deleteRequest(session, requestID)
+		writeJSON(w, info, err)
+	case endpoint == "":
+		record, err := h.loadRecord(session, requestID)
+		writeJSON(w, record, err)
+	case endpoint == "annotation" && r.Method == http.MethodPut:
+		info, err := h.annotateRequest(r, session, requestID)
+		writeJSON(w, info, err)
+	case endpoint == "replay" && r.Method == http.MethodPost:
+		info, err := h.replayRequest(r, session, requestID)
+		writeJSON(w, info, err)
+	case endpoint == "snapshot-diff":
+		diff, err := h.diffRequestSnapshots(session, requestID)
+		writeJSON(w, diff, err)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// requestEditor returns what the requests in a session are changed with.
+// Recordings are read-only in a viewer, and archives can't be changed.
+func (h *Handler) requestEditor(session string) (recorder.RecorderLoader, recorder.RequestEditor, error) {
+	if h.controller == nil {
+		return nil, nil, fmt.Errorf("%w, the recording is read-only", Conflict)
+	}
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, nil, err
+	}
+	editor, ok := rec.(recorder.RequestEditor)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w, requests in session %s can't be changed", Conflict, session)
+	}
+	return rec, editor, nil
+}
+
+func (h *Handler) deleteRequest(session string, requestID int) (*proxy.RequestInfo, error) {
+	rec, editor, err := h.requestEditor(session)
+	if err != nil {
+		return nil, err
+	}
+	err = editor.DeleteRequest(requestID)
+	switch {
+	case os.IsNotExist(err):
+		return nil, fmt.Errorf("%w, no request %d in session %s", NotFound, requestID, session)
+	case errors.Is(err, recorder.ErrDeleted):
+		return nil, fmt.Errorf("%w, %s", Conflict, err)
+	case err != nil:
+		return nil, err
+	}
+	return h.broadcastRequestInfo(session, rec, requestID)
+}
+
+func (h *Handler) annotateRequest(r *http.Request, session string, requestID int) (*proxy.RequestInfo, error) {
+	rec, editor, err := h.requestEditor(session)
+	if err != nil {
+		return nil, err
+	}
+	var annotation recorder.Annotation
+	err = json.NewDecoder(r.Body).Decode(&annotation)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", BadRequest, err)
+	}
+	err = editor.SaveAnnotation(requestID, annotation)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w, no request %d in session %s", NotFound, requestID, session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.broadcastRequestInfo(session, rec, requestID)
+}
+
+// broadcastRequestInfo tells all clients a request's info after it's been
+// changed, and returns it.
+func (h *Handler) broadcastRequestInfo(session string, rec recorder.RecorderLoader, requestID int) (*proxy.RequestInfo, error) {
+	entry, err := loadRequestEntry(session, rec, requestID)
+	if err != nil {
+		return nil, err
+	}
+	h.broadcast(WebsocketMessage{
+		Type: "record",
+		Data: entry.info,
+	})
+	return &entry.info, nil
+}
+
+// requestFilter selects the requests listed by the API.
+type requestFilter struct {
+	// operationType is "query", "mutation" or "checkpoint".
+	operationType string
+	// name and search are lower case.
+	name   string
+	search string
+	tag    string
+	// minStatus and maxStatus are 0 when not filtering by status.
+	minStatus   int
+	maxStatus   int
+	since       time.Time
+	until       time.Time
+	hasSnapshot *bool
+	starred     *bool
+}
+
+// parseRequestFilter parses the filter query params:
+//
+//	type         query, mutation or checkpoint
+//	name         part of the operation name or checkpoint label
+//	status       a response status, e.g. 200, or a class of them, e.g. 4xx
+//	since, until RFC 3339 times the request was sent, or the checkpoint
+//	             taken, at or after and before
+//	hasSnapshot  true or false
+//	tag          a tag, exactly
+//	starred      true or false
+//	q            text in the request or response body, the checkpoint label
+//	             or the notes and tags
+//
+// Text other than tags is matched case insensitively. Requests recorded
+// without their status or times don't match those filters.
+func parseRequestFilter(query url.Values) (requestFilter, error) {
+	filter := requestFilter{
+		operationType: query.Get("type"),
+		name:          strings.ToLower(query.Get("name")),
+		search:        strings.ToLower(query.Get("q")),
+		tag:           query.Get("tag"),
+	}
+
+	switch filter.operationType {
+	case "", string(proxy.OperationTypeQuery), string(proxy.OperationTypeMutation), "checkpoint":
+	default:
+		return requestFilter{}, fmt.Errorf(
+			"%w, invalid type \"%s\", expected query, mutation or checkpoint",
+			BadRequest,
+			filter.operationType,
+		)
+	}
+
+	if status := query.Get("status"); status != "" {
+		var err error
+		if len(status) == 3 && strings.HasSuffix(status, "xx") {
+			var class int
+			class, err = strconv.Atoi(status[:1])
+			filter.minStatus, filter.maxStatus = class*100, class*100+99
+		} else {
+			filter.minStatus, err = strconv.Atoi(status)
+			filter.maxStatus = filter.minStatus
+		}
+		if err != nil {
+			return requestFilter{}, fmt.Errorf("%w, invalid status \"%s\"", BadRequest, status)
+		}
+	}
+
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{
+		{"since", &filter.since},
+		{"until", &filter.until},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return requestFilter{}, fmt.Errorf("%w, invalid %s time \"%s\"", BadRequest, param.name, value)
+		}
+		*param.t = t
+	}
+
+	for _, param := range []struct {
+		name string
+		b    **bool
+	}{
+		{"hasSnapshot", &filter.hasSnapshot},
+		{"starred", &filter.starred},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return requestFilter{}, fmt.Errorf("%w, invalid %s \"%s\"", BadRequest, param.name, value)
+		}
+		*param.b = &b
+	}
+
+	return filter, nil
+}
+
+// isEmpty reports whether the filter matches every request.
+func (f requestFilter) isEmpty() bool {
+	return f == requestFilter{}
+}
+
+// matches reports whether a request matches the filter. The request and
+// response bodies are only loaded when searching.
+func (f requestFilter) matches(rec recorder.RecorderLoader, entry requestEntry) (bool, error) {
+	info := entry.info
+	isCheckpoint := entry.checkpoint != nil
+
+	switch {
+	case f.operationType == "checkpoint" && !isCheckpoint:
+		return false, nil
+	case f.operationType != "" && f.operationType != "checkpoint" &&
+		(isCheckpoint || string(info.OperationType) != f.operationType):
+		return false, nil
+	}
+
+	name := info.OperationName
+	if isCheckpoint {
+		name = info.Checkpoint
+	}
+	if f.name != "" && !strings.Contains(strings.ToLower(name), f.name) {
+		return false, nil
+	}
+
+	if f.minStatus != 0 {
+		if entry.meta == nil || entry.meta.Status < f.minStatus || entry.meta.Status > f.maxStatus {
+			return false, nil
+		}
+	}
+
+	if !f.since.IsZero() || !f.until.IsZero() {
+		var t time.Time
+		switch {
+		case isCheckpoint:
+			t = entry.checkpoint.TakenAt
+		case entry.meta != nil:
+			t = entry.meta.SentAt
+		}
+		if t.IsZero() ||
+			(!f.since.IsZero() && t.Before(f.since)) ||
+			(!f.until.IsZero() && !t.Before(f.until)) {
+			return false, nil
+		}
+	}
+
+	if f.hasSnapshot != nil && info.ShapshotComplete != *f.hasSnapshot {
+		return false, nil
+	}
+
+	annotation := info.Annotation
+	if annotation == nil {
+		annotation = &recorder.Annotation{}
+	}
+	if f.tag != "" && !annotation.HasTag(f.tag) {
+		return false, nil
+	}
+	if f.starred != nil && annotation.Starred != *f.starred {
+		return false, nil
+	}
+
+	if f.search != "" {
+		if annotationContains(annotation, f.search) {
+			return true, nil
+		}
+		if isCheckpoint {
+			return strings.Contains(strings.ToLower(info.Checkpoint), f.search), nil
+		}
+		return bodiesContain(rec, info.RequestID, f.search)
+	}
+
+	return true, nil
+}
+
+// annotationContains reports whether an annotation's notes or tags contain
+// lower case text, case insensitively.
+func annotationContains(annotation *recorder.Annotation, text string) bool {
+	if strings.Contains(strings.ToLower(annotation.Notes), text) {
+		return true
+	}
+	for _, tag := range annotation.Tags {
+		if strings.Contains(strings.ToLower(tag), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// bodiesContain reports whether a request's request or response body
+// contains lower case text, case insensitively.
+func bodiesContain(rec recorder.RecorderLoader, requestID int, text string) (bool, error) {
+	request, err := rec.GetRequest(requestID)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Contains(bytes.ToLower(request), []byte(text)) {
+		return true, nil
+	}
+	response, err := rec.GetResponse(requestID)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Contains(bytes.ToLower(response), []byte(text)), nil
+}
+
+// intParam parses a non-negative integer query param, which defaults to
+// defaultValue.
+func intParam(query url.Values, name string, defaultValue int) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return defaultValue, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w, invalid %s \"%s\"", BadRequest, name, value)
+	}
+	return n, nil
+}
@@ -0,0 +1,328 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/stretchr/testify/suite"
+)
+
+// countingSessions counts the snapshots its loaders read.
+type countingSessions struct {
+	*recorder.Sessions
+	snapshotsRead int
+}
+
+func (s *countingSessions) Loader(name string) (recorder.RecorderLoader, error) {
+	rec, err := s.Sessions.Loader(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingLoader{RecorderLoader: rec, sessions: s}, nil
+}
+
+type countingLoader struct {
+	recorder.RecorderLoader
+	sessions *countingSessions
+}
+
+func (l *countingLoader) MaybeGetSnapshot(requestID int) ([]byte, error) {
+	l.sessions.snapshotsRead += 1
+	return l.RecorderLoader.MaybeGetSnapshot(requestID)
+}
+
+// testController records into nothing. Replays fail, since there's no
+// proxy.
+type testController struct{}
+
+func (c *testController) NewSession(session recorder.Session) (recorder.Session, error) {
+	return session, nil
+}
+
+func (c *testController) SwitchSession(name string) error {
+	return nil
+}
+
+func (c *testController) CloseSession(name string) error {
+	return nil
+}
+
+func (c *testController) Replay(req *http.Request, replayOf recorder.ReplayOf) (proxy.RequestInfo, error) {
+	return proxy.RequestInfo{}, errors.New("no proxy")
+}
+
+type apiSuite struct {
+	suite.Suite
+	sessions *countingSessions
+	handler  *Handler
+}
+
+var apiStart = time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+
+func (suite *apiSuite) BeforeTest(suiteName, testName string) {
+	rootPath, err := ioutil.TempDir("", "api")
+	suite.Require().NoError(err)
+	suite.sessions = &countingSessions{Sessions: &recorder.Sessions{RootPath: rootPath}}
+	_, err = suite.sessions.Create(recorder.Session{Name: "signup-flow"})
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.sessions.SetActive("signup-flow"))
+	rec, err := suite.sessions.Recorder("signup-flow")
+	suite.Require().NoError(err)
+
+	suite.saveRequest(rec, 1, "query", "getUser", `{"user": null}`, 200, true)
+	suite.Require().NoError(rec.SaveAnnotation(1, recorder.Annotation{
+		Notes:   "The total is wrong",
+		Tags:    []string{"bug"},
+		Starred: true,
+	}))
+	suite.saveRequest(rec, 2, "mutation", "saveUser", `{"error": "kaid_123 not found"}`, 500, true)
+	suite.Require().NoError(rec.SaveCheckpoint(3, recorder.Checkpoint{
+		Label:   "cron ran",
+		TakenAt: apiStart.Add(2 * time.Minute),
+	}))
+	suite.Require().NoError(rec.SaveSnapshot(3, []byte(`{}`)))
+	suite.saveRequest(rec, 4, "query", "getUser", `{}`, 404, false)
+
+	suite.handler = NewHandlerAndStartWebsocketWorker(suite.sessions, nil, nil)
+}
+
+func (suite *apiSuite) AfterTest(suiteName, testName string) {
+	os.RemoveAll(suite.sessions.RootPath)
+}
+
+// saveRequest saves a request sent requestID minutes after apiStart.
+func (suite *apiSuite) saveRequest(
+	rec *recorder.Recorder,
+	requestID int,
+	operationType string,
+	operationName string,
+	response string,
+	status int,
+	snapshot bool,
+) {
+	request, err := json.Marshal(map[string]string{
+		"operationName": operationName,
+		"query":         operationType + " " + operationName + " { field }",
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(rec.SaveRequest(requestID, request))
+	suite.Require().NoError(rec.SaveResponse(requestID, []byte(response)))
+	sentAt := apiStart.Add(time.Duration(requestID-1) * time.Minute)
+	suite.Require().NoError(rec.SaveMeta(requestID, recorder.RequestMeta{
+		Method:      "POST",
+		URL:         "http://localhost:8109/api/internal/graphql/" + operationName,
+		Status:      status,
+		SentAt:      sentAt,
+		CompletedAt: sentAt.Add(time.Second),
+	}))
+	if snapshot {
+		suite.Require().NoError(rec.SaveSnapshot(requestID, []byte(`{}`)))
+	}
+}
+
+// list gets a page of requests, returning the status and the IDs listed.
+func (suite *apiSuite) list(query string) (int, RequestPage, []int) {
+	w := httptest.NewRecorder()
+	suite.handler.ServeHTTP(w, httptest.NewRequest("GET", apiRequestsPath+"?"+query, nil))
+	var page RequestPage
+	if w.Code != http.StatusOK {
+		return w.Code, page, nil
+	}
+	suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &page))
+	requestIDs := []int{}
+	for _, request := range page.Requests {
+		requestIDs = append(requestIDs, request.RequestID)
+	}
+	return w.Code, page, requestIDs
+}
+
+func (suite *apiSuite) TestPaging() {
+	_, page, requestIDs := suite.list("")
+	suite.Equal(4, page.Total)
+	suite.Equal(defaultPageLimit, page.Limit)
+	suite.Equal([]int{1, 2, 3, 4}, requestIDs)
+	suite.Equal(500, page.Requests[1].Status)
+	suite.True(page.Requests[0].ShapshotComplete)
+	suite.False(page.Requests[3].ShapshotComplete)
+
+	_, page, requestIDs = suite.list("offset=1&limit=2")
+	suite.Equal(4, page.Total)
+	suite.Equal([]int{2, 3}, requestIDs)
+
+	_, _, requestIDs = suite.list("order=desc&limit=3")
+	suite.Equal([]int{4, 3, 2}, requestIDs)
+
+	_, page, requestIDs = suite.list("offset=10")
+	suite.Equal(4, page.Total)
+	suite.Equal([]int{}, requestIDs)
+
+	_, page, _ = suite.list("limit=5000")
+	suite.Equal(maxPageLimit, page.Limit)
+
+	_, page, requestIDs = suite.list("type=query&limit=1&offset=1")
+	suite.Equal(2, page.Total)
+	suite.Equal([]int{4}, requestIDs)
+}
+
+func (suite *apiSuite) TestFilters() {
+	for query, expected := range map[string][]int{
+		"type=query":                          {1, 4},
+		"type=mutation":                       {2},
+		"type=checkpoint":                     {3},
+		"name=GETUSER":                        {1, 4},
+		"name=cron":                           {3},
+		"status=500":                          {2},
+		"status=4xx":                          {4},
+		"since=2020-08-01T12:01:00Z":          {2, 3, 4},
+		"until=2020-08-01T12:01:00Z":          {1},
+		"hasSnapshot=false":                   {4},
+		"tag=bug":                             {1},
+		"tag=BUG":                             {},
+		"starred=true":                        {1},
+		"q=KAID_123":                          {2},
+		"q=total":                             {1},
+		"q=cron":                              {3},
+		"type=query&status=2xx&hasSnapshot=1": {1},
+	} {
+		status, page, requestIDs := suite.list(query)
+		suite.Equal(http.StatusOK, status, query)
+		suite.Equal(expected, requestIDs, query)
+		suite.Equal(len(expected), page.Total, query)
+	}
+}
+
+func (suite *apiSuite) TestInvalidParams() {
+	for _, query := range []string{
+		"offset=-1",
+		"limit=ten",
+		"order=newest",
+		"type=subscription",
+		"status=2x",
+		"status=ok",
+		"since=yesterday",
+		"until=2020-08-01",
+		"hasSnapshot=maybe",
+		"starred=yes",
+	} {
+		status, _, _ := suite.list(query)
+		suite.Equal(http.StatusBadRequest, status, query)
+	}
+
+	status, _, _ := suite.list("session=nope")
+	suite.Equal(http.StatusNotFound, status)
+}
+
+func (suite *apiSuite) TestDeletedRequests() {
+	rec, err := suite.sessions.Recorder("signup-flow")
+	suite.Require().NoError(err)
+	suite.Require().NoError(rec.DeleteRequest(2))
+	suite.Require().NoError(rec.DeleteRequest(4))
+
+	_, page, requestIDs := suite.list("type=checkpoint")
+	suite.Equal([]int{2, 3, 4}, requestIDs)
+	suite.True(page.Requests[0].Deleted)
+	suite.True(page.Requests[0].WillSnapshot)
+	suite.True(page.Requests[0].ShapshotComplete)
+	// Request 4 had no snapshot, which isn't a failed snapshot.
+	suite.True(page.Requests[2].Deleted)
+	suite.False(page.Requests[2].WillSnapshot)
+	suite.False(page.Requests[2].ShapshotComplete)
+}
+
+func (suite *apiSuite) TestListingDoesntReadSnapshots() {
+	suite.list("")
+	suite.list("type=query&hasSnapshot=true&q=user")
+	suite.Equal(0, suite.sessions.snapshotsRead)
+}
+
+// compare gets the comparison of two snapshots, returning the status.
+func (suite *apiSuite) compare(query string) (int, SnapshotComparison) {
+	w := httptest.NewRecorder()
+	suite.handler.ServeHTTP(w, httptest.NewRequest("GET", "/snapshot-compare?"+query, nil))
+	var comparison SnapshotComparison
+	if w.Code == http.StatusOK {
+		suite.Require().NoError(json.Unmarshal(w.Body.Bytes(), &comparison))
+	}
+	return w.Code, comparison
+}
+
+func (suite *apiSuite) TestCompareSnapshots() {
+	_, err := suite.sessions.Create(recorder.Session{Name: "login-flow"})
+	suite.Require().NoError(err)
+	rec, err := suite.sessions.Recorder("login-flow")
+	suite.Require().NoError(err)
+	suite.Require().NoError(rec.SaveSnapshot(0, []byte(`{"users": []}`)))
+	suite.saveRequest(rec, 1, "mutation", "createUser", `{}`, 200, false)
+	suite.Require().NoError(rec.SaveSnapshot(1, []byte(`{"users": [{"id": 1}]}`)))
+	suite.saveRequest(rec, 2, "query", "getUser", `{}`, 200, false)
+
+	status, comparison := suite.compare("session=login-flow&from=0&to=2")
+	suite.Require().Equal(http.StatusOK, status)
+	suite.Equal("login-flow", comparison.FromSession)
+	suite.Equal("login-flow", comparison.ToSession)
+	suite.Equal(0, comparison.FromRequestID)
+	suite.Equal(2, comparison.ToRequestID)
+	suite.JSONEq(`{"users": []}`, comparison.FromSnapshot)
+	suite.JSONEq(`{"users": [{"id": 1}]}`, comparison.ToSnapshot, "requests without a snapshot use the one before them")
+	suite.False(comparison.Diff.Empty())
+
+	// The from session defaults to the active session.
+	status, comparison = suite.compare("from=1&toSession=login-flow&to=0")
+	suite.Require().Equal(http.StatusOK, status)
+	suite.Equal("signup-flow", comparison.FromSession)
+	suite.JSONEq(`{}`, comparison.FromSnapshot)
+	suite.JSONEq(`{"users": []}`, comparison.ToSnapshot)
+}
+
+func (suite *apiSuite) TestCompareSnapshotsNotFound() {
+	for _, query := range []string{
+		"session=nope&from=1&to=2",
+		"fromSession=nope&from=1&to=2",
+		"toSession=nope&from=1&to=2",
+		"toSession=../signup-flow&from=1&to=2",
+		"from=1&to=99",
+		"from=99&to=1",
+		// signup-flow has no initial snapshot.
+		"from=0&to=1",
+	} {
+		status, _ := suite.compare(query)
+		suite.Equal(http.StatusNotFound, status, query)
+	}
+	for _, query := range []string{"from=1", "from=1&to=two", "from=-1&to=1"} {
+		status, _ := suite.compare(query)
+		suite.Equal(http.StatusBadRequest, status, query)
+	}
+}
+
+func (suite *apiSuite) TestMissingRequestsAreNotFound() {
+	suite.handler = NewHandlerAndStartWebsocketWorker(suite.sessions.Sessions, &testController{}, nil)
+
+	for _, query := range []string{"session=signup-flow", "session=nope", "session=../signup-flow"} {
+		path := apiRequestsPath + "/99"
+		for _, req := range []*http.Request{
+			httptest.NewRequest("GET", path+"?"+query, nil),
+			httptest.NewRequest("DELETE", path+"?"+query, nil),
+			httptest.NewRequest("PUT", path+"/annotation?"+query, strings.NewReader(`{"notes": "missing"}`)),
+			httptest.NewRequest("POST", path+"/replay?"+query, nil),
+			httptest.NewRequest("GET", path+"/snapshot-diff?"+query, nil),
+			httptest.NewRequest("GET", "/request?id=99&"+query, nil),
+		} {
+			w := httptest.NewRecorder()
+			suite.handler.ServeHTTP(w, req)
+			suite.Equal(http.StatusNotFound, w.Code, "%s %s: %s", req.Method, req.URL, w.Body.String())
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	suite.Run(t, new(apiSuite))
+}
@@ -0,0 +1,43 @@
+package tool
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+)
+
+// Checkpointer takes snapshots on demand. SessionControllers that also
+// implement it can take checkpoints from the tool.
+type Checkpointer interface {
+	// Checkpoint takes a snapshot and records it as a labeled checkpoint
+	// after the requests recorded so far.
+	Checkpoint(label string) (proxy.RequestInfo, error)
+}
+
+// defaultCheckpointLabel labels checkpoints taken without a label.
+const defaultCheckpointLabel = "manual snapshot"
+
+func (h *Handler) takeCheckpoint(w http.ResponseWriter, r *http.Request) {
+	info, err := h._takeCheckpoint(r)
+	writeJSON(w, info, err)
+}
+
+func (h *Handler) _takeCheckpoint(r *http.Request) (*proxy.RequestInfo, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("%w, expected POST", BadRequest)
+	}
+	checkpointer, ok := h.controller.(Checkpointer)
+	if !ok {
+		return nil, fmt.Errorf("%w, snapshots can't be taken from a viewer", Conflict)
+	}
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		label = defaultCheckpointLabel
+	}
+	info, err := checkpointer.Checkpoint(label)
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
@@ -1,9 +1,15 @@
 // Package tool contains the various handlers needed to support the proxy
 // recorder frontend interface. The tool interface is powered by web sockets
-// and a single REST API. When a request is recorded by the proxy, RequestInfo
-// is sent to the tool over a channel. The tool forwards this info to the
-// interface via a web socket. When the user wants to load the full data for a
-// request, the interface makes a REST call to retrieve a full request Record.
+// and a REST API. When a request is recorded by the proxy, RequestInfo is
+// sent to the tool over a channel. The tool forwards this info to the
+// interface via a web socket. The interface lists the requests recorded so
+// far a page at a time, and loads the full Record for a request, through the
+// versioned JSON API under /api/v1, which scripts can use too.
+// Requests are grouped into sessions; the tool can browse any session and,
+// given a SessionController, create, switch and close them. Given a
+// RecordingController, clients can also pause and filter recording by
+// sending commands over the web socket, and given a Checkpointer, take
+// snapshots on demand.
 package tool
 
 import (
@@ -12,11 +18,15 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"sync"
 
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
 	"github.com/dnerdy/proxyrecorder/pkg/proxy"
 	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
 	"github.com/gorilla/websocket"
 )
 
@@ -28,14 +38,33 @@ type Record struct {
 	Response        string              `json:"response"`
 	CurrentSnapshot string              `json:"currentSnapshot"`
 	PriorSnapshot   string              `json:"priorSnapshot"`
+	// SnapshotFormat is the content type the snapshots were recorded in.
+	// If SnapshotDecoded is true, the snapshots have been decoded from it
+	// into JSON, otherwise they're as recorded.
+	SnapshotFormat  string `json:"snapshotFormat"`
+	SnapshotDecoded bool   `json:"snapshotDecoded"`
+	// Checkpoint is set when the record is a checkpoint rather than a
+	// request. Checkpoints don't have a request or a response.
+	Checkpoint *recorder.Checkpoint `json:"checkpoint,omitempty"`
+	Annotation *recorder.Annotation `json:"annotation,omitempty"`
+	// Meta is the request's method, URL, headers, status and times, if they
+	// were recorded.
+	Meta *recorder.RequestMeta `json:"meta,omitempty"`
 }
 
 type Handler struct {
-	recorder    *recorder.Recorder
+	sessions    recorder.SessionLoader
+	controller  SessionController
 	mux         http.Handler
 	connections map[*websocket.Conn]struct{}
 	connMu      sync.Mutex
 	upgrader    websocket.Upgrader
+	// snapshotDiff configures the diffs served by /snapshot-diff.
+	snapshotDiff snapshotdiff.Options
+	// normalizer, if set, normalizes snapshots before they're shown.
+	normalizer *normalize.Normalizer
+	// formats are the registries sessions' snapshots are decoded with.
+	formats snapshotformat.Registries
 }
 
 type WebsocketMessage struct {
@@ -45,14 +74,19 @@ type WebsocketMessage struct {
 
 // NewHandlerAndStartWebsocketWorker creates a handler with all of the tool
 // routes. It also creates a goroutine that forwards RequestInfo to all
-// connected web socket clients.
+// connected web socket clients. If controller is nil, sessions can be
+// browsed but not changed. requestInfoChan may be nil when nothing is being
+// recorded.
 func NewHandlerAndStartWebsocketWorker(
-	rec *recorder.Recorder,
+	sessions recorder.SessionLoader,
+	controller SessionController,
 	requestInfoChan chan proxy.RequestInfo,
 ) *Handler {
 	h := &Handler{
-		recorder:    rec,
-		connections: make(map[*websocket.Conn]struct{}),
+		sessions:     sessions,
+		controller:   controller,
+		connections:  make(map[*websocket.Conn]struct{}),
+		snapshotDiff: snapshotdiff.DefaultOptions(),
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
@@ -61,28 +95,35 @@ func NewHandlerAndStartWebsocketWorker(
 	h.mux = buildRoutes(h)
 
 	// There's no way to stop this go routine at the moment, but that's okay
-	// since we only create one handler.
-	go func() {
-		for v := range requestInfoChan {
-			message := WebsocketMessage{
-				Type: "record",
-				Data: v,
+	// since we only create one handler. Viewers don't record, so they don't
+	// have a channel.
+	if requestInfoChan != nil {
+		go func() {
+			for v := range requestInfoChan {
+				h.broadcast(WebsocketMessage{
+					Type: "record",
+					Data: v,
+				})
 			}
+		}()
+	}
 
-			data, _ := json.Marshal(message)
+	return h
+}
 
-			for conn := range h.connections {
-				err := conn.WriteMessage(websocket.TextMessage, data)
-				if err != nil {
-					h.connMu.Lock()
-					delete(h.connections, conn)
-					h.connMu.Unlock()
-				}
-			}
-		}
-	}()
+// broadcast sends a message to all connected web socket clients.
+func (h *Handler) broadcast(message WebsocketMessage) {
+	data, _ := json.Marshal(message)
 
-	return h
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+
+	for conn := range h.connections {
+		err := conn.WriteMessage(websocket.TextMessage, data)
+		if err != nil {
+			delete(h.connections, conn)
+		}
+	}
 }
 
 func buildRoutes(h *Handler) http.Handler {
@@ -91,6 +132,17 @@ func buildRoutes(h *Handler) http.Handler {
 
 	mux.HandleFunc("/", serveIndex)
 	mux.HandleFunc("/request", h.getRequestRecord)
+	mux.HandleFunc("/records", h.getSessionRecords)
+	mux.HandleFunc("/snapshot-diff", h.getSnapshotDiff)
+	mux.HandleFunc("/snapshot-compare", h.compareSnapshots)
+	mux.HandleFunc("/recording-diff", h.getRecordingDiff)
+	mux.HandleFunc("/sessions", h.getSessions)
+	mux.HandleFunc("/sessions/new", h.newSession)
+	mux.HandleFunc("/sessions/switch", h.switchSession)
+	mux.HandleFunc("/sessions/close", h.closeSession)
+	mux.HandleFunc("/checkpoint", h.takeCheckpoint)
+	mux.HandleFunc(apiRequestsPath, h.listRequests)
+	mux.HandleFunc(apiRequestsPath+"/", h.apiRequest)
 	mux.HandleFunc("/ws", h.websocketHandler)
 
 	return mux
@@ -104,13 +156,8 @@ var BadRequest = fmt.Errorf("bad request")
 
 func (h *Handler) getRequestRecord(w http.ResponseWriter, r *http.Request) {
 	record, err := h._getRequestRecord(r)
-
-	switch {
-	case errors.Is(err, BadRequest):
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	case err != nil:
-		w.WriteHeader(http.StatusInternalServerError)
+	if err != nil {
+		w.WriteHeader(errorStatus(err))
 		return
 	}
 
@@ -120,45 +167,140 @@ func (h *Handler) getRequestRecord(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) _getRequestRecord(r *http.Request) (*Record, error) {
-	requestIDString := r.URL.Query().Get("id")
+	requestID, err := requestIDParam(r)
+	if err != nil {
+		return nil, err
+	}
 
-	if requestIDString == "" {
-		return nil, fmt.Errorf("%w, no id query param", BadRequest)
+	session, err := h.sessionName(r)
+	if err != nil {
+		return nil, err
 	}
+	return h.loadRecord(session, requestID)
+}
 
-	requestID, err := strconv.Atoi(requestIDString)
+// loadRecord loads the full record for a request in a session.
+func (h *Handler) loadRecord(session string, requestID int) (*Record, error) {
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
 
+	checkpoint, err := rec.MaybeGetCheckpoint(requestID)
 	if err != nil {
-		return nil, fmt.Errorf("%w, invalid request id \"%s\"", BadRequest, requestIDString)
+		return nil, err
 	}
 
-	if requestID == 0 {
-		return nil, fmt.Errorf("%w, invalid request id %d", BadRequest, requestID)
+	var request, response []byte
+	var graphQLRequest proxy.GraphQLRequest
+	var meta *recorder.RequestMeta
+	if checkpoint == nil {
+		request, err = rec.GetRequest(requestID)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w, no request %d in session %s", NotFound, requestID, session)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		graphQLRequest, err = proxy.ParseRequest(request)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err = rec.GetResponse(requestID)
+		if err != nil {
+			return nil, err
+		}
+
+		meta, err = rec.MaybeGetMeta(requestID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	request, err := h.recorder.GetRequest(requestID)
+	annotation, err := rec.MaybeGetAnnotation(requestID)
 	if err != nil {
 		return nil, err
 	}
 
-	graphQLRequest, err := proxy.ParseRequest(request)
+	format := h.snapshotFormat(session)
+	snapshot, priorSnapshot, err := h.rawRequestSnapshots(rec, requestID)
 	if err != nil {
 		return nil, err
 	}
+	if format == "" {
+		format = snapshotformat.Detect(snapshot)
+	}
+	readable, decoded := h.readableSnapshot(session, format, snapshot)
+	readablePrior, _ := h.readableSnapshot(session, format, priorSnapshot)
+
+	return &Record{
+		RequestID:       requestID,
+		OperationType:   graphQLRequest.OperationType,
+		OperationName:   graphQLRequest.OperationName,
+		Request:         string(request),
+		Response:        string(response),
+		CurrentSnapshot: string(readable),
+		PriorSnapshot:   string(readablePrior),
+		SnapshotFormat:  format,
+		SnapshotDecoded: decoded,
+		Checkpoint:      checkpoint,
+		Annotation:      annotation,
+		Meta:            meta,
+	}, nil
+}
+
+func requestIDParam(r *http.Request) (int, error) {
+	requestIDString := r.URL.Query().Get("id")
+
+	if requestIDString == "" {
+		return 0, fmt.Errorf("%w, no id query param", BadRequest)
+	}
+
+	return parseRequestID(requestIDString)
+}
+
+// parseRequestID parses a request ID from a query param or a path.
+func parseRequestID(requestIDString string) (int, error) {
+	requestID, err := strconv.Atoi(requestIDString)
 
-	response, err := h.recorder.GetResponse(requestID)
 	if err != nil {
-		return nil, err
+		return 0, fmt.Errorf("%w, invalid request id \"%s\"", BadRequest, requestIDString)
+	}
+
+	if requestID == 0 {
+		return 0, fmt.Errorf("%w, invalid request id %d", BadRequest, requestID)
 	}
 
-	snapshot, err := h.recorder.MaybeGetSnapshot(requestID)
+	return requestID, nil
+}
+
+// requestSnapshots returns the current and prior snapshots shown for a
+// request, readable. Either may be nil.
+func (h *Handler) requestSnapshots(session string, rec recorder.RecorderLoader, requestID int) ([]byte, []byte, error) {
+	snapshot, priorSnapshot, err := h.rawRequestSnapshots(rec, requestID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	format := h.snapshotFormat(session)
+	readable, _ := h.readableSnapshot(session, format, snapshot)
+	readablePrior, _ := h.readableSnapshot(session, format, priorSnapshot)
+	return readable, readablePrior, nil
+}
 
-	priorSnapshot, err := h.recorder.GetPriorSnapshot(requestID)
+// rawRequestSnapshots returns the current and prior snapshots shown for a
+// request, as recorded.
+func (h *Handler) rawRequestSnapshots(rec recorder.RecorderLoader, requestID int) ([]byte, []byte, error) {
+	snapshot, err := rec.MaybeGetSnapshot(requestID)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	// Imported recordings don't have any snapshots.
+	priorSnapshot, err := rec.GetPriorSnapshot(requestID)
+	if err != nil && !errors.Is(err, recorder.ErrNoPriorSnapshot) {
+		return nil, nil, err
 	}
 
 	// Requests that don't have their own snapshots use the snapshot from the
@@ -168,15 +310,28 @@ func (h *Handler) _getRequestRecord(r *http.Request) (*Record, error) {
 		priorSnapshot = nil
 	}
 
-	return &Record{
-		RequestID:       requestID,
-		OperationType:   graphQLRequest.OperationType,
-		OperationName:   graphQLRequest.OperationName,
-		Request:         string(request),
-		Response:        string(response),
-		CurrentSnapshot: string(snapshot),
-		PriorSnapshot:   string(priorSnapshot),
-	}, nil
+	return snapshot, priorSnapshot, nil
+}
+
+// snapshotFormat returns the content type of a session's snapshots, or ""
+// if it isn't known.
+func (h *Handler) snapshotFormat(session string) string {
+	s, err := h.sessions.Get(session)
+	if err != nil {
+		return ""
+	}
+	return s.SnapshotType
+}
+
+// readableSnapshot returns a snapshot of a session as it's shown and
+// diffed: decoded to JSON from its format and normalized, and true.
+// Snapshots that can't be decoded are returned as recorded, and false.
+func (h *Handler) readableSnapshot(session string, format string, snapshot []byte) ([]byte, bool) {
+	decoded, ok := h.formats.For(session).Readable(format, snapshot)
+	if !ok {
+		return snapshot, false
+	}
+	return h.normalizer.ApplyJSON(decoded), true
 }
 
 func (h *Handler) websocketHandler(w http.ResponseWriter, r *http.Request) {
@@ -190,19 +345,22 @@ func (h *Handler) websocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	records, err := _getAllRequestInfo(h.recorder)
+	sessions, err := h._getSessions()
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// When a web socket connection is first established we send info for all
-	// existing requests to the client. This is the only time this go routine
-	// writes to the connection. After sending this message, only the goroutine
-	// that services the requestInfoChan writes to the connection.
+	// When a web socket connection is first established we send the sessions
+	// and the recording status to the client. This is the only time this go
+	// routine writes to the connection without holding connMu. After sending
+	// this message, only broadcast and send write to the connection.
 	message := WebsocketMessage{
 		Type: "init",
-		Data: records,
+		Data: initMessageData{
+			Sessions: sessions,
+			Status:   h.recordingStatus(),
+		},
 	}
 	data, _ := json.Marshal(message)
 
@@ -217,10 +375,9 @@ func (h *Handler) websocketHandler(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Open conn")
 	for {
-		// The only time read message should return is when the client closes
-		// the connection. Currently there aren't any supported client to
-		// server messages.
-		_, _, err := conn.ReadMessage()
+		// Clients send commands, e.g. to pause recording. Read message
+		// returns an error when the client closes the connection.
+		_, content, err := conn.ReadMessage()
 		if err != nil {
 			fmt.Println("Close conn")
 			h.connMu.Lock()
@@ -228,10 +385,42 @@ func (h *Handler) websocketHandler(w http.ResponseWriter, r *http.Request) {
 			h.connMu.Unlock()
 			return
 		}
+		err = h.handleCommand(content)
+		if err != nil {
+			h.send(conn, WebsocketMessage{
+				Type: "error",
+				Data: err.Error(),
+			})
+		}
+	}
+}
+
+// send sends a message to one web socket client. Like broadcast, it holds
+// connMu, since connections don't support concurrent writers.
+func (h *Handler) send(conn *websocket.Conn, message WebsocketMessage) {
+	data, _ := json.Marshal(message)
+
+	h.connMu.Lock()
+	defer h.connMu.Unlock()
+
+	if _, ok := h.connections[conn]; !ok {
+		return
+	}
+	err := conn.WriteMessage(websocket.TextMessage, data)
+	if err != nil {
+		delete(h.connections, conn)
 	}
 }
 
-func _getAllRequestInfo(rec *recorder.Recorder) ([]proxy.RequestInfo, error) {
+// initMessageData doesn't include the requests recorded so far; clients
+// page through them with the API.
+type initMessageData struct {
+	Sessions *SessionList `json:"sessions"`
+	// Status is nil if recording can't be controlled.
+	Status *RecordingStatus `json:"status"`
+}
+
+func _getAllRequestInfo(session string, rec recorder.RecorderLoader) ([]proxy.RequestInfo, error) {
 	var records []proxy.RequestInfo
 
 	requestIDs, err := rec.GetAllRequestIDs()
@@ -240,31 +429,88 @@ func _getAllRequestInfo(rec *recorder.Recorder) ([]proxy.RequestInfo, error) {
 	}
 
 	for _, requestID := range requestIDs {
-		request, err := rec.GetRequest(requestID)
+		entry, err := loadRequestEntry(session, rec, requestID)
 		if err != nil {
 			return nil, err
 		}
+		records = append(records, entry.info)
+	}
 
-		graphQLRequest, err := proxy.ParseRequest(request)
-		if err != nil {
-			return nil, err
-		}
+	return records, nil
+}
 
-		snapshot, err := rec.MaybeGetSnapshot(requestID)
-		if err != nil {
-			return nil, err
-		}
+// requestEntry is what's known about a request, or a checkpoint, without
+// loading its response.
+type requestEntry struct {
+	info       proxy.RequestInfo
+	meta       *recorder.RequestMeta
+	checkpoint *recorder.Checkpoint
+}
 
-		records = append(records, proxy.RequestInfo{
-			RequestID:        requestID,
-			OperationType:    graphQLRequest.OperationType,
-			OperationName:    graphQLRequest.OperationName,
-			WillSnapshot:     snapshot != nil,
-			ShapshotComplete: snapshot != nil,
-		})
+// loadRequestEntry loads what's listed for a request. Snapshots can be
+// large, so it only checks whether the request has one.
+func loadRequestEntry(session string, rec recorder.RecorderLoader, requestID int) (requestEntry, error) {
+	hasSnapshot, err := rec.HasFile(requestID, "snapshot.txt")
+	if err != nil {
+		return requestEntry{}, err
 	}
 
-	return records, nil
+	checkpoint, err := rec.MaybeGetCheckpoint(requestID)
+	if err != nil {
+		return requestEntry{}, err
+	}
+	annotation, err := rec.MaybeGetAnnotation(requestID)
+	if err != nil {
+		return requestEntry{}, err
+	}
+	if checkpoint != nil {
+		return requestEntry{
+			info: proxy.RequestInfo{
+				Session:   session,
+				RequestID: requestID,
+				// A checkpoint without a snapshot failed to take one,
+				// unless it's a deleted request that never had one.
+				WillSnapshot:     !checkpoint.Deleted || hasSnapshot,
+				ShapshotComplete: hasSnapshot,
+				Checkpoint:       checkpoint.Label,
+				Drift:            checkpoint.Drift,
+				Deleted:          checkpoint.Deleted,
+				Annotation:       annotation,
+			},
+			checkpoint: checkpoint,
+		}, nil
+	}
+
+	request, err := rec.GetRequest(requestID)
+	if err != nil {
+		return requestEntry{}, err
+	}
+
+	graphQLRequest, err := proxy.ParseRequest(request)
+	if err != nil {
+		return requestEntry{}, err
+	}
+
+	meta, err := rec.MaybeGetMeta(requestID)
+	if err != nil {
+		return requestEntry{}, err
+	}
+
+	info := proxy.RequestInfo{
+		Session:          session,
+		RequestID:        requestID,
+		OperationType:    graphQLRequest.OperationType,
+		OperationName:    graphQLRequest.OperationName,
+		WillSnapshot:     hasSnapshot,
+		ShapshotComplete: hasSnapshot,
+		Annotation:       annotation,
+	}
+	if meta != nil {
+		info.SentAt = &meta.SentAt
+		info.CompletedAt = &meta.CompletedAt
+		info.ReplayOf = meta.ReplayOf
+	}
+	return requestEntry{info: info, meta: meta}, nil
 }
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,119 @@
+package tool
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RecordingController pauses and filters recording while the proxy runs.
+// SessionControllers that also implement it can be controlled from the
+// tool.
+type RecordingController interface {
+	RecordingStatus() RecordingStatus
+	// SetRecording pauses or resumes recording. Requests are still proxied
+	// while recording is paused.
+	SetRecording(recording bool) error
+	// SetSnapshotting turns snapshots on or off. Requests are still
+	// recorded while snapshots are off.
+	SetSnapshotting(snapshotting bool) error
+	// SetRuleSet switches to another set of rules for selecting requests.
+	SetRuleSet(name string) error
+}
+
+type RecordingStatus struct {
+	Recording    bool `json:"recording"`
+	Snapshotting bool `json:"snapshotting"`
+	// RuleSet is the name of the rules requests are selected with, one of
+	// RuleSets.
+	RuleSet  string   `json:"ruleSet"`
+	RuleSets []string `json:"ruleSets"`
+	// ConfigError is why the config file couldn't be reloaded, if it
+	// couldn't.
+	ConfigError string `json:"configError,omitempty"`
+}
+
+// The commands clients can send over the web socket. Data is a bool for
+// "snapshotting" and the rule set name for "ruleSet".
+const (
+	commandPause        = "pause"
+	commandResume       = "resume"
+	commandSnapshotting = "snapshotting"
+	commandRuleSet      = "ruleSet"
+)
+
+// commandMessage is a message from a client.
+type commandMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// recordingController returns the controller, or nil if recording can't be
+// controlled, e.g. in a viewer.
+func (h *Handler) recordingController() RecordingController {
+	controller, _ := h.controller.(RecordingController)
+	return controller
+}
+
+// recordingStatus returns the recording status, or nil if recording can't be
+// controlled.
+func (h *Handler) recordingStatus() *RecordingStatus {
+	controller := h.recordingController()
+	if controller == nil {
+		return nil
+	}
+	status := controller.RecordingStatus()
+	return &status
+}
+
+// handleCommand applies a command from a client and tells all clients the
+// new status.
+func (h *Handler) handleCommand(content []byte) error {
+	var command commandMessage
+	if err := json.Unmarshal(content, &command); err != nil {
+		return fmt.Errorf("%w, %s", BadRequest, err)
+	}
+	controller := h.recordingController()
+	if controller == nil {
+		return fmt.Errorf("%w, recording can't be controlled from a viewer", Conflict)
+	}
+
+	var err error
+	switch command.Type {
+	case commandPause:
+		err = controller.SetRecording(false)
+	case commandResume:
+		err = controller.SetRecording(true)
+	case commandSnapshotting:
+		var snapshotting bool
+		if err := json.Unmarshal(command.Data, &snapshotting); err != nil {
+			return fmt.Errorf("%w, %s expects true or false", BadRequest, command.Type)
+		}
+		err = controller.SetSnapshotting(snapshotting)
+	case commandRuleSet:
+		var name string
+		if err := json.Unmarshal(command.Data, &name); err != nil {
+			return fmt.Errorf("%w, %s expects a rule set name", BadRequest, command.Type)
+		}
+		err = controller.SetRuleSet(name)
+	default:
+		return fmt.Errorf("%w, unknown command \"%s\"", BadRequest, command.Type)
+	}
+	if err != nil {
+		return err
+	}
+	h.BroadcastRecordingStatus()
+	return nil
+}
+
+// BroadcastRecordingStatus tells all clients the recording status, e.g.
+// after it's changed by something other than a client.
+func (h *Handler) BroadcastRecordingStatus() {
+	status := h.recordingStatus()
+	if status == nil {
+		return
+	}
+	h.broadcast(WebsocketMessage{
+		Type: "status",
+		Data: status,
+	})
+}
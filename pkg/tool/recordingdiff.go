@@ -0,0 +1,48 @@
+package tool
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/dnerdy/proxyrecorder/pkg/recordingdiff"
+)
+
+func (h *Handler) getRecordingDiff(w http.ResponseWriter, r *http.Request) {
+	diff, err := h._getRecordingDiff(r)
+	writeJSON(w, diff, err)
+}
+
+// _getRecordingDiff compares the sessions named by the a and b query
+// params.
+func (h *Handler) _getRecordingDiff(r *http.Request) (*recordingdiff.Diff, error) {
+	query := r.URL.Query()
+	if query.Get("a") == "" || query.Get("b") == "" {
+		return nil, fmt.Errorf("%w, expected a and b query params", BadRequest)
+	}
+	a, err := h.sessions.Loader(query.Get("a"))
+	if err != nil {
+		return nil, err
+	}
+	b, err := h.sessions.Loader(query.Get("b"))
+	if err != nil {
+		return nil, err
+	}
+	return recordingdiff.Compare(a, b, recordingdiff.Options{
+		DecodeSnapshot:  h.snapshotDecoder(query.Get("a")),
+		DecodeSnapshotB: h.snapshotDecoder(query.Get("b")),
+	})
+}
+
+// snapshotDecoder returns a function that decodes and normalizes the
+// snapshots of a session.
+func (h *Handler) snapshotDecoder(session string) func(snapshot []byte) (interface{}, error) {
+	format := h.snapshotFormat(session)
+	formats := h.formats.For(session)
+	return func(snapshot []byte) (interface{}, error) {
+		value, err := formats.Decode(format, snapshot)
+		if err != nil || h.normalizer == nil {
+			return value, err
+		}
+		return h.normalizer.Apply(value), nil
+	}
+}
@@ -0,0 +1,131 @@
+package tool
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/redact"
+)
+
+// Replayer sends requests through the proxy. SessionControllers that also
+// implement it can replay recorded requests from the tool.
+type Replayer interface {
+	// Replay sends a request through the proxy and records it in the
+	// active session, linked to the request it replays.
+	Replay(req *http.Request, replayOf recorder.ReplayOf) (proxy.RequestInfo, error)
+}
+
+// ReplayEdits are the changes made to a recorded request before it's
+// replayed. What isn't set is sent as recorded.
+type ReplayEdits struct {
+	Query     *string         `json:"query,omitempty"`
+	Variables json.RawMessage `json:"variables,omitempty"`
+}
+
+// replayRequest sends a recorded request again, with the edits in the
+// request body, if any. It's sent with the recorded method, URL and
+// headers, so it's proxied, selected and snapshotted like the original.
+func (h *Handler) replayRequest(r *http.Request, session string, requestID int) (*proxy.RequestInfo, error) {
+	replayer, ok := h.controller.(Replayer)
+	if !ok {
+		return nil, fmt.Errorf("%w, requests can't be replayed from a viewer", Conflict)
+	}
+
+	var edits ReplayEdits
+	err := json.NewDecoder(r.Body).Decode(&edits)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%w, %s", BadRequest, err)
+	}
+
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint, err := rec.MaybeGetCheckpoint(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil {
+		return nil, fmt.Errorf("%w, %d is a checkpoint, not a request", BadRequest, requestID)
+	}
+	content, err := rec.GetRequest(requestID)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w, no request %d in session %s", NotFound, requestID, session)
+	}
+	if err != nil {
+		return nil, err
+	}
+	meta, err := rec.MaybeGetMeta(requestID)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		return nil, fmt.Errorf("%w, request %d was recorded without its URL and headers", Conflict, requestID)
+	}
+
+	content, err = editRequest(content, edits)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(meta.Method, meta.URL, bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = replayHeader(meta.RequestHeader)
+
+	info, err := replayer.Replay(req, recorder.ReplayOf{Session: session, RequestID: requestID})
+	if err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// editRequest applies edits to a recorded GraphQL request body, keeping
+// the rest of it, e.g. the operation name.
+func editRequest(content []byte, edits ReplayEdits) ([]byte, error) {
+	if edits.Query == nil && edits.Variables == nil {
+		return content, nil
+	}
+	var request map[string]json.RawMessage
+	err := json.Unmarshal(content, &request)
+	if err != nil {
+		return nil, fmt.Errorf("%w, the recorded request isn't a GraphQL request: %s", Conflict, err)
+	}
+	if edits.Query != nil {
+		request["query"], err = json.Marshal(*edits.Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if edits.Variables != nil {
+		request["variables"] = edits.Variables
+	}
+	return json.Marshal(request)
+}
+
+// replayHeader returns the headers a recorded request is replayed with.
+// Headers that were redacted aren't sent, since their values weren't
+// recorded. Neither are the ones set when the replay is sent: the length of
+// the edited body and the address the proxy adds.
+func replayHeader(recorded http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range recorded {
+		if name == "Content-Length" || name == "X-Forwarded-For" {
+			continue
+		}
+		redacted := false
+		for _, value := range values {
+			redacted = redacted || value == redact.Placeholder
+		}
+		if !redacted {
+			header[name] = values
+		}
+	}
+	return header
+}
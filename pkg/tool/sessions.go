@@ -0,0 +1,184 @@
+package tool
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+// SessionController changes which session the proxy records into.
+type SessionController interface {
+	NewSession(session recorder.Session) (recorder.Session, error)
+	SwitchSession(name string) error
+	CloseSession(name string) error
+}
+
+type SessionList struct {
+	Active   string             `json:"active"`
+	Sessions []recorder.Session `json:"sessions"`
+	// ReadOnly is true when sessions can't be created, switched or closed.
+	ReadOnly bool `json:"readOnly"`
+}
+
+var Conflict = fmt.Errorf("conflict")
+var NotFound = fmt.Errorf("not found")
+
+func (h *Handler) getSessions(w http.ResponseWriter, r *http.Request) {
+	sessions, err := h._getSessions()
+	writeJSON(w, sessions, err)
+}
+
+func (h *Handler) _getSessions() (*SessionList, error) {
+	sessions, err := h.sessions.List()
+	if err != nil {
+		return nil, err
+	}
+	if sessions == nil {
+		sessions = []recorder.Session{}
+	}
+	active, err := h.sessions.Active()
+	if err != nil && !errors.Is(err, recorder.ErrNoActiveSession) {
+		return nil, err
+	}
+	return &SessionList{
+		Active:   active,
+		Sessions: sessions,
+		ReadOnly: h.controller == nil,
+	}, nil
+}
+
+func (h *Handler) getSessionRecords(w http.ResponseWriter, r *http.Request) {
+	records, err := h._getSessionRecords(r)
+	writeJSON(w, records, err)
+}
+
+func (h *Handler) _getSessionRecords(r *http.Request) ([]proxy.RequestInfo, error) {
+	session, err := h.sessionName(r)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
+	return _getAllRequestInfo(session, rec)
+}
+
+func (h *Handler) newSession(w http.ResponseWriter, r *http.Request) {
+	session, err := h._newSession(r)
+	writeJSON(w, session, err)
+}
+
+func (h *Handler) _newSession(r *http.Request) (*recorder.Session, error) {
+	if err := h.checkCanChangeSessions(r); err != nil {
+		return nil, err
+	}
+	var session recorder.Session
+	err := json.NewDecoder(r.Body).Decode(&session)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", BadRequest, err)
+	}
+	session, err = h.controller.NewSession(session)
+	if err != nil {
+		return nil, err
+	}
+	h.BroadcastSessions()
+	return &session, nil
+}
+
+func (h *Handler) switchSession(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, nil, h.changeSession(r, func(name string) error {
+		return h.controller.SwitchSession(name)
+	}))
+}
+
+func (h *Handler) closeSession(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, nil, h.changeSession(r, func(name string) error {
+		return h.controller.CloseSession(name)
+	}))
+}
+
+func (h *Handler) changeSession(r *http.Request, change func(name string) error) error {
+	if err := h.checkCanChangeSessions(r); err != nil {
+		return err
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return fmt.Errorf("%w, no name query param", BadRequest)
+	}
+	err := change(name)
+	if err != nil {
+		return err
+	}
+	h.BroadcastSessions()
+	return nil
+}
+
+func (h *Handler) checkCanChangeSessions(r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return fmt.Errorf("%w, expected POST", BadRequest)
+	}
+	if h.controller == nil {
+		return fmt.Errorf("%w, sessions are read-only", Conflict)
+	}
+	return nil
+}
+
+// BroadcastSessions tells all clients that the sessions have changed, e.g.
+// after a session is flagged as drifted.
+func (h *Handler) BroadcastSessions() {
+	sessions, err := h._getSessions()
+	if err != nil {
+		return
+	}
+	h.broadcast(WebsocketMessage{
+		Type: "sessions",
+		Data: sessions,
+	})
+}
+
+// sessionName returns the session named by the session query param,
+// defaulting to the active session.
+func (h *Handler) sessionName(r *http.Request) (string, error) {
+	session := r.URL.Query().Get("session")
+	if session != "" {
+		return session, nil
+	}
+	active, err := h.sessions.Active()
+	if errors.Is(err, recorder.ErrNoActiveSession) {
+		return "", fmt.Errorf("%w, no session query param and no active session", BadRequest)
+	}
+	return active, err
+}
+
+// writeJSON writes value as JSON, or an error status if err isn't nil.
+func writeJSON(w http.ResponseWriter, value interface{}, err error) {
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data, _ := json.Marshal(value)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// errorStatus returns the status an error is served with. Files that don't
+// exist are requests or sessions that don't.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, BadRequest):
+		return http.StatusBadRequest
+	case errors.Is(err, recorder.ErrSessionNotFound), errors.Is(err, NotFound), errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, recorder.ErrSessionExists), errors.Is(err, Conflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,163 @@
+package tool
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/dnerdy/proxyrecorder/pkg/normalize"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotdiff"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+)
+
+// SetSnapshotDiffOptions configures how snapshots are diffed. It must be
+// called before the handler serves requests.
+func (h *Handler) SetSnapshotDiffOptions(options snapshotdiff.Options) {
+	h.snapshotDiff = options
+}
+
+// SetSnapshotNormalizer sets the rules snapshots are normalized with before
+// they're shown or diffed. It must be called before the handler serves
+// requests.
+func (h *Handler) SetSnapshotNormalizer(normalizer *normalize.Normalizer) {
+	h.normalizer = normalizer
+}
+
+// SetSnapshotFormats sets the registries sessions' snapshots are decoded
+// with, snapshotformat.Default for sessions it has none for. It must be
+// called before the handler serves requests.
+func (h *Handler) SetSnapshotFormats(formats snapshotformat.Registries) {
+	h.formats = formats
+}
+
+func (h *Handler) getSnapshotDiff(w http.ResponseWriter, r *http.Request) {
+	diff, err := h._getSnapshotDiff(r)
+	writeJSON(w, diff, err)
+}
+
+// _getSnapshotDiff diffs the snapshots shown for a request, the same ones
+// /request returns.
+func (h *Handler) _getSnapshotDiff(r *http.Request) (*snapshotdiff.Diff, error) {
+	requestID, err := requestIDParam(r)
+	if err != nil {
+		return nil, err
+	}
+	session, err := h.sessionName(r)
+	if err != nil {
+		return nil, err
+	}
+	return h.diffRequestSnapshots(session, requestID)
+}
+
+// diffRequestSnapshots diffs the snapshots shown for a request in a session.
+func (h *Handler) diffRequestSnapshots(session string, requestID int) (*snapshotdiff.Diff, error) {
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, priorSnapshot, err := h.requestSnapshots(session, rec, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil || priorSnapshot == nil {
+		return nil, fmt.Errorf("%w, request %d has no snapshots to diff", NotFound, requestID)
+	}
+	return snapshotdiff.DiffJSON(priorSnapshot, snapshot, h.snapshotDiff)
+}
+
+// SnapshotComparison is the diff between the snapshots at two points,
+// which may be in different sessions.
+type SnapshotComparison struct {
+	FromSession   string             `json:"fromSession"`
+	FromRequestID int                `json:"fromRequestID"`
+	ToSession     string             `json:"toSession"`
+	ToRequestID   int                `json:"toRequestID"`
+	FromSnapshot  string             `json:"fromSnapshot"`
+	ToSnapshot    string             `json:"toSnapshot"`
+	Diff          *snapshotdiff.Diff `json:"diff"`
+}
+
+func (h *Handler) compareSnapshots(w http.ResponseWriter, r *http.Request) {
+	comparison, err := h._compareSnapshots(r)
+	writeJSON(w, comparison, err)
+}
+
+// _compareSnapshots diffs the snapshots after the from and to requests. The
+// fromSession and toSession query params default to the session param, and
+// then the active session. Request 0 is the initial snapshot.
+func (h *Handler) _compareSnapshots(r *http.Request) (*SnapshotComparison, error) {
+	session, err := h.sessionName(r)
+	if err != nil {
+		return nil, err
+	}
+	query := r.URL.Query()
+	comparison := &SnapshotComparison{
+		FromSession: query.Get("fromSession"),
+		ToSession:   query.Get("toSession"),
+	}
+	if comparison.FromSession == "" {
+		comparison.FromSession = session
+	}
+	if comparison.ToSession == "" {
+		comparison.ToSession = session
+	}
+
+	fromSnapshot, err := h.snapshotAt(comparison.FromSession, query.Get("from"), &comparison.FromRequestID)
+	if err != nil {
+		return nil, err
+	}
+	toSnapshot, err := h.snapshotAt(comparison.ToSession, query.Get("to"), &comparison.ToRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	comparison.FromSnapshot = string(fromSnapshot)
+	comparison.ToSnapshot = string(toSnapshot)
+	comparison.Diff, err = snapshotdiff.DiffJSON(fromSnapshot, toSnapshot, h.snapshotDiff)
+	if err != nil {
+		return nil, err
+	}
+	return comparison, nil
+}
+
+// snapshotAt returns the readable snapshot after a request in a session,
+// parsing the request ID into requestID.
+func (h *Handler) snapshotAt(session string, requestIDString string, requestID *int) ([]byte, error) {
+	id, err := strconv.Atoi(requestIDString)
+	if err != nil || id < 0 {
+		return nil, fmt.Errorf("%w, invalid request id \"%s\"", BadRequest, requestIDString)
+	}
+	*requestID = id
+	rec, err := h.sessions.Loader(session)
+	if err != nil {
+		return nil, err
+	}
+	if id != 0 {
+		requestIDs, err := rec.GetAllRequestIDs()
+		if err != nil {
+			return nil, err
+		}
+		if !containsRequestID(requestIDs, id) {
+			return nil, fmt.Errorf("%w, no request %d in session %s", NotFound, id, session)
+		}
+	}
+	snapshot, err := recorder.SnapshotAt(rec, id)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("%w, no snapshot at request %d in session %s", NotFound, id, session)
+	}
+	readable, _ := h.readableSnapshot(session, h.snapshotFormat(session), snapshot)
+	return readable, nil
+}
+
+func containsRequestID(requestIDs []int, requestID int) bool {
+	for _, id := range requestIDs {
+		if id == requestID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,428 @@
+// Package verify replays a recording against a live upstream and compares
+// the results with what was recorded, turning a recording into a backend
+// regression test. Responses are compared structurally, and snapshots are
+// compared by what changed between them rather than byte for byte, so a
+// replay doesn't have to start from exactly the recorded state.
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsondiff"
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+)
+
+// SnapshotRestorer is implemented by snapshotters that can put the upstream
+// back into the state a snapshot was taken in.
+type SnapshotRestorer interface {
+	RestoreSnapshot(snapshot []byte) error
+}
+
+type Options struct {
+	// Upstream is the origin requests are replayed against, e.g.
+	// http://localhost:8309.
+	Upstream string
+	Client   *http.Client
+	// Snapshotter takes snapshots after the requests that had one when they
+	// were recorded. Without one, snapshots aren't compared.
+	Snapshotter proxy.Snapshotter
+	// Reset puts the upstream back into the state the recording started
+	// from. If it's nil and the snapshotter is a SnapshotRestorer, the
+	// recording's initial snapshot is restored instead.
+	Reset func() error
+	// Ignore matches volatile values, like timestamps and generated IDs,
+	// that are left out when comparing responses and snapshots.
+	Ignore []*jsonpath.Pattern
+	// DecodeSnapshot decodes a snapshot for comparison. It defaults to
+	// decoding JSON. Snapshots that can't be decoded are compared by
+	// whether they changed at all.
+	DecodeSnapshot func(snapshot []byte) (interface{}, error)
+	// OnReplay, if set, is called with the response and snapshot of each
+	// replayed request. It's called with request ID 0 and the snapshot
+	// taken before replaying. Snapshot is nil when none was taken.
+	OnReplay func(requestID int, status int, response []byte, snapshot []byte)
+	Reporter proxy.Reporter
+}
+
+type MismatchKind string
+
+const (
+	MismatchError    MismatchKind = "error"
+	MismatchStatus   MismatchKind = "status"
+	MismatchResponse MismatchKind = "response"
+	MismatchSnapshot MismatchKind = "snapshot"
+)
+
+type Mismatch struct {
+	RequestID     int          `json:"requestID"`
+	OperationName string       `json:"operationName"`
+	Kind          MismatchKind `json:"kind"`
+	Message       string       `json:"message"`
+	// Changes are the differences between the recorded and replayed
+	// response.
+	Changes []jsondiff.Change `json:"changes,omitempty"`
+	// Missing are snapshot changes made when recording but not when
+	// replaying, and Unexpected are the reverse.
+	Missing    []jsondiff.Change `json:"missing,omitempty"`
+	Unexpected []jsondiff.Change `json:"unexpected,omitempty"`
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%06d %-8s %s: %s", m.RequestID, m.Kind, m.OperationName, m.Message)
+}
+
+type Result struct {
+	Requests   int        `json:"requests"`
+	Snapshots  int        `json:"snapshots"`
+	Mismatches []Mismatch `json:"mismatches"`
+}
+
+func (r *Result) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+// defaultPath is where requests recorded without metadata are sent.
+const defaultPath = "/backend-graphql/"
+
+// hopHeaders aren't replayed, either because they describe the original
+// connection or because the client sets them itself.
+var hopHeaders = []string{
+	"Accept-Encoding",
+	"Connection",
+	"Content-Length",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+type runner struct {
+	rec     recorder.RecorderLoader
+	options Options
+	result  *Result
+	// priorSnapshot is the most recent snapshot taken during the replay.
+	priorSnapshot []byte
+}
+
+// Run replays every request in a recording in order and returns the
+// mismatches found. An error is only returned when the replay couldn't be
+// carried out; failed requests are reported as mismatches.
+//
+// Requests are replayed one at a time in request ID order, which is the
+// order the proxy received them in, so a request is never replayed before
+// one whose response came back before it was sent. Recordings made before
+// IDs were assigned on arrival are numbered in the order the responses
+// came back in, which keeps that order too.
+func Run(rec recorder.RecorderLoader, options Options) (*Result, error) {
+	if options.Client == nil {
+		options.Client = http.DefaultClient
+	}
+	if options.DecodeSnapshot == nil {
+		options.DecodeSnapshot = jsondiff.Decode
+	}
+	r := &runner{
+		rec:     rec,
+		options: options,
+		result:  &Result{Mismatches: []Mismatch{}},
+	}
+
+	err := r.reset()
+	if err != nil {
+		return nil, err
+	}
+
+	requestIDs, err := rec.GetAllRequestIDs()
+	if err != nil {
+		return nil, err
+	}
+	for _, requestID := range requestIDs {
+		err := r.replay(requestID)
+		if err != nil {
+			return r.result, err
+		}
+	}
+	return r.result, nil
+}
+
+func (r *runner) report(label, message string) {
+	if r.options.Reporter != nil {
+		r.options.Reporter.Report(label, message)
+	}
+}
+
+// reset resets the upstream and takes the snapshot later snapshots are
+// compared against.
+func (r *runner) reset() error {
+	initialSnapshot, err := r.rec.MaybeGetSnapshot(0)
+	if err != nil {
+		return err
+	}
+
+	restorer, canRestore := r.options.Snapshotter.(SnapshotRestorer)
+	switch {
+	case r.options.Reset != nil:
+		r.report("", "resetting upstream")
+		err = r.options.Reset()
+	case canRestore && initialSnapshot != nil:
+		r.report("", "restoring the initial snapshot")
+		err = restorer.RestoreSnapshot(initialSnapshot)
+	default:
+		r.report("warning", "not resetting upstream, replaying against its current state")
+	}
+	if err != nil {
+		return fmt.Errorf("resetting upstream: %w", err)
+	}
+
+	if r.options.Snapshotter != nil {
+		r.priorSnapshot, err = r.options.Snapshotter.TakeSnapshot(proxy.GraphQLRequest{})
+		if err != nil {
+			return fmt.Errorf("taking the initial snapshot: %w", err)
+		}
+	}
+	if r.options.OnReplay != nil {
+		r.options.OnReplay(0, 0, nil, r.priorSnapshot)
+	}
+	return nil
+}
+
+func (r *runner) replay(requestID int) error {
+	// Checkpoints capture changes that didn't go through the proxy, so
+	// there's nothing to replay. Snapshots are compared by what changed, so
+	// the requests after them are unaffected.
+	checkpoint, err := r.rec.MaybeGetCheckpoint(requestID)
+	if err != nil {
+		return err
+	}
+	if checkpoint != nil {
+		r.report("", fmt.Sprintf("%s skipping checkpoint %s", r.rec.FormatRequestID(requestID), checkpoint.Label))
+		return nil
+	}
+
+	content, err := r.rec.GetRequest(requestID)
+	if err != nil {
+		return err
+	}
+	graphQLRequest, err := proxy.ParseRequest(content)
+	if err != nil {
+		return fmt.Errorf("request %s: %w", r.rec.FormatRequestID(requestID), err)
+	}
+	expectedResponse, err := r.rec.GetResponse(requestID)
+	if err != nil {
+		return err
+	}
+	meta, err := r.rec.MaybeGetMeta(requestID)
+	if err != nil {
+		return err
+	}
+
+	r.result.Requests++
+	r.report(
+		string(graphQLRequest.OperationType),
+		fmt.Sprintf("%s %s", r.rec.FormatRequestID(requestID), graphQLRequest.OperationName),
+	)
+
+	mismatch := func(kind MismatchKind, message string) *Mismatch {
+		r.result.Mismatches = append(r.result.Mismatches, Mismatch{
+			RequestID:     requestID,
+			OperationName: graphQLRequest.OperationName,
+			Kind:          kind,
+			Message:       message,
+		})
+		return &r.result.Mismatches[len(r.result.Mismatches)-1]
+	}
+
+	// Requests that were snapshotted before they were forwarded are
+	// snapshotted before they're replayed too, so that both diffs are exact.
+	preSnapshot, err := r.rec.MaybeGetPreSnapshot(requestID)
+	if err != nil {
+		return err
+	}
+	if preSnapshot != nil && r.options.Snapshotter != nil {
+		r.priorSnapshot, err = r.options.Snapshotter.TakeSnapshot(graphQLRequest)
+		if err != nil {
+			mismatch(MismatchError, fmt.Sprintf("taking snapshot before: %s", err))
+			return nil
+		}
+	}
+
+	var snapshot []byte
+	status, response, err := r.send(graphQLRequest, content, meta)
+	if err != nil {
+		mismatch(MismatchError, err.Error())
+	} else {
+		if r.options.OnReplay != nil {
+			defer func() {
+				r.options.OnReplay(requestID, status, response, snapshot)
+			}()
+		}
+		if meta != nil && meta.Status != 0 && meta.Status != status {
+			mismatch(MismatchStatus, fmt.Sprintf("expected status %d, got %d", meta.Status, status))
+		}
+		changes, err := r.compareResponses(expectedResponse, response)
+		if err != nil {
+			mismatch(MismatchResponse, err.Error())
+		} else if len(changes) > 0 {
+			mismatch(MismatchResponse, fmt.Sprintf("%d differences", len(changes))).Changes = changes
+		}
+	}
+
+	expectedSnapshot, err := r.rec.MaybeGetSnapshot(requestID)
+	if err != nil {
+		return err
+	}
+	if expectedSnapshot == nil || r.options.Snapshotter == nil {
+		return nil
+	}
+
+	r.result.Snapshots++
+	snapshot, err = r.options.Snapshotter.TakeSnapshot(graphQLRequest)
+	if err != nil {
+		mismatch(MismatchError, fmt.Sprintf("taking snapshot: %s", err))
+		return nil
+	}
+	expectedPrior, err := r.rec.GetPriorSnapshot(requestID)
+	if err != nil && !errors.Is(err, recorder.ErrNoPriorSnapshot) {
+		return err
+	}
+	prior := r.priorSnapshot
+	r.priorSnapshot = snapshot
+
+	missing, unexpected, message := r.compareSnapshots(expectedPrior, expectedSnapshot, prior, snapshot)
+	if message != "" {
+		m := mismatch(MismatchSnapshot, message)
+		m.Missing = missing
+		m.Unexpected = unexpected
+	}
+	return nil
+}
+
+func (r *runner) send(
+	graphQLRequest proxy.GraphQLRequest,
+	content []byte,
+	meta *recorder.RequestMeta,
+) (int, []byte, error) {
+	method := http.MethodPost
+	target := defaultPath + graphQLRequest.OperationName
+	header := http.Header{"Content-Type": {"application/json"}}
+	if meta != nil {
+		if meta.Method != "" {
+			method = meta.Method
+		}
+		if u, err := url.Parse(meta.URL); err == nil && u.Path != "" {
+			target = u.RequestURI()
+		}
+		if meta.RequestHeader != nil {
+			header = meta.RequestHeader.Clone()
+			for _, name := range hopHeaders {
+				header.Del(name)
+			}
+		}
+	}
+
+	req, err := http.NewRequest(
+		method,
+		strings.TrimSuffix(r.options.Upstream, "/")+target,
+		bytes.NewReader(content),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header = header
+
+	resp, err := r.options.Client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// compareResponses returns the differences between two responses, ignoring
+// volatile values. Responses that aren't JSON are compared byte for byte.
+func (r *runner) compareResponses(expected, actual []byte) ([]jsondiff.Change, error) {
+	expectedValue, expectedErr := jsondiff.Decode(expected)
+	actualValue, actualErr := jsondiff.Decode(actual)
+	switch {
+	case expectedErr != nil && bytes.Equal(expected, actual):
+		return nil, nil
+	case expectedErr != nil || actualErr != nil:
+		return nil, fmt.Errorf("responses differ, and at least one isn't JSON")
+	}
+	return jsondiff.Diff(r.ignore(expectedValue), r.ignore(actualValue)), nil
+}
+
+// compareSnapshots compares what changed between two recorded snapshots
+// with what changed between the corresponding replayed snapshots. It
+// returns a message describing the mismatch, or "" if there isn't one.
+func (r *runner) compareSnapshots(
+	expectedPrior, expected, prior, actual []byte,
+) ([]jsondiff.Change, []jsondiff.Change, string) {
+	if expectedPrior == nil {
+		// There's nothing to diff against, so compare the snapshots
+		// themselves.
+		changes, err := r.diffSnapshots(expected, actual)
+		if err != nil {
+			return nil, nil, err.Error()
+		}
+		if len(changes) > 0 {
+			return nil, changes, fmt.Sprintf("snapshot differs from the recording in %d places", len(changes))
+		}
+		return nil, nil, ""
+	}
+
+	expectedChanges, expectedErr := r.diffSnapshots(expectedPrior, expected)
+	actualChanges, actualErr := r.diffSnapshots(prior, actual)
+	if expectedErr != nil || actualErr != nil {
+		expectedChanged := !bytes.Equal(expectedPrior, expected)
+		actualChanged := !bytes.Equal(prior, actual)
+		if expectedChanged != actualChanged {
+			return nil, nil, fmt.Sprintf(
+				"snapshot can't be decoded, and it changed when recording: %t, when replaying: %t",
+				expectedChanged,
+				actualChanged,
+			)
+		}
+		return nil, nil, ""
+	}
+
+	missing := jsondiff.Subtract(expectedChanges, actualChanges)
+	unexpected := jsondiff.Subtract(actualChanges, expectedChanges)
+	if len(missing) == 0 && len(unexpected) == 0 {
+		return nil, nil, ""
+	}
+	return missing, unexpected, fmt.Sprintf(
+		"snapshot changes differ, %d missing and %d unexpected",
+		len(missing),
+		len(unexpected),
+	)
+}
+
+func (r *runner) diffSnapshots(before, after []byte) ([]jsondiff.Change, error) {
+	beforeValue, err := r.options.DecodeSnapshot(before)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	afterValue, err := r.options.DecodeSnapshot(after)
+	if err != nil {
+		return nil, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	return jsondiff.Diff(r.ignore(beforeValue), r.ignore(afterValue)), nil
+}
+
+func (r *runner) ignore(value interface{}) interface{} {
+	return jsonpath.Remove(value, r.options.Ignore)
+}
@@ -0,0 +1,194 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/dnerdy/proxyrecorder/pkg/jsonpath"
+	"github.com/dnerdy/proxyrecorder/pkg/proxy"
+	"github.com/dnerdy/proxyrecorder/pkg/recorder"
+	"github.com/dnerdy/proxyrecorder/pkg/snapshotformat"
+	"github.com/stretchr/testify/suite"
+)
+
+// testUpstream keeps a points total. Mutations add to it and queries return
+// it, along with a value that changes on every request.
+type testUpstream struct {
+	mu       sync.Mutex
+	points   int
+	step     int
+	requests int
+}
+
+func (u *testUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	content, _ := ioutil.ReadAll(r.Body)
+	graphQLRequest, err := proxy.ParseRequest(content)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.requests++
+	if graphQLRequest.OperationType == proxy.OperationTypeMutation {
+		u.points += u.step
+	}
+	fmt.Fprintf(w, `{"data": {"points": %d, "requestedAt": %d}}`, u.points, u.requests)
+}
+
+func (u *testUpstream) TakeSnapshot(proxy.GraphQLRequest) ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return json.Marshal(map[string]interface{}{
+		"points":    u.points,
+		"snapshots": u.requests,
+	})
+}
+
+func (u *testUpstream) SnapshotInfo() string {
+	return "test"
+}
+
+func (u *testUpstream) SnapshotContentType() string {
+	return snapshotformat.JSON
+}
+
+type verifySuite struct {
+	suite.Suite
+	rootPath string
+	rec      *recorder.Recorder
+	upstream *testUpstream
+	server   *httptest.Server
+}
+
+func (suite *verifySuite) BeforeTest(suiteName, testName string) {
+	var err error
+	suite.rootPath, err = ioutil.TempDir("", "verify")
+	suite.Require().NoError(err)
+	suite.rec = &recorder.Recorder{RootPath: suite.rootPath}
+	suite.upstream = &testUpstream{step: 10}
+	suite.server = httptest.NewServer(suite.upstream)
+
+	// The recording starts with 100 points, reads them, adds 10 and reads
+	// them again.
+	suite.save(0, "", "", `{"points": 100, "snapshots": 0}`)
+	suite.save(
+		1,
+		`{"operationName": "getPoints", "query": "query getPoints { points }"}`,
+		`{"data": {"points": 100, "requestedAt": 1}}`,
+		"",
+	)
+	suite.save(
+		2,
+		`{"operationName": "addPoints", "query": "mutation addPoints { points }"}`,
+		`{"data": {"points": 110, "requestedAt": 2}}`,
+		`{"points": 110, "snapshots": 2}`,
+	)
+	suite.save(
+		3,
+		`{"operationName": "getPoints", "query": "query getPoints { points }"}`,
+		`{"data": {"points": 110, "requestedAt": 3}}`,
+		"",
+	)
+}
+
+func (suite *verifySuite) AfterTest(suiteName, testName string) {
+	suite.server.Close()
+	os.RemoveAll(suite.rootPath)
+}
+
+func (suite *verifySuite) save(requestID int, request, response, snapshot string) {
+	if request != "" {
+		suite.Require().NoError(suite.rec.SaveRequest(requestID, []byte(request)))
+		suite.Require().NoError(suite.rec.SaveResponse(requestID, []byte(response)))
+	}
+	if snapshot != "" {
+		suite.Require().NoError(suite.rec.SaveSnapshot(requestID, []byte(snapshot)))
+	}
+}
+
+func (suite *verifySuite) options(ignore ...string) Options {
+	patterns, err := jsonpath.CompileAll(ignore)
+	suite.Require().NoError(err)
+	return Options{
+		Upstream:    suite.server.URL,
+		Snapshotter: suite.upstream,
+		Reset: func() error {
+			suite.upstream.points = 100
+			return nil
+		},
+		Ignore: patterns,
+	}
+}
+
+func (suite *verifySuite) TestMatchingReplay() {
+	result, err := Run(suite.rec, suite.options())
+	suite.Require().NoError(err)
+
+	suite.Equal(3, result.Requests)
+	suite.Equal(1, result.Snapshots)
+	suite.Empty(result.Mismatches)
+}
+
+func (suite *verifySuite) TestIgnoredValues() {
+	// Replaying against an upstream that has served other requests changes
+	// the requestedAt values, and the snapshots counter changes by a
+	// different amount.
+	suite.upstream.requests = 5
+
+	result, err := Run(suite.rec, suite.options())
+	suite.Require().NoError(err)
+	suite.False(result.OK())
+
+	result, err = Run(suite.rec, suite.options("**.requestedAt", "snapshots"))
+	suite.Require().NoError(err)
+	suite.Empty(result.Mismatches)
+}
+
+func (suite *verifySuite) TestMismatches() {
+	suite.upstream.step = 5
+
+	result, err := Run(suite.rec, suite.options("**.requestedAt"))
+	suite.Require().NoError(err)
+
+	suite.Require().Len(result.Mismatches, 3)
+
+	suite.Equal(2, result.Mismatches[0].RequestID)
+	suite.Equal(MismatchResponse, result.Mismatches[0].Kind)
+	suite.Require().Len(result.Mismatches[0].Changes, 1)
+	suite.Equal("data.points", result.Mismatches[0].Changes[0].Path)
+
+	suite.Equal(2, result.Mismatches[1].RequestID)
+	suite.Equal(MismatchSnapshot, result.Mismatches[1].Kind)
+	suite.Require().Len(result.Mismatches[1].Missing, 1)
+	suite.Equal("points", result.Mismatches[1].Missing[0].Path)
+	suite.Require().Len(result.Mismatches[1].Unexpected, 1)
+
+	suite.Equal(3, result.Mismatches[2].RequestID)
+	suite.Equal(MismatchResponse, result.Mismatches[2].Kind)
+}
+
+func (suite *verifySuite) TestStatus() {
+	suite.Require().NoError(suite.rec.SaveMeta(1, recorder.RequestMeta{
+		Method: http.MethodPost,
+		URL:    "http://localhost:8109/backend-graphql/getPoints",
+		Status: http.StatusCreated,
+	}))
+
+	result, err := Run(suite.rec, suite.options())
+	suite.Require().NoError(err)
+
+	suite.Require().Len(result.Mismatches, 1)
+	suite.Equal(MismatchStatus, result.Mismatches[0].Kind)
+}
+
+func TestVerify(t *testing.T) {
+	suite.Run(t, new(verifySuite))
+}
@@ -2,11 +2,38 @@
 
 /*::
 type RecordInfo = {|
+    session: string,
     requestID: number,
     operationType: "query" | "mutation",
     operationName: string,
     willSnapshot: boolean,
     snapshotComplete: boolean,
+    checkpoint?: string,
+    drift?: boolean,
+    sentAt?: string,
+    completedAt?: string,
+    status?: number,
+    deleted?: boolean,
+    annotation?: Annotation,
+    replayOf?: ReplayOf,
+|}
+
+type ReplayOf = {|
+    session: string,
+    requestID: number,
+|}
+
+type Annotation = {|
+    notes?: string,
+    tags?: Array<string>,
+    starred?: boolean,
+|}
+
+type RequestPage = {|
+    total: number,
+    offset: number,
+    limit: number,
+    requests: Array<RecordInfo>,
 |}
 
 type Record = {|
@@ -17,12 +44,49 @@ type Record = {|
     response: string,
     currentSnapshot: string,
     priorSnapshot: string,
+    snapshotFormat: string,
+    snapshotDecoded: boolean,
     notes: string,
+    checkpoint?: Checkpoint,
+    annotation?: Annotation,
+    meta?: Object,
+|}
+
+type Checkpoint = {|
+    label: string,
+    takenAt: string,
+    drift?: boolean,
+    deleted?: boolean,
+|}
+
+type Session = {|
+    name: string,
+    description?: string,
+    startedAt: string,
+    endedAt?: string,
+    drifted?: boolean,
+|}
+
+type SessionList = {|
+    active: string,
+    sessions: Array<Session>,
+    readOnly: boolean,
+|}
+
+type RecordingStatus = {|
+    recording: boolean,
+    snapshotting: boolean,
+    ruleSet: string,
+    ruleSets: Array<string>,
+    configError?: string,
 |}
 
 type InitMessage = {|
     type: "init",
-    data: Array<RecordInfo>,
+    data: {|
+        sessions: SessionList,
+        status: ?RecordingStatus,
+    |},
 |}
 
 type RecordMessage = {|
@@ -30,7 +94,82 @@ type RecordMessage = {|
     data: RecordInfo,
 |}
 
-type Message = InitMessage | RecordMessage;
+type SessionsMessage = {|
+    type: "sessions",
+    data: SessionList,
+|}
+
+type StatusMessage = {|
+    type: "status",
+    data: RecordingStatus,
+|}
+
+type ErrorMessage = {|
+    type: "error",
+    data: string,
+|}
+
+type Command =
+    | {| type: "pause" |}
+    | {| type: "resume" |}
+    | {| type: "snapshotting", data: boolean |}
+    | {| type: "ruleSet", data: string |};
+
+type JSONChange = {|
+    path: string,
+    kind: "added" | "removed" | "changed",
+    before?: any,
+    after?: any,
+|}
+
+type EntityChange = {|
+    list: string,
+    identity: string,
+    kind: "added" | "removed" | "changed",
+    changes?: Array<JSONChange>,
+|}
+
+type SnapshotDiff = {|
+    added: number,
+    removed: number,
+    changed: number,
+    entities: Array<EntityChange>,
+    other: Array<JSONChange>,
+    summary: string,
+|}
+
+type SnapshotComparison = {|
+    fromSession: string,
+    fromRequestID: number,
+    toSession: string,
+    toRequestID: number,
+    fromSnapshot: string,
+    toSnapshot: string,
+    diff: SnapshotDiff,
+|}
+
+type Step = {|
+    requestID: number,
+    operationType: string,
+    operationName: string,
+|}
+
+type RecordingPair = {|
+    kind: "same" | "changed" | "added" | "removed",
+    a?: Step,
+    b?: Step,
+    responseChanges?: Array<JSONChange>,
+    snapshotOnlyA?: Array<JSONChange>,
+    snapshotOnlyB?: Array<JSONChange>,
+    message?: string,
+|}
+
+type RecordingDiff = {|
+    pairs: Array<RecordingPair>,
+    summary: string,
+|}
+
+type Message = InitMessage | RecordMessage | SessionsMessage | StatusMessage | ErrorMessage;
 */
 
 function buildHumanReadableValue(value) {
@@ -68,7 +207,18 @@ function buildHumanReadableEntity(entity) {
 }
 
 function buildHumanReadableSnapshot(snapshot) {
-    const parsedSnapshot = JSON.parse(snapshot);
+    let parsedSnapshot;
+    try {
+        parsedSnapshot = JSON.parse(snapshot);
+    } catch (_) {
+        // Snapshots the server couldn't decode are shown as recorded.
+        return snapshot;
+    }
+    if (parsedSnapshot == null
+        || !Array.isArray(parsedSnapshot.task_entities)
+        || !Array.isArray(parsedSnapshot.non_task_entities)) {
+        return JSON.stringify(parsedSnapshot, null, 4);
+    }
     return JSON.stringify({
         ...parsedSnapshot,
         non_task_entities: parsedSnapshot.non_task_entities.map(buildHumanReadableEntity),
@@ -124,6 +274,27 @@ class Item {
         if (info.willSnapshot && !info.snapshotComplete) {
             statusClass = "x--status-pending"
         }
+        const star = info.annotation != null && info.annotation.starred
+            ? `<span class="c-request-list--item--star" title="Starred">&#9733;</span>`
+            : "";
+        if (info.checkpoint != null) {
+            let [typeClass, typeName, title] = ["x--checkpoint", "C", "Checkpoint"];
+            if (info.drift) {
+                [typeClass, typeName, title] = ["x--drift", "D", "Changes made without a recorded request"];
+            } else if (info.deleted) {
+                [typeClass, typeName, title] = ["x--deleted", "X", "Deleted request"];
+            }
+            return `
+                <div class="c-request-list--item--type ${typeClass}" title="${title}">
+                    ${typeName}
+                </div>
+                <div class="c-request-list--item--name">
+                    ${star}${escapeHTML(info.checkpoint)}
+                </div>
+                <div class="c-request-list--item--status ${statusClass}">
+                </div>
+            `;
+        }
         let operationTypeName = "Q";
         if (info.operationType === "mutation") {
             operationTypeName = "M";
@@ -132,8 +303,8 @@ class Item {
             <div class="c-request-list--item--type x--${info.operationType}">
                 ${operationTypeName}
             </div>
-            <div class="c-request-list--item--name">
-                ${this._recordInfo.operationName}
+            <div class="c-request-list--item--name" title="${escapeHTML(formatTiming(info))}">
+                ${star}${info.replayOf != null ? buildReplayMarker(info.replayOf) : ""}${this._recordInfo.operationName}
             </div>
             <div class="c-request-list--item--status ${statusClass}">
             </div>
@@ -146,12 +317,340 @@ class Item {
     }
 }
 
+// RecordingBar pauses and resumes recording, turns snapshots on and off,
+// switches the rules requests are selected with and takes snapshots on
+// demand. It's empty when recording can't be controlled, e.g. in a viewer.
+class RecordingBar {
+    /*:: _status: ?RecordingStatus */
+    /*:: _element: HTMLDivElement */
+    /*:: _sendCommand: Command => void */
+
+    constructor(sendCommand /*: Command => void */) {
+        this._status = null;
+        this._sendCommand = sendCommand;
+        this._element = document.createElement("div");
+        this._update();
+    }
+
+    element() {
+        return this._element;
+    }
+
+    updateStatus(status /*: ?RecordingStatus */) {
+        this._status = status;
+        this._update();
+    }
+
+    _innerHTML() {
+        const status = this._status;
+        if (status == null) {
+            return "";
+        }
+        const ruleSets = status.ruleSets.length < 2 ? "" : `
+            <select class="js-rule-set" title="Rules for selecting requests">
+                ${status.ruleSets.map(name => {
+                    const selected = name === status.ruleSet ? "selected" : "";
+                    return `<option value="${escapeHTML(name)}" ${selected}>${escapeHTML(name)}</option>`;
+                }).join("")}
+            </select>
+        `;
+        return `
+            <span class="c-recording-bar--state">${status.recording ? "&#9679; Recording" : "Paused"}</span>
+            <button class="js-toggle-recording">${status.recording ? "Pause" : "Resume"}</button>
+            <label>
+                <input class="js-toggle-snapshots" type="checkbox" ${status.snapshotting ? "checked" : ""}>
+                Snapshots
+            </label>
+            <button class="js-checkpoint" title="Take a snapshot now and add it as a checkpoint">Snapshot now</button>
+            ${ruleSets}
+            ${status.configError == null ? "" : `
+                <div class="c-recording-bar--error" title="The previous config is still used">
+                    ${escapeHTML(status.configError)}
+                </div>
+            `}
+        `;
+    }
+
+    _update() {
+        const status = this._status;
+        this._element.className = status == null
+            ? ""
+            : `c-recording-bar ${status.recording ? "" : "x--paused"}`;
+        this._element.innerHTML = this._innerHTML();
+
+        if (status == null) {
+            return;
+        }
+
+        const toggleRecording = this._element.querySelector(".js-toggle-recording");
+        if (toggleRecording) {
+            toggleRecording.addEventListener("click", () => {
+                this._sendCommand(status.recording ? {type: "pause"} : {type: "resume"});
+            });
+        }
+
+        const toggleSnapshots = this._element.querySelector(".js-toggle-snapshots");
+        if (toggleSnapshots instanceof HTMLInputElement) {
+            toggleSnapshots.addEventListener("change", () => {
+                this._sendCommand({type: "snapshotting", data: toggleSnapshots.checked});
+            });
+        }
+
+        const checkpoint = this._element.querySelector(".js-checkpoint");
+        if (checkpoint) {
+            checkpoint.addEventListener("click", () => {
+                const label = window.prompt("Checkpoint label", "manual snapshot");
+                if (label == null) {
+                    return;
+                }
+                postJSON(`/checkpoint?label=${encodeURIComponent(label)}`);
+            });
+        }
+
+        const ruleSet = this._element.querySelector(".js-rule-set");
+        if (ruleSet instanceof HTMLSelectElement) {
+            ruleSet.addEventListener("change", () => {
+                this._sendCommand({type: "ruleSet", data: ruleSet.value});
+            });
+        }
+    }
+}
+
+class SessionBar {
+    /*:: _sessions: ?SessionList */
+    /*:: _viewing: string */
+    /*:: _element: HTMLDivElement */
+    /*:: _viewCallback: string => void */
+    /*:: _diffCallback: (string, string) => void */
+
+    constructor(viewCallback /*: string => void */, diffCallback /*: (string, string) => void */) {
+        this._sessions = null;
+        this._viewing = "";
+        this._viewCallback = viewCallback;
+        this._diffCallback = diffCallback;
+        this._element = document.createElement("div");
+        this._element.className = "c-session-bar";
+        this._update();
+    }
+
+    element() {
+        return this._element;
+    }
+
+    viewing() {
+        return this._viewing;
+    }
+
+    updateSessions(sessions /*: SessionList */) {
+        const followActive = this._sessions == null || this._viewing === defaultSession(this._sessions);
+        this._sessions = sessions;
+        if (followActive || !sessions.sessions.some(s => s.name === this._viewing)) {
+            this._view(defaultSession(sessions));
+        }
+        this._update();
+    }
+
+    _view(name /*: string */) {
+        if (name === this._viewing) {
+            return;
+        }
+        this._viewing = name;
+        this._viewCallback(name);
+    }
+
+    _innerHTML() {
+        const sessions = this._sessions;
+        if (sessions == null) {
+            return "";
+        }
+        const options = sessions.sessions.map(session => {
+            const selected = session.name === this._viewing ? "selected" : "";
+            const flags = [];
+            if (session.name === sessions.active) {
+                flags.push("recording");
+            } else if (session.endedAt != null) {
+                flags.push("closed");
+            }
+            if (session.drifted) {
+                flags.push("drifted");
+            }
+            const status = flags.length ? ` (${flags.join(", ")})` : "";
+            return `<option value="${session.name}" ${selected}>${session.name}${status}</option>`;
+        }).join("");
+        const viewed = sessions.sessions.find(s => s.name === this._viewing);
+        const description = viewed != null && viewed.description != null ? viewed.description : "";
+        const changeButtons = sessions.readOnly ? "" : `
+            <button class="js-new-session">New</button>
+            <button class="js-switch-session" ${this._viewing === sessions.active ? "disabled" : ""}>Record here</button>
+            <button class="js-close-session" ${viewed == null || viewed.endedAt != null ? "disabled" : ""}>Close</button>
+        `;
+        const buttons = `
+            <div class="c-session-bar--buttons">
+                ${changeButtons}
+                <button class="js-diff-session" ${sessions.sessions.length < 2 ? "disabled" : ""}>Diff</button>
+            </div>
+        `;
+        return `
+            <select class="js-session-select">
+                ${options}
+            </select>
+            <div class="c-session-bar--description">${description}</div>
+            ${buttons}
+        `;
+    }
+
+    _update() {
+        this._element.innerHTML = this._innerHTML();
+
+        const select = this._element.querySelector(".js-session-select");
+        if (select instanceof HTMLSelectElement) {
+            select.addEventListener("change", () => {
+                this._view(select.value);
+                this._update();
+            });
+        }
+
+        const newButton = this._element.querySelector(".js-new-session");
+        if (newButton) {
+            newButton.addEventListener("click", () => {
+                const name = window.prompt("Session name");
+                if (!name) {
+                    return;
+                }
+                const description = window.prompt("Description (optional)") || "";
+                postJSON("/sessions/new", {name, description});
+            });
+        }
+
+        const switchButton = this._element.querySelector(".js-switch-session");
+        if (switchButton) {
+            switchButton.addEventListener("click", () => {
+                postJSON(`/sessions/switch?name=${encodeURIComponent(this._viewing)}`);
+            });
+        }
+
+        const diffButton = this._element.querySelector(".js-diff-session");
+        const sessions = this._sessions;
+        if (diffButton && sessions != null) {
+            diffButton.addEventListener("click", () => {
+                const other = sessions.sessions.find(s => s.name !== this._viewing);
+                const name = window.prompt(
+                    `Diff ${this._viewing} with session`,
+                    other != null ? other.name : "",
+                );
+                if (!name) {
+                    return;
+                }
+                this._diffCallback(this._viewing, name);
+            });
+        }
+
+        const closeButton = this._element.querySelector(".js-close-session");
+        if (closeButton) {
+            closeButton.addEventListener("click", () => {
+                postJSON(`/sessions/close?name=${encodeURIComponent(this._viewing)}`);
+            });
+        }
+    }
+}
+
+// defaultSession is the session to show: the one being recorded into or,
+// when nothing is being recorded, e.g. in a viewer, the most recent one.
+function defaultSession(sessions /*: SessionList */) /*: string */ {
+    if (sessions.active !== "") {
+        return sessions.active;
+    }
+    if (sessions.sessions.length > 0) {
+        return sessions.sessions[sessions.sessions.length - 1].name;
+    }
+    return "";
+}
+
+// buildCheckpoint describes a checkpoint, which is shown in place of a
+// request and response.
+function buildCheckpoint(requestID /*: number */, checkpoint /*: Checkpoint */) /*: string */ {
+    const takenAt = escapeHTML(new Date(checkpoint.takenAt).toLocaleString());
+    if (checkpoint.deleted) {
+        return `
+            <h3>Deleted &bull; ${requestID}</h3>
+            <div class="c-verbatim-output">
+                ${escapeHTML(checkpoint.label)}, deleted. Its snapshot, if it had one, is
+                kept so the requests after it are still diffed against it.
+            </div>
+        `;
+    }
+    if (checkpoint.drift) {
+        return `
+            <h3>Drift &bull; ${requestID}</h3>
+            <div class="c-verbatim-output">
+                The upstream changed without a recorded request between the previous
+                snapshot and ${takenAt}, e.g. because of a request sent to it directly.
+                The snapshot diff above shows those changes, so they aren't attributed
+                to the next request.
+            </div>
+        `;
+    }
+    return `
+        <h3>Checkpoint &bull; ${requestID}</h3>
+        <div class="c-verbatim-output">
+            ${escapeHTML(checkpoint.label)}, taken ${takenAt}
+            outside of any request.
+        </div>
+    `;
+}
+
+// buildReplayMarker marks a request replayed from the tool in the list.
+function buildReplayMarker(replayOf /*: ReplayOf */) /*: string */ {
+    const title = escapeHTML(`Replay of ${replayOf.session} \u2022 ${replayOf.requestID}`);
+    return `<span class="c-request-list--item--replay" title="${title}">&#8635;</span>`;
+}
+
+function postJSON(url /*: string */, body /*: ?Object */) /*: Promise<any> */ {
+    return sendJSON("POST", url, body);
+}
+
+function sendJSON(method /*: string */, url /*: string */, body /*: ?Object */) /*: Promise<any> */ {
+    return fetch(url, {
+        method,
+        headers: {"Content-Type": "application/json"},
+        body: body != null ? JSON.stringify(body) : undefined,
+    })
+        .then(response => {
+            if (!response.ok) {
+                return response.text().then(text => {
+                    throw new Error(text);
+                });
+            }
+            return response.json();
+        })
+        .catch(error => {
+            console.error(error);
+            window.alert(error.message);
+        });
+}
+
 class Content {
     /*:: _record: ?Record */
+    /*:: _snapshotDiff: ?SnapshotDiff */
+    /*:: _session: string */
+    /*:: _sessionNames: Array<string> */
+    /*:: _readOnly: boolean */
+    /*:: _replaying: boolean */
+    /*:: _comparison: ?SnapshotComparison */
+    /*:: _recordingDiff: ?{a: string, b: string, diff: RecordingDiff} */
     /*:: _element: HTMLDivElement */
+    /*:: _reloadCallback: (session: string, requestID: number) => void */
 
-    constructor(record /*: ?Record */) {
+    constructor(record /*: ?Record */, reloadCallback /*: (session: string, requestID: number) => void */) {
         this._record = record
+        this._snapshotDiff = null
+        this._session = "";
+        this._sessionNames = [];
+        this._readOnly = true;
+        this._replaying = false;
+        this._reloadCallback = reloadCallback;
+        this._comparison = null;
+        this._recordingDiff = null;
         this._element = document.createElement("div");
         this._element.className = "c-content-wrapper";
         this._update();
@@ -161,12 +660,213 @@ class Content {
         return this._element;
     }
 
-    updateRecord(record /*: ?Record */) {
+    updateRecord(record /*: ?Record */, snapshotDiff /*: ?SnapshotDiff */, session /*: string */ = "") {
         this._record = record;
+        this._snapshotDiff = snapshotDiff;
+        this._session = session;
+        this._comparison = null;
+        this._recordingDiff = null;
+        this._replaying = false;
         this._update();
     }
 
+    showRecordingDiff(a /*: string */, b /*: string */, diff /*: RecordingDiff */) {
+        this._record = null;
+        this._recordingDiff = {a, b, diff};
+        this._update();
+    }
+
+    // setSessions updates the sessions that can be compared with. It doesn't
+    // re-render, so a comparison being set up isn't lost.
+    setSessions(sessions /*: SessionList */) {
+        this._sessionNames = sessions.sessions.map(session => session.name);
+        this._readOnly = sessions.readOnly;
+    }
+
+    _requestURL(record /*: Record */, endpoint /*: string */ = "") /*: string */ {
+        return `/api/v1/requests/${record.requestID}${endpoint}?session=${encodeURIComponent(this._session)}`;
+    }
+
+    _saveAnnotation(annotation /*: Annotation */) {
+        const record = this._record;
+        if (record == null) {
+            return;
+        }
+        sendJSON("PUT", this._requestURL(record, "/annotation"), annotation)
+            .then((info /*: ?RecordInfo */) => {
+                if (info == null || record !== this._record) {
+                    return;
+                }
+                record.annotation = info.annotation;
+                this._update();
+            });
+    }
+
+    _delete() {
+        const record = this._record;
+        if (record == null || !window.confirm(`Delete request ${record.requestID}? This can't be undone.`)) {
+            return;
+        }
+        const session = this._session;
+        sendJSON("DELETE", this._requestURL(record))
+            .then((info /*: ?RecordInfo */) => {
+                if (info != null && record === this._record) {
+                    this._reloadCallback(session, record.requestID);
+                }
+            });
+    }
+
+    // _replay sends the record's request again, edited, and shows the new
+    // request once it's recorded.
+    _replay(edits /*: {query: string, variables: Object} */) /*: Promise<void> */ {
+        const record = this._record;
+        if (record == null) {
+            return Promise.resolve();
+        }
+        return sendJSON("POST", this._requestURL(record, "/replay"), edits)
+            .then((info /*: ?RecordInfo */) => {
+                if (info != null) {
+                    this._reloadCallback(info.session, info.requestID);
+                }
+            });
+    }
+
+    // _requestSection shows the record's request, or edits it to be
+    // replayed.
+    _requestSection(record /*: Record */) /*: string */ {
+        const meta = record.meta || {};
+        const replayOf = meta.replayOf != null
+            ? `<div class="c-replay-of">Replay of ${escapeHTML(meta.replayOf.session)} &bull; ${meta.replayOf.requestID}</div>`
+            : "";
+        if (!this._replaying) {
+            const replay = this._readOnly || record.meta == null
+                ? ""
+                : `<button class="js-replay" title="Edit the request and send it through the proxy again">Replay</button>`;
+            return `
+                <h3>Request &bull; ${record.requestID} ${replay}</h3>
+                ${replayOf}
+                <pre class="c-verbatim-output">
+${formatJSON(record.request)}
+                </pre>
+            `;
+        }
+        let request = {};
+        try {
+            request = JSON.parse(record.request);
+        } catch (_) {}
+        return `
+            <h3>Replay request &bull; ${record.requestID}</h3>
+            <div class="c-replay">
+                <div>Query</div>
+                <textarea class="js-replay-query" rows="12">${escapeHTML(request.query || "")}</textarea>
+                <div>Variables</div>
+                <textarea class="js-replay-variables" rows="8">${escapeHTML(JSON.stringify(request.variables || {}, null, 4))}</textarea>
+                <div class="c-replay--buttons">
+                    <button class="js-replay-send" title="Record the response as a new request in the active session">Send</button>
+                    <button class="js-replay-cancel">Cancel</button>
+                </div>
+            </div>
+        `;
+    }
+
+    // _annotationForm edits the record's notes, tags and star, or shows them
+    // when the recording is read-only.
+    _annotationForm() {
+        const record = this._record;
+        if (record == null || this._session === "") {
+            return "";
+        }
+        const annotation = record.annotation || {};
+        const notes = annotation.notes || "";
+        const tags = (annotation.tags || []).join(", ");
+        const deleted = record.checkpoint != null && record.checkpoint.deleted;
+        if (this._readOnly || deleted) {
+            if (notes === "" && tags === "" && !annotation.starred) {
+                return "";
+            }
+            return `
+                <div class="c-annotation">
+                    ${annotation.starred ? "&#9733; " : ""}${tags !== "" ? `Tags: ${escapeHTML(tags)}` : ""}
+                    ${notes !== "" ? `<pre class="c-verbatim-output">${escapeHTML(notes)}</pre>` : ""}
+                </div>
+            `;
+        }
+        return `
+            <div class="c-annotation">
+                <textarea class="js-annotation-notes" rows="3" placeholder="Notes">${escapeHTML(notes)}</textarea>
+                <div class="c-annotation--row">
+                    <input class="js-annotation-tags" type="text" placeholder="Tags, e.g. bug, flaky" value="${escapeHTML(tags)}">
+                    <label>
+                        <input class="js-annotation-starred" type="checkbox" ${annotation.starred ? "checked" : ""}>
+                        Starred
+                    </label>
+                    <button class="js-annotation-save">Save</button>
+                    <button class="js-delete-request" title="Delete the request, keeping its snapshot">Delete</button>
+                </div>
+            </div>
+        `;
+    }
+
+    _compare(fromSession /*: string */, fromRequestID /*: number */) {
+        const record = this._record;
+        if (record == null) {
+            return;
+        }
+        const params = [
+            `fromSession=${encodeURIComponent(fromSession)}`,
+            `from=${fromRequestID}`,
+            `toSession=${encodeURIComponent(this._session)}`,
+            `to=${record.requestID}`,
+        ].join("&");
+        fetch(`/snapshot-compare?${params}`)
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => {
+                        throw new Error(text);
+                    });
+                }
+                return response.json();
+            })
+            .then((comparison /*: SnapshotComparison */) => {
+                if (record !== this._record) {
+                    return;
+                }
+                this._comparison = comparison;
+                this._update();
+            })
+            .catch(error => window.alert(error.message));
+    }
+
+    _compareForm() {
+        const record = this._record;
+        if (record == null || this._session === "") {
+            return "";
+        }
+        const fromSession = this._comparison != null ? this._comparison.fromSession : this._session;
+        const fromRequestID = this._comparison != null ? this._comparison.fromRequestID : 0;
+        const options = this._sessionNames.map(name => {
+            const selected = name === fromSession ? "selected" : "";
+            return `<option value="${escapeHTML(name)}" ${selected}>${escapeHTML(name)}</option>`;
+        }).join("");
+        const back = this._comparison != null ? `<button class="js-compare-back">Back to diff</button>` : "";
+        return `
+            <div class="c-compare">
+                Compare with the snapshot after request
+                <input class="js-compare-request" type="number" min="0" value="${fromRequestID}">
+                in
+                <select class="js-compare-session">${options}</select>
+                <button class="js-compare">Compare</button>
+                ${back}
+            </div>
+        `;
+    }
+
     _innerHTML() {
+        if (this._recordingDiff != null) {
+            const {a, b, diff} = this._recordingDiff;
+            return buildRecordingDiff(a, b, diff);
+        }
+
         if (this._record == null) {
             return `
                 <div class="c-placeholder">
@@ -179,7 +879,9 @@ class Content {
 
         const record /*: Record */ = this._record;
 
+        const comparison = this._comparison;
         let snapshotHeader = "Snapshot diff"
+        let snapshotDiff = this._snapshotDiff;
         let snapshot = `<div id="snapshot-diff-interface"></div>`;
         let buttons = `
             <div class="c-diff-buttons">
@@ -188,11 +890,23 @@ class Content {
             </div>
         `;
 
-        if (!record.priorSnapshot.length) {
+        if (comparison != null) {
+            snapshotHeader = `Snapshot diff from ${escapeHTML(comparison.fromSession)} &bull; ${comparison.fromRequestID}`
+                + ` to ${escapeHTML(comparison.toSession)} &bull; ${comparison.toRequestID}`;
+            snapshotDiff = comparison.diff;
+        } else if (!record.currentSnapshot.length) {
+            snapshotHeader = "Snapshot"
+            snapshot = `
+                <div class="c-verbatim-output">
+                    There are no snapshots in this recording.
+                </div>
+            `
+            buttons = "";
+        } else if (!record.priorSnapshot.length) {
             snapshotHeader = "Most recent snapshot"
             snapshot = `
                 <pre class="c-verbatim-output x--limit-height">
-${buildHumanReadableSnapshot(record.currentSnapshot)}
+${escapeHTML(buildHumanReadableSnapshot(record.currentSnapshot))}
                 </pre>
             `
             buttons = "";
@@ -200,17 +914,20 @@ ${buildHumanReadableSnapshot(record.currentSnapshot)}
 
         return `
             <div class="c-content-container">
+                ${this._annotationForm()}
                 <h3>${snapshotHeader}</h3>
+                ${buildSnapshotFormat(record)}
+                ${record.currentSnapshot.length ? this._compareForm() : ""}
+                ${snapshotDiff != null ? buildSnapshotDiffSummary(snapshotDiff) : ""}
                 ${buttons}
                 ${snapshot}
-                <h3>Request &bull; ${record.requestID}</h3>
-                <pre class="c-verbatim-output">
-${formatJSON(record.request)}
-                </pre>
+                ${record.checkpoint != null ? buildCheckpoint(record.requestID, record.checkpoint) : `
+                ${this._requestSection(record)}
                 <h3>Response</h3>
                 <pre class="c-verbatim-output">
 ${formatJSON(record.response)}
                 </pre>
+                `}
             </div>
         `;
     }
@@ -224,15 +941,96 @@ ${formatJSON(record.response)}
 
         const record /*: Record */ = this._record;
 
+        const compareButton = this._element.querySelector(".js-compare");
+        const compareRequest = this._element.querySelector(".js-compare-request");
+        const compareSession = this._element.querySelector(".js-compare-session");
+        if (compareButton
+            && compareRequest instanceof HTMLInputElement
+            && compareSession instanceof HTMLSelectElement) {
+            compareButton.addEventListener("click", () => {
+                this._compare(compareSession.value, parseInt(compareRequest.value, 10) || 0);
+            });
+        }
+
+        const saveButton = this._element.querySelector(".js-annotation-save");
+        const notes = this._element.querySelector(".js-annotation-notes");
+        const tags = this._element.querySelector(".js-annotation-tags");
+        const starred = this._element.querySelector(".js-annotation-starred");
+        if (saveButton
+            && notes instanceof HTMLTextAreaElement
+            && tags instanceof HTMLInputElement
+            && starred instanceof HTMLInputElement) {
+            saveButton.addEventListener("click", () => {
+                this._saveAnnotation({
+                    notes: notes.value,
+                    tags: tags.value.split(",").map(tag => tag.trim()).filter(tag => tag !== ""),
+                    starred: starred.checked,
+                });
+            });
+        }
+
+        const deleteButton = this._element.querySelector(".js-delete-request");
+        if (deleteButton) {
+            deleteButton.addEventListener("click", () => this._delete());
+        }
+
+        const replayButton = this._element.querySelector(".js-replay");
+        if (replayButton) {
+            replayButton.addEventListener("click", () => {
+                this._replaying = true;
+                this._update();
+            });
+        }
+
+        const sendButton = this._element.querySelector(".js-replay-send");
+        const query = this._element.querySelector(".js-replay-query");
+        const variables = this._element.querySelector(".js-replay-variables");
+        if (sendButton instanceof HTMLButtonElement
+            && query instanceof HTMLTextAreaElement
+            && variables instanceof HTMLTextAreaElement) {
+            sendButton.addEventListener("click", () => {
+                let parsedVariables;
+                try {
+                    parsedVariables = JSON.parse(variables.value || "{}");
+                } catch (error) {
+                    window.alert(`The variables aren't valid JSON: ${error.message}`);
+                    return;
+                }
+                // Snapshotted requests take a while to be recorded.
+                sendButton.disabled = true;
+                this._replay({query: query.value, variables: parsedVariables})
+                    .then(() => {
+                        sendButton.disabled = false;
+                    });
+            });
+        }
+
+        const cancelButton = this._element.querySelector(".js-replay-cancel");
+        if (cancelButton) {
+            cancelButton.addEventListener("click", () => {
+                this._replaying = false;
+                this._update();
+            });
+        }
+
+        const backButton = this._element.querySelector(".js-compare-back");
+        if (backButton) {
+            backButton.addEventListener("click", () => {
+                this._comparison = null;
+                this._update();
+            });
+        }
+
         var target = this._element.querySelector("#snapshot-diff-interface");
 
         if (target == null) {
             return;
         }
 
+        const comparison = this._comparison;
         const dv = window.CodeMirror.MergeView(target, {
-            value: buildHumanReadableSnapshot(record.priorSnapshot),
-            orig: buildHumanReadableSnapshot(record.currentSnapshot),
+            value: buildHumanReadableSnapshot(comparison != null ? comparison.fromSnapshot : record.priorSnapshot),
+            orig: buildHumanReadableSnapshot(comparison != null ? comparison.toSnapshot : record.currentSnapshot),
             lineNumbers: true,
             mode: "application/json",
             connect: "align",
@@ -259,6 +1057,104 @@ ${formatJSON(record.response)}
     }
 }
 
+function escapeHTML(s /*: string */) /*: string */ {
+    return s
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+// formatTiming describes when a request was sent and how long its response
+// took, for requests recorded with their times.
+function formatTiming(info /*: RecordInfo */) /*: string */ {
+    if (info.sentAt == null || info.completedAt == null) {
+        return "";
+    }
+    const sentAt = new Date(info.sentAt);
+    const took = new Date(info.completedAt).getTime() - sentAt.getTime();
+    return `Sent at ${sentAt.toLocaleTimeString()}, took ${took} ms`;
+}
+
+function formatChangeValue(value /*: any */) /*: string */ {
+    return value === undefined ? "" : escapeHTML(JSON.stringify(value));
+}
+
+function buildSnapshotDiffSummary(diff /*: SnapshotDiff */) /*: string */ {
+    const changes = (changes /*: Array<JSONChange> */) => changes.map(change => `
+        <li>
+            ${escapeHTML(change.path)}:
+            ${formatChangeValue(change.before)} &rarr; ${formatChangeValue(change.after)}
+        </li>
+    `).join("");
+
+    const entities = diff.entities.map(entity => `
+        <li class="c-snapshot-summary--entity x--${entity.kind}">
+            <span class="c-snapshot-summary--kind">${entity.kind}</span>
+            ${escapeHTML(entity.identity)}
+            <span class="c-snapshot-summary--list">${escapeHTML(entity.list)}</span>
+            <ul>${changes(entity.changes || [])}</ul>
+        </li>
+    `).join("");
+
+    return `
+        <div class="c-snapshot-summary">
+            <div class="c-snapshot-summary--headline">${escapeHTML(diff.summary)}</div>
+            <ul>${entities}${changes(diff.other)}</ul>
+        </div>
+    `;
+}
+
+function buildSnapshotFormat(record /*: Record */) /*: string */ {
+    if (!record.snapshotFormat) {
+        return "";
+    }
+    const note = record.snapshotDecoded ? "" : ", shown as recorded";
+    return `<div class="c-snapshot-format">${escapeHTML(record.snapshotFormat)}${note}</div>`;
+}
+
+function buildChangeList(label /*: string */, changes /*: ?Array<JSONChange> */) /*: string */ {
+    if (changes == null || changes.length === 0) {
+        return "";
+    }
+    const items = changes.map(change => `
+        <li>
+            ${escapeHTML(change.path)}:
+            ${formatChangeValue(change.before)} &rarr; ${formatChangeValue(change.after)}
+        </li>
+    `).join("");
+    return `<div>${escapeHTML(label)}</div><ul>${items}</ul>`;
+}
+
+function buildRecordingDiff(a /*: string */, b /*: string */, diff /*: RecordingDiff */) /*: string */ {
+    const step = (step /*: ?Step */) => step == null ? "" : `${step.requestID} ${escapeHTML(step.operationName)}`;
+    const rows = diff.pairs.map(pair => {
+        const details = [
+            pair.message != null ? `<div>${escapeHTML(pair.message)}</div>` : "",
+            buildChangeList("Response", pair.responseChanges),
+            buildChangeList(`Snapshot changes only in ${a}`, pair.snapshotOnlyA),
+            buildChangeList(`Snapshot changes only in ${b}`, pair.snapshotOnlyB),
+        ].join("");
+        return `
+            <tr class="c-recording-diff--row x--${pair.kind}">
+                <td>${step(pair.a)}</td>
+                <td>${step(pair.b)}</td>
+                <td>${pair.kind}${details !== "" ? `<details><summary>details</summary>${details}</details>` : ""}</td>
+            </tr>
+        `;
+    }).join("");
+    return `
+        <div class="c-content-container">
+            <h3>${escapeHTML(a)} &rarr; ${escapeHTML(b)}</h3>
+            <div class="c-snapshot-summary--headline">${escapeHTML(diff.summary)}</div>
+            <table class="c-recording-diff">
+                <tr><th>${escapeHTML(a)}</th><th>${escapeHTML(b)}</th><th></th></tr>
+                ${rows}
+            </table>
+        </div>
+    `;
+}
+
 function formatJSON(s /*: string */) {
     return JSON.stringify(JSON.parse(s), null, 4);
 }
@@ -271,7 +1167,17 @@ window.addEventListener('DOMContentLoaded', (event) => {
         return;
     }
 
-    const items = [];
+    let items = [];
+
+    // Requests are listed newest first, a page at a time. The items are
+    // always the newest requests, so the next page starts after them.
+    const pageSize = 100;
+    const more = document.createElement("button");
+    more.className = "c-request-list--more";
+    more.textContent = "Load older requests";
+    more.hidden = true;
+    more.addEventListener("click", () => loadPage(sessionBar.viewing(), items.length));
+    list.appendChild(more);
 
     function clearAllSelections() {
         items.forEach(item => item.setSelected(false));
@@ -279,7 +1185,14 @@ window.addEventListener('DOMContentLoaded', (event) => {
 
     function handleClick(recordInfo /*: RecordInfo */) {
         clearAllSelections();
-        loadRecord(recordInfo.requestID);
+        loadRecord(recordInfo.session, recordInfo.requestID);
+    }
+
+    function clearItems() {
+        items = [];
+        list.innerHTML = "";
+        more.hidden = true;
+        list.appendChild(more);
     }
 
     function addItem(recordInfo /*: RecordInfo */) {
@@ -288,7 +1201,37 @@ window.addEventListener('DOMContentLoaded', (event) => {
         items.push(item);
     }
 
+    function addOlderItem(recordInfo /*: RecordInfo */) {
+        if (items.some(item => item.id() === recordInfo.requestID)) {
+            return;
+        }
+        const item = new Item(recordInfo, handleClick);
+        list.insertBefore(item.element(), more);
+        items.push(item);
+    }
+
+    function loadPage(session /*: string */, offset /*: number */) {
+        const params = `session=${encodeURIComponent(session)}&order=desc&offset=${offset}&limit=${pageSize}`;
+        fetch(`/api/v1/requests?${params}`)
+            .then(response => response.json())
+            .then((page /*: RequestPage */) => {
+                if (session !== sessionBar.viewing()) {
+                    return;
+                }
+                if (offset === 0) {
+                    clearItems();
+                }
+                page.requests.forEach(record => addOlderItem(record));
+                more.hidden = page.offset + page.requests.length >= page.total;
+            })
+            .catch(error => console.error(error));
+    }
+
     function handleRecord(recordInfo /*: RecordInfo*/) {
+        if (recordInfo.session !== sessionBar.viewing()) {
+            return;
+        }
+
         let found = false;
         
         items.forEach(item => {
@@ -303,6 +1246,56 @@ window.addEventListener('DOMContentLoaded', (event) => {
         }
     }
 
+    // Recording
+
+    const recordingBar = new RecordingBar(sendCommand);
+    const recordingBarContainer = document.getElementById("recording-bar");
+
+    if (recordingBarContainer != null) {
+        recordingBarContainer.appendChild(recordingBar.element());
+    } else {
+        console.error("no recording bar container");
+    }
+
+    function sendCommand(command /*: Command */) {
+        socket.send(JSON.stringify(command));
+    }
+
+    // Sessions
+
+    const sessionBar = new SessionBar(viewSession, diffSessions);
+    const sessionBarContainer = document.getElementById("session-bar");
+
+    if (sessionBarContainer != null) {
+        sessionBarContainer.appendChild(sessionBar.element());
+    } else {
+        console.error("no session bar container");
+    }
+
+    function diffSessions(a /*: string */, b /*: string */) {
+        clearAllSelections();
+        fetch(`/recording-diff?a=${encodeURIComponent(a)}&b=${encodeURIComponent(b)}`)
+            .then(response => {
+                if (!response.ok) {
+                    return response.text().then(text => {
+                        throw new Error(text);
+                    });
+                }
+                return response.json();
+            })
+            .then((diff /*: RecordingDiff */) => content.showRecordingDiff(a, b, diff))
+            .catch(error => window.alert(error.message));
+    }
+
+    function viewSession(session /*: string */) {
+        clearItems();
+        content.updateRecord(null);
+        if (session === "") {
+            return;
+        }
+        loadPage(session, 0);
+    }
+
     const socket = new WebSocket(`ws://${window.location.host}/ws`);
 
     // Listen for messages
@@ -311,9 +1304,18 @@ window.addEventListener('DOMContentLoaded', (event) => {
         const message /*: Message */ = JSON.parse(data);
 
         if (message.type === "init") {
-            message.data.forEach(record => addItem(record));
+            sessionBar.updateSessions(message.data.sessions);
+            content.setSessions(message.data.sessions);
+            recordingBar.updateStatus(message.data.status);
         } else if (message.type === "record") {
             handleRecord(message.data);
+        } else if (message.type === "sessions") {
+            sessionBar.updateSessions(message.data);
+            content.setSessions(message.data);
+        } else if (message.type === "status") {
+            recordingBar.updateStatus(message.data);
+        } else if (message.type === "error") {
+            window.alert(message.data);
         } else {
             console.error("unknown message type", message.type);
         }
@@ -323,7 +1325,7 @@ window.addEventListener('DOMContentLoaded', (event) => {
 
     const contentContainer = document.getElementById("content");
 
-    const content = new Content(null);
+    const content = new Content(null, loadRecord);
 
     if (contentContainer != null) {
         contentContainer.appendChild(content.element())
@@ -331,10 +1333,14 @@ window.addEventListener('DOMContentLoaded', (event) => {
         console.error("no content container");
     }
 
-    function loadRecord(requestID /*: number */) {
-        fetch(`/request?id=${requestID}`)
-            .then(response => response.json())
-            .then((record /*: any */) => content.updateRecord(record))
+    function loadRecord(session /*: string */, requestID /*: number */) {
+        const query = `session=${encodeURIComponent(session)}`;
+        Promise.all([
+            fetch(`/api/v1/requests/${requestID}?${query}`).then(response => response.json()),
+            // Requests without a prior snapshot have no diff.
+            fetch(`/api/v1/requests/${requestID}/snapshot-diff?${query}`).then(response => response.ok ? response.json() : null),
+        ])
+            .then(([record /*: any */, snapshotDiff /*: ?SnapshotDiff */]) => content.updateRecord(record, snapshotDiff, session))
             .catch(error => console.error(error))
     }
 });